@@ -15,71 +15,49 @@ package main
 //// @description     Example: api_key_123
 
 import (
+	"aswadwk/messaging-task-go/internal/assets"
 	"aswadwk/messaging-task-go/internal/config"
 	"aswadwk/messaging-task-go/internal/routes"
 	"aswadwk/messaging-task-go/internal/utils"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/MarceloPetrucio/go-scalar-api-reference"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 
 	"github.com/gofiber/fiber/v2/middleware/recover"
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
-func runMigration() error {
-	log.Println("Running migration...")
-
-	dbUrl := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		config.Cfg.DBUserName,
-		config.Cfg.DBPassword,
-		config.Cfg.DBHost,
-		config.Cfg.DBPort,
-		config.Cfg.DBName,
-	)
-
-	if config.Cfg.Debug {
-		fmt.Println("Connecting to database with URL:", dbUrl)
-	}
+func main() {
+	config.LoadConfig()
 
-	m, err := migrate.New(
-		"file://db/migrations",
-		dbUrl,
-	)
+	flag.StringVar(&config.Cfg.AssetsDir, "assets-dir", config.Cfg.AssetsDir,
+		"load migrations, API docs and static files from this directory instead of the embedded copies")
+	flag.Parse()
 
+	files, err := assets.Load(config.Cfg.AssetsDir)
 	if err != nil {
-		return err
+		log.Fatal("Failed to load assets: ", err)
 	}
 
-	err = m.Up()
-	if err != nil {
-		if err == migrate.ErrNoChange {
-			log.Println("✅ No migration needed. Database is up to date.")
-			return nil
-		}
-		return fmt.Errorf("❌ Migration failed: %w", err)
-	}
-
-	log.Println("✅ Migration applied successfully.")
-	return nil
-}
-
-func main() {
-	config.LoadConfig()
-
-	if len(os.Args) > 1 && os.Args[1] == "migrate" {
-		if err := runMigration(); err != nil {
-			log.Fatal("Migration failed:", err)
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			os.Exit(runMigrate(files.Migrations, args[1:]))
+		case "reconcile":
+			os.Exit(runReconcile(args[1:]))
+		case "rotate-key":
+			os.Exit(runRotateKey(args[1:]))
 		}
-
-		return
 	}
 
 	routes.Init()
@@ -91,12 +69,17 @@ func main() {
 		WriteBufferSize:       16 * 1024, // 16KB (default: 4096)
 		DisableStartupMessage: false,     // Keep or set to true to disable startup message
 		ErrorHandler:          utils.HandleError,
+		// Leave room for the JSON envelope so oversized payloads reach the
+		// publisher and get its 413 with the configured limit
+		BodyLimit: max(fiber.DefaultBodyLimit, 2*config.Cfg.MaxPayloadBytes),
 	})
 
 	// Static Handler
-	app.Static("/static", "./static")
+	app.Use("/static", filesystem.New(filesystem.Config{
+		Root: http.FS(files.Static),
+	}))
 	app.Get("/favicon.ico", func(c *fiber.Ctx) error {
-		return c.SendFile("./static/favicon.ico")
+		return filesystem.SendFile(c, http.FS(files.Static), "favicon.ico")
 	})
 
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -104,7 +87,7 @@ func main() {
 	})
 
 	app.Get("/docs/openapi.json", func(c *fiber.Ctx) error {
-		data, err := os.ReadFile("./internal/docs/v3/openapi.json")
+		data, err := fs.ReadFile(files.Docs, "v3/openapi.json")
 		if err != nil {
 			return utils.Output(c, "Failed to load OpenAPI spec", false, 500)
 		}
@@ -113,7 +96,7 @@ func main() {
 	})
 
 	app.Get("/internal/docs/swagger.json", func(c *fiber.Ctx) error {
-		data, err := os.ReadFile("./internal/docs/swagger.json")
+		data, err := fs.ReadFile(files.Docs, "swagger.json")
 		if err != nil {
 			return utils.Output(c, "Failed to load Swagger spec", false, 500)
 		}
@@ -122,15 +105,20 @@ func main() {
 	})
 
 	app.Get("/swagger", func(c *fiber.Ctx) error {
-		data, _ := os.ReadFile("./internal/docs/index.html")
+		data, _ := fs.ReadFile(files.Docs, "index.html")
 		html := string(data)
 		c.Set("Content-Type", "text/html")
 		return c.SendString(html)
 	})
 
 	app.Get("/docs", func(c *fiber.Ctx) error {
+		spec, err := fs.ReadFile(files.Docs, "v3/openapi.json")
+		if err != nil {
+			return utils.Output(c, "Failed to load OpenAPI spec", false, 500)
+		}
+
 		htmlContent, err := scalar.ApiReferenceHTML(&scalar.Options{
-			SpecURL: "./internal/docs/v3/openapi.json",
+			SpecContent: string(spec),
 			CustomOptions: scalar.CustomOptions{
 				PageTitle: "Ximply Api Documentation",
 			},
@@ -162,11 +150,23 @@ func main() {
 	// app.Use(middleware.AuthMiddleware())
 	routes.SetupRoutes(app)
 
+	// Stop accepting requests on SIGINT/SIGTERM, then stop the background workers
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		log.Println("Shutting down...")
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
 	// Start Server
 	port := config.Cfg.AppPort
-	err := app.Listen(":" + port)
+	err = app.Listen(":" + port)
 	if err != nil {
 		log.Fatal("Error starting server: ", err)
 	}
-	fmt.Printf("Server is running on port %s...\n", port)
+	routes.Shutdown()
+	fmt.Println("Server stopped")
 }
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"aswadwk/messaging-task-go/internal/config"
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Exit codes of the migrate command, stable so CD workflows can branch on them
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitUsage   = 2
+	exitAborted = 3
+	exitDirty   = 4
+)
+
+const migrateUsage = `Usage: app migrate <command> [args] [-y|--yes]
+
+Commands:
+  up [n]        Apply all or n pending migrations (default when no command is given)
+  down [n]      Roll back all or n applied migrations
+  status        Show the current version and every migration with its state
+  version       Print the current version
+  force <v>     Set the version without running migrations (clears the dirty flag)
+  goto <v>      Migrate up or down to version v
+
+Destructive commands (down, force, goto to a lower version) ask for confirmation
+unless -y/--yes is given. Without a terminal they abort unless -y/--yes is given.
+
+Exit codes: 0 ok, 1 error, 2 usage, 3 aborted, 4 database is dirty`
+
+// runMigrate executes a migrate subcommand and returns the process exit code
+func runMigrate(migrations fs.FS, args []string) int {
+	assumeYes := false
+	var positional []string
+	for _, arg := range args {
+		switch arg {
+		case "-y", "--yes":
+			assumeYes = true
+		case "-h", "--help", "help":
+			fmt.Println(migrateUsage)
+			return exitOK
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	command := "up"
+	if len(positional) > 0 {
+		command, positional = positional[0], positional[1:]
+	}
+
+	// Validate arguments before touching the database
+	switch command {
+	case "up", "down":
+		if _, ok := optionalCount(positional); !ok {
+			return usage(command + " takes an optional positive number of steps")
+		}
+	case "force":
+		if _, ok := requiredVersion(positional); !ok {
+			return usage("force requires a version, use -1 for no version")
+		}
+	case "goto":
+		if v, ok := requiredVersion(positional); !ok || v < 0 {
+			return usage("goto requires a non-negative version")
+		}
+	case "status", "version":
+		if len(positional) > 0 {
+			return usage(command + " takes no arguments")
+		}
+	default:
+		return usage(fmt.Sprintf("unknown migrate command %q", command))
+	}
+
+	src, err := iofs.New(migrations, ".")
+	if err != nil {
+		log.Printf("❌ Failed to open migrations: %v", err)
+		return exitError
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", src, databaseURL())
+	if err != nil {
+		log.Printf("❌ Failed to connect to database: %v", err)
+		return exitError
+	}
+	defer m.Close()
+
+	switch command {
+	case "up":
+		n, _ := optionalCount(positional)
+		if n > 0 {
+			return result(m.Steps(n), "applied")
+		}
+		return result(m.Up(), "applied")
+
+	case "down":
+		n, _ := optionalCount(positional)
+		prompt := "Roll back ALL migrations?"
+		if n > 0 {
+			prompt = fmt.Sprintf("Roll back %d migration(s)?", n)
+		}
+		if !confirm(prompt, assumeYes) {
+			return aborted()
+		}
+		if n > 0 {
+			return result(m.Steps(-n), "rolled back")
+		}
+		return result(m.Down(), "rolled back")
+
+	case "status":
+		return printStatus(m, src)
+
+	case "version":
+		version, dirty, err := m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			fmt.Println("none")
+			return exitOK
+		}
+		if err != nil {
+			log.Printf("❌ Failed to read version: %v", err)
+			return exitError
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", version)
+			return exitDirty
+		}
+		fmt.Println(version)
+		return exitOK
+
+	case "force":
+		v, _ := requiredVersion(positional)
+		if !confirm(fmt.Sprintf("Force version to %d without running migrations?", v), assumeYes) {
+			return aborted()
+		}
+		return result(m.Force(v), "forced")
+
+	case "goto":
+		v, _ := requiredVersion(positional)
+		current, _, err := m.Version()
+		if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+			log.Printf("❌ Failed to read version: %v", err)
+			return exitError
+		}
+		if err == nil && uint(v) < current {
+			if !confirm(fmt.Sprintf("Roll back from version %d to %d?", current, v), assumeYes) {
+				return aborted()
+			}
+		}
+		return result(m.Migrate(uint(v)), "migrated")
+	}
+
+	return exitOK
+}
+
+func databaseURL() string {
+	dbUrl := fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		config.Cfg.DBUserName,
+		config.Cfg.DBPassword,
+		config.Cfg.DBHost,
+		config.Cfg.DBPort,
+		config.Cfg.DBName,
+	)
+
+	if config.Cfg.Debug {
+		fmt.Println("Connecting to database with URL:", dbUrl)
+	}
+
+	return dbUrl
+}
+
+// printStatus lists every migration in the source and whether it is applied
+func printStatus(m *migrate.Migrate, src source.Driver) int {
+	current, dirty, err := m.Version()
+	hasVersion := true
+	if errors.Is(err, migrate.ErrNilVersion) {
+		hasVersion = false
+	} else if err != nil {
+		log.Printf("❌ Failed to read version: %v", err)
+		return exitError
+	}
+
+	if hasVersion {
+		state := "clean"
+		if dirty {
+			state = "dirty"
+		}
+		fmt.Printf("Current version: %d (%s)\n\n", current, state)
+	} else {
+		fmt.Print("Current version: none\n\n")
+	}
+
+	version, err := src.First()
+	for err == nil {
+		identifier := ""
+		if r, id, readErr := src.ReadUp(version); readErr == nil {
+			r.Close()
+			identifier = id
+		}
+
+		state := "pending"
+		switch {
+		case hasVersion && version == current && dirty:
+			state = "dirty"
+		case hasVersion && version <= current:
+			state = "applied"
+		}
+		fmt.Printf("  %06d  %-8s %s\n", version, state, identifier)
+
+		version, err = src.Next(version)
+	}
+
+	if dirty {
+		return exitDirty
+	}
+	return exitOK
+}
+
+func result(err error, action string) int {
+	if errors.Is(err, migrate.ErrNoChange) {
+		log.Println("✅ No migration needed. Database is up to date.")
+		return exitOK
+	}
+
+	var dirty migrate.ErrDirty
+	if errors.As(err, &dirty) {
+		log.Printf("❌ Database is dirty at version %d, fix it and run `migrate force <v>`", dirty.Version)
+		return exitDirty
+	}
+
+	if err != nil {
+		log.Printf("❌ Migration failed: %v", err)
+		return exitError
+	}
+
+	log.Printf("✅ Migrations %s successfully.", action)
+	return exitOK
+}
+
+// confirm asks for a yes/no answer on the terminal
+func confirm(prompt string, assumeYes bool) bool {
+	if assumeYes {
+		return true
+	}
+
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		log.Println("Refusing to run without confirmation on a non-interactive terminal, pass --yes")
+		return false
+	}
+
+	fmt.Printf("%s [y/N]: ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func optionalCount(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, true
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 || len(args) > 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+func requiredVersion(args []string) (int, bool) {
+	if len(args) != 1 {
+		return 0, false
+	}
+	v, err := strconv.Atoi(args[0])
+	if err != nil || v < -1 {
+		return 0, false
+	}
+	return v, true
+}
+
+func usage(message string) int {
+	fmt.Fprintln(os.Stderr, message)
+	fmt.Fprintln(os.Stderr, migrateUsage)
+	return exitUsage
+}
+
+func aborted() int {
+	log.Println("Aborted.")
+	return exitAborted
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/config"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"aswadwk/messaging-task-go/internal/services"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// exitMismatch is returned when drift remains after the command ran
+const exitMismatch = 5
+
+const reconcileUsage = `Usage: app reconcile [--fix]
+       app reconcile drop-orphans [-y|--yes]
+       app reconcile strays
+       app reconcile adopt <tenant_id>
+
+Compares registered tenants with the messages_tenant_* partitions and the
+tenant_*_queue queues and reports every mismatch.
+
+  --fix         Create missing partitions and queues
+  drop-orphans  Drop partitions and queues of unregistered tenants with their data
+                (asks for confirmation unless -y/--yes is given)
+  strays        List tenants whose messages landed in the messages_default partition
+  adopt <id>    Move a registered tenant's rows from messages_default into its partition
+
+Exit codes: 0 no drift, 1 error, 2 usage, 3 aborted, 5 drift remains or strays found`
+
+// runReconcile executes the reconcile subcommand and returns the process exit code
+func runReconcile(args []string) int {
+	fix, assumeYes := false, false
+	var positional []string
+	for _, arg := range args {
+		switch arg {
+		case "--fix":
+			fix = true
+		case "-y", "--yes":
+			assumeYes = true
+		case "-h", "--help", "help":
+			fmt.Println(reconcileUsage)
+			return exitOK
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	// Validate arguments before touching the database
+	command := ""
+	var adoptID uuid.UUID
+	if len(positional) > 0 {
+		command = positional[0]
+	}
+	switch {
+	case command == "":
+	case command == "drop-orphans" && len(positional) == 1 && !fix:
+	case command == "strays" && len(positional) == 1 && !fix:
+	case command == "adopt" && len(positional) == 2 && !fix:
+		id, err := uuid.Parse(positional[1])
+		if err != nil {
+			return reconcileUsageError(fmt.Sprintf("invalid tenant id %q", positional[1]))
+		}
+		adoptID = id
+	default:
+		return reconcileUsageError(fmt.Sprintf("unknown reconcile arguments %q", positional))
+	}
+
+	if config.Cfg.QueueDriver == "memory" {
+		log.Println("❌ QUEUE_DRIVER=memory queues only exist inside the server process")
+		return exitError
+	}
+
+	db := config.DBConnect()
+
+	// strays and adopt only touch the database
+	var broker services.Broker
+	var queues services.QueueLister
+	if command == "" || command == "drop-orphans" {
+		var err error
+		broker, queues, err = services.NewConfiguredBroker(repositories.NewQueueRepository(db))
+		if err != nil {
+			log.Printf("❌ %v", err)
+			return exitError
+		}
+		defer broker.Close()
+	}
+
+	// No consumers run here, so missing consumers are not reported
+	reconcile := services.NewReconcileService(
+		repositories.NewTenantRepository(db),
+		repositories.NewMessageRepository(db, nil),
+		broker,
+		queues,
+		nil,
+	)
+
+	ctx := context.Background()
+	switch command {
+	case "strays":
+		return printStrays(ctx, reconcile)
+	case "drop-orphans":
+		return dropOrphans(ctx, reconcile, assumeYes)
+	case "adopt":
+		result, err := reconcile.AdoptStrayTenant(ctx, adoptID)
+		if err != nil {
+			log.Printf("❌ %v", err)
+			return exitError
+		}
+		fmt.Printf("✅ Moved %d message(s) of tenant %s into its partition.\n", result.Moved, result.TenantID)
+		return exitOK
+	}
+
+	report, err := reconcile.Check(ctx)
+	if err != nil {
+		log.Printf("❌ Reconcile failed: %v", err)
+		return exitError
+	}
+
+	fmt.Printf("Tenants: %d  Partitions: %d  Queues: %d\n", report.Tenants, report.Partitions, report.Queues)
+	if len(report.Issues) == 0 {
+		fmt.Println("✅ No drift found.")
+		return exitOK
+	}
+	printIssues(report.Issues)
+
+	if !fix {
+		return exitMismatch
+	}
+
+	reconcile.Fix(ctx, &report)
+	fmt.Println()
+	printIssues(report.Issues)
+
+	for _, issue := range report.Issues {
+		if !issue.Fixed {
+			return exitMismatch
+		}
+	}
+	fmt.Println("✅ All drift fixed.")
+	return exitOK
+}
+
+// dropOrphans drops the orphaned partitions and queues after the operator
+// confirmed the list
+func dropOrphans(ctx context.Context, reconcile *services.ReconcileService, assumeYes bool) int {
+	report, err := reconcile.Check(ctx)
+	if err != nil {
+		log.Printf("❌ Reconcile failed: %v", err)
+		return exitError
+	}
+
+	var orphans []dto.ReconcileIssueDto
+	var resources []string
+	for _, issue := range report.Issues {
+		if issue.Kind == services.IssueOrphanPartition || issue.Kind == services.IssueOrphanQueue {
+			orphans = append(orphans, issue)
+			resources = append(resources, issue.Resource)
+		}
+	}
+	if len(orphans) == 0 {
+		fmt.Println("✅ No orphaned partitions or queues.")
+		return exitOK
+	}
+
+	printIssues(orphans)
+	if !confirm(fmt.Sprintf("Drop these %d partition(s)/queue(s) and the data in them?", len(orphans)), assumeYes) {
+		return aborted()
+	}
+
+	dropped, err := reconcile.DropOrphans(ctx, resources)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return exitError
+	}
+	fmt.Println()
+	printIssues(dropped)
+
+	for _, issue := range dropped {
+		if !issue.Fixed {
+			return exitMismatch
+		}
+	}
+	fmt.Println("✅ Orphans dropped.")
+	return exitOK
+}
+
+// printStrays lists tenants stuck in the default partition; they count as drift
+func printStrays(ctx context.Context, reconcile *services.ReconcileService) int {
+	strays, err := reconcile.StrayTenants(ctx)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return exitError
+	}
+	if len(strays) == 0 {
+		fmt.Println("✅ The default partition is empty.")
+		return exitOK
+	}
+
+	for _, stray := range strays {
+		registered := "unregistered"
+		if stray.Registered {
+			registered = "registered"
+		}
+		fmt.Printf("  %s  %8d message(s)  %s .. %s  %s\n", stray.TenantID, stray.Messages,
+			stray.FirstSeen.Format(time.RFC3339), stray.LastSeen.Format(time.RFC3339), registered)
+	}
+	return exitMismatch
+}
+
+func reconcileUsageError(message string) int {
+	fmt.Fprintln(os.Stderr, message)
+	fmt.Fprintln(os.Stderr, reconcileUsage)
+	return exitUsage
+}
+
+func printIssues(issues []dto.ReconcileIssueDto) {
+	for _, issue := range issues {
+		state := ""
+		switch {
+		case issue.Fixed:
+			state = "fixed"
+		case issue.Error != "":
+			state = "failed: " + issue.Error
+		}
+		fmt.Printf("  %-18s %-60s %s\n", issue.Kind, issue.Resource, state)
+	}
+}
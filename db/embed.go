@@ -0,0 +1,7 @@
+// Package db embeds the SQL migrations so the binary can migrate from any directory.
+package db
+
+import "embed"
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
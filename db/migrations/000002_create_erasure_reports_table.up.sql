@@ -0,0 +1,16 @@
+CREATE TABLE erasure_reports (
+  id UUID PRIMARY KEY,
+  tenant_id UUID NOT NULL,
+  json_path TEXT NOT NULL,
+  value_hash TEXT NOT NULL,
+  status TEXT NOT NULL,
+  deleted_count BIGINT NOT NULL DEFAULT 0,
+  batches JSONB NOT NULL DEFAULT '[]',
+  chain_hash TEXT NOT NULL DEFAULT '',
+  signature TEXT NOT NULL DEFAULT '',
+  error TEXT NOT NULL DEFAULT '',
+  requested_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX erasure_reports_tenant_id_idx ON erasure_reports (tenant_id);
@@ -0,0 +1,13 @@
+CREATE TABLE outbox (
+  id UUID PRIMARY KEY,
+  queue_name TEXT NOT NULL,
+  body JSONB NOT NULL,
+  headers JSONB NOT NULL DEFAULT '{}',
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (created_at) WHERE status = 'pending';
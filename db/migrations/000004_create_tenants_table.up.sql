@@ -0,0 +1,6 @@
+CREATE TABLE tenants (
+  id UUID PRIMARY KEY,
+  workers INT NOT NULL DEFAULT 3,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+-- Catch messages for tenants that have no partition yet instead of failing the insert
+CREATE TABLE IF NOT EXISTS messages_default PARTITION OF messages DEFAULT;
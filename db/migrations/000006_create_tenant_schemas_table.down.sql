@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS schema_version;
+DROP TABLE IF EXISTS tenant_schemas;
@@ -0,0 +1,13 @@
+CREATE TABLE tenant_schemas (
+  tenant_id UUID NOT NULL,
+  version INT NOT NULL,
+  schema JSONB NOT NULL,
+  active BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, version)
+);
+
+-- At most one active schema per tenant
+CREATE UNIQUE INDEX tenant_schemas_active_idx ON tenant_schemas (tenant_id) WHERE active;
+
+ALTER TABLE messages ADD COLUMN schema_version INT;
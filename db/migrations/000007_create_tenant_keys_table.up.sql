@@ -0,0 +1,11 @@
+CREATE TABLE tenant_keys (
+  tenant_id UUID NOT NULL,
+  version INT NOT NULL,
+  wrapped_key BYTEA NOT NULL,
+  active BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, version)
+);
+
+-- New rows are encrypted with the single active key; older versions stay for reads
+CREATE UNIQUE INDEX tenant_keys_active_idx ON tenant_keys (tenant_id) WHERE active;
@@ -0,0 +1,12 @@
+-- Hourly message counters per tenant, so stats never scan the message partitions
+CREATE TABLE usage_rollups (
+  tenant_id UUID NOT NULL,
+  bucket TIMESTAMPTZ NOT NULL,
+  published BIGINT NOT NULL DEFAULT 0,
+  published_bytes BIGINT NOT NULL DEFAULT 0,
+  stored BIGINT NOT NULL DEFAULT 0,
+  stored_bytes BIGINT NOT NULL DEFAULT 0,
+  failed BIGINT NOT NULL DEFAULT 0,
+  dead_lettered BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (tenant_id, bucket)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS queue_messages;
+DROP TABLE IF EXISTS queues;
@@ -0,0 +1,21 @@
+-- Queue backend for QUEUE_DRIVER=postgres
+CREATE TABLE queues (
+  name TEXT PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE queue_messages (
+  id BIGSERIAL PRIMARY KEY,
+  queue_name TEXT NOT NULL REFERENCES queues (name) ON DELETE CASCADE,
+  content_type TEXT NOT NULL DEFAULT '',
+  content_encoding TEXT NOT NULL DEFAULT '',
+  message_id TEXT NOT NULL DEFAULT '',
+  headers JSONB NOT NULL DEFAULT '{}',
+  body BYTEA NOT NULL,
+  deliveries INT NOT NULL DEFAULT 0,
+  -- A claimed message is invisible to other workers until it is acked or the lock expires
+  locked_until TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX queue_messages_queue_idx ON queue_messages (queue_name, id);
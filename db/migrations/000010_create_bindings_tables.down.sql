@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS queue_bindings;
+ALTER TABLE outbox DROP COLUMN IF EXISTS exchange;
+ALTER TABLE messages DROP COLUMN IF EXISTS binding;
+ALTER TABLE messages DROP COLUMN IF EXISTS event_type;
+DROP TABLE IF EXISTS tenant_bindings;
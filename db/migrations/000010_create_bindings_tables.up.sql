@@ -0,0 +1,25 @@
+-- Topic patterns a tenant subscribes to, relative to "<tenant_id>."
+CREATE TABLE tenant_bindings (
+  tenant_id UUID NOT NULL,
+  pattern TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, pattern)
+);
+
+-- Existing tenants keep receiving every event type
+INSERT INTO tenant_bindings (tenant_id, pattern) SELECT id, '#' FROM tenants;
+
+ALTER TABLE messages ADD COLUMN event_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN binding TEXT NOT NULL DEFAULT '';
+
+-- Outbox rows for the topic exchange keep the routing key in queue_name
+ALTER TABLE outbox ADD COLUMN exchange TEXT NOT NULL DEFAULT '';
+
+-- Bindings of the Postgres queue backend; regex matches '.' || routing_key
+CREATE TABLE queue_bindings (
+  queue_name TEXT NOT NULL REFERENCES queues (name) ON DELETE CASCADE,
+  exchange TEXT NOT NULL,
+  pattern TEXT NOT NULL,
+  regex TEXT NOT NULL,
+  PRIMARY KEY (queue_name, exchange, pattern)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_broadcast_id_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS broadcast_id;
+DROP INDEX IF EXISTS tenants_labels_idx;
+ALTER TABLE tenants DROP COLUMN IF EXISTS labels;
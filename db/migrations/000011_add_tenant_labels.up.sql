@@ -0,0 +1,7 @@
+-- Free-form key/value labels used to target groups of tenants
+ALTER TABLE tenants ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+CREATE INDEX tenants_labels_idx ON tenants USING GIN (labels);
+
+-- Rows stored from the same broadcast share its ID
+ALTER TABLE messages ADD COLUMN broadcast_id UUID;
+CREATE INDEX messages_broadcast_id_idx ON messages (broadcast_id) WHERE broadcast_id IS NOT NULL;
@@ -0,0 +1,2 @@
+-- RabbitMQ x-arguments applied whenever the tenant queue is declared
+ALTER TABLE tenants ADD COLUMN queue_options JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+-- Ordered processing steps of the tenant consumer, empty runs the default pipeline
+ALTER TABLE tenants ADD COLUMN pipeline JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,28 @@
+CREATE TABLE tenant_webhooks (
+  id UUID PRIMARY KEY,
+  tenant_id UUID NOT NULL,
+  url TEXT NOT NULL,
+  event_types TEXT NOT NULL DEFAULT '#',
+  secret TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_tenant_webhooks_tenant ON tenant_webhooks (tenant_id);
+
+-- One row per message and webhook; attempt_log keeps every POST made
+CREATE TABLE webhook_deliveries (
+  id UUID PRIMARY KEY,
+  webhook_id UUID NOT NULL REFERENCES tenant_webhooks (id) ON DELETE CASCADE,
+  tenant_id UUID NOT NULL,
+  event_type TEXT NOT NULL DEFAULT '',
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  attempt_log JSONB NOT NULL DEFAULT '[]',
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, created_at DESC);
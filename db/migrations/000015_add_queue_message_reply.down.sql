@@ -0,0 +1,2 @@
+ALTER TABLE queue_messages DROP COLUMN IF EXISTS correlation_id;
+ALTER TABLE queue_messages DROP COLUMN IF EXISTS reply_to;
@@ -0,0 +1,3 @@
+-- Request/reply on the Postgres queue backend: the queue the reply goes to and the request it answers
+ALTER TABLE queue_messages ADD COLUMN reply_to TEXT NOT NULL DEFAULT '';
+ALTER TABLE queue_messages ADD COLUMN correlation_id TEXT NOT NULL DEFAULT '';
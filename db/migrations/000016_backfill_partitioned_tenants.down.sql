@@ -0,0 +1 @@
+-- Backfilled tenants cannot be told apart from created ones and are kept
@@ -0,0 +1,16 @@
+-- Register the tenants that only have a messages_tenant_<uuid> partition,
+-- otherwise reconcile reports their partitions as orphans. They keep receiving
+-- every event type, like the tenants bound in 000010.
+WITH registered AS (
+  INSERT INTO tenants (id)
+  SELECT substring(c.relname FROM length('messages_tenant_') + 1)::uuid
+  FROM pg_inherits i
+  JOIN pg_class c ON c.oid = i.inhrelid
+  WHERE i.inhparent = 'messages'::regclass
+    AND c.relname ~ '^messages_tenant_[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$'
+  ON CONFLICT (id) DO NOTHING
+  RETURNING id
+)
+INSERT INTO tenant_bindings (tenant_id, pattern)
+SELECT id, '#' FROM registered
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DROP FUNCTION IF EXISTS message_content_jsonb(TEXT);
+ALTER TABLE erasure_reports DROP COLUMN IF EXISTS updated_at;
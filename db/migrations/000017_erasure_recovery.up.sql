@@ -0,0 +1,14 @@
+-- Last time an erasure made progress, so reports left running by a crashed
+-- instance can be told apart from ones still in progress
+ALTER TABLE erasure_reports ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Legacy rows hold the raw delivery body as a string under "content"; this
+-- parses it for erasure matching and yields NULL when it is not valid JSON
+CREATE OR REPLACE FUNCTION message_content_jsonb(content TEXT) RETURNS JSONB
+LANGUAGE plpgsql IMMUTABLE STRICT AS $$
+BEGIN
+  RETURN content::jsonb;
+EXCEPTION WHEN others THEN
+  RETURN NULL;
+END;
+$$;
@@ -0,0 +1,3 @@
+-- Rows claimed by a relay are hidden from other relays until locked_until, so
+-- they can be published outside the claiming transaction
+ALTER TABLE outbox ADD COLUMN locked_until TIMESTAMPTZ;
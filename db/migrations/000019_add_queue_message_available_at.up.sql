@@ -0,0 +1,2 @@
+-- Pipeline retries wait in the queue until available_at instead of holding up a worker
+ALTER TABLE queue_messages ADD COLUMN available_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
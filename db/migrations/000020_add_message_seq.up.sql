@@ -0,0 +1,12 @@
+-- Streams resume from seq. Store hands it out under a per-tenant lock, so
+-- within a tenant it follows commit order; existing rows are numbered by id.
+CREATE SEQUENCE IF NOT EXISTS messages_seq_seq;
+ALTER TABLE messages ADD COLUMN seq BIGINT;
+ALTER SEQUENCE messages_seq_seq OWNED BY messages.seq;
+UPDATE messages SET seq = ordered.n
+FROM (SELECT id, tenant_id, row_number() OVER (ORDER BY id) AS n FROM messages) ordered
+WHERE messages.id = ordered.id AND messages.tenant_id = ordered.tenant_id;
+SELECT setval('messages_seq_seq', COALESCE((SELECT MAX(seq) FROM messages), 0) + 1, false);
+ALTER TABLE messages ALTER COLUMN seq SET DEFAULT nextval('messages_seq_seq');
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_tenant_seq ON messages (tenant_id, seq);
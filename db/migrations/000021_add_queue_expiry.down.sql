@@ -0,0 +1 @@
+ALTER TABLE queues DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,2 @@
+-- Queues of our own, like the reply queues, are deleted once no consumer keeps them alive
+ALTER TABLE queues ADD COLUMN expires_at TIMESTAMPTZ;
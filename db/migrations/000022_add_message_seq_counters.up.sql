@@ -0,0 +1,9 @@
+-- Store takes seq from the tenant's counter row, which stays locked until the
+-- insert commits: a tenant's inserts commit in seq order while other tenants
+-- never wait on it. Counters continue from the seq already handed out.
+CREATE TABLE message_seqs (
+  tenant_id UUID PRIMARY KEY,
+  last_seq BIGINT NOT NULL
+);
+INSERT INTO message_seqs (tenant_id, last_seq)
+SELECT tenant_id, MAX(seq) FROM messages GROUP BY tenant_id;
@@ -0,0 +1,30 @@
+package dto
+
+// BroadcastTargetDto selects the tenants of a broadcast. Exactly one of the
+// fields must be set.
+type BroadcastTargetDto struct {
+	All       bool     `json:"all"`
+	TenantIDs []string `json:"tenant_ids"`
+	// Labels matches tenants that carry every given label
+	Labels map[string]string `json:"labels"`
+}
+
+type BroadcastDto struct {
+	Target    BroadcastTargetDto `json:"target" validate:"required"`
+	EventType string             `json:"event_type"`
+	Payload   map[string]any     `json:"payload" validate:"required"`
+}
+
+// BroadcastDeliveryDto is the outcome of a broadcast for one tenant. Broadcasts
+// to all tenants share the outcome of their single fanout publish.
+type BroadcastDeliveryDto struct {
+	TenantID string `json:"tenant_id"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+type BroadcastResultDto struct {
+	BroadcastID string                 `json:"broadcast_id"`
+	EventType   string                 `json:"event_type"`
+	Results     []BroadcastDeliveryDto `json:"results"`
+}
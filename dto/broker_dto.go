@@ -0,0 +1,23 @@
+package dto
+
+// ChannelPoolStatsDto reports the RabbitMQ publishing channel pool. Wait times
+// are measured from the publish call until a channel is free.
+type ChannelPoolStatsDto struct {
+	Size        int             `json:"size"`
+	InUse       int             `json:"in_use"`
+	Idle        int             `json:"idle"`
+	Opened      int64           `json:"opened"`
+	Discarded   int64           `json:"discarded"`
+	Acquired    int64           `json:"acquired"`
+	Timeouts    int64           `json:"timeouts"`
+	WaitTotalMs float64         `json:"wait_total_ms"`
+	WaitAvgMs   float64         `json:"wait_avg_ms"`
+	WaitMaxMs   float64         `json:"wait_max_ms"`
+	WaitBuckets []WaitBucketDto `json:"wait_buckets"`
+}
+
+// WaitBucketDto counts the acquisitions that waited at most Le
+type WaitBucketDto struct {
+	Le    string `json:"le"`
+	Count int64  `json:"count"`
+}
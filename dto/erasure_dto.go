@@ -0,0 +1,14 @@
+package dto
+
+import "aswadwk/messaging-task-go/internal/models"
+
+type ErasureRequestDto struct {
+	// Path is a dotted JSON path into the payload, e.g. "customer.id"
+	Path  string `json:"path" validate:"required"`
+	Value string `json:"value" validate:"required"`
+}
+
+type ErasureReportDto struct {
+	models.ErasureReport
+	Verified bool `json:"verified"`
+}
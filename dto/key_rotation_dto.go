@@ -0,0 +1,16 @@
+package dto
+
+import "time"
+
+type KeyRotationJobDto struct {
+	ID         string     `json:"id"`
+	TenantID   string     `json:"tenant_id"`
+	KeyVersion int        `json:"key_version"`
+	Status     string     `json:"status"`
+	Total      int64      `json:"total"`
+	Done       int64      `json:"done"`
+	Failed     int64      `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -3,8 +3,18 @@ package dto
 import "time"
 
 type NewMessageDto struct {
-	TenantID string         `json:"tenant_id" validate:"required"`
-	Payload  map[string]any `json:"payload" validate:"required"`
+	TenantID      string         `json:"tenant_id" validate:"required"`
+	Payload       map[string]any `json:"payload" validate:"required"`
+	SchemaVersion *int           `json:"-" swaggerignore:"true"`
+	EventType     string         `json:"event_type"`
+	// OrderingKey makes the tenant consumer handle messages with the same key
+	// in publish order
+	OrderingKey string `json:"ordering_key,omitempty"`
+	// Binding is the tenant binding the consumer matched the event type with
+	Binding     string `json:"-" swaggerignore:"true"`
+	BroadcastID string `json:"-" swaggerignore:"true"`
+	// ID is generated on store when empty
+	ID string `json:"-" swaggerignore:"true"`
 }
 
 type MessageDto struct {
@@ -18,3 +28,19 @@ type MessageResponseDto struct {
 	Data       []MessageDto `json:"data"`
 	NextCursor string       `json:"next_cursor"`
 }
+
+// MessageFilter narrows message queries to a tenant, an optional time range and
+// an optional JSONB containment match on the payload.
+type MessageFilter struct {
+	TenantID string
+	From     *time.Time
+	To       *time.Time
+	Payload  map[string]any
+}
+
+type ExportMessagesDto struct {
+	MessageFilter
+	Format  string   `query:"format"`
+	Columns []string `query:"columns"`
+	Gzip    bool     `query:"gzip"`
+}
@@ -0,0 +1,38 @@
+package dto
+
+import "time"
+
+type ReconcileIssueDto struct {
+	Kind     string `json:"kind"`
+	TenantID string `json:"tenant_id"`
+	Resource string `json:"resource"`
+	Fixed    bool   `json:"fixed"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ReconcileReportDto struct {
+	Tenants    int                 `json:"tenants"`
+	Partitions int                 `json:"partitions"`
+	Queues     int                 `json:"queues"`
+	Issues     []ReconcileIssueDto `json:"issues"`
+	CheckedAt  time.Time           `json:"checked_at"`
+}
+
+// DropOrphansDto confirms the orphaned partitions and queues to drop
+type DropOrphansDto struct {
+	Resources []string `json:"resources"`
+}
+
+// StrayTenantDto describes a tenant whose messages landed in the default partition
+type StrayTenantDto struct {
+	TenantID   string    `json:"tenant_id"`
+	Messages   int64     `json:"messages"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	Registered bool      `json:"registered" gorm:"-"`
+}
+
+type AdoptStrayTenantDto struct {
+	TenantID string `json:"tenant_id"`
+	Moved    int64  `json:"moved"`
+}
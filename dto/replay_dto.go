@@ -0,0 +1,24 @@
+package dto
+
+import "time"
+
+type ReplayRequestDto struct {
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+	// Filter is matched against the stored payload with JSONB containment (@>)
+	Filter map[string]any `json:"filter"`
+	// Rate is the maximum number of messages republished per second
+	Rate int `json:"rate"`
+}
+
+type ReplayJobDto struct {
+	ID         string     `json:"id"`
+	TenantID   string     `json:"tenant_id"`
+	Status     string     `json:"status"`
+	Total      int64      `json:"total"`
+	Published  int64      `json:"published"`
+	Failed     int64      `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
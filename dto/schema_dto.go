@@ -0,0 +1,7 @@
+package dto
+
+type RegisterSchemaDto struct {
+	Schema map[string]any `json:"schema" validate:"required"`
+	// Activate makes the new version the active one, defaults to true
+	Activate *bool `json:"activate"`
+}
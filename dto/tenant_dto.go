@@ -1,10 +1,29 @@
 package dto
 
+import "aswadwk/messaging-task-go/internal/models"
+
 type CreateConsumerDto struct {
-	TenantID string `json:"tenant_id" validate:"required"`
-	Workers  int    `json:"workers" validate:"required"`
+	TenantID string            `json:"tenant_id" validate:"required"`
+	Workers  int               `json:"workers" validate:"required"`
+	Labels   map[string]string `json:"labels"`
+	// Queue sets the queue arguments, e.g. {"x-queue-type": "quorum"}
+	Queue *models.QueueOptions `json:"queue"`
 }
 
 type UpdateConcurrencyDto struct {
 	Workers int `json:"workers" validate:"required"`
 }
+
+type CreateBindingDto struct {
+	// Pattern matches event types, * matches one word and # zero or more words
+	Pattern string `json:"pattern" validate:"required"`
+}
+
+type UpdateLabelsDto struct {
+	Labels map[string]string `json:"labels"`
+}
+
+type UpdatePipelineDto struct {
+	// Steps run in order, e.g. [{"type": "validate"}, {"type": "store"}]
+	Steps models.Pipeline `json:"steps"`
+}
@@ -0,0 +1,28 @@
+package dto
+
+import "time"
+
+type UsageQueryDto struct {
+	From   *time.Time
+	To     *time.Time
+	Bucket string `query:"bucket"`
+}
+
+type UsagePointDto struct {
+	Bucket         time.Time `json:"bucket"`
+	Published      int64     `json:"published"`
+	PublishedBytes int64     `json:"published_bytes"`
+	Stored         int64     `json:"stored"`
+	StoredBytes    int64     `json:"stored_bytes"`
+	Failed         int64     `json:"failed"`
+	DeadLettered   int64     `json:"dead_lettered"`
+}
+
+type UsageStatsDto struct {
+	TenantID string          `json:"tenant_id"`
+	Bucket   string          `json:"bucket"`
+	From     time.Time       `json:"from"`
+	To       time.Time       `json:"to"`
+	Series   []UsagePointDto `json:"series"`
+	Totals   UsagePointDto   `json:"totals"`
+}
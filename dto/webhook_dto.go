@@ -0,0 +1,13 @@
+package dto
+
+type CreateWebhookDto struct {
+	URL string `json:"url" validate:"required"`
+	// EventTypes is a binding pattern, e.g. orders.*; defaults to every event type
+	EventTypes string `json:"event_types"`
+}
+
+type WebhookDeliveryQueryDto struct {
+	// Status is pending, delivered or failed; empty returns all
+	Status string `query:"status"`
+	Limit  int    `query:"limit"`
+}
@@ -0,0 +1,48 @@
+// Package assets resolves the migrations, API docs and static files either from
+// the copies embedded in the binary or, for development, from a directory on disk.
+package assets
+
+import (
+	"aswadwk/messaging-task-go/db"
+	"aswadwk/messaging-task-go/internal/docs"
+	"aswadwk/messaging-task-go/static"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type Assets struct {
+	Migrations fs.FS
+	Docs       fs.FS
+	Static     fs.FS
+}
+
+// Load returns the embedded assets, or the ones under dir (the repository root
+// layout: db/migrations, internal/docs, static) when dir is not empty
+func Load(dir string) (Assets, error) {
+	if dir != "" {
+		for _, sub := range []string{"db/migrations", "internal/docs", "static"} {
+			if _, err := os.Stat(filepath.Join(dir, sub)); err != nil {
+				return Assets{}, fmt.Errorf("assets directory %s: %w", dir, err)
+			}
+		}
+
+		return Assets{
+			Migrations: os.DirFS(filepath.Join(dir, "db", "migrations")),
+			Docs:       os.DirFS(filepath.Join(dir, "internal", "docs")),
+			Static:     os.DirFS(filepath.Join(dir, "static")),
+		}, nil
+	}
+
+	migrations, err := fs.Sub(db.Migrations, "migrations")
+	if err != nil {
+		return Assets{}, err
+	}
+
+	return Assets{
+		Migrations: migrations,
+		Docs:       docs.Files,
+		Static:     static.Files,
+	}, nil
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"aswadwk/messaging-task-go/internal/envelope"
+	"log"
+)
+
+// LoadMasterKey loads the key that wraps tenant data keys. It returns nil when
+// MASTER_KEY_FILE is not set, which leaves payloads unencrypted.
+func LoadMasterKey() *envelope.MasterKey {
+	if Cfg.MasterKeyFile == "" {
+		return nil
+	}
+
+	master, err := envelope.LoadMasterKey(Cfg.MasterKeyFile)
+	if err != nil {
+		log.Fatalf("[Encryption] %v", err)
+	}
+
+	log.Printf("[Encryption] Payload encryption enabled, %d clear field(s)", len(Cfg.EncryptionClearFields))
+	return master
+}
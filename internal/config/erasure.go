@@ -0,0 +1,30 @@
+package config
+
+import (
+	"errors"
+	"log"
+)
+
+// LoadErasureSigningKey returns the key that signs erasure reports. It stops
+// the server when erasure is enabled without a dedicated key, so reports are
+// never signed with a default or with the JWT secret.
+func LoadErasureSigningKey() []byte {
+	if !Cfg.ErasureEnabled {
+		return nil
+	}
+
+	if err := validateErasureSigningKey(Cfg.ErasureSigningKey, Cfg.JWTSecret); err != nil {
+		log.Fatalf("[Erasure] %v", err)
+	}
+	return []byte(Cfg.ErasureSigningKey)
+}
+
+func validateErasureSigningKey(key, jwtSecret string) error {
+	if key == "" {
+		return errors.New("ERASURE_SIGNING_KEY is required when ERASURE_ENABLED=true")
+	}
+	if key == jwtSecret {
+		return errors.New("ERASURE_SIGNING_KEY must not reuse JWT_SECRET")
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateErasureSigningKey(t *testing.T) {
+	assert.Error(t, validateErasureSigningKey("", "jwt-secret"))
+	assert.Error(t, validateErasureSigningKey("jwt-secret", "jwt-secret"))
+	assert.NoError(t, validateErasureSigningKey("erasure-key", "jwt-secret"))
+}
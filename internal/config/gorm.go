@@ -11,11 +11,16 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-func DBConnect() *gorm.DB {
-	dsn := fmt.Sprintf(
+// DSN returns the connection string of the primary database
+func DSN() string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		Cfg.DBHost, Cfg.DBPort, Cfg.DBUserName, Cfg.DBPassword, Cfg.DBName,
 	)
+}
+
+func DBConnect() *gorm.DB {
+	dsn := DSN()
 	dbUrl := fmt.Sprintf(
 		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
 		Cfg.DBUserName,
@@ -51,6 +56,12 @@ func DBConnect() *gorm.DB {
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
+	if Cfg.DBReadHost != "" {
+		if err := registerReadReplica(db); err != nil {
+			log.Printf("Failed to register read replica, using primary only: %v", err)
+		}
+	}
+
 	return db
 }
 
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// replicaLagQuery reports how far the replica is behind in seconds.
+// A replica that has replayed everything it received is not lagging even if
+// the primary has been idle, so only an outstanding WAL gap counts.
+const replicaLagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() THEN 0
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+
+// replicaPolicy sends reads to the replica while it is healthy and to the
+// primary otherwise. It expects the replica pool first and the primary second.
+type replicaPolicy struct {
+	healthy atomic.Bool
+}
+
+func (p *replicaPolicy) Resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	if p.healthy.Load() {
+		return pools[0]
+	}
+	return pools[len(pools)-1]
+}
+
+// check probes the replica and updates the health flag, logging transitions
+func (p *replicaPolicy) check(replica *sql.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var lag float64
+	err := replica.QueryRowContext(ctx, replicaLagQuery).Scan(&lag)
+	lagDuration := time.Duration(lag * float64(time.Second))
+
+	healthy := err == nil && lagDuration <= Cfg.DBReadMaxLag
+	if p.healthy.Swap(healthy) == healthy {
+		return
+	}
+
+	switch {
+	case healthy:
+		log.Println("[DB] Read replica healthy, routing reads to replica")
+	case err != nil:
+		log.Printf("[DB] Read replica unreachable, routing reads to primary: %v", err)
+	default:
+		log.Printf("[DB] Read replica lagging by %s, routing reads to primary", lagDuration)
+	}
+}
+
+func (p *replicaPolicy) monitor(replica *sql.DB) {
+	ticker := time.NewTicker(Cfg.DBReadCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.check(replica)
+	}
+}
+
+// registerReadReplica opens a second pool on DB_READ_HOST and routes read-only
+// queries there through the dbresolver plugin. Writes and transactions keep
+// using the primary unless a read transaction is requested explicitly.
+func registerReadReplica(primary *gorm.DB) error {
+	dsn := fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		Cfg.DBReadHost, Cfg.DBReadPort, Cfg.DBUserName, Cfg.DBPassword, Cfg.DBName,
+	)
+
+	// Skip the initial ping so a replica that is down at boot can recover later
+	replica, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:               primary.Logger,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	replicaSQL, err := replica.DB()
+	if err != nil {
+		return err
+	}
+	replicaSQL.SetMaxIdleConns(10)
+	replicaSQL.SetMaxOpenConns(100)
+	replicaSQL.SetConnMaxLifetime(time.Hour)
+
+	policy := &replicaPolicy{}
+	policy.check(replicaSQL)
+
+	if err := routeReads(primary, replicaSQL, policy); err != nil {
+		return err
+	}
+
+	go policy.monitor(replicaSQL)
+
+	log.Printf("[DB] Read replica registered at %s:%s", Cfg.DBReadHost, Cfg.DBReadPort)
+	return nil
+}
+
+// routeReads registers the dbresolver plugin on primary with the replica pool
+// first and the primary pool as fallback, the order replicaPolicy expects
+func routeReads(primary *gorm.DB, replicaSQL *sql.DB, policy *replicaPolicy) error {
+	primarySQL, err := primary.DB()
+	if err != nil {
+		return err
+	}
+
+	return primary.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{
+			postgres.New(postgres.Config{Conn: replicaSQL}),
+			postgres.New(postgres.Config{Conn: primarySQL}),
+		},
+		Policy: policy,
+	}))
+}
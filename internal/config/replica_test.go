@@ -0,0 +1,157 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
+)
+
+// recordingDriver is a database/sql driver that answers every statement with
+// no rows and records which pool (the DSN) ran it
+type recordingDriver struct {
+	mu  sync.Mutex
+	ran map[string][]string
+}
+
+var recorder = &recordingDriver{ran: map[string][]string{}}
+
+func init() {
+	sql.Register("recording", recorder)
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{driver: d, pool: name}, nil
+}
+
+func (d *recordingDriver) record(pool, statement string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ran[pool] = append(d.ran[pool], strings.Fields(statement)[0])
+}
+
+// take returns and clears the statements each pool ran
+func (d *recordingDriver) take(pools ...string) map[string][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ran := map[string][]string{}
+	for _, pool := range pools {
+		ran[pool] = d.ran[pool]
+		delete(d.ran, pool)
+	}
+	return ran
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+	pool   string
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *recordingConn) Close() error                              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *recordingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.driver.record(c.pool, "BEGIN")
+	return c, nil
+}
+
+func (c *recordingConn) Commit() error   { c.driver.record(c.pool, "COMMIT"); return nil }
+func (c *recordingConn) Rollback() error { c.driver.record(c.pool, "ROLLBACK"); return nil }
+
+func (c *recordingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.driver.record(c.pool, query)
+	return emptyRows{}, nil
+}
+
+func (c *recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.driver.record(c.pool, query)
+	return driver.RowsAffected(1), nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return []string{"id"} }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+type replicaTestRow struct {
+	ID string
+}
+
+// openRoutedDB returns a primary connection with reads routed by policy
+func openRoutedDB(t *testing.T, policy *replicaPolicy) *gorm.DB {
+	t.Helper()
+	primarySQL, err := sql.Open("recording", "primary")
+	require.NoError(t, err)
+	replicaSQL, err := sql.Open("recording", "replica")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		primarySQL.Close()
+		replicaSQL.Close()
+	})
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: primarySQL}), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, routeReads(db, replicaSQL, policy))
+	recorder.take("primary", "replica")
+	return db
+}
+
+func TestReadsGoToHealthyReplica(t *testing.T) {
+	policy := &replicaPolicy{}
+	policy.healthy.Store(true)
+	db := openRoutedDB(t, policy)
+
+	var rows []replicaTestRow
+	require.NoError(t, db.Find(&rows).Error)
+	require.NoError(t, db.Raw("SELECT id FROM replica_test_rows").Scan(&rows).Error)
+	assert.Equal(t, map[string][]string{"primary": nil, "replica": {"SELECT", "SELECT"}}, recorder.take("primary", "replica"))
+
+	// Writes run in gorm's default transaction on the primary
+	require.NoError(t, db.Create(&replicaTestRow{ID: "r-1"}).Error)
+	require.NoError(t, db.Exec("UPDATE replica_test_rows SET id = id").Error)
+	assert.Equal(t, map[string][]string{"primary": {"BEGIN", "INSERT", "COMMIT", "UPDATE"}, "replica": nil},
+		recorder.take("primary", "replica"))
+
+	// Reads inside a transaction see its writes, so they stay on the primary
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return tx.Find(&rows).Error
+	}))
+	require.NoError(t, db.Clauses(dbresolver.Write).Find(&rows).Error)
+	assert.Equal(t, map[string][]string{"primary": {"BEGIN", "SELECT", "COMMIT", "SELECT"}, "replica": nil},
+		recorder.take("primary", "replica"))
+
+	// A read transaction is only sent to the replica when asked for
+	require.NoError(t, db.Clauses(dbresolver.Read).Transaction(func(tx *gorm.DB) error {
+		return tx.Find(&rows).Error
+	}))
+	assert.Equal(t, map[string][]string{"primary": nil, "replica": {"BEGIN", "SELECT", "COMMIT"}},
+		recorder.take("primary", "replica"))
+}
+
+func TestReadsFallBackToPrimary(t *testing.T) {
+	policy := &replicaPolicy{}
+	db := openRoutedDB(t, policy)
+
+	// Unhealthy or lagging replicas get no reads
+	var rows []replicaTestRow
+	require.NoError(t, db.Find(&rows).Error)
+	assert.Equal(t, map[string][]string{"primary": {"SELECT"}, "replica": nil}, recorder.take("primary", "replica"))
+
+	policy.healthy.Store(true)
+	require.NoError(t, db.Find(&rows).Error)
+	assert.Equal(t, map[string][]string{"primary": nil, "replica": {"SELECT"}}, recorder.take("primary", "replica"))
+}
@@ -0,0 +1,8 @@
+package docs
+
+import "embed"
+
+// Files holds the generated API documentation served by the /docs and /swagger routes
+//
+//go:embed index.html swagger.json v3/openapi.json
+var Files embed.FS
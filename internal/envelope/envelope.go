@@ -0,0 +1,120 @@
+// Package envelope implements envelope encryption: payloads are sealed with a
+// per-tenant data key, and data keys are stored wrapped by a master key.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of master and data keys (AES-256)
+const KeySize = 32
+
+// MasterKey wraps and unwraps tenant data keys
+type MasterKey struct {
+	aead cipher.AEAD
+}
+
+// LoadMasterKey reads a master key file holding either 32 raw bytes or the
+// base64 encoding of 32 bytes (e.g. `openssl rand -base64 32`)
+func LoadMasterKey(path string) (*MasterKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+
+	key := raw
+	if len(raw) != KeySize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+		if err != nil || len(decoded) != KeySize {
+			return nil, fmt.Errorf("master key must be %d raw bytes or their base64 encoding", KeySize)
+		}
+		key = decoded
+	}
+
+	return NewMasterKey(key)
+}
+
+// NewMasterKey builds a master key from 32 bytes
+func NewMasterKey(key []byte) (*MasterKey, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &MasterKey{aead: aead}, nil
+}
+
+// NewDataKey returns a fresh random data key
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Wrap encrypts a data key; aad binds it to its owner (e.g. the tenant ID)
+func (m *MasterKey) Wrap(dataKey, aad []byte) ([]byte, error) {
+	return seal(m.aead, dataKey, aad)
+}
+
+// Unwrap decrypts a data key wrapped with the same aad
+func (m *MasterKey) Unwrap(wrapped, aad []byte) ([]byte, error) {
+	return open(m.aead, wrapped, aad)
+}
+
+// Seal encrypts plaintext with a data key
+func Seal(dataKey, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, plaintext, aad)
+}
+
+// Open decrypts a value produced by Seal
+func Open(dataKey, sealed, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, sealed, aad)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("decryption failed")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type AdminHandler struct {
+	Reconcile *services.ReconcileService
+	Broker    services.Broker
+}
+
+// NewAdminHandler constructor
+func NewAdminHandler(reconcile *services.ReconcileService, broker services.Broker) *AdminHandler {
+	return &AdminHandler{
+		Reconcile: reconcile,
+		Broker:    broker,
+	}
+}
+
+// GetReconcile reports drift between tenants, partitions and queues
+// @FileName		admin_handler.go
+// @Description	Report mismatches between registered tenants, message partitions and tenant queues
+// @Tags			Admin
+// @Produce		json
+// @Success		200	{object}	dto.ReconcileReportDto	"Reconcile report"
+// @Failure		500	{object}	fiber.Map				"Internal server error"
+// @Router			/admin/reconcile [get]
+func (h *AdminHandler) GetReconcile(c *fiber.Ctx) error {
+	report, err := h.Reconcile.Check(c.Context())
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(report)
+}
+
+// FixReconcile repairs drift between tenants, partitions and queues
+// @FileName		admin_handler.go
+// @Description	Create missing partitions, queues and consumers. Orphaned partitions and queues are kept, see POST /admin/reconcile/orphans.
+// @Tags			Admin
+// @Produce		json
+// @Success		200	{object}	dto.ReconcileReportDto	"Reconcile report with fix results"
+// @Failure		500	{object}	fiber.Map				"Internal server error"
+// @Router			/admin/reconcile [post]
+func (h *AdminHandler) FixReconcile(c *fiber.Ctx) error {
+	report, err := h.Reconcile.Check(c.Context())
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	h.Reconcile.Fix(c.Context(), &report)
+
+	log.Printf("[API] Reconcile checked %d issue(s)", len(report.Issues))
+	return c.JSON(report)
+}
+
+// DropOrphans drops confirmed orphaned partitions and queues
+// @FileName		admin_handler.go
+// @Description	Drop the orphaned partitions and queues listed in resources, as reported by GET /admin/reconcile, with the data in them. Resources that are no longer orphaned are kept.
+// @Tags			Admin
+// @Accept			json
+// @Produce		json
+// @Param			body	body		dto.DropOrphansDto			true	"Resources to drop"	Example({"resources": ["messages_tenant_0190d8a4-0000-7000-8000-000000000003"]})
+// @Success		200		{array}		dto.ReconcileIssueDto		"Dropped orphans"
+// @Failure		400		{object}	fiber.Map					"No resources confirmed"
+// @Failure		500		{object}	fiber.Map					"Internal server error"
+// @Router			/admin/reconcile/orphans [post]
+func (h *AdminHandler) DropOrphans(c *fiber.Ctx) error {
+	var req dto.DropOrphansDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+	if len(req.Resources) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "list the orphaned resources to drop in resources")
+	}
+
+	dropped, err := h.Reconcile.DropOrphans(c.Context(), req.Resources)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	log.Printf("[API] Reconcile dropped %d of %d confirmed orphan(s)", len(dropped), len(req.Resources))
+	return c.JSON(dropped)
+}
+
+// GetStrayTenants lists tenants with messages in the default partition
+// @FileName		admin_handler.go
+// @Description	List tenants whose messages landed in the default partition because they had no partition yet
+// @Tags			Admin
+// @Produce		json
+// @Success		200	{array}		dto.StrayTenantDto	"Stray tenants"
+// @Failure		500	{object}	fiber.Map			"Internal server error"
+// @Router			/admin/default-partition [get]
+func (h *AdminHandler) GetStrayTenants(c *fiber.Ctx) error {
+	strays, err := h.Reconcile.StrayTenants(c.Context())
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(strays)
+}
+
+// AdoptStrayTenant moves a tenant's rows from the default partition into its own partition
+// @FileName		admin_handler.go
+// @Description	Move a registered tenant's messages out of the default partition into its own partition
+// @Tags			Admin
+// @Produce		json
+// @Param			tenantId	path		string					true	"Tenant ID"
+// @Success		200			{object}	dto.AdoptStrayTenantDto	"Rows moved"
+// @Failure		400			{object}	fiber.Map				"Invalid tenant_id"
+// @Failure		404			{object}	fiber.Map				"Tenant not registered"
+// @Failure		500			{object}	fiber.Map				"Internal server error"
+// @Router			/admin/default-partition/{tenantId}/adopt [post]
+func (h *AdminHandler) AdoptStrayTenant(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("tenantId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	result, err := h.Reconcile.AdoptStrayTenant(c.Context(), tenantID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(result)
+}
+
+// GetChannelPool reports the RabbitMQ publishing channel pool
+// @FileName		admin_handler.go
+// @Description	Report the publishing channel pool: channels in use and idle, channels opened and discarded by health checks, and how long publishes waited for a channel
+// @Tags			Admin
+// @Produce		json
+// @Success		200	{object}	dto.ChannelPoolStatsDto	"Channel pool statistics"
+// @Failure		404	{object}	fiber.Map				"The queue driver has no channel pool"
+// @Router			/admin/broker/channels [get]
+func (h *AdminHandler) GetChannelPool(c *fiber.Ctx) error {
+	reporter, ok := h.Broker.(services.ChannelPoolReporter)
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "the queue driver has no channel pool")
+	}
+
+	return c.JSON(reporter.ChannelPoolStats())
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+	"log"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type BindingHandler struct {
+	Manager *services.TenantManager
+}
+
+// NewBindingHandler constructor
+func NewBindingHandler(manager *services.TenantManager) *BindingHandler {
+	return &BindingHandler{
+		Manager: manager,
+	}
+}
+
+// CreateBinding subscribes a tenant to the event types matching a pattern
+// @FileName		binding_handler.go
+// @Description	Subscribe a tenant to event types. Messages are routed with the key <tenant_id>.<event_type>; * matches one word and # zero or more words.
+// @Tags			Binding
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string					true	"Tenant ID"
+// @Param			body	body		dto.CreateBindingDto	true	"Binding pattern"
+// @Success		201		{object}	models.TenantBinding	"Binding created"
+// @Failure		400		{object}	fiber.Map				"Invalid pattern"
+// @Failure		409		{object}	fiber.Map				"Binding already exists"
+// @Failure		500		{object}	fiber.Map				"Internal server error"
+// @Router			/tenants/{id}/bindings [post]
+func (h *BindingHandler) CreateBinding(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	var req dto.CreateBindingDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+
+	binding, err := h.Manager.AddBinding(c.Context(), tenantID, req.Pattern)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[API] Binding %s added for tenant %s", binding.Pattern, tenantID)
+	return c.Status(fiber.StatusCreated).JSON(binding)
+}
+
+// ListBindings returns the bindings of a tenant
+// @FileName		binding_handler.go
+// @Description	List the event type bindings of a tenant
+// @Tags			Binding
+// @Produce		json
+// @Param			id	path		string					true	"Tenant ID"
+// @Success		200	{array}		models.TenantBinding	"Bindings"
+// @Failure		400	{object}	fiber.Map				"Invalid tenant_id"
+// @Router			/tenants/{id}/bindings [get]
+func (h *BindingHandler) ListBindings(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	bindings, err := h.Manager.ListBindings(c.Context(), tenantID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(bindings)
+}
+
+// DeleteBinding unsubscribes a tenant from a pattern
+// @FileName		binding_handler.go
+// @Description	Remove an event type binding of a tenant. # must be sent as %23.
+// @Tags			Binding
+// @Produce		json
+// @Param			id		path		string		true	"Tenant ID"
+// @Param			pattern	path		string		true	"Binding pattern"
+// @Success		200		{object}	fiber.Map	"Binding removed"
+// @Failure		400		{object}	fiber.Map	"Invalid tenant_id"
+// @Failure		404		{object}	fiber.Map	"Binding not found"
+// @Router			/tenants/{id}/bindings/{pattern} [delete]
+func (h *BindingHandler) DeleteBinding(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+	pattern, err := url.PathUnescape(c.Params("pattern"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid pattern")
+	}
+
+	if err := h.Manager.RemoveBinding(c.Context(), tenantID, pattern); err != nil {
+		return err
+	}
+
+	log.Printf("[API] Binding %s removed for tenant %s", pattern, tenantID)
+	return c.JSON(fiber.Map{
+		"message": "Binding removed",
+	})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type BroadcastHandler struct {
+	Broadcasts *services.BroadcastService
+}
+
+// NewBroadcastHandler constructor
+func NewBroadcastHandler(broadcasts *services.BroadcastService) *BroadcastHandler {
+	return &BroadcastHandler{
+		Broadcasts: broadcasts,
+	}
+}
+
+// Broadcast sends the same message to a group of tenants
+// @FileName		broadcast_handler.go
+// @Description	Send a message to every tenant (target.all), a list of tenants (target.tenant_ids) or the tenants carrying every given label (target.labels). The stored rows share the returned broadcast_id. Broadcasts bypass tenant bindings and schemas.
+// @Tags			Message
+// @Accept			json
+// @Produce		json
+// @Param			body	body		dto.BroadcastDto		true	"Broadcast"
+// @Success		202		{object}	dto.BroadcastResultDto	"Per-tenant results"
+// @Failure		400		{object}	fiber.Map				"Invalid target, event_type or payload"
+// @Failure		413		{object}	fiber.Map				"Payload exceeds MAX_PAYLOAD_BYTES"
+// @Failure		500		{object}	fiber.Map				"Internal server error"
+// @Router			/messages/broadcast [post]
+func (h *BroadcastHandler) Broadcast(c *fiber.Ctx) error {
+	var req dto.BroadcastDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+
+	result, err := h.Broadcasts.Broadcast(c.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(result)
+}
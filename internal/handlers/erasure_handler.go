@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ErasureHandler struct {
+	Erasure *services.ErasureService
+}
+
+// NewErasureHandler constructor
+func NewErasureHandler(erasure *services.ErasureService) *ErasureHandler {
+	return &ErasureHandler{
+		Erasure: erasure,
+	}
+}
+
+// DeleteMessage removes a single message from a tenant
+// @FileName		erasure_handler.go
+// @Description	Delete a single message
+// @Tags			Erasure
+// @Produce		json
+// @Param			id			path		string	true	"Tenant ID"
+// @Param			messageId	path		string	true	"Message ID"
+// @Success		200			{object}	fiber.Map	"Message deleted"
+// @Failure		400			{object}	fiber.Map	"Invalid request"
+// @Failure		404			{object}	fiber.Map	"Message not found"
+// @Router			/tenants/{id}/messages/{messageId} [delete]
+func (h *ErasureHandler) DeleteMessage(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	messageID, err := uuid.Parse(c.Params("messageId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid message_id")
+	}
+
+	if err := h.Erasure.DeleteMessage(c.Context(), tenantID, messageID); err != nil {
+		return err
+	}
+
+	log.Printf("[API] Message %s deleted for tenant %s", messageID, tenantID)
+	return c.JSON(fiber.Map{
+		"message": "Message deleted",
+	})
+}
+
+// StartErasure deletes every message whose payload path equals the given value
+// @FileName		erasure_handler.go
+// @Description	Start a bulk erasure job keyed on a JSONB path/value
+// @Tags			Erasure
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string					true	"Tenant ID"
+// @Param			body	body		dto.ErasureRequestDto	true	"Payload path and value"
+// @Success		202		{object}	models.ErasureReport	"Erasure started"
+// @Failure		400		{object}	fiber.Map				"Invalid request"
+// @Router			/tenants/{id}/erasures [post]
+func (h *ErasureHandler) StartErasure(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	var req dto.ErasureRequestDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+
+	report, err := h.Erasure.StartErasure(tenantID, req)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[API] Erasure %s started for tenant %s", report.ID, tenantID)
+	return c.Status(fiber.StatusAccepted).JSON(report)
+}
+
+// GetErasure returns an erasure report and whether it verifies
+// @FileName		erasure_handler.go
+// @Description	Get an erasure report
+// @Tags			Erasure
+// @Produce		json
+// @Param			id			path		string	true	"Tenant ID"
+// @Param			reportId	path		string	true	"Erasure report ID"
+// @Success		200			{object}	dto.ErasureReportDto	"Erasure report"
+// @Failure		404			{object}	fiber.Map				"Report not found"
+// @Router			/tenants/{id}/erasures/{reportId} [get]
+func (h *ErasureHandler) GetErasure(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	reportID, err := uuid.Parse(c.Params("reportId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid report_id")
+	}
+
+	report, err := h.Erasure.GetErasure(c.Context(), tenantID, reportID.String())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(report)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ExportHandler struct {
+	Exporter *services.ExportService
+}
+
+// NewExportHandler constructor
+func NewExportHandler(exporter *services.ExportService) *ExportHandler {
+	return &ExportHandler{
+		Exporter: exporter,
+	}
+}
+
+// ExportMessages streams a tenant's messages as NDJSON or CSV
+// @FileName		export_handler.go
+// @Description	Stream a tenant's messages as NDJSON or CSV. An export that fails partway ends with an {"error": ...} line in NDJSON, and the connection is closed before the end of the chunked body, so a truncated file can be told apart.
+// @Tags			Message
+// @Produce		application/x-ndjson
+// @Produce		text/csv
+// @Produce		application/gzip
+// @Param			id		path		string	true	"Tenant ID"
+// @Param			format	query		string	false	"ndjson or csv"	Enums(ndjson, csv)	default(ndjson)
+// @Param			from	query		string	false	"Start of the range (RFC3339, inclusive)"
+// @Param			to		query		string	false	"End of the range (RFC3339, exclusive)"
+// @Param			columns	query		string	false	"Comma separated columns: id,tenant_id,payload,created_at"
+// @Param			gzip	query		bool	false	"Gzip the output"
+// @Success		200		{file}		file	"Export stream"
+// @Failure		400		{object}	fiber.Map	"Invalid request"
+// @Router			/tenants/{id}/messages/export [get]
+func (h *ExportHandler) ExportMessages(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	req := dto.ExportMessagesDto{
+		MessageFilter: dto.MessageFilter{TenantID: tenantID.String()},
+		Format:        c.Query("format"),
+		Gzip:          c.QueryBool("gzip"),
+	}
+
+	if req.From, err = parseTimeQuery(c, "from"); err != nil {
+		return err
+	}
+	if req.To, err = parseTimeQuery(c, "to"); err != nil {
+		return err
+	}
+
+	if columns := c.Query("columns"); columns != "" {
+		for _, column := range strings.Split(columns, ",") {
+			req.Columns = append(req.Columns, strings.TrimSpace(column))
+		}
+	}
+
+	if err := h.Exporter.Validate(&req); err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("messages_%s_%s.%s", tenantID, time.Now().UTC().Format("20060102T150405Z"), req.Format)
+	switch {
+	case req.Gzip:
+		filename += ".gz"
+		c.Set(fiber.HeaderContentType, "application/gzip")
+	case req.Format == services.ExportFormatCSV:
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	default:
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	}
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// The body is produced after the handler returns, so failures can only be
+	// logged and signalled in the body
+	reqCtx := c.Context()
+	reqCtx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		// Cancelled on shutdown, or when a write fails because the client left,
+		// which also closes the database cursor
+		ctx, cancel := context.WithCancel(reqCtx)
+		defer cancel()
+
+		if err := h.Exporter.Export(ctx, &exportWriter{Writer: w, cancel: cancel}, req); err != nil {
+			log.Printf("[API] Export for tenant %s aborted: %v", tenantID, err)
+			// Closing the connection before the last chunk makes the response
+			// visibly truncated, whatever the format
+			reqCtx.Conn().Close()
+		}
+	})
+
+	return nil
+}
+
+// exportWriter cancels the export as soon as writing to the client fails
+type exportWriter struct {
+	*bufio.Writer
+	cancel context.CancelFunc
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil {
+		w.cancel()
+	}
+	return n, err
+}
+
+func (w *exportWriter) Flush() error {
+	err := w.Writer.Flush()
+	if err != nil {
+		w.cancel()
+	}
+	return err
+}
+
+// parseTimeQuery reads an optional RFC3339 timestamp from the query string
+func parseTimeQuery(c *fiber.Ctx, key string) (*time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("%s must be an RFC3339 timestamp", key))
+	}
+	return &t, nil
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/internal/services"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type KeyHandler struct {
+	Rotation *services.KeyRotationService
+}
+
+// NewKeyHandler constructor
+func NewKeyHandler(rotation *services.KeyRotationService) *KeyHandler {
+	return &KeyHandler{
+		Rotation: rotation,
+	}
+}
+
+// RotateKey gives the tenant a new data key and re-encrypts its messages
+// @FileName		key_handler.go
+// @Description	Rotate the tenant data key and re-encrypt the tenant partition in the background
+// @Tags			Encryption
+// @Produce		json
+// @Param			id	path		string					true	"Tenant ID"
+// @Success		202	{object}	dto.KeyRotationJobDto	"Rotation started"
+// @Failure		400	{object}	fiber.Map				"Invalid tenant_id"
+// @Failure		409	{object}	fiber.Map				"Encryption is not enabled or a rotation is running"
+// @Router			/tenants/{id}/keys/rotate [post]
+func (h *KeyHandler) RotateKey(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	job, err := h.Rotation.Start(tenantID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[API] Key rotation %s started for tenant %s (key version %d)", job.ID, tenantID, job.KeyVersion)
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// GetRotation returns the progress of a key rotation
+// @FileName		key_handler.go
+// @Description	Get the progress of a key rotation
+// @Tags			Encryption
+// @Produce		json
+// @Param			id		path		string					true	"Tenant ID"
+// @Param			jobId	path		string					true	"Rotation job ID"
+// @Success		200		{object}	dto.KeyRotationJobDto	"Rotation job"
+// @Failure		404		{object}	fiber.Map				"Job not found"
+// @Router			/tenants/{id}/keys/rotations/{jobId} [get]
+func (h *KeyHandler) GetRotation(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	job, err := h.Rotation.Get(tenantID, c.Params("jobId"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(job)
+}
@@ -2,43 +2,60 @@ package handlers
 
 import (
 	"aswadwk/messaging-task-go/internal/services"
+	"context"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type MessageHandler struct {
 	Publisher     *services.PublisherService
 	TenantManager *services.TenantManager
+	Schemas       *services.SchemaService
+	Replies       *services.ReplyQueue
 }
 
 // NewMessageHandler constructor
 func NewMessageHandler(
 	publisher *services.PublisherService,
 	tenantManager *services.TenantManager,
+	schemas *services.SchemaService,
+	replies *services.ReplyQueue,
 ) *MessageHandler {
 	return &MessageHandler{
 		Publisher:     publisher,
 		TenantManager: tenantManager,
+		Schemas:       schemas,
+		Replies:       replies,
 	}
 }
 
 // PublishMessage publishes a message to the specified tenant
 // @FileName		message_handler.go
-// @Description	Publish a message to a tenant
+// @Description	Publish a message to a tenant. With wait, the request blocks until the tenant pipeline answers it with a reply step, at most 30s.
 // @Tags			Message
 // @Accept			json
 // @Produce		json
 // @Param			body	body		dto.NewMessageDto	true	"Request body"	Example
+// @Param			wait	query		string				false	"Wait for the reply, e.g. 5s"
+// @Success		200	{object}	services.Reply	"Reply of the tenant pipeline"
 // @Success		202	{object}	fiber.Map	"Message published"
-// @Failure		400	{object}	fiber.Map	"Invalid request"
+// @Failure		400	{object}	fiber.Map	"Invalid request, wait, event_type, payload does not match the tenant schema, or wait on a pipeline without a reply step"
+// @Failure		404	{object}	fiber.Map	"Tenant not found (with wait)"
+// @Failure		413	{object}	fiber.Map	"Payload exceeds MAX_PAYLOAD_BYTES"
 // @Failure		500	{object}	fiber.Map	"Internal server error"
+// @Failure		502	{object}	fiber.Map	"The tenant pipeline dead-lettered the request"
+// @Failure		504	{object}	fiber.Map	"No reply within wait"
 // @Router			/messages [post]
 func (h *MessageHandler) PublishMessage(ctx *fiber.Ctx) error {
 	type payload struct {
-		TenantID string         `json:"tenant_id"`
-		Payload  map[string]any `json:"payload"`
+		TenantID    string         `json:"tenant_id"`
+		EventType   string         `json:"event_type"`
+		Payload     map[string]any `json:"payload"`
+		OrderingKey string         `json:"ordering_key"`
 	}
 	var p payload
 	if err := ctx.BodyParser(&p); err != nil {
@@ -52,22 +69,74 @@ func (h *MessageHandler) PublishMessage(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "payload cannot be empty")
 	}
 
+	var wait time.Duration
+	if raw := ctx.Query("wait"); raw != "" {
+		var err error
+		wait, err = time.ParseDuration(raw)
+		if err != nil || wait <= 0 || wait > services.MaxReplyWait {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("wait must be a duration up to %s, e.g. 5s", services.MaxReplyWait))
+		}
+		if h.Replies == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "waiting for replies is not enabled")
+		}
+
+		tenantID, err := uuid.Parse(p.TenantID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+		}
+		// Fail now instead of waiting for a reply that never comes
+		replies, err := h.TenantManager.HasReplyStep(tenantID)
+		if err != nil {
+			return err
+		}
+		if !replies {
+			return fiber.NewError(fiber.StatusBadRequest, "tenant pipeline has no reply step")
+		}
+	}
+
+	// Validate against the tenant's active schema, if it registered one
+	schemaVersion, err := h.Schemas.Validate(ctx.Context(), p.TenantID, p.Payload)
+	if err != nil {
+		return err
+	}
+
+	if p.EventType == "" {
+		p.EventType = services.DefaultEventType
+	}
+
 	msg := services.Message{
-		TenantID: p.TenantID,
-		Payload:  p.Payload,
+		TenantID:      p.TenantID,
+		EventType:     p.EventType,
+		Payload:       p.Payload,
+		SchemaVersion: schemaVersion,
+		OrderingKey:   p.OrderingKey,
 	}
 
-	queueName := fmt.Sprintf("tenant_%s_queue", p.TenantID)
+	if wait > 0 {
+		// Ends early when the server shuts down
+		callCtx, cancel := context.WithTimeout(ctx.Context(), wait)
+		defer cancel()
+		reply, err := h.Replies.Call(callCtx, func(replyTo, correlationID string) error {
+			return h.Publisher.PublishRequest(msg, replyTo, correlationID)
+		})
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(reply)
+	}
 
-	err := h.Publisher.Publish(queueName, msg)
+	// Routed through the topic exchange to the tenant queue by its bindings
+	err = h.Publisher.PublishEvent(msg)
 	if err != nil {
 		return err
 	}
 
 	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{
-		"message": "Message published successfully",
-		"tenant":  p.TenantID,
-		"payload": p.Payload,
+		"message":        "Message published successfully",
+		"tenant":         p.TenantID,
+		"event_type":     p.EventType,
+		"payload":        p.Payload,
+		"schema_version": schemaVersion,
 	})
 }
 
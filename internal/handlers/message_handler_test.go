@@ -28,11 +28,14 @@ func setupMessageTestApp() (*fiber.App, *MessageHandler) {
 
 	// Initialize dependencies using existing config
 	db := config.DBConnect()
-	messageRepo := repositories.NewMessageRepository(db)
+	messageRepo := repositories.NewMessageRepository(db, nil)
 	rabbitService := services.NewRabbitMQ(config.Cfg.RabbitMQURL)
-	tenantManager := services.NewTenantManager(rabbitService, messageRepo)
+	tenantRepo := repositories.NewTenantRepository(db)
+	bindingRepo := repositories.NewBindingRepository(db)
+	tenantManager := services.NewTenantManager(rabbitService, messageRepo, tenantRepo, bindingRepo)
 	publisherService := services.NewPublisherService(rabbitService)
-	messageHandler := NewMessageHandler(publisherService, tenantManager)
+	schemaService := services.NewSchemaService(repositories.NewSchemaRepository(db))
+	messageHandler := NewMessageHandler(publisherService, tenantManager, schemaService, nil)
 
 	app := fiber.New(fiber.Config{
 		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type PipelineHandler struct {
+	Manager *services.TenantManager
+}
+
+// NewPipelineHandler constructor
+func NewPipelineHandler(manager *services.TenantManager) *PipelineHandler {
+	return &PipelineHandler{
+		Manager: manager,
+	}
+}
+
+// GetPipeline returns the processing steps of a tenant
+// @FileName		pipeline_handler.go
+// @Description	Get the steps the tenant consumer runs on every message
+// @Tags			Pipeline
+// @Produce		json
+// @Param			id	path		string				true	"Tenant ID"
+// @Success		200	{object}	dto.UpdatePipelineDto	"Pipeline"
+// @Failure		400	{object}	fiber.Map			"Invalid tenant_id"
+// @Failure		404	{object}	fiber.Map			"Tenant not found"
+// @Router			/tenants/{id}/pipeline [get]
+func (h *PipelineHandler) GetPipeline(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	steps, err := h.Manager.GetPipeline(c.Context(), tenantID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(dto.UpdatePipelineDto{Steps: steps})
+}
+
+// UpdatePipeline replaces the processing steps of a tenant
+// @FileName		pipeline_handler.go
+// @Description	Replace the steps run on every message: validate, transform, store, forward and drop, in order. Retryable failures are retried with backoff, other failures go to the tenant dead-letter queue. An empty list restores the default pipeline (store).
+// @Tags			Pipeline
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string				true	"Tenant ID"
+// @Param			body	body		dto.UpdatePipelineDto	true	"Pipeline steps"	Example({"steps": [{"type": "validate"}, {"type": "drop", "event_types": "debug.#"}, {"type": "store"}]})
+// @Success		200		{object}	fiber.Map			"Pipeline updated"
+// @Failure		400		{object}	fiber.Map			"Invalid pipeline"
+// @Failure		404		{object}	fiber.Map			"Tenant not found"
+// @Router			/tenants/{id}/pipeline [put]
+func (h *PipelineHandler) UpdatePipeline(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	var req dto.UpdatePipelineDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+
+	if err := h.Manager.SetPipeline(c.Context(), tenantID, req.Steps); err != nil {
+		return err
+	}
+
+	log.Printf("[API] Tenant %s pipeline updated with %d step(s)", tenantID, len(req.Steps))
+	return c.JSON(fiber.Map{
+		"message": "Pipeline updated",
+		"steps":   req.Steps,
+	})
+}
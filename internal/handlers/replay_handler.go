@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ReplayHandler struct {
+	Replay *services.ReplayService
+}
+
+// NewReplayHandler constructor
+func NewReplayHandler(replay *services.ReplayService) *ReplayHandler {
+	return &ReplayHandler{
+		Replay: replay,
+	}
+}
+
+// StartReplay republishes stored messages back into the tenant queue
+// @FileName		replay_handler.go
+// @Description	Replay stored messages back into the tenant queue
+// @Tags			Replay
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string				true	"Tenant ID"
+// @Param			body	body		dto.ReplayRequestDto	true	"Time range, payload filter and rate"
+// @Success		202		{object}	dto.ReplayJobDto	"Replay started"
+// @Failure		400		{object}	fiber.Map			"Invalid request"
+// @Failure		500		{object}	fiber.Map			"Internal server error"
+// @Router			/tenants/{id}/replay [post]
+func (h *ReplayHandler) StartReplay(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	var req dto.ReplayRequestDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+
+	job, err := h.Replay.Start(tenantID, req)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[API] Replay %s started for tenant %s (%d messages)", job.ID, tenantID, job.Total)
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// GetReplay returns the progress of a replay job
+// @FileName		replay_handler.go
+// @Description	Get the progress of a replay job
+// @Tags			Replay
+// @Produce		json
+// @Param			id		path		string	true	"Tenant ID"
+// @Param			jobId	path		string	true	"Replay job ID"
+// @Success		200		{object}	dto.ReplayJobDto	"Replay job"
+// @Failure		404		{object}	fiber.Map			"Job not found"
+// @Router			/tenants/{id}/replay/{jobId} [get]
+func (h *ReplayHandler) GetReplay(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	job, err := h.Replay.Get(tenantID, c.Params("jobId"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(job)
+}
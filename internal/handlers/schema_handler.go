@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type SchemaHandler struct {
+	Schemas *services.SchemaService
+}
+
+// NewSchemaHandler constructor
+func NewSchemaHandler(schemas *services.SchemaService) *SchemaHandler {
+	return &SchemaHandler{
+		Schemas: schemas,
+	}
+}
+
+// RegisterSchema stores a new JSON Schema version for a tenant
+// @FileName		schema_handler.go
+// @Description	Register a new JSON Schema version for a tenant. The new version becomes active unless activate is false.
+// @Tags			Schema
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string					true	"Tenant ID"
+// @Param			body	body		dto.RegisterSchemaDto	true	"JSON Schema"
+// @Success		201		{object}	models.TenantSchema		"Schema registered"
+// @Failure		400		{object}	fiber.Map				"Invalid schema"
+// @Failure		500		{object}	fiber.Map				"Internal server error"
+// @Router			/tenants/{id}/schemas [post]
+func (h *SchemaHandler) RegisterSchema(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	var req dto.RegisterSchemaDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+
+	schema, err := h.Schemas.Register(c.Context(), tenantID, req)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[API] Schema v%d registered for tenant %s (active=%t)", schema.Version, tenantID, schema.Active)
+	return c.Status(fiber.StatusCreated).JSON(schema)
+}
+
+// ListSchemas returns every schema version of a tenant
+// @FileName		schema_handler.go
+// @Description	List the JSON Schema versions of a tenant
+// @Tags			Schema
+// @Produce		json
+// @Param			id	path		string					true	"Tenant ID"
+// @Success		200	{array}		models.TenantSchema		"Schemas"
+// @Failure		400	{object}	fiber.Map				"Invalid tenant_id"
+// @Router			/tenants/{id}/schemas [get]
+func (h *SchemaHandler) ListSchemas(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	schemas, err := h.Schemas.List(c.Context(), tenantID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(schemas)
+}
+
+// GetSchema returns a single schema version
+// @FileName		schema_handler.go
+// @Description	Get a JSON Schema version of a tenant
+// @Tags			Schema
+// @Produce		json
+// @Param			id		path		string				true	"Tenant ID"
+// @Param			version	path		int					true	"Schema version"
+// @Success		200		{object}	models.TenantSchema	"Schema"
+// @Failure		404		{object}	fiber.Map			"Schema not found"
+// @Router			/tenants/{id}/schemas/{version} [get]
+func (h *SchemaHandler) GetSchema(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	version, err := c.ParamsInt("version")
+	if err != nil || version <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "version must be a positive integer")
+	}
+
+	schema, err := h.Schemas.Get(c.Context(), tenantID, version)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(schema)
+}
+
+// ActivateSchema makes an existing version the active one
+// @FileName		schema_handler.go
+// @Description	Validate new messages against this schema version
+// @Tags			Schema
+// @Produce		json
+// @Param			id		path		string				true	"Tenant ID"
+// @Param			version	path		int					true	"Schema version"
+// @Success		200		{object}	models.TenantSchema	"Schema activated"
+// @Failure		404		{object}	fiber.Map			"Schema not found"
+// @Router			/tenants/{id}/schemas/{version}/activate [put]
+func (h *SchemaHandler) ActivateSchema(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	version, err := c.ParamsInt("version")
+	if err != nil || version <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "version must be a positive integer")
+	}
+
+	schema, err := h.Schemas.Activate(c.Context(), tenantID, version)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[API] Schema v%d activated for tenant %s", version, tenantID)
+	return c.JSON(schema)
+}
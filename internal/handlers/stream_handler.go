@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/services"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type StreamHandler struct {
+	Stream    *services.MessageStream
+	websocket fiber.Handler
+}
+
+// NewStreamHandler constructor
+func NewStreamHandler(stream *services.MessageStream) *StreamHandler {
+	h := &StreamHandler{
+		Stream: stream,
+	}
+	h.websocket = websocket.New(h.followWebSocket)
+	return h
+}
+
+// StreamMessages pushes a tenant's messages as they are stored
+// @FileName		stream_handler.go
+// @Description	Server-Sent Events stream of the tenant messages, one "message" event per stored message with the message seq, its commit order in the tenant, as event ID. Send Last-Event-ID (or last_event_id) to first receive the messages stored after it; message IDs sent as event IDs by earlier versions are still accepted. The same URL upgrades to a WebSocket that sends each message as a JSON text frame.
+// @Tags			Message
+// @Produce		text/event-stream
+// @Param			id				path		string	true	"Tenant ID"
+// @Param			Last-Event-ID	header		string	false	"Seq of the last message received"
+// @Param			last_event_id	query		string	false	"Seq of the last message received, for clients that cannot set headers"
+// @Success		200				{string}	string	"Event stream"
+// @Failure		400				{object}	fiber.Map	"Invalid tenant_id or last event ID"
+// @Router			/tenants/{id}/stream [get]
+func (h *StreamHandler) StreamMessages(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+	lastSeq, err := h.Stream.LastSeq(c.Context(), tenantID.String(), c.Get("Last-Event-ID", c.Query("last_event_id")))
+	if err != nil {
+		return err
+	}
+
+	if websocket.IsWebSocketUpgrade(c) {
+		c.Locals("tenant_id", tenantID.String())
+		c.Locals("last_seq", lastSeq)
+		return h.websocket(c)
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		log.Printf("[Stream] SSE client following tenant %s", tenantID)
+		// The client is gone once a write or flush fails
+		send := func(message models.Message) error {
+			data, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", message.Seq, data)
+			return w.Flush()
+		}
+		ping := func() error {
+			fmt.Fprint(w, ": ping\n\n")
+			return w.Flush()
+		}
+
+		// Tell the client right away that the stream is open
+		if err := ping(); err != nil {
+			return
+		}
+		err := h.Stream.Follow(context.Background(), tenantID.String(), lastSeq, send, ping)
+		if errors.Is(err, services.ErrStreamLagged) {
+			// EventSource reconnects with the last event ID and catches up
+			fmt.Fprint(w, "event: lagged\ndata: {}\n\n")
+			w.Flush()
+		}
+		log.Printf("[Stream] SSE client of tenant %s left: %v", tenantID, err)
+	})
+	return nil
+}
+
+// followWebSocket sends each message as a JSON text frame until the client
+// closes the connection
+func (h *StreamHandler) followWebSocket(conn *websocket.Conn) {
+	tenantID, _ := conn.Locals("tenant_id").(string)
+	lastSeq, _ := conn.Locals("last_seq").(int64)
+	log.Printf("[Stream] WebSocket client following tenant %s", tenantID)
+
+	// Reading notices the close frame and answers pings; clients send nothing else
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(message models.Message) error {
+		return conn.WriteJSON(message)
+	}
+	ping := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second))
+	}
+
+	err := h.Stream.Follow(ctx, tenantID, lastSeq, send, ping)
+	code, reason := websocket.CloseNormalClosure, ""
+	if errors.Is(err, services.ErrStreamLagged) {
+		code, reason = websocket.CloseTryAgainLater, "lagged, reconnect with last_event_id"
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	conn.Close()
+	log.Printf("[Stream] WebSocket client of tenant %s left: %v", tenantID, err)
+}
@@ -30,7 +30,7 @@ func NewTenantHandler(manager *services.TenantManager) *TenantHandler {
 // @Produce		json
 // @Param			body	body		dto.CreateConsumerDto	true	"Request body"	Example
 // @Success		201	{object}	fiber.Map	"Tenant created"
-// @Failure		400	{object}	fiber.Map	"Invalid request"
+// @Failure		400	{object}	fiber.Map	"Invalid request or queue options"
 // @Failure		500	{object}	fiber.Map	"Internal server error"
 // @Router			/tenants [post]
 func (h *TenantHandler) CreateTenant(c *fiber.Ctx) error {
@@ -47,12 +47,39 @@ func (h *TenantHandler) CreateTenant(c *fiber.Ctx) error {
 	if createDto.Workers <= 0 {
 		createDto.Workers = 3 // default
 	}
+	if createDto.Queue != nil {
+		if err := h.Manager.ValidateQueueOptions(*createDto.Queue); err != nil {
+			return err
+		}
+	}
+
+	// Register the tenant first so a failure below is visible to reconcile
+	if err := h.Manager.SaveTenant(c.Context(), tenantID, createDto.Workers); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	// Stored before the consumer starts, it declares the queue with them
+	if createDto.Queue != nil {
+		if err := h.Manager.SetQueueOptions(c.Context(), tenantID, *createDto.Queue); err != nil {
+			return err
+		}
+	}
+	if len(createDto.Labels) > 0 {
+		if err := h.Manager.SetLabels(c.Context(), tenantID, createDto.Labels); err != nil {
+			return err
+		}
+	}
 
 	// Create partition for tenant
 	if err := h.Manager.CreatePartition(tenantID); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
+	// New tenants receive every event type until they narrow their bindings
+	if err := h.Manager.AddDefaultBinding(c.Context(), tenantID); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
 	if err := h.Manager.StartTenantConsumer(context.Background(), tenantID, createDto.Workers); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
@@ -85,6 +112,10 @@ func (h *TenantHandler) DeleteTenant(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
+	if err := h.Manager.RemoveTenant(c.Context(), tenantID); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
 	log.Printf("[API] Tenant deleted: %s", tenantID)
 	return c.JSON(fiber.Map{
 		"message": "Tenant stopped",
@@ -131,8 +162,46 @@ func (h *TenantHandler) UpdateConcurrency(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
+	if err := h.Manager.SaveTenant(c.Context(), tenantID, req.Workers); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
 	log.Printf("[API] Tenant %s concurrency updated to %d", tenantID, req.Workers)
 	return c.JSON(fiber.Map{
 		"message": "Concurrency updated",
 	})
 }
+
+// UpdateLabels replaces the labels used to target the tenant in broadcasts
+// @FileName		tenant_handler.go
+// @Description	Replace the labels of a tenant
+// @Tags			Tenant
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string				true	"Tenant ID"
+// @Param			body	body		dto.UpdateLabelsDto	true	"Labels"	Example({"labels": {"tier": "gold"}})
+// @Success		200		{object}	fiber.Map			"Labels updated"
+// @Failure		400		{object}	fiber.Map			"Invalid request"
+// @Failure		404		{object}	fiber.Map			"Tenant not found"
+// @Router			/tenants/{id}/labels [put]
+func (h *TenantHandler) UpdateLabels(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	var req dto.UpdateLabelsDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+
+	if err := h.Manager.SetLabels(c.Context(), tenantID, req.Labels); err != nil {
+		return err
+	}
+
+	log.Printf("[API] Tenant %s labels updated", tenantID)
+	return c.JSON(fiber.Map{
+		"message": "Labels updated",
+		"labels":  req.Labels,
+	})
+}
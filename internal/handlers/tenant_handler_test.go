@@ -31,9 +31,11 @@ func setupTestApp() (*fiber.App, *TenantHandler) {
 
 	// Initialize dependencies using existing config
 	db := config.DBConnect()
-	messageRepo := repositories.NewMessageRepository(db)
+	messageRepo := repositories.NewMessageRepository(db, nil)
 	rabbitService := services.NewRabbitMQ(config.Cfg.RabbitMQURL)
-	tenantManager := services.NewTenantManager(rabbitService, messageRepo)
+	tenantRepo := repositories.NewTenantRepository(db)
+	bindingRepo := repositories.NewBindingRepository(db)
+	tenantManager := services.NewTenantManager(rabbitService, messageRepo, tenantRepo, bindingRepo)
 	tenantHandler := NewTenantHandler(tenantManager)
 
 	// Setup Fiber app
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type UsageHandler struct {
+	Usage *services.UsageService
+}
+
+// NewUsageHandler constructor
+func NewUsageHandler(usage *services.UsageService) *UsageHandler {
+	return &UsageHandler{
+		Usage: usage,
+	}
+}
+
+// GetStats returns a tenant's message volume as a time series
+// @FileName		usage_handler.go
+// @Description	Published, stored, failed and dead-lettered counts per hour or day, read from the usage rollups. Defaults to the last 24 hours.
+// @Tags			Tenant
+// @Produce		json
+// @Param			id		path		string				true	"Tenant ID"
+// @Param			from	query		string				false	"Start of the range (RFC3339, inclusive)"
+// @Param			to		query		string				false	"End of the range (RFC3339)"
+// @Param			bucket	query		string				false	"Bucket size"	Enums(hour, day)	default(hour)
+// @Success		200		{object}	dto.UsageStatsDto	"Usage statistics"
+// @Failure		400		{object}	fiber.Map			"Invalid request"
+// @Failure		500		{object}	fiber.Map			"Internal server error"
+// @Router			/tenants/{id}/stats [get]
+func (h *UsageHandler) GetStats(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	query := dto.UsageQueryDto{Bucket: c.Query("bucket")}
+	if query.From, err = parseTimeQuery(c, "from"); err != nil {
+		return err
+	}
+	if query.To, err = parseTimeQuery(c, "to"); err != nil {
+		return err
+	}
+
+	stats, err := h.Usage.Stats(c.Context(), tenantID, query)
+	if err != nil {
+		return err
+	}
+	return c.JSON(stats)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/services"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type WebhookHandler struct {
+	Webhooks *services.WebhookService
+}
+
+// NewWebhookHandler constructor
+func NewWebhookHandler(webhooks *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		Webhooks: webhooks,
+	}
+}
+
+// CreateWebhook registers an endpoint that receives the tenant messages
+// @FileName		webhook_handler.go
+// @Description	Register a webhook. Every matching message is POSTed as JSON with the headers X-Webhook-Id, X-Webhook-Timestamp and X-Webhook-Signature (sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret). The secret is only returned here.
+// @Tags			Webhook
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string					true	"Tenant ID"
+// @Param			body	body		dto.CreateWebhookDto	true	"Webhook"	Example({"url": "https://example.com/hooks/messages", "event_types": "orders.*"})
+// @Success		201		{object}	models.TenantWebhook	"Webhook created"
+// @Failure		400		{object}	fiber.Map				"Invalid url or event_types"
+// @Failure		500		{object}	fiber.Map				"Internal server error"
+// @Router			/tenants/{id}/webhooks [post]
+func (h *WebhookHandler) CreateWebhook(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	var req dto.CreateWebhookDto
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid JSON")
+	}
+
+	hook, err := h.Webhooks.Register(c.Context(), tenantID, req)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[API] Webhook %s added for tenant %s", hook.ID, tenantID)
+	return c.Status(fiber.StatusCreated).JSON(hook)
+}
+
+// ListWebhooks returns the webhooks of a tenant
+// @FileName		webhook_handler.go
+// @Description	List the webhooks of a tenant, without their secrets
+// @Tags			Webhook
+// @Produce		json
+// @Param			id	path		string					true	"Tenant ID"
+// @Success		200	{array}		models.TenantWebhook	"Webhooks"
+// @Failure		400	{object}	fiber.Map				"Invalid tenant_id"
+// @Router			/tenants/{id}/webhooks [get]
+func (h *WebhookHandler) ListWebhooks(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	hooks, err := h.Webhooks.List(c.Context(), tenantID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(hooks)
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+// @FileName		webhook_handler.go
+// @Description	Remove a webhook of a tenant together with its delivery log
+// @Tags			Webhook
+// @Produce		json
+// @Param			id		path		string		true	"Tenant ID"
+// @Param			hookId	path		string		true	"Webhook ID"
+// @Success		200		{object}	fiber.Map	"Webhook removed"
+// @Failure		400		{object}	fiber.Map	"Invalid tenant_id"
+// @Failure		404		{object}	fiber.Map	"Webhook not found"
+// @Router			/tenants/{id}/webhooks/{hookId} [delete]
+func (h *WebhookHandler) DeleteWebhook(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+	hookID := c.Params("hookId")
+	if _, err := uuid.Parse(hookID); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid webhook id")
+	}
+
+	if err := h.Webhooks.Delete(c.Context(), tenantID, hookID); err != nil {
+		return err
+	}
+
+	log.Printf("[API] Webhook %s removed for tenant %s", hookID, tenantID)
+	return c.JSON(fiber.Map{
+		"message": "Webhook removed",
+	})
+}
+
+// ListDeliveries returns the delivery log of a webhook
+// @FileName		webhook_handler.go
+// @Description	Newest deliveries of a webhook with every attempt, its status code and error
+// @Tags			Webhook
+// @Produce		json
+// @Param			id		path		string						true	"Tenant ID"
+// @Param			hookId	path		string						true	"Webhook ID"
+// @Param			status	query		string						false	"Delivery status"	Enums(pending, delivered, failed)
+// @Param			limit	query		int							false	"Maximum deliveries"	default(50)
+// @Success		200		{array}		models.WebhookDelivery		"Deliveries"
+// @Failure		400		{object}	fiber.Map					"Invalid request"
+// @Failure		404		{object}	fiber.Map					"Webhook not found"
+// @Router			/tenants/{id}/webhooks/{hookId}/deliveries [get]
+func (h *WebhookHandler) ListDeliveries(c *fiber.Ctx) error {
+	tenantID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	var query dto.WebhookDeliveryQueryDto
+	if err := c.QueryParser(&query); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid query")
+	}
+
+	deliveries, err := h.Webhooks.Deliveries(c.Context(), tenantID, c.Params("hookId"), query)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(deliveries)
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErasureBatch is one link in the hash chain of an erasure report
+type ErasureBatch struct {
+	Seq        int       `json:"seq"`
+	MessageIDs []string  `json:"message_ids"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+type ErasureBatches []ErasureBatch
+
+func (b *ErasureBatches) Scan(value any) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to scan erasure batches")
+	}
+
+	return json.Unmarshal(bytes, b)
+}
+
+func (b ErasureBatches) Value() (driver.Value, error) {
+	if b == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(b)
+}
+
+type ErasureReport struct {
+	ID           string         `json:"id"`
+	TenantID     string         `json:"tenant_id"`
+	JSONPath     string         `json:"json_path"`
+	ValueHash    string         `json:"value_hash"`
+	Status       string         `json:"status"`
+	DeletedCount int64          `json:"deleted_count"`
+	Batches      ErasureBatches `json:"batches"`
+	ChainHash    string         `json:"chain_hash"`
+	Signature    string         `json:"signature"`
+	Error        string         `json:"error,omitempty"`
+	RequestedAt  time.Time      `json:"requested_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	CompletedAt  *time.Time     `json:"completed_at"`
+}
@@ -24,8 +24,17 @@ func (j JSONB) Value() (driver.Value, error) {
 }
 
 type Message struct {
-	ID        string    `json:"id"`
-	TenantID  string    `json:"tenant_id"`
-	Payload   JSONB     `json:"payload"`
-	CreatedAt time.Time `json:"created_at"`
+	ID       string `json:"id"`
+	TenantID string `json:"tenant_id"`
+	Payload  JSONB  `json:"payload"`
+	// SchemaVersion is the tenant schema the payload was validated against
+	SchemaVersion *int   `json:"schema_version"`
+	EventType     string `json:"event_type"`
+	// Binding is the tenant binding pattern the event type matched
+	Binding string `json:"binding"`
+	// BroadcastID links the rows stored from one broadcast
+	BroadcastID *string   `json:"broadcast_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Seq numbers the tenant's rows in commit order; streams resume from it
+	Seq int64 `json:"seq" gorm:"<-:create"`
 }
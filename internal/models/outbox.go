@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	// OutboxStatusFailed parks a row that kept failing, so it no longer holds up the relay
+	OutboxStatusFailed = "failed"
+)
+
+// OutboxMessage is a publish request waiting to be relayed to the broker
+type OutboxMessage struct {
+	ID       string `json:"id"`
+	Exchange string `json:"exchange"`
+	// QueueName is the routing key: the queue name on the default exchange
+	QueueName string     `json:"queue_name"`
+	Body      JSONB      `json:"body"`
+	Headers   JSONB      `json:"headers"`
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at"`
+
+	// LockedUntil hides a claimed row from other relays while it is published
+	LockedUntil *time.Time `json:"locked_until"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Queue is a queue of the Postgres queue backend
+type Queue struct {
+	Name      string    `json:"name" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is pushed back while the queue has a consumer, see QueueOptions.Expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// QueueMessage is a message waiting in a Postgres queue
+type QueueMessage struct {
+	ID              int64      `json:"id" gorm:"primaryKey"`
+	QueueName       string     `json:"queue_name"`
+	ContentType     string     `json:"content_type"`
+	ContentEncoding string     `json:"content_encoding"`
+	MessageID       string     `json:"message_id"`
+	Headers         JSONB      `json:"headers"`
+	Body            []byte     `json:"body"`
+	ReplyTo         string     `json:"reply_to"`
+	CorrelationID   string     `json:"correlation_id"`
+	Deliveries      int        `json:"deliveries"`
+	LockedUntil     *time.Time `json:"locked_until"`
+	CreatedAt       time.Time  `json:"created_at"`
+
+	// AvailableAt delays the first delivery, e.g. of a pipeline retry
+	AvailableAt *time.Time `json:"available_at"`
+}
+
+// QueueBinding routes topic messages of the Postgres queue backend to a queue
+type QueueBinding struct {
+	QueueName string `json:"queue_name" gorm:"primaryKey"`
+	Exchange  string `json:"exchange" gorm:"primaryKey"`
+	Pattern   string `json:"pattern" gorm:"primaryKey"`
+	// Regex matches "." + routing key
+	Regex string `json:"regex"`
+}
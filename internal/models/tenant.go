@@ -0,0 +1,89 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type Tenant struct {
+	ID           string       `json:"id"`
+	Workers      int          `json:"workers"`
+	Labels       JSONB        `json:"labels"`
+	QueueOptions QueueOptions `json:"queue_options"`
+	Pipeline     Pipeline     `json:"pipeline"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// QueueOptions are the arguments of a tenant queue, encoded with the names of
+// the RabbitMQ x-arguments. Zero values are left to the broker defaults.
+type QueueOptions struct {
+	// QueueType is classic or quorum
+	QueueType      string `json:"x-queue-type,omitempty"`
+	MaxLength      int64  `json:"x-max-length,omitempty"`
+	MaxLengthBytes int64  `json:"x-max-length-bytes,omitempty"`
+	// Overflow is drop-head, reject-publish or reject-publish-dlx
+	Overflow             string `json:"x-overflow,omitempty"`
+	SingleActiveConsumer bool   `json:"x-single-active-consumer,omitempty"`
+	// QueueMode is default or lazy, classic queues only
+	QueueMode string `json:"x-queue-mode,omitempty"`
+
+	// Expires deletes the queue once it has had no consumer for that long.
+	// It is set for queues of our own, like the reply queues, and is never
+	// taken from tenants or stored.
+	Expires time.Duration `json:"-"`
+}
+
+func (o *QueueOptions) Scan(value any) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to scan queue options")
+	}
+
+	return json.Unmarshal(bytes, o)
+}
+
+func (o QueueOptions) Value() (driver.Value, error) {
+	return json.Marshal(o)
+}
+
+// PipelineStep configures one step of a tenant's message pipeline. Type is
+// validate, transform, store, forward, drop, webhook or reply; the other fields
+// belong to the step type named in their comment.
+type PipelineStep struct {
+	Type string `json:"type"`
+	// transform: Rename moves fields, Remove deletes them and Set assigns
+	// values, applied in that order. Keys are dot separated payload paths.
+	Rename map[string]string `json:"rename,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+	Set    map[string]any    `json:"set,omitempty"`
+	// forward: TenantID receives a copy, as EventType or the original event type
+	TenantID  string `json:"tenant_id,omitempty"`
+	EventType string `json:"event_type,omitempty"`
+	// drop: EventTypes is a binding pattern and Match holds payload path
+	// values; a step without either drops every message
+	EventTypes string         `json:"event_types,omitempty"`
+	Match      map[string]any `json:"match,omitempty"`
+}
+
+// Pipeline is the ordered list of steps a tenant runs on every message
+type Pipeline []PipelineStep
+
+func (p *Pipeline) Scan(value any) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to scan pipeline")
+	}
+
+	return json.Unmarshal(bytes, p)
+}
+
+func (p Pipeline) Value() (driver.Value, error) {
+	if p == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(p)
+}
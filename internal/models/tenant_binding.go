@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// TenantBinding subscribes a tenant to the event types matching Pattern
+type TenantBinding struct {
+	TenantID  string    `json:"tenant_id" gorm:"primaryKey"`
+	Pattern   string    `json:"pattern" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// TenantKey is a tenant data key, stored wrapped by the master key
+type TenantKey struct {
+	TenantID   string    `json:"tenant_id"`
+	Version    int       `json:"version"`
+	WrappedKey []byte    `json:"-"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type TenantSchema struct {
+	TenantID  string    `json:"tenant_id"`
+	Version   int       `json:"version"`
+	Schema    JSONB     `json:"schema"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
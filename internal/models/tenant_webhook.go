@@ -0,0 +1,71 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TenantWebhook is an endpoint that receives the tenant's messages
+type TenantWebhook struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenant_id"`
+	URL      string `json:"url"`
+	// EventTypes is a binding pattern selecting the messages sent to the hook
+	EventTypes string `json:"event_types"`
+	// Secret signs the deliveries; it is only returned when the hook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one message sent to one webhook, with every attempt made
+type WebhookDelivery struct {
+	ID            string          `json:"id"`
+	WebhookID     string          `json:"webhook_id"`
+	TenantID      string          `json:"tenant_id"`
+	EventType     string          `json:"event_type"`
+	Payload       JSONB           `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	AttemptLog    WebhookAttempts `json:"attempt_log"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at"`
+
+	Webhook TenantWebhook `json:"-" gorm:"foreignKey:WebhookID"`
+}
+
+// WebhookAttempt records one POST of a delivery
+type WebhookAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	At         time.Time `json:"at"`
+}
+
+type WebhookAttempts []WebhookAttempt
+
+func (a *WebhookAttempts) Scan(value any) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to scan webhook attempts")
+	}
+
+	return json.Unmarshal(bytes, a)
+}
+
+func (a WebhookAttempts) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(a)
+}
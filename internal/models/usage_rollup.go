@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// UsageRollup holds the message counters of a tenant for one hour
+type UsageRollup struct {
+	TenantID       string    `json:"tenant_id"`
+	Bucket         time.Time `json:"bucket"`
+	Published      int64     `json:"published"`
+	PublishedBytes int64     `json:"published_bytes"`
+	Stored         int64     `json:"stored"`
+	StoredBytes    int64     `json:"stored_bytes"`
+	Failed         int64     `json:"failed"`
+	DeadLettered   int64     `json:"dead_lettered"`
+}
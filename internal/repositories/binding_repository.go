@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BindingRepository interface {
+	List(ctx context.Context, tenantID string) ([]models.TenantBinding, error)
+	Create(ctx context.Context, binding *models.TenantBinding) (bool, error)
+	Delete(ctx context.Context, tenantID, pattern string) (bool, error)
+	DeleteAll(ctx context.Context, tenantID string) error
+}
+
+type bindingRepository struct {
+	db *gorm.DB
+}
+
+func NewBindingRepository(db *gorm.DB) BindingRepository {
+	return &bindingRepository{
+		db: db,
+	}
+}
+
+// List implements BindingRepository.
+// Bindings are returned in the order they were created.
+func (r *bindingRepository) List(ctx context.Context, tenantID string) ([]models.TenantBinding, error) {
+	var bindings []models.TenantBinding
+	if err := r.db.WithContext(ctx).Where("tenant_id = ?", tenantID).
+		Order("created_at, pattern").Find(&bindings).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving bindings: %w", err)
+	}
+	return bindings, nil
+}
+
+// Create implements BindingRepository.
+// Returns false when the tenant already has the binding.
+func (r *bindingRepository) Create(ctx context.Context, binding *models.TenantBinding) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(binding)
+	if result.Error != nil {
+		return false, fmt.Errorf("error saving binding: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Delete implements BindingRepository.
+// Returns false when the tenant has no such binding.
+func (r *bindingRepository) Delete(ctx context.Context, tenantID, pattern string) (bool, error) {
+	result := r.db.WithContext(ctx).Where("tenant_id = ? AND pattern = ?", tenantID, pattern).
+		Delete(&models.TenantBinding{})
+	if result.Error != nil {
+		return false, fmt.Errorf("error deleting binding: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteAll implements BindingRepository.
+func (r *bindingRepository) DeleteAll(ctx context.Context, tenantID string) error {
+	if err := r.db.WithContext(ctx).Where("tenant_id = ?", tenantID).Delete(&models.TenantBinding{}).Error; err != nil {
+		return fmt.Errorf("error deleting bindings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/config"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var errRollback = errors.New("rollback")
+
+// inTestTransaction runs fn against the migrated database configured in the
+// environment and rolls everything back; the test is skipped without one
+func inTestTransaction(t *testing.T, fn func(tx *gorm.DB)) {
+	t.Helper()
+	config.LoadConfig()
+	db, err := gorm.Open(postgres.Open(config.DSN()), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Skipf("database not available: %v", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		fn(tx)
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+}
@@ -0,0 +1,135 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ErasureRepository interface {
+	Create(ctx context.Context, report *models.ErasureReport) error
+	Find(ctx context.Context, tenantID, reportID string) (models.ErasureReport, error)
+	EraseBatch(ctx context.Context, report *models.ErasureReport, path []string, value string, limit int, seal func(ids []string) models.ErasureBatch) (int, error)
+	Complete(ctx context.Context, report *models.ErasureReport) error
+	ListStale(ctx context.Context, status string, before time.Time) ([]models.ErasureReport, error)
+}
+
+type erasureRepository struct {
+	db *gorm.DB
+}
+
+func NewErasureRepository(db *gorm.DB) ErasureRepository {
+	return &erasureRepository{
+		db: db,
+	}
+}
+
+// Create implements ErasureRepository.
+func (r *erasureRepository) Create(ctx context.Context, report *models.ErasureReport) error {
+	if err := r.db.WithContext(ctx).Create(report).Error; err != nil {
+		return fmt.Errorf("error creating erasure report: %w", err)
+	}
+	return nil
+}
+
+// Find implements ErasureRepository.
+func (r *erasureRepository) Find(ctx context.Context, tenantID, reportID string) (models.ErasureReport, error) {
+	var report models.ErasureReport
+	err := r.db.WithContext(ctx).Where("id = ? AND tenant_id = ?", reportID, tenantID).First(&report).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return report, fiber.NewError(fiber.StatusNotFound, "erasure report not found")
+	}
+	if err != nil {
+		return report, fmt.Errorf("error retrieving erasure report: %w", err)
+	}
+	return report, nil
+}
+
+// EraseBatch implements ErasureRepository.
+// Up to limit matching rows are deleted from the tenant partition and the batch
+// returned by seal is appended to the report in the same transaction, so the
+// report can never disagree with what was actually removed. Legacy rows that
+// hold the raw delivery body under "content" match on the message payload
+// inside it, or on the body itself. Matching webhook deliveries are deleted too,
+// whatever their status, but are not counted in the report.
+func (r *erasureRepository) EraseBatch(ctx context.Context, report *models.ErasureReport, path []string, value string, limit int, seal func(ids []string) models.ErasureBatch) (int, error) {
+	var batch models.ErasureBatch
+	var ids []string
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw(
+			`DELETE FROM messages WHERE tenant_id = ? AND id IN (
+				SELECT id FROM messages WHERE tenant_id = ? AND (
+					payload #>> ?::text[] = ?
+					OR (jsonb_typeof(payload->'content') = 'string' AND (
+						message_content_jsonb(payload->>'content') #>> ?::text[] = ?
+						OR message_content_jsonb(payload->>'content') #>> ?::text[] = ?
+					))
+				) LIMIT ?
+			) RETURNING id`,
+			report.TenantID, report.TenantID, textArray(path), value,
+			textArray(append([]string{"payload"}, path...)), value, textArray(path), value, limit,
+		).Scan(&ids).Error; err != nil {
+			return fmt.Errorf("error erasing messages: %w", err)
+		}
+
+		// Webhook deliveries keep a copy of the message payload
+		if err := tx.Exec(
+			`DELETE FROM webhook_deliveries WHERE tenant_id = ? AND payload #>> ?::text[] = ?`,
+			report.TenantID, textArray(path), value,
+		).Error; err != nil {
+			return fmt.Errorf("error erasing webhook deliveries: %w", err)
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		batch = seal(ids)
+		return tx.Model(&models.ErasureReport{}).Where("id = ?", report.ID).Updates(map[string]any{
+			"batches":       append(append(models.ErasureBatches{}, report.Batches...), batch),
+			"deleted_count": report.DeletedCount + int64(len(ids)),
+		}).Error
+	})
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	report.Batches = append(report.Batches, batch)
+	report.DeletedCount += int64(len(ids))
+	return len(ids), nil
+}
+
+// Complete implements ErasureRepository.
+func (r *erasureRepository) Complete(ctx context.Context, report *models.ErasureReport) error {
+	return r.db.WithContext(ctx).Model(&models.ErasureReport{}).Where("id = ?", report.ID).Updates(map[string]any{
+		"status":       report.Status,
+		"chain_hash":   report.ChainHash,
+		"signature":    report.Signature,
+		"error":        report.Error,
+		"completed_at": report.CompletedAt,
+	}).Error
+}
+
+// ListStale implements ErasureRepository.
+// Returns the reports in status that made no progress since before.
+func (r *erasureRepository) ListStale(ctx context.Context, status string, before time.Time) ([]models.ErasureReport, error) {
+	var reports []models.ErasureReport
+	if err := r.db.WithContext(ctx).Where("status = ? AND updated_at < ?", status, before).
+		Find(&reports).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving stale erasure reports: %w", err)
+	}
+	return reports, nil
+}
+
+// textArray renders path segments as a Postgres text[] literal.
+// Segments are validated by the caller, so no quoting is needed.
+func textArray(segments []string) string {
+	return "{" + strings.Join(segments, ",") + "}"
+}
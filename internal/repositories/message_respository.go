@@ -3,11 +3,15 @@ package repositories
 import (
 	"aswadwk/messaging-task-go/dto"
 	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
 type MessageRepository interface {
@@ -15,10 +19,35 @@ type MessageRepository interface {
 	CreatePartition(tenantID uuid.UUID) error
 	DropPartition(tenantID uuid.UUID) error
 	GetMessages(cursor int) (dto.QueryResponse, error)
+	StreamMessages(ctx context.Context, filter dto.MessageFilter, fn func(models.Message) error) error
+	CountMessages(ctx context.Context, filter dto.MessageFilter) (int64, error)
+	ListMessagesAfter(ctx context.Context, filter dto.MessageFilter, afterID string, limit int) ([]models.Message, error)
+	ListMessagesAfterSeq(ctx context.Context, tenantID string, afterSeq int64, limit int) ([]models.Message, error)
+	DeleteMessage(ctx context.Context, tenantID, messageID string) error
+	ListPartitions(ctx context.Context) ([]string, error)
+	ListDefaultTenants(ctx context.Context) ([]dto.StrayTenantDto, error)
+	AdoptDefaultRows(ctx context.Context, tenantID uuid.UUID) (int64, error)
+	ReencryptMessage(ctx context.Context, message models.Message) error
+	FindMessage(ctx context.Context, tenantID, messageID string) (models.Message, error)
+	NotifyStored(ctx context.Context, payload string) error
 }
 
+// MessageNotifyChannel is the LISTEN/NOTIFY channel announcing stored messages
+// to the stream subscribers of other instances
+const MessageNotifyChannel = "tenant_messages"
+
+// streamBatchSize is the number of rows fetched per round trip from a server-side cursor
+const streamBatchSize = 500
+
+// TenantPartitionPrefix prefixes the name of every per-tenant partition
+const TenantPartitionPrefix = "messages_tenant_"
+
+// DefaultPartition receives messages of tenants that have no partition yet
+const DefaultPartition = "messages_default"
+
 type messageRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	cipher PayloadCipher
 }
 
 // GetMessages implements MessageRepository.
@@ -47,6 +76,9 @@ func (m *messageRepository) GetMessages(cursor int) (dto.QueryResponse, error) {
 		Find(&messages).Error; err != nil {
 		return dto.QueryResponse{}, fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("error retrieving messages: %v", err))
 	}
+	if err := m.decrypt(context.Background(), messages); err != nil {
+		return dto.QueryResponse{}, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
 
 	// Calculate last page
 	lastPage := int(total) / perPage
@@ -71,46 +103,355 @@ func (m *messageRepository) GetMessages(cursor int) (dto.QueryResponse, error) {
 }
 
 // CreatePartition implements MessageRepository.
+// Rows the tenant already has in the default partition are moved into the new one.
 func (m *messageRepository) CreatePartition(tenantID uuid.UUID) error {
-	partitionName := `messages_tenant_` + tenantID.String()
-	partitionNameQuoted := `"` + partitionName + `"`
+	_, err := m.AdoptDefaultRows(context.Background(), tenantID)
+	return err
+}
+
+// AdoptDefaultRows implements MessageRepository.
+// It moves the tenant's rows out of the default partition into the tenant
+// partition, creating the partition when needed, and returns how many moved.
+// Postgres refuses to attach a partition whose values are still in the default
+// partition, so a new partition is created detached, filled, then attached.
+func (m *messageRepository) AdoptDefaultRows(ctx context.Context, tenantID uuid.UUID) (int64, error) {
+	partitionNameQuoted := `"` + TenantPartitionPrefix + tenantID.String() + `"`
 	tenantIDStr := "'" + tenantID.String() + "'"
-	query := "CREATE TABLE IF NOT EXISTS " + partitionNameQuoted + " PARTITION OF messages FOR VALUES IN (" + tenantIDStr + ")"
-	if err := m.db.Exec(query).Error; err != nil {
-		return err
-	}
-	return nil
+	moveQuery := "WITH moved AS (DELETE FROM " + DefaultPartition + " WHERE tenant_id = ? RETURNING *) " +
+		"INSERT INTO " + partitionNameQuoted + " SELECT * FROM moved"
+
+	var moved int64
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var hasDefault, exists bool
+		if err := tx.Raw("SELECT to_regclass(?) IS NOT NULL", DefaultPartition).Scan(&hasDefault).Error; err != nil {
+			return err
+		}
+		if err := tx.Raw("SELECT to_regclass(?) IS NOT NULL", partitionNameQuoted).Scan(&exists).Error; err != nil {
+			return err
+		}
+
+		if !hasDefault {
+			if exists {
+				return nil
+			}
+			return tx.Exec("CREATE TABLE " + partitionNameQuoted + " PARTITION OF messages FOR VALUES IN (" + tenantIDStr + ")").Error
+		}
+
+		// Hold off inserts into the default partition until the rows are moved
+		if err := tx.Exec("LOCK TABLE " + DefaultPartition + " IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+			return err
+		}
+
+		if !exists {
+			if err := tx.Exec("CREATE TABLE " + partitionNameQuoted + " (LIKE messages INCLUDING DEFAULTS INCLUDING CONSTRAINTS)").Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Exec(moveQuery, tenantID.String())
+		if result.Error != nil {
+			return fmt.Errorf("error moving rows out of the default partition: %w", result.Error)
+		}
+		moved = result.RowsAffected
+
+		if !exists {
+			return tx.Exec("ALTER TABLE messages ATTACH PARTITION " + partitionNameQuoted + " FOR VALUES IN (" + tenantIDStr + ")").Error
+		}
+		return nil
+	})
+
+	return moved, err
 }
 
 // DropPartition implements MessageRepository.
 func (m *messageRepository) DropPartition(tenantID uuid.UUID) error {
-	partitionName := "messages_tenant_" + tenantID.String()
+	partitionName := `"` + TenantPartitionPrefix + tenantID.String() + `"`
 	query := "DROP TABLE IF EXISTS " + partitionName
 	if err := m.db.Exec(query).Error; err != nil {
 		return err
 	}
+	return m.db.Exec("DELETE FROM message_seqs WHERE tenant_id = ?", tenantID).Error
+}
+
+// StreamMessages implements MessageRepository.
+// Rows are read through a server-side cursor so that large partitions are never
+// buffered in memory; fn is called once per row in created_at order.
+func (m *messageRepository) StreamMessages(ctx context.Context, filter dto.MessageFilter, fn func(models.Message) error) error {
+	where, args, err := messageFilterClause(filter)
+	if err != nil {
+		return err
+	}
+	query := "SELECT id, tenant_id, payload, schema_version, event_type, binding, broadcast_id, created_at FROM messages WHERE " + where + " ORDER BY created_at, id"
+
+	// Cursors need a transaction; ask for a read transaction so it can use the replica
+	return m.db.WithContext(ctx).Clauses(dbresolver.Read).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DECLARE message_stream NO SCROLL CURSOR FOR "+query, args...).Error; err != nil {
+			return fmt.Errorf("error declaring cursor: %w", err)
+		}
+		// The cursor is closed implicitly when the transaction ends
+		fetch := fmt.Sprintf("FETCH FORWARD %d FROM message_stream", streamBatchSize)
+		for {
+			var batch []models.Message
+			if err := tx.Raw(fetch).Scan(&batch).Error; err != nil {
+				return fmt.Errorf("error fetching messages: %w", err)
+			}
+			if err := m.decrypt(ctx, batch); err != nil {
+				return err
+			}
+
+			for _, message := range batch {
+				if err := fn(message); err != nil {
+					return err
+				}
+			}
+
+			if len(batch) < streamBatchSize {
+				return nil
+			}
+		}
+	})
+}
+
+// CountMessages implements MessageRepository.
+func (m *messageRepository) CountMessages(ctx context.Context, filter dto.MessageFilter) (int64, error) {
+	where, args, err := messageFilterClause(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	if err := m.db.WithContext(ctx).Model(&models.Message{}).Where(where, args...).Count(&total).Error; err != nil {
+		return 0, fmt.Errorf("error counting messages: %w", err)
+	}
+	return total, nil
+}
+
+// ListMessagesAfter implements MessageRepository.
+// It pages through matching rows by ID (UUIDv7, so roughly creation order) without
+// holding a transaction open between pages, which suits slow, throttled readers.
+func (m *messageRepository) ListMessagesAfter(ctx context.Context, filter dto.MessageFilter, afterID string, limit int) ([]models.Message, error) {
+	where, args, err := messageFilterClause(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := m.db.WithContext(ctx).Model(&models.Message{}).Where(where, args...)
+	if afterID != "" {
+		query = query.Where("id > ?", afterID)
+	}
+
+	var messages []models.Message
+	if err := query.Order("id").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving messages: %w", err)
+	}
+	if err := m.decrypt(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ListMessagesAfterSeq implements MessageRepository.
+// It pages through a tenant's rows in seq order, which is their commit order,
+// reading from the primary so nothing just committed is skipped.
+func (m *messageRepository) ListMessagesAfterSeq(ctx context.Context, tenantID string, afterSeq int64, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	if err := m.db.WithContext(ctx).Clauses(dbresolver.Write).
+		Where("tenant_id = ? AND seq > ?", tenantID, afterSeq).
+		Order("seq").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving messages: %w", err)
+	}
+	if err := m.decrypt(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// DeleteMessage implements MessageRepository.
+func (m *messageRepository) DeleteMessage(ctx context.Context, tenantID, messageID string) error {
+	result := m.db.WithContext(ctx).Where("tenant_id = ? AND id = ?", tenantID, messageID).Delete(&models.Message{})
+	if result.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("error deleting message: %v", result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "message not found")
+	}
 	return nil
 }
 
-func NewMessageRepository(db *gorm.DB) MessageRepository {
+// ListPartitions implements MessageRepository.
+// It returns the names of every partition attached to the messages table.
+func (m *messageRepository) ListPartitions(ctx context.Context) ([]string, error) {
+	// Read from the primary; a stale replica would report drift that is not there
+	var partitions []string
+	err := m.db.WithContext(ctx).Clauses(dbresolver.Write).Raw(`
+		SELECT child.relname
+		FROM pg_inherits
+		JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+		JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+		WHERE parent.relname = 'messages'
+		ORDER BY child.relname`).Scan(&partitions).Error
+	if err != nil {
+		return nil, fmt.Errorf("error listing partitions: %w", err)
+	}
+	return partitions, nil
+}
+
+// ListDefaultTenants implements MessageRepository.
+// It lists the tenants that have rows in the default partition.
+func (m *messageRepository) ListDefaultTenants(ctx context.Context) ([]dto.StrayTenantDto, error) {
+	strays := []dto.StrayTenantDto{}
+	err := m.db.WithContext(ctx).Clauses(dbresolver.Write).Raw(`
+		SELECT tenant_id, COUNT(*) AS messages, MIN(created_at) AS first_seen, MAX(created_at) AS last_seen
+		FROM ` + DefaultPartition + `
+		GROUP BY tenant_id
+		ORDER BY MIN(created_at)`).Scan(&strays).Error
+	if err != nil {
+		return nil, fmt.Errorf("error listing default partition tenants: %w", err)
+	}
+	return strays, nil
+}
+
+// messageFilterClause builds the WHERE clause shared by filtered message queries.
+// Filtering on tenant_id first lets Postgres prune to the tenant partition.
+func messageFilterClause(filter dto.MessageFilter) (string, []any, error) {
+	where := "tenant_id = ?"
+	args := []any{filter.TenantID}
+	if filter.From != nil {
+		where += " AND created_at >= ?"
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		where += " AND created_at < ?"
+		args = append(args, *filter.To)
+	}
+	if len(filter.Payload) > 0 {
+		contains, err := json.Marshal(filter.Payload)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid payload filter: %w", err)
+		}
+		where += " AND payload @> ?::jsonb"
+		args = append(args, string(contains))
+	}
+	return where, args, nil
+}
+
+// NewMessageRepository constructor. cipher may be nil to store payloads in clear.
+func NewMessageRepository(db *gorm.DB, cipher PayloadCipher) MessageRepository {
 	return &messageRepository{
-		db: db,
+		db:     db,
+		cipher: cipher,
 	}
 }
 
 // Store implements MessageRepository.
 func (m *messageRepository) Store(message dto.NewMessageDto) error {
 	ID, _ := uuid.NewV7()
+	if message.ID != "" {
+		parsed, err := uuid.Parse(message.ID)
+		if err != nil {
+			return fmt.Errorf("invalid message id: %w", err)
+		}
+		ID = parsed
+	}
+
+	payload, err := m.encrypt(context.Background(), message.TenantID, message.Payload)
+	if err != nil {
+		return err
+	}
 
 	newMessage := models.Message{
-		ID:       ID.String(),
-		TenantID: message.TenantID,
-		Payload:  message.Payload,
+		ID:            ID.String(),
+		TenantID:      message.TenantID,
+		Payload:       payload,
+		SchemaVersion: message.SchemaVersion,
+		EventType:     message.EventType,
+		Binding:       message.Binding,
+	}
+	if message.BroadcastID != "" {
+		newMessage.BroadcastID = &message.BroadcastID
 	}
 
-	if err := m.db.Create(&newMessage).Error; err != nil {
+	// seq comes from the tenant's counter row, locked until the insert
+	// commits: the tenant's inserts commit in seq order, so a stream resuming
+	// after a seq never skips a later commit. Other tenants have their own row
+	// and never wait; the cost is one insert at a time per tenant.
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Raw(`INSERT INTO message_seqs (tenant_id, last_seq) VALUES (?, 1)
+			ON CONFLICT (tenant_id) DO UPDATE SET last_seq = message_seqs.last_seq + 1
+			RETURNING last_seq`, message.TenantID).Scan(&newMessage.Seq).Error
+		if err != nil {
+			return fmt.Errorf("error assigning message seq: %w", err)
+		}
+		return tx.Create(&newMessage).Error
+	})
+}
+
+// FindMessage implements MessageRepository.
+// It reads from the primary, the message was usually stored a moment ago.
+func (m *messageRepository) FindMessage(ctx context.Context, tenantID, messageID string) (models.Message, error) {
+	var message models.Message
+	err := m.db.WithContext(ctx).Clauses(dbresolver.Write).
+		Where("tenant_id = ? AND id = ?", tenantID, messageID).First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return message, fiber.NewError(fiber.StatusNotFound, "message not found")
+	}
+	if err != nil {
+		return message, fmt.Errorf("error retrieving message: %w", err)
+	}
+
+	messages := []models.Message{message}
+	if err := m.decrypt(ctx, messages); err != nil {
+		return message, err
+	}
+	return messages[0], nil
+}
+
+// NotifyStored implements MessageRepository.
+func (m *messageRepository) NotifyStored(ctx context.Context, payload string) error {
+	if err := m.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", MessageNotifyChannel, payload).Error; err != nil {
+		return fmt.Errorf("error notifying stored message: %w", err)
+	}
+	return nil
+}
+
+// ReencryptMessage implements MessageRepository.
+// It rewrites a row with its decrypted payload sealed under the active key.
+func (m *messageRepository) ReencryptMessage(ctx context.Context, message models.Message) error {
+	payload, err := m.encrypt(ctx, message.TenantID, message.Payload)
+	if err != nil {
 		return err
 	}
 
+	if err := m.db.WithContext(ctx).Model(&models.Message{}).
+		Where("tenant_id = ? AND id = ?", message.TenantID, message.ID).
+		Update("payload", payload).Error; err != nil {
+		return fmt.Errorf("error re-encrypting message %s: %w", message.ID, err)
+	}
+	return nil
+}
+
+func (m *messageRepository) encrypt(ctx context.Context, tenantID string, payload map[string]any) (models.JSONB, error) {
+	if m.cipher == nil {
+		return payload, nil
+	}
+	encrypted, err := m.cipher.Encrypt(ctx, tenantID, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting payload: %w", err)
+	}
+	return encrypted, nil
+}
+
+// decrypt replaces encrypted payloads with their plaintext in place
+func (m *messageRepository) decrypt(ctx context.Context, messages []models.Message) error {
+	if m.cipher == nil {
+		return nil
+	}
+	for i := range messages {
+		payload, err := m.cipher.Decrypt(ctx, messages[i].TenantID, messages[i].Payload)
+		if err != nil {
+			return fmt.Errorf("error decrypting message %s: %w", messages[i].ID, err)
+		}
+		messages[i].Payload = payload
+	}
 	return nil
 }
@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestAdoptDefaultRowsMovesTenantRows(t *testing.T) {
+	inTestTransaction(t, func(tx *gorm.DB) {
+		ctx := context.Background()
+		tenantID := uuid.New()
+		otherID := uuid.New()
+		partition := TenantPartitionPrefix + tenantID.String()
+
+		// Without a partition the tenant's rows land in the default partition
+		for _, id := range []uuid.UUID{tenantID, tenantID, otherID} {
+			require.NoError(t, tx.Exec("INSERT INTO messages (id, tenant_id, payload) VALUES (?, ?, '{}')", uuid.New(), id).Error)
+		}
+		count := func(table string, tenant uuid.UUID) int64 {
+			var n int64
+			require.NoError(t, tx.Raw(`SELECT count(*) FROM "`+table+`" WHERE tenant_id = ?`, tenant).Scan(&n).Error)
+			return n
+		}
+		require.Equal(t, int64(2), count(DefaultPartition, tenantID))
+
+		repo := NewMessageRepository(tx, nil)
+		moved, err := repo.AdoptDefaultRows(ctx, tenantID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), moved)
+		assert.Equal(t, int64(2), count(partition, tenantID))
+		assert.Zero(t, count(DefaultPartition, tenantID))
+		assert.Equal(t, int64(1), count(DefaultPartition, otherID))
+
+		// The new partition is attached: inserts go straight into it
+		require.NoError(t, tx.Exec("INSERT INTO messages (id, tenant_id, payload) VALUES (?, ?, '{}')", uuid.New(), tenantID).Error)
+		assert.Equal(t, int64(3), count(partition, tenantID))
+
+		// Adopting again moves nothing
+		moved, err = repo.AdoptDefaultRows(ctx, tenantID)
+		require.NoError(t, err)
+		assert.Zero(t, moved)
+	})
+}
+
+func TestMessagesArePagedInSeqOrder(t *testing.T) {
+	inTestTransaction(t, func(tx *gorm.DB) {
+		ctx := context.Background()
+		tenantID := uuid.NewString()
+		repo := NewMessageRepository(tx, nil)
+
+		// The ID order differs from the order the rows were stored in
+		first, _ := uuid.NewV7()
+		second, _ := uuid.NewV7()
+		require.NoError(t, repo.Store(dto.NewMessageDto{ID: second.String(), TenantID: tenantID, Payload: map[string]any{"n": 1}}))
+		require.NoError(t, repo.Store(dto.NewMessageDto{ID: first.String(), TenantID: tenantID, Payload: map[string]any{"n": 2}}))
+
+		page, err := repo.ListMessagesAfterSeq(ctx, tenantID, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, second.String(), page[0].ID)
+		assert.Equal(t, first.String(), page[1].ID)
+		// Every tenant counts from its own counter
+		assert.Equal(t, int64(1), page[0].Seq)
+		assert.Equal(t, int64(2), page[1].Seq)
+
+		found, err := repo.FindMessage(ctx, tenantID, first.String())
+		require.NoError(t, err)
+		assert.Equal(t, page[1].Seq, found.Seq)
+
+		rest, err := repo.ListMessagesAfterSeq(ctx, tenantID, page[0].Seq, 10)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		assert.Equal(t, first.String(), rest[0].ID)
+	})
+}
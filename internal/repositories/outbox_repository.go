@@ -0,0 +1,125 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+type OutboxRepository interface {
+	Enqueue(ctx context.Context, exchange, routingKey string, body, headers models.JSONB) error
+	Claim(ctx context.Context, limit int, lockFor time.Duration) ([]models.OutboxMessage, error)
+	MarkSent(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id string, cause error, maxAttempts int) error
+	Release(ctx context.Context, ids []string) error
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{
+		db: db,
+	}
+}
+
+// Enqueue implements OutboxRepository.
+func (r *outboxRepository) Enqueue(ctx context.Context, exchange, routingKey string, body, headers models.JSONB) error {
+	ID, _ := uuid.NewV7()
+	if headers == nil {
+		headers = models.JSONB{}
+	}
+
+	message := models.OutboxMessage{
+		ID:        ID.String(),
+		Exchange:  exchange,
+		QueueName: routingKey,
+		Body:      body,
+		Headers:   headers,
+		Status:    models.OutboxStatusPending,
+	}
+
+	if err := r.db.WithContext(ctx).Create(&message).Error; err != nil {
+		return fmt.Errorf("error writing to outbox: %w", err)
+	}
+	return nil
+}
+
+// Claim implements OutboxRepository.
+// Up to limit pending rows are locked for lockFor and returned in creation
+// order. The claim commits right away, so rows are never held locked while
+// they are published; SKIP LOCKED and locked_until keep other relays off them,
+// and a row whose lock expired (e.g. the relay died) is claimed again.
+func (r *outboxRepository) Claim(ctx context.Context, limit int, lockFor time.Duration) ([]models.OutboxMessage, error) {
+	var messages []models.OutboxMessage
+	err := r.db.WithContext(ctx).Clauses(dbresolver.Write).Raw(`
+		UPDATE outbox
+		SET locked_until = NOW() + make_interval(secs => ?)
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE status = ? AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY created_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, lockFor.Seconds(), models.OutboxStatusPending, limit).Scan(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("error claiming outbox rows: %w", err)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	return messages, nil
+}
+
+// MarkSent implements OutboxRepository.
+func (r *outboxRepository) MarkSent(ctx context.Context, id string) error {
+	if err := r.db.WithContext(ctx).Model(&models.OutboxMessage{}).Where("id = ?", id).Updates(map[string]any{
+		"status":       models.OutboxStatusSent,
+		"attempts":     gorm.Expr("attempts + 1"),
+		"sent_at":      time.Now(),
+		"locked_until": nil,
+	}).Error; err != nil {
+		return fmt.Errorf("error marking outbox row %s sent: %w", id, err)
+	}
+	return nil
+}
+
+// MarkFailed implements OutboxRepository.
+// The row stays pending and is released for the next claim, until it has
+// failed maxAttempts times; it is then parked as failed and never claimed again.
+func (r *outboxRepository) MarkFailed(ctx context.Context, id string, cause error, maxAttempts int) error {
+	if err := r.db.WithContext(ctx).Model(&models.OutboxMessage{}).Where("id = ?", id).Updates(map[string]any{
+		"status":       gorm.Expr("CASE WHEN attempts + 1 >= ? THEN ? ELSE status END", maxAttempts, models.OutboxStatusFailed),
+		"attempts":     gorm.Expr("attempts + 1"),
+		"last_error":   cause.Error(),
+		"locked_until": nil,
+	}).Error; err != nil {
+		return fmt.Errorf("error recording outbox failure of %s: %w", id, err)
+	}
+	return nil
+}
+
+// Release implements OutboxRepository.
+// Claimed rows that were not published are made visible again.
+func (r *outboxRepository) Release(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := r.db.WithContext(ctx).Model(&models.OutboxMessage{}).Where("id IN ?", ids).
+		Update("locked_until", nil).Error; err != nil {
+		return fmt.Errorf("error releasing outbox rows: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestOutboxClaimsPublishesAndMarksRows(t *testing.T) {
+	inTestTransaction(t, func(tx *gorm.DB) {
+		ctx := context.Background()
+		require.NoError(t, tx.Exec("DELETE FROM outbox").Error)
+
+		repo := NewOutboxRepository(tx)
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, repo.Enqueue(ctx, "", "outbox_test_"+key, models.JSONB{"key": key}, nil))
+		}
+
+		claimed, err := repo.Claim(ctx, 2, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 2)
+		assert.Equal(t, "outbox_test_a", claimed[0].QueueName)
+		assert.Equal(t, "outbox_test_b", claimed[1].QueueName)
+
+		// Claimed rows are not handed out again while they are locked
+		rest, err := repo.Claim(ctx, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		assert.Equal(t, "outbox_test_c", rest[0].QueueName)
+
+		require.NoError(t, repo.MarkSent(ctx, claimed[0].ID))
+		require.NoError(t, repo.MarkFailed(ctx, claimed[1].ID, errors.New("broker down"), 2))
+		require.NoError(t, repo.Release(ctx, []string{rest[0].ID}))
+
+		// Failed and released rows are claimed again, sent ones never
+		again, err := repo.Claim(ctx, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, again, 2)
+		assert.Equal(t, claimed[1].ID, again[0].ID)
+		assert.Equal(t, 1, again[0].Attempts)
+		assert.Equal(t, "broker down", again[0].LastError)
+		assert.Equal(t, rest[0].ID, again[1].ID)
+
+		var sent models.OutboxMessage
+		require.NoError(t, tx.First(&sent, "id = ?", claimed[0].ID).Error)
+		assert.Equal(t, models.OutboxStatusSent, sent.Status)
+		assert.NotNil(t, sent.SentAt)
+		assert.Nil(t, sent.LockedUntil)
+
+		// The last allowed failure parks the row, it is never claimed again
+		require.NoError(t, repo.MarkFailed(ctx, again[0].ID, errors.New("broker down"), 2))
+		require.NoError(t, repo.Release(ctx, []string{again[1].ID}))
+		last, err := repo.Claim(ctx, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, last, 1)
+		assert.Equal(t, rest[0].ID, last[0].ID)
+
+		var parked models.OutboxMessage
+		require.NoError(t, tx.First(&parked, "id = ?", again[0].ID).Error)
+		assert.Equal(t, models.OutboxStatusFailed, parked.Status)
+		assert.Equal(t, 2, parked.Attempts)
+	})
+}
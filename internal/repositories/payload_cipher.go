@@ -0,0 +1,291 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/envelope"
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EncryptedField holds the sealed payload in an encrypted row, next to the
+// fields kept in clear: {"order_id": "A-1", "_encrypted": {"v": 2, "data": "..."}}
+const EncryptedField = "_encrypted"
+
+// activeKeyTTL bounds how long another instance keeps encrypting with the
+// previous key after a rotation
+const activeKeyTTL = time.Minute
+
+// PayloadCipher encrypts message payloads at rest with per-tenant data keys
+type PayloadCipher interface {
+	Encrypt(ctx context.Context, tenantID string, payload models.JSONB) (models.JSONB, error)
+	Decrypt(ctx context.Context, tenantID string, payload models.JSONB) (models.JSONB, error)
+	RotateKey(ctx context.Context, tenantID string) (models.TenantKey, error)
+}
+
+type cachedActiveKey struct {
+	version   int
+	expiresAt time.Time
+}
+
+type envelopeCipher struct {
+	keys        TenantKeyRepository
+	master      *envelope.MasterKey
+	clearFields [][]string
+
+	mu       sync.RWMutex
+	dataKeys map[string][]byte // by tenant and version, unwrapped
+	active   map[string]cachedActiveKey
+}
+
+// NewPayloadCipher returns nil when master is nil, which leaves payloads in clear.
+// clearFields are dotted payload paths copied outside the ciphertext so they can
+// still be indexed, filtered and erased.
+func NewPayloadCipher(keys TenantKeyRepository, master *envelope.MasterKey, clearFields []string) PayloadCipher {
+	if master == nil {
+		return nil
+	}
+
+	c := &envelopeCipher{
+		keys:     keys,
+		master:   master,
+		dataKeys: make(map[string][]byte),
+		active:   make(map[string]cachedActiveKey),
+	}
+	for _, field := range clearFields {
+		if field = strings.TrimSpace(field); field != "" {
+			c.clearFields = append(c.clearFields, strings.Split(field, "."))
+		}
+	}
+	return c
+}
+
+// Encrypt implements PayloadCipher.
+func (c *envelopeCipher) Encrypt(ctx context.Context, tenantID string, payload models.JSONB) (models.JSONB, error) {
+	version, dataKey, err := c.activeKey(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := envelope.Seal(dataKey, plaintext, []byte(tenantID))
+	if err != nil {
+		return nil, err
+	}
+
+	stored := clearCopy(payload, c.clearFields)
+	stored[EncryptedField] = map[string]any{
+		"v":    version,
+		"data": base64.StdEncoding.EncodeToString(sealed),
+	}
+	return stored, nil
+}
+
+// Decrypt implements PayloadCipher.
+// Rows written before encryption was enabled are returned as is.
+func (c *envelopeCipher) Decrypt(ctx context.Context, tenantID string, payload models.JSONB) (models.JSONB, error) {
+	encrypted, ok := payload[EncryptedField].(map[string]any)
+	if !ok {
+		return payload, nil
+	}
+
+	version, _ := encrypted["v"].(float64)
+	data, _ := encrypted["data"].(string)
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted payload: %w", err)
+	}
+
+	dataKey, err := c.dataKey(ctx, tenantID, int(version))
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := envelope.Open(dataKey, sealed, []byte(tenantID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload with key version %d: %w", int(version), err)
+	}
+
+	var decrypted models.JSONB
+	if err := json.Unmarshal(plaintext, &decrypted); err != nil {
+		return nil, err
+	}
+	return decrypted, nil
+}
+
+// RotateKey implements PayloadCipher.
+// Rows keep their old key version until they are re-encrypted.
+func (c *envelopeCipher) RotateKey(ctx context.Context, tenantID string) (models.TenantKey, error) {
+	dataKey, wrapped, err := c.newWrappedKey(tenantID)
+	if err != nil {
+		return models.TenantKey{}, err
+	}
+
+	key, err := c.keys.Rotate(ctx, tenantID, wrapped)
+	if err != nil {
+		return models.TenantKey{}, err
+	}
+
+	c.remember(tenantID, key.Version, dataKey)
+	return key, nil
+}
+
+func (c *envelopeCipher) activeKey(ctx context.Context, tenantID string) (int, []byte, error) {
+	c.mu.RLock()
+	cached, ok := c.active[tenantID]
+	dataKey := c.dataKeys[dataKeyID(tenantID, cached.version)]
+	c.mu.RUnlock()
+	if ok && dataKey != nil && time.Now().Before(cached.expiresAt) {
+		return cached.version, dataKey, nil
+	}
+
+	key, err := c.keys.FindActive(ctx, tenantID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if key == nil {
+		// First message of the tenant; a concurrent writer may win the race,
+		// in which case its key is returned and ours is discarded
+		fresh, wrapped, err := c.newWrappedKey(tenantID)
+		if err != nil {
+			return 0, nil, err
+		}
+		created, err := c.keys.EnsureActive(ctx, tenantID, wrapped)
+		if err != nil {
+			return 0, nil, err
+		}
+		if string(created.WrappedKey) == string(wrapped) {
+			c.remember(tenantID, created.Version, fresh)
+			return created.Version, fresh, nil
+		}
+		key = &created
+	}
+
+	dataKey, err = c.unwrap(*key)
+	if err != nil {
+		return 0, nil, err
+	}
+	c.remember(tenantID, key.Version, dataKey)
+	return key.Version, dataKey, nil
+}
+
+func (c *envelopeCipher) dataKey(ctx context.Context, tenantID string, version int) ([]byte, error) {
+	c.mu.RLock()
+	dataKey, ok := c.dataKeys[dataKeyID(tenantID, version)]
+	c.mu.RUnlock()
+	if ok {
+		return dataKey, nil
+	}
+
+	key, err := c.keys.Find(ctx, tenantID, version)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err = c.unwrap(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.dataKeys[dataKeyID(tenantID, version)] = dataKey
+	c.mu.Unlock()
+	return dataKey, nil
+}
+
+func (c *envelopeCipher) newWrappedKey(tenantID string) ([]byte, []byte, error) {
+	dataKey, err := envelope.NewDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := c.master.Wrap(dataKey, []byte(tenantID))
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, wrapped, nil
+}
+
+func (c *envelopeCipher) unwrap(key models.TenantKey) ([]byte, error) {
+	dataKey, err := c.master.Unwrap(key.WrappedKey, []byte(key.TenantID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key version %d of tenant %s, wrong master key?", key.Version, key.TenantID)
+	}
+	return dataKey, nil
+}
+
+func (c *envelopeCipher) remember(tenantID string, version int, dataKey []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dataKeys[dataKeyID(tenantID, version)] = dataKey
+	c.active[tenantID] = cachedActiveKey{version: version, expiresAt: time.Now().Add(activeKeyTTL)}
+}
+
+func dataKeyID(tenantID string, version int) string {
+	return fmt.Sprintf("%s:%d", tenantID, version)
+}
+
+// clearCopy copies the clear fields that are present in payload, keeping their nesting
+func clearCopy(payload models.JSONB, clearFields [][]string) models.JSONB {
+	stored := models.JSONB{}
+	for _, path := range clearFields {
+		value, ok := lookupPath(payload, path)
+		if !ok {
+			continue
+		}
+
+		node := map[string]any(stored)
+		for _, segment := range path[:len(path)-1] {
+			child, ok := node[segment].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				node[segment] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = value
+	}
+	return stored
+}
+
+func lookupPath(payload map[string]any, path []string) (any, bool) {
+	var value any = payload
+	for _, segment := range path {
+		node, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = node[segment]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// IsClearPath reports whether a payload path stays readable in the database
+// when encryption is enabled, i.e. it is a clear field or lies inside one
+func IsClearPath(clearFields []string, path []string) bool {
+	for _, field := range clearFields {
+		segments := strings.Split(strings.TrimSpace(field), ".")
+		if len(segments) > len(path) {
+			continue
+		}
+		matches := true
+		for i, segment := range segments {
+			if path[i] != segment {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
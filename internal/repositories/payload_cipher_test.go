@@ -0,0 +1,134 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/envelope"
+	"aswadwk/messaging-task-go/internal/models"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKeyRepository keeps tenant keys in memory
+type memoryKeyRepository struct {
+	keys []models.TenantKey
+}
+
+func (r *memoryKeyRepository) FindActive(ctx context.Context, tenantID string) (*models.TenantKey, error) {
+	for i := range r.keys {
+		if r.keys[i].TenantID == tenantID && r.keys[i].Active {
+			return &r.keys[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryKeyRepository) Find(ctx context.Context, tenantID string, version int) (models.TenantKey, error) {
+	for _, key := range r.keys {
+		if key.TenantID == tenantID && key.Version == version {
+			return key, nil
+		}
+	}
+	return models.TenantKey{}, assert.AnError
+}
+
+func (r *memoryKeyRepository) EnsureActive(ctx context.Context, tenantID string, wrappedKey []byte) (models.TenantKey, error) {
+	if key, _ := r.FindActive(ctx, tenantID); key != nil {
+		return *key, nil
+	}
+	return r.Rotate(ctx, tenantID, wrappedKey)
+}
+
+func (r *memoryKeyRepository) Rotate(ctx context.Context, tenantID string, wrappedKey []byte) (models.TenantKey, error) {
+	version := 0
+	for i := range r.keys {
+		if r.keys[i].TenantID == tenantID {
+			r.keys[i].Active = false
+			version = max(version, r.keys[i].Version)
+		}
+	}
+	key := models.TenantKey{TenantID: tenantID, Version: version + 1, WrappedKey: wrappedKey, Active: true}
+	r.keys = append(r.keys, key)
+	return key, nil
+}
+
+func testCipher(t *testing.T, keys TenantKeyRepository) PayloadCipher {
+	master, err := envelope.NewMasterKey(bytes.Repeat([]byte{7}, envelope.KeySize))
+	require.NoError(t, err)
+	return NewPayloadCipher(keys, master, []string{"order_id", "customer.id"})
+}
+
+func TestPayloadCipherRoundTrip(t *testing.T) {
+	const tenantID = "0190d8a4-0000-7000-8000-0000000000aa"
+	ctx := context.Background()
+	cipher := testCipher(t, &memoryKeyRepository{})
+
+	payload := models.JSONB{
+		"order_id": "A-1",
+		"customer": map[string]any{"id": "C-42", "email": "jane@example.com"},
+	}
+
+	stored, err := cipher.Encrypt(ctx, tenantID, payload)
+	require.NoError(t, err)
+
+	// Clear fields stay readable, everything else is only in the ciphertext
+	assert.Equal(t, "A-1", stored["order_id"])
+	assert.Equal(t, map[string]any{"id": "C-42"}, stored["customer"])
+	assert.Contains(t, stored, EncryptedField)
+
+	decrypted, err := cipher.Decrypt(ctx, tenantID, roundTripJSONB(t, stored))
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", decrypted["customer"].(map[string]any)["email"])
+
+	// Ciphertext is bound to the tenant
+	_, err = cipher.Decrypt(ctx, "0190d8a4-0000-7000-8000-0000000000bb", roundTripJSONB(t, stored))
+	assert.Error(t, err)
+
+	// Rows without ciphertext were written before encryption and pass through
+	plain, err := cipher.Decrypt(ctx, tenantID, models.JSONB{"legacy": true})
+	require.NoError(t, err)
+	assert.Equal(t, models.JSONB{"legacy": true}, plain)
+}
+
+func TestPayloadCipherRotationKeepsOldRowsReadable(t *testing.T) {
+	const tenantID = "0190d8a4-0000-7000-8000-0000000000aa"
+	ctx := context.Background()
+	keys := &memoryKeyRepository{}
+	cipher := testCipher(t, keys)
+
+	old, err := cipher.Encrypt(ctx, tenantID, models.JSONB{"n": 1})
+	require.NoError(t, err)
+
+	key, err := cipher.RotateKey(ctx, tenantID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, key.Version)
+
+	fresh, err := cipher.Encrypt(ctx, tenantID, models.JSONB{"n": 2})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, fresh[EncryptedField].(map[string]any)["v"])
+
+	// A new process only has the wrapped keys
+	restarted := testCipher(t, keys)
+	decrypted, err := restarted.Decrypt(ctx, tenantID, roundTripJSONB(t, old))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, decrypted["n"])
+}
+
+func TestIsClearPath(t *testing.T) {
+	clear := []string{"order_id", "customer.id"}
+	assert.True(t, IsClearPath(clear, []string{"customer", "id"}))
+	assert.True(t, IsClearPath(clear, []string{"order_id"}))
+	assert.False(t, IsClearPath(clear, []string{"customer"}))
+	assert.False(t, IsClearPath(clear, []string{"customer", "email"}))
+}
+
+// roundTripJSONB mimics reading the row back from Postgres
+func roundTripJSONB(t *testing.T, payload models.JSONB) models.JSONB {
+	raw, err := payload.Value()
+	require.NoError(t, err)
+	var scanned models.JSONB
+	require.NoError(t, scanned.Scan(raw))
+	return scanned
+}
@@ -0,0 +1,281 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+// QueueNotifyChannel is the LISTEN/NOTIFY channel that carries the name of a
+// queue whenever a message becomes available in it
+const QueueNotifyChannel = "queue_messages"
+
+type QueueRepository interface {
+	Declare(ctx context.Context, queueName string, expires time.Duration) error
+	Delete(ctx context.Context, queueName string) error
+	Touch(ctx context.Context, queueName string, expires time.Duration) error
+	DeleteExpired(ctx context.Context) ([]string, error)
+	List(ctx context.Context) ([]string, error)
+	Bind(ctx context.Context, binding models.QueueBinding) error
+	Unbind(ctx context.Context, binding models.QueueBinding) error
+	Bindings(ctx context.Context, queueName, exchange string) ([]string, error)
+	Enqueue(ctx context.Context, message *models.QueueMessage) (bool, error)
+	Route(ctx context.Context, exchange, routingKey string, message *models.QueueMessage) (int64, error)
+	Claim(ctx context.Context, queueName string, limit int, lockFor time.Duration) ([]models.QueueMessage, error)
+	Ack(ctx context.Context, id int64) error
+	Release(ctx context.Context, id int64) error
+	Extend(ctx context.Context, ids []int64, lockFor time.Duration) error
+	DeadLetter(ctx context.Context, id int64, deadLetterQueue, reason string) error
+}
+
+type queueRepository struct {
+	db *gorm.DB
+}
+
+func NewQueueRepository(db *gorm.DB) QueueRepository {
+	return &queueRepository{
+		db: db,
+	}
+}
+
+// Declare implements QueueRepository.
+// A queue declared with expires is deleted by DeleteExpired unless Touch
+// pushes its expiry back in time; without it the queue never expires.
+func (r *queueRepository) Declare(ctx context.Context, queueName string, expires time.Duration) error {
+	var err error
+	if expires > 0 {
+		err = r.db.WithContext(ctx).Exec(`
+			INSERT INTO queues (name, expires_at) VALUES (?, NOW() + make_interval(secs => ?))
+			ON CONFLICT (name) DO UPDATE SET expires_at = EXCLUDED.expires_at`, queueName, expires.Seconds()).Error
+	} else {
+		err = r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.Queue{Name: queueName}).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+	}
+	return nil
+}
+
+// Delete implements QueueRepository.
+// Waiting messages are removed with the queue.
+func (r *queueRepository) Delete(ctx context.Context, queueName string) error {
+	if err := r.db.WithContext(ctx).Delete(&models.Queue{Name: queueName}).Error; err != nil {
+		return fmt.Errorf("failed to delete queue %s: %w", queueName, err)
+	}
+	return nil
+}
+
+// Touch implements QueueRepository.
+// Queues declared without expiry are left alone.
+func (r *queueRepository) Touch(ctx context.Context, queueName string, expires time.Duration) error {
+	if err := r.db.WithContext(ctx).Model(&models.Queue{}).
+		Where("name = ? AND expires_at IS NOT NULL", queueName).
+		Update("expires_at", gorm.Expr("NOW() + make_interval(secs => ?)", expires.Seconds())).Error; err != nil {
+		return fmt.Errorf("failed to extend queue %s: %w", queueName, err)
+	}
+	return nil
+}
+
+// DeleteExpired implements QueueRepository.
+// Returns the names of the deleted queues; their messages and bindings go
+// with them.
+func (r *queueRepository) DeleteExpired(ctx context.Context) ([]string, error) {
+	var names []string
+	if err := r.db.WithContext(ctx).Raw(
+		"DELETE FROM queues WHERE expires_at < NOW() RETURNING name").Scan(&names).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete expired queues: %w", err)
+	}
+	return names, nil
+}
+
+// List implements QueueRepository.
+func (r *queueRepository) List(ctx context.Context) ([]string, error) {
+	var names []string
+	if err := r.db.WithContext(ctx).Clauses(dbresolver.Write).Model(&models.Queue{}).
+		Order("name").Pluck("name", &names).Error; err != nil {
+		return nil, fmt.Errorf("failed to list queues: %w", err)
+	}
+	return names, nil
+}
+
+// Bind implements QueueRepository.
+func (r *queueRepository) Bind(ctx context.Context, binding models.QueueBinding) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&binding).Error
+	if err != nil {
+		return fmt.Errorf("failed to bind queue %s to %s: %w", binding.QueueName, binding.Pattern, err)
+	}
+	return nil
+}
+
+// Unbind implements QueueRepository.
+func (r *queueRepository) Unbind(ctx context.Context, binding models.QueueBinding) error {
+	err := r.db.WithContext(ctx).
+		Where("queue_name = ? AND exchange = ? AND pattern = ?", binding.QueueName, binding.Exchange, binding.Pattern).
+		Delete(&models.QueueBinding{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to unbind queue %s from %s: %w", binding.QueueName, binding.Pattern, err)
+	}
+	return nil
+}
+
+// Bindings implements QueueRepository.
+func (r *queueRepository) Bindings(ctx context.Context, queueName, exchange string) ([]string, error) {
+	var patterns []string
+	err := r.db.WithContext(ctx).Clauses(dbresolver.Write).Model(&models.QueueBinding{}).
+		Where("queue_name = ? AND exchange = ?", queueName, exchange).
+		Order("pattern").Pluck("pattern", &patterns).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bindings of %s: %w", queueName, err)
+	}
+	return patterns, nil
+}
+
+// Enqueue implements QueueRepository.
+// Returns false without error when the queue does not exist. Listeners are
+// notified when the transaction commits.
+func (r *queueRepository) Enqueue(ctx context.Context, message *models.QueueMessage) (bool, error) {
+	if message.Headers == nil {
+		message.Headers = models.JSONB{}
+	}
+
+	inserted := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(`
+			INSERT INTO queue_messages (queue_name, content_type, content_encoding, message_id, headers, body, reply_to, correlation_id, available_at)
+			SELECT CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS JSONB), CAST(? AS BYTEA), CAST(? AS TEXT), CAST(? AS TEXT),
+				COALESCE(CAST(? AS TIMESTAMPTZ), NOW())
+			WHERE EXISTS (SELECT 1 FROM queues WHERE name = ?)`,
+			message.QueueName, message.ContentType, message.ContentEncoding, message.MessageID,
+			message.Headers, message.Body, message.ReplyTo, message.CorrelationID, message.AvailableAt, message.QueueName)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		inserted = true
+		return tx.Exec("SELECT pg_notify(?, ?)", QueueNotifyChannel, message.QueueName).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to publish message: %w", err)
+	}
+	return inserted, nil
+}
+
+// Route implements QueueRepository.
+// The message is copied into every queue with a binding on exchange that
+// matches routingKey, once per queue, and the number of queues is returned.
+func (r *queueRepository) Route(ctx context.Context, exchange, routingKey string, message *models.QueueMessage) (int64, error) {
+	if message.Headers == nil {
+		message.Headers = models.JSONB{}
+	}
+
+	result := r.db.WithContext(ctx).Exec(`
+		WITH routed AS (
+			INSERT INTO queue_messages (queue_name, content_type, content_encoding, message_id, headers, body, reply_to, correlation_id)
+			SELECT b.queue_name, CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS JSONB), CAST(? AS BYTEA), CAST(? AS TEXT), CAST(? AS TEXT)
+			FROM (
+				SELECT DISTINCT queue_name FROM queue_bindings
+				WHERE exchange = ? AND '.' || CAST(? AS TEXT) ~ regex
+			) b
+			RETURNING queue_name
+		)
+		SELECT pg_notify(?, queue_name) FROM (SELECT DISTINCT queue_name FROM routed) q`,
+		message.ContentType, message.ContentEncoding, message.MessageID, message.Headers, message.Body,
+		message.ReplyTo, message.CorrelationID, exchange, routingKey, QueueNotifyChannel)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to publish message: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Claim implements QueueRepository.
+// Up to limit visible messages, available and not locked, are locked for
+// lockFor. SKIP LOCKED lets
+// several workers claim from the same queue without waiting on each other; a
+// message whose lock expired (e.g. the worker died) is claimed again.
+func (r *queueRepository) Claim(ctx context.Context, queueName string, limit int, lockFor time.Duration) ([]models.QueueMessage, error) {
+	var messages []models.QueueMessage
+	err := r.db.WithContext(ctx).Clauses(dbresolver.Write).Raw(`
+		UPDATE queue_messages
+		SET deliveries = deliveries + 1, locked_until = NOW() + make_interval(secs => ?)
+		WHERE id IN (
+			SELECT id FROM queue_messages
+			WHERE queue_name = ? AND available_at <= NOW() AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, lockFor.Seconds(), queueName, limit).Scan(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim messages: %w", err)
+	}
+
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+// Ack implements QueueRepository.
+func (r *queueRepository) Ack(ctx context.Context, id int64) error {
+	if err := r.db.WithContext(ctx).Delete(&models.QueueMessage{}, id).Error; err != nil {
+		return fmt.Errorf("failed to ack message %d: %w", id, err)
+	}
+	return nil
+}
+
+// Release implements QueueRepository.
+// The message becomes visible again and listeners are woken up.
+func (r *queueRepository) Release(ctx context.Context, id int64) error {
+	err := r.db.WithContext(ctx).Exec(`
+		WITH released AS (
+			UPDATE queue_messages SET locked_until = NULL WHERE id = ? RETURNING queue_name
+		)
+		SELECT pg_notify(?, queue_name) FROM released`, id, QueueNotifyChannel).Error
+	if err != nil {
+		return fmt.Errorf("failed to release message %d: %w", id, err)
+	}
+	return nil
+}
+
+// Extend implements QueueRepository.
+// Claimed messages stay invisible for another lockFor while they are handled.
+func (r *queueRepository) Extend(ctx context.Context, ids []int64, lockFor time.Duration) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := r.db.WithContext(ctx).Exec(`
+		UPDATE queue_messages SET locked_until = NOW() + make_interval(secs => ?)
+		WHERE id IN ? AND locked_until IS NOT NULL`, lockFor.Seconds(), ids).Error
+	if err != nil {
+		return fmt.Errorf("failed to extend message locks: %w", err)
+	}
+	return nil
+}
+
+// DeadLetter implements QueueRepository.
+// The message is moved to deadLetterQueue, declared when missing, with the
+// reason in its x-death-reason header.
+func (r *queueRepository) DeadLetter(ctx context.Context, id int64, deadLetterQueue, reason string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Queue{Name: deadLetterQueue}).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+			UPDATE queue_messages
+			SET queue_name = ?, locked_until = NULL, deliveries = 0,
+				headers = headers || jsonb_build_object('x-death-reason', ?::text)
+			WHERE id = ?`, deadLetterQueue, reason, id).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter message %d: %w", id, err)
+	}
+	return nil
+}
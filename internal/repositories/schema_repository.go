@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type SchemaRepository interface {
+	Create(ctx context.Context, schema *models.TenantSchema) error
+	List(ctx context.Context, tenantID string) ([]models.TenantSchema, error)
+	Find(ctx context.Context, tenantID string, version int) (models.TenantSchema, error)
+	FindActive(ctx context.Context, tenantID string) (*models.TenantSchema, error)
+	Activate(ctx context.Context, tenantID string, version int) error
+}
+
+type schemaRepository struct {
+	db *gorm.DB
+}
+
+func NewSchemaRepository(db *gorm.DB) SchemaRepository {
+	return &schemaRepository{
+		db: db,
+	}
+}
+
+// Create implements SchemaRepository.
+// The next version number is assigned under a per-tenant advisory lock; when
+// schema.Active is set the new version replaces the active one.
+func (r *schemaRepository) Create(ctx context.Context, schema *models.TenantSchema) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "tenant_schemas:"+schema.TenantID).Error; err != nil {
+			return err
+		}
+
+		var latest int
+		if err := tx.Model(&models.TenantSchema{}).
+			Where("tenant_id = ?", schema.TenantID).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error; err != nil {
+			return fmt.Errorf("error reading schema version: %w", err)
+		}
+		schema.Version = latest + 1
+
+		if schema.Active {
+			if err := deactivateSchemas(tx, schema.TenantID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(schema).Error; err != nil {
+			return fmt.Errorf("error creating schema: %w", err)
+		}
+		return nil
+	})
+}
+
+// List implements SchemaRepository.
+func (r *schemaRepository) List(ctx context.Context, tenantID string) ([]models.TenantSchema, error) {
+	schemas := []models.TenantSchema{}
+	if err := r.db.WithContext(ctx).Where("tenant_id = ?", tenantID).Order("version").Find(&schemas).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving schemas: %w", err)
+	}
+	return schemas, nil
+}
+
+// Find implements SchemaRepository.
+func (r *schemaRepository) Find(ctx context.Context, tenantID string, version int) (models.TenantSchema, error) {
+	var schema models.TenantSchema
+	err := r.db.WithContext(ctx).Where("tenant_id = ? AND version = ?", tenantID, version).First(&schema).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return schema, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("schema version %d not found", version))
+	}
+	if err != nil {
+		return schema, fmt.Errorf("error retrieving schema: %w", err)
+	}
+	return schema, nil
+}
+
+// FindActive implements SchemaRepository.
+// It returns nil when the tenant has no active schema.
+func (r *schemaRepository) FindActive(ctx context.Context, tenantID string) (*models.TenantSchema, error) {
+	var schemas []models.TenantSchema
+	if err := r.db.WithContext(ctx).Where("tenant_id = ? AND active", tenantID).Limit(1).Find(&schemas).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving active schema: %w", err)
+	}
+	if len(schemas) == 0 {
+		return nil, nil
+	}
+	return &schemas[0], nil
+}
+
+// Activate implements SchemaRepository.
+func (r *schemaRepository) Activate(ctx context.Context, tenantID string, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := deactivateSchemas(tx, tenantID); err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.TenantSchema{}).
+			Where("tenant_id = ? AND version = ?", tenantID, version).
+			Update("active", true)
+		if result.Error != nil {
+			return fmt.Errorf("error activating schema: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("schema version %d not found", version))
+		}
+		return nil
+	})
+}
+
+func deactivateSchemas(tx *gorm.DB, tenantID string) error {
+	if err := tx.Model(&models.TenantSchema{}).
+		Where("tenant_id = ? AND active", tenantID).
+		Update("active", false).Error; err != nil {
+		return fmt.Errorf("error deactivating schemas: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type TenantKeyRepository interface {
+	FindActive(ctx context.Context, tenantID string) (*models.TenantKey, error)
+	Find(ctx context.Context, tenantID string, version int) (models.TenantKey, error)
+	EnsureActive(ctx context.Context, tenantID string, wrappedKey []byte) (models.TenantKey, error)
+	Rotate(ctx context.Context, tenantID string, wrappedKey []byte) (models.TenantKey, error)
+}
+
+type tenantKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewTenantKeyRepository(db *gorm.DB) TenantKeyRepository {
+	return &tenantKeyRepository{
+		db: db,
+	}
+}
+
+// FindActive implements TenantKeyRepository.
+// It returns nil when the tenant has no key yet.
+func (r *tenantKeyRepository) FindActive(ctx context.Context, tenantID string) (*models.TenantKey, error) {
+	var keys []models.TenantKey
+	if err := r.db.WithContext(ctx).Where("tenant_id = ? AND active", tenantID).Limit(1).Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving tenant key: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return &keys[0], nil
+}
+
+// Find implements TenantKeyRepository.
+func (r *tenantKeyRepository) Find(ctx context.Context, tenantID string, version int) (models.TenantKey, error) {
+	var key models.TenantKey
+	err := r.db.WithContext(ctx).Where("tenant_id = ? AND version = ?", tenantID, version).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return key, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("key version %d not found for tenant %s", version, tenantID))
+	}
+	if err != nil {
+		return key, fmt.Errorf("error retrieving tenant key: %w", err)
+	}
+	return key, nil
+}
+
+// EnsureActive implements TenantKeyRepository.
+// It stores wrappedKey as the first key unless the tenant already has an
+// active key, in which case that key is returned and wrappedKey is discarded.
+func (r *tenantKeyRepository) EnsureActive(ctx context.Context, tenantID string, wrappedKey []byte) (models.TenantKey, error) {
+	var key models.TenantKey
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockTenantKeys(tx, tenantID); err != nil {
+			return err
+		}
+
+		var existing []models.TenantKey
+		if err := tx.Where("tenant_id = ? AND active", tenantID).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			key = existing[0]
+			return nil
+		}
+
+		var err error
+		key, err = insertTenantKey(tx, tenantID, wrappedKey)
+		return err
+	})
+	if err != nil {
+		return key, fmt.Errorf("error creating tenant key: %w", err)
+	}
+	return key, nil
+}
+
+// Rotate implements TenantKeyRepository.
+// The new key becomes active; previous versions are kept to read old rows.
+func (r *tenantKeyRepository) Rotate(ctx context.Context, tenantID string, wrappedKey []byte) (models.TenantKey, error) {
+	var key models.TenantKey
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockTenantKeys(tx, tenantID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.TenantKey{}).Where("tenant_id = ? AND active", tenantID).Update("active", false).Error; err != nil {
+			return err
+		}
+
+		var err error
+		key, err = insertTenantKey(tx, tenantID, wrappedKey)
+		return err
+	})
+	if err != nil {
+		return key, fmt.Errorf("error rotating tenant key: %w", err)
+	}
+	return key, nil
+}
+
+func lockTenantKeys(tx *gorm.DB, tenantID string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "tenant_keys:"+tenantID).Error
+}
+
+func insertTenantKey(tx *gorm.DB, tenantID string, wrappedKey []byte) (models.TenantKey, error) {
+	var latest int
+	if err := tx.Model(&models.TenantKey{}).
+		Where("tenant_id = ?", tenantID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&latest).Error; err != nil {
+		return models.TenantKey{}, err
+	}
+
+	key := models.TenantKey{
+		TenantID:   tenantID,
+		Version:    latest + 1,
+		WrappedKey: wrappedKey,
+		Active:     true,
+	}
+	if err := tx.Create(&key).Error; err != nil {
+		return models.TenantKey{}, err
+	}
+	return key, nil
+}
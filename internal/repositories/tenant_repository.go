@@ -0,0 +1,141 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TenantRepository interface {
+	Save(ctx context.Context, tenant *models.Tenant) error
+	Find(ctx context.Context, tenantID string) (models.Tenant, error)
+	FindAll(ctx context.Context) ([]models.Tenant, error)
+	FindByLabels(ctx context.Context, labels map[string]string) ([]models.Tenant, error)
+	SetLabels(ctx context.Context, tenantID string, labels map[string]string) error
+	SetQueueOptions(ctx context.Context, tenantID string, options models.QueueOptions) error
+	SetPipeline(ctx context.Context, tenantID string, pipeline models.Pipeline) error
+	Delete(ctx context.Context, tenantID string) error
+}
+
+type tenantRepository struct {
+	db *gorm.DB
+}
+
+func NewTenantRepository(db *gorm.DB) TenantRepository {
+	return &tenantRepository{
+		db: db,
+	}
+}
+
+// Save implements TenantRepository.
+// Labels are only written for new tenants, use SetLabels to change them.
+func (r *tenantRepository) Save(ctx context.Context, tenant *models.Tenant) error {
+	if tenant.Labels == nil {
+		tenant.Labels = models.JSONB{}
+	}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"workers", "updated_at"}),
+	}).Create(tenant).Error
+	if err != nil {
+		return fmt.Errorf("error saving tenant: %w", err)
+	}
+	return nil
+}
+
+// Find implements TenantRepository.
+func (r *tenantRepository) Find(ctx context.Context, tenantID string) (models.Tenant, error) {
+	var tenant models.Tenant
+	err := r.db.WithContext(ctx).Where("id = ?", tenantID).First(&tenant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tenant, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("tenant %s not found", tenantID))
+	}
+	if err != nil {
+		return tenant, fmt.Errorf("error retrieving tenant: %w", err)
+	}
+	return tenant, nil
+}
+
+// FindAll implements TenantRepository.
+func (r *tenantRepository) FindAll(ctx context.Context) ([]models.Tenant, error) {
+	var tenants []models.Tenant
+	if err := r.db.WithContext(ctx).Order("created_at").Find(&tenants).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving tenants: %w", err)
+	}
+	return tenants, nil
+}
+
+// FindByLabels implements TenantRepository.
+// A tenant matches when it carries every given label.
+func (r *tenantRepository) FindByLabels(ctx context.Context, labels map[string]string) ([]models.Tenant, error) {
+	contains, err := json.Marshal(labels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labels: %w", err)
+	}
+
+	var tenants []models.Tenant
+	if err := r.db.WithContext(ctx).Where("labels @> ?::jsonb", string(contains)).
+		Order("created_at").Find(&tenants).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving tenants: %w", err)
+	}
+	return tenants, nil
+}
+
+// SetLabels implements TenantRepository.
+// It replaces every label of the tenant.
+func (r *tenantRepository) SetLabels(ctx context.Context, tenantID string, labels map[string]string) error {
+	encoded, err := json.Marshal(labels)
+	if err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.Tenant{}).Where("id = ?", tenantID).
+		Updates(map[string]any{"labels": gorm.Expr("?::jsonb", string(encoded)), "updated_at": gorm.Expr("NOW()")})
+	if result.Error != nil {
+		return fmt.Errorf("error saving labels: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("tenant %s not found", tenantID))
+	}
+	return nil
+}
+
+// SetQueueOptions implements TenantRepository.
+func (r *tenantRepository) SetQueueOptions(ctx context.Context, tenantID string, options models.QueueOptions) error {
+	result := r.db.WithContext(ctx).Model(&models.Tenant{}).Where("id = ?", tenantID).
+		Updates(map[string]any{"queue_options": options, "updated_at": gorm.Expr("NOW()")})
+	if result.Error != nil {
+		return fmt.Errorf("error saving queue options: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("tenant %s not found", tenantID))
+	}
+	return nil
+}
+
+// SetPipeline implements TenantRepository.
+func (r *tenantRepository) SetPipeline(ctx context.Context, tenantID string, pipeline models.Pipeline) error {
+	result := r.db.WithContext(ctx).Model(&models.Tenant{}).Where("id = ?", tenantID).
+		Updates(map[string]any{"pipeline": pipeline, "updated_at": gorm.Expr("NOW()")})
+	if result.Error != nil {
+		return fmt.Errorf("error saving pipeline: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("tenant %s not found", tenantID))
+	}
+	return nil
+}
+
+// Delete implements TenantRepository.
+func (r *tenantRepository) Delete(ctx context.Context, tenantID string) error {
+	if err := r.db.WithContext(ctx).Where("id = ?", tenantID).Delete(&models.Tenant{}).Error; err != nil {
+		return fmt.Errorf("error deleting tenant: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UsageRepository interface {
+	Increment(ctx context.Context, rollups []models.UsageRollup) error
+	Series(ctx context.Context, tenantID string, from, to time.Time, bucket string) ([]dto.UsagePointDto, error)
+}
+
+type usageRepository struct {
+	db *gorm.DB
+}
+
+func NewUsageRepository(db *gorm.DB) UsageRepository {
+	return &usageRepository{
+		db: db,
+	}
+}
+
+// Increment implements UsageRepository.
+// Counters are added to the existing bucket rows, so concurrent instances can
+// flush into the same bucket.
+func (r *usageRepository) Increment(ctx context.Context, rollups []models.UsageRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	increment := func(column string) clause.Assignment {
+		return clause.Assignment{
+			Column: clause.Column{Name: column},
+			Value:  gorm.Expr("usage_rollups." + column + " + EXCLUDED." + column),
+		}
+	}
+
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "tenant_id"}, {Name: "bucket"}},
+		DoUpdates: clause.Set{
+			increment("published"),
+			increment("published_bytes"),
+			increment("stored"),
+			increment("stored_bytes"),
+			increment("failed"),
+			increment("dead_lettered"),
+		},
+	}).Create(&rollups).Error
+	if err != nil {
+		return fmt.Errorf("error saving usage: %w", err)
+	}
+	return nil
+}
+
+// Series implements UsageRepository.
+// Hourly rows are summed into day buckets in UTC when bucket is "day".
+func (r *usageRepository) Series(ctx context.Context, tenantID string, from, to time.Time, bucket string) ([]dto.UsagePointDto, error) {
+	points := []dto.UsagePointDto{}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT date_trunc(?, bucket AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket,
+			SUM(published) AS published,
+			SUM(published_bytes) AS published_bytes,
+			SUM(stored) AS stored,
+			SUM(stored_bytes) AS stored_bytes,
+			SUM(failed) AS failed,
+			SUM(dead_lettered) AS dead_lettered
+		FROM usage_rollups
+		WHERE tenant_id = ? AND bucket >= ? AND bucket < ?
+		GROUP BY 1
+		ORDER BY 1`, bucket, tenantID, from, to).Scan(&points).Error
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving usage: %w", err)
+	}
+	return points, nil
+}
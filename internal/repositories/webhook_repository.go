@@ -0,0 +1,290 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type WebhookRepository interface {
+	Create(ctx context.Context, hook *models.TenantWebhook) error
+	List(ctx context.Context, tenantID string) ([]models.TenantWebhook, error)
+	Find(ctx context.Context, tenantID, hookID string) (models.TenantWebhook, error)
+	Delete(ctx context.Context, tenantID, hookID string) (bool, error)
+	DeleteAll(ctx context.Context, tenantID string) error
+	EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	ClaimDue(ctx context.Context, limit int, lockFor time.Duration) ([]models.WebhookDelivery, error)
+	SaveAttempt(ctx context.Context, delivery *models.WebhookDelivery) error
+	ListDeliveries(ctx context.Context, hookID, status string, limit int) ([]models.WebhookDelivery, error)
+}
+
+type webhookRepository struct {
+	db     *gorm.DB
+	cipher PayloadCipher
+}
+
+// NewWebhookRepository constructor. With a cipher the signing secrets and the
+// delivery payloads are encrypted with the tenant key, like message payloads;
+// cipher may be nil to store them in clear.
+func NewWebhookRepository(db *gorm.DB, cipher PayloadCipher) WebhookRepository {
+	return &webhookRepository{
+		db:     db,
+		cipher: cipher,
+	}
+}
+
+// Create implements WebhookRepository.
+// hook keeps its plaintext secret; only the stored row is encrypted.
+func (r *webhookRepository) Create(ctx context.Context, hook *models.TenantWebhook) error {
+	stored := *hook
+	secret, err := r.sealSecret(ctx, hook.TenantID, hook.Secret)
+	if err != nil {
+		return err
+	}
+	stored.Secret = secret
+
+	if err := r.db.WithContext(ctx).Create(&stored).Error; err != nil {
+		return fmt.Errorf("error saving webhook: %w", err)
+	}
+	hook.CreatedAt = stored.CreatedAt
+	return nil
+}
+
+// List implements WebhookRepository.
+func (r *webhookRepository) List(ctx context.Context, tenantID string) ([]models.TenantWebhook, error) {
+	var hooks []models.TenantWebhook
+	if err := r.db.WithContext(ctx).Where("tenant_id = ?", tenantID).
+		Order("created_at").Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// Find implements WebhookRepository.
+func (r *webhookRepository) Find(ctx context.Context, tenantID, hookID string) (models.TenantWebhook, error) {
+	var hook models.TenantWebhook
+	err := r.db.WithContext(ctx).Where("tenant_id = ? AND id = ?", tenantID, hookID).First(&hook).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hook, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("webhook %s not found", hookID))
+	}
+	if err != nil {
+		return hook, fmt.Errorf("error retrieving webhook: %w", err)
+	}
+	return hook, nil
+}
+
+// Delete implements WebhookRepository.
+// Returns false when the tenant has no such webhook; its deliveries go with it.
+func (r *webhookRepository) Delete(ctx context.Context, tenantID, hookID string) (bool, error) {
+	result := r.db.WithContext(ctx).Where("tenant_id = ? AND id = ?", tenantID, hookID).
+		Delete(&models.TenantWebhook{})
+	if result.Error != nil {
+		return false, fmt.Errorf("error deleting webhook: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteAll implements WebhookRepository.
+func (r *webhookRepository) DeleteAll(ctx context.Context, tenantID string) error {
+	if err := r.db.WithContext(ctx).Where("tenant_id = ?", tenantID).
+		Delete(&models.TenantWebhook{}).Error; err != nil {
+		return fmt.Errorf("error deleting webhooks: %w", err)
+	}
+	return nil
+}
+
+// EnqueueDeliveries implements WebhookRepository.
+func (r *webhookRepository) EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	stored := make([]models.WebhookDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		payload, err := r.encrypt(ctx, delivery.TenantID, delivery.Payload)
+		if err != nil {
+			return err
+		}
+		stored[i] = delivery
+		stored[i].Payload = payload
+	}
+	if err := r.db.WithContext(ctx).Omit("Webhook").Create(&stored).Error; err != nil {
+		return fmt.Errorf("error enqueueing webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// ClaimDue implements WebhookRepository.
+// Up to limit due pending deliveries are leased by moving their
+// next_attempt_at lockFor ahead, and returned with their webhook. SKIP LOCKED
+// and the lease keep other instances from sending them meanwhile; the claim
+// commits right away, so no transaction stays open while they are sent. A
+// delivery whose attempt is never saved (e.g. the process died) is sent again
+// once the lease ends (at-least-once delivery). A delivery that cannot be
+// decrypted is marked failed with the error and left out of the batch.
+func (r *webhookRepository) ClaimDue(ctx context.Context, limit int, lockFor time.Duration) ([]models.WebhookDelivery, error) {
+	var due []models.WebhookDelivery
+	err := r.db.WithContext(ctx).Raw(`
+		UPDATE webhook_deliveries
+		SET next_attempt_at = NOW() + make_interval(secs => ?)
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, lockFor.Seconds(), models.WebhookDeliveryPending, limit).Scan(&due).Error
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	hookIDs := make([]string, 0, len(due))
+	for _, delivery := range due {
+		hookIDs = append(hookIDs, delivery.WebhookID)
+	}
+	var hooks []models.TenantWebhook
+	if err := r.db.WithContext(ctx).Where("id IN ?", hookIDs).Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("error reading webhooks: %w", err)
+	}
+	byID := make(map[string]models.TenantWebhook, len(hooks))
+	for _, hook := range hooks {
+		byID[hook.ID] = hook
+	}
+	claimed := due[:0]
+	for _, delivery := range due {
+		delivery.Webhook = byID[delivery.WebhookID]
+		if delivery.Payload, err = r.decrypt(ctx, delivery.TenantID, delivery.Payload); err != nil {
+			r.failUndecryptable(ctx, delivery, fmt.Errorf("error decrypting payload: %w", err))
+			continue
+		}
+		if delivery.Webhook.Secret, err = r.openSecret(ctx, delivery.TenantID, delivery.Webhook.Secret); err != nil {
+			r.failUndecryptable(ctx, delivery, fmt.Errorf("error decrypting webhook secret: %w", err))
+			continue
+		}
+		claimed = append(claimed, delivery)
+	}
+
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].CreatedAt.Before(claimed[j].CreatedAt) })
+	return claimed, nil
+}
+
+// failUndecryptable records cause as the last attempt of a delivery that can
+// never be sent, so it stops being claimed. When that fails the delivery is
+// claimed again once its lease ends.
+func (r *webhookRepository) failUndecryptable(ctx context.Context, delivery models.WebhookDelivery, cause error) {
+	log.Printf("[Webhook] Delivery %s failed: %v", delivery.ID, cause)
+	delivery.Attempts++
+	delivery.Status = models.WebhookDeliveryFailed
+	delivery.AttemptLog = append(delivery.AttemptLog, models.WebhookAttempt{
+		Attempt: delivery.Attempts,
+		Error:   cause.Error(),
+		At:      time.Now(),
+	})
+	if err := r.SaveAttempt(ctx, &delivery); err != nil {
+		log.Printf("[Webhook] %v", err)
+	}
+}
+
+// SaveAttempt implements WebhookRepository.
+// Records the attempt made on a claimed delivery, ending its lease.
+func (r *webhookRepository) SaveAttempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if err := r.db.WithContext(ctx).Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(map[string]any{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"attempt_log":     delivery.AttemptLog,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"delivered_at":    delivery.DeliveredAt,
+	}).Error; err != nil {
+		return fmt.Errorf("error saving webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListDeliveries implements WebhookRepository.
+// Newest deliveries come first; an empty status returns every status.
+func (r *webhookRepository) ListDeliveries(ctx context.Context, hookID, status string, limit int) ([]models.WebhookDelivery, error) {
+	query := r.db.WithContext(ctx).Where("webhook_id = ?", hookID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("created_at DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("error retrieving webhook deliveries: %w", err)
+	}
+	for i := range deliveries {
+		payload, err := r.decrypt(ctx, deliveries[i].TenantID, deliveries[i].Payload)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting webhook delivery %s: %w", deliveries[i].ID, err)
+		}
+		deliveries[i].Payload = payload
+	}
+	return deliveries, nil
+}
+
+func (r *webhookRepository) encrypt(ctx context.Context, tenantID string, payload models.JSONB) (models.JSONB, error) {
+	if r.cipher == nil {
+		return payload, nil
+	}
+	encrypted, err := r.cipher.Encrypt(ctx, tenantID, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting payload: %w", err)
+	}
+	return encrypted, nil
+}
+
+func (r *webhookRepository) decrypt(ctx context.Context, tenantID string, payload models.JSONB) (models.JSONB, error) {
+	if r.cipher == nil {
+		return payload, nil
+	}
+	return r.cipher.Decrypt(ctx, tenantID, payload)
+}
+
+// sealSecret stores the secret as an encrypted JSON payload. It is sealed
+// under EncryptedField, which the cipher always overwrites with the
+// ciphertext, so no clear field can copy it out.
+func (r *webhookRepository) sealSecret(ctx context.Context, tenantID, secret string) (string, error) {
+	if r.cipher == nil {
+		return secret, nil
+	}
+	sealed, err := r.encrypt(ctx, tenantID, models.JSONB{EncryptedField: secret})
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// openSecret returns the plaintext of a sealed secret.
+// Secrets stored before encryption was enabled are returned as is.
+func (r *webhookRepository) openSecret(ctx context.Context, tenantID, stored string) (string, error) {
+	if r.cipher == nil || !strings.HasPrefix(stored, "{") {
+		return stored, nil
+	}
+	var sealed models.JSONB
+	if err := json.Unmarshal([]byte(stored), &sealed); err != nil {
+		return "", err
+	}
+	opened, err := r.cipher.Decrypt(ctx, tenantID, sealed)
+	if err != nil {
+		return "", err
+	}
+	secret, _ := opened[EncryptedField].(string)
+	return secret, nil
+}
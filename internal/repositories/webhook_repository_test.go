@@ -0,0 +1,128 @@
+package repositories
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestWebhookDeliveriesAreLeasedUntilSaved(t *testing.T) {
+	inTestTransaction(t, func(tx *gorm.DB) {
+		ctx := context.Background()
+		require.NoError(t, tx.Exec("DELETE FROM webhook_deliveries").Error)
+
+		repo := NewWebhookRepository(tx, nil)
+		hook := models.TenantWebhook{ID: uuid.NewString(), TenantID: uuid.NewString(), URL: "https://example.com/hook", EventTypes: "#", Secret: "whsec_test"}
+		require.NoError(t, repo.Create(ctx, &hook))
+
+		var deliveries []models.WebhookDelivery
+		for range 3 {
+			deliveries = append(deliveries, models.WebhookDelivery{
+				ID: uuid.NewString(), WebhookID: hook.ID, TenantID: hook.TenantID,
+				Payload: models.JSONB{"n": 1}, Status: models.WebhookDeliveryPending, NextAttemptAt: time.Now().Add(-time.Second),
+			})
+		}
+		require.NoError(t, repo.EnqueueDeliveries(ctx, deliveries))
+
+		claimed, err := repo.ClaimDue(ctx, 2, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 2)
+		assert.Equal(t, hook.URL, claimed[0].Webhook.URL)
+		assert.True(t, claimed[0].NextAttemptAt.After(time.Now().Add(50*time.Second)))
+
+		// Leased deliveries are not claimed again
+		rest, err := repo.ClaimDue(ctx, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+
+		now := time.Now()
+		claimed[0].Status, claimed[0].Attempts, claimed[0].DeliveredAt = models.WebhookDeliveryDelivered, 1, &now
+		require.NoError(t, repo.SaveAttempt(ctx, &claimed[0]))
+		claimed[1].Attempts, claimed[1].NextAttemptAt = 1, now.Add(-time.Second)
+		require.NoError(t, repo.SaveAttempt(ctx, &claimed[1]))
+
+		// A saved retry is due again, a delivered one never
+		again, err := repo.ClaimDue(ctx, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, again, 1)
+		assert.Equal(t, claimed[1].ID, again[0].ID)
+		assert.Equal(t, 1, again[0].Attempts)
+	})
+}
+
+func TestWebhookSecretsAndPayloadsAreEncrypted(t *testing.T) {
+	inTestTransaction(t, func(tx *gorm.DB) {
+		ctx := context.Background()
+		require.NoError(t, tx.Exec("DELETE FROM webhook_deliveries").Error)
+
+		repo := NewWebhookRepository(tx, testCipher(t, &memoryKeyRepository{}))
+		hook := models.TenantWebhook{ID: uuid.NewString(), TenantID: uuid.NewString(), URL: "https://example.com/hook", EventTypes: "#", Secret: "whsec_test"}
+		require.NoError(t, repo.Create(ctx, &hook))
+		assert.Equal(t, "whsec_test", hook.Secret)
+
+		delivery := models.WebhookDelivery{
+			ID: uuid.NewString(), WebhookID: hook.ID, TenantID: hook.TenantID,
+			Payload: models.JSONB{"order_id": "A-1", "email": "jane@example.com"}, Status: models.WebhookDeliveryPending, NextAttemptAt: time.Now().Add(-time.Second),
+		}
+		require.NoError(t, repo.EnqueueDeliveries(ctx, []models.WebhookDelivery{delivery}))
+
+		var secret, payload string
+		require.NoError(t, tx.Raw("SELECT secret FROM tenant_webhooks WHERE id = ?", hook.ID).Scan(&secret).Error)
+		require.NoError(t, tx.Raw("SELECT payload::text FROM webhook_deliveries WHERE id = ?", delivery.ID).Scan(&payload).Error)
+		assert.NotContains(t, secret, "whsec_test")
+		assert.NotContains(t, payload, "jane@example.com")
+		assert.Contains(t, payload, "A-1")
+
+		claimed, err := repo.ClaimDue(ctx, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		assert.Equal(t, "whsec_test", claimed[0].Webhook.Secret)
+		assert.Equal(t, "jane@example.com", claimed[0].Payload["email"])
+	})
+}
+
+func TestWebhookDeliveryThatCannotBeDecryptedIsFailed(t *testing.T) {
+	inTestTransaction(t, func(tx *gorm.DB) {
+		ctx := context.Background()
+		require.NoError(t, tx.Exec("DELETE FROM webhook_deliveries").Error)
+
+		repo := NewWebhookRepository(tx, testCipher(t, &memoryKeyRepository{}))
+		hook := models.TenantWebhook{ID: uuid.NewString(), TenantID: uuid.NewString(), URL: "https://example.com/hook", EventTypes: "#", Secret: "whsec_test"}
+		require.NoError(t, repo.Create(ctx, &hook))
+
+		var deliveries []models.WebhookDelivery
+		for range 3 {
+			deliveries = append(deliveries, models.WebhookDelivery{
+				ID: uuid.NewString(), WebhookID: hook.ID, TenantID: hook.TenantID,
+				Payload: models.JSONB{"email": "jane@example.com"}, Status: models.WebhookDeliveryPending, NextAttemptAt: time.Now().Add(-time.Second),
+			})
+		}
+		require.NoError(t, repo.EnqueueDeliveries(ctx, deliveries))
+
+		// Corrupt the ciphertext of one delivery
+		corrupt := deliveries[1].ID
+		require.NoError(t, tx.Exec(`UPDATE webhook_deliveries SET payload = jsonb_set(payload, '{_encrypted,data}', '"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"') WHERE id = ?`,
+			corrupt).Error)
+
+		claimed, err := repo.ClaimDue(ctx, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 2)
+		for _, delivery := range claimed {
+			assert.NotEqual(t, corrupt, delivery.ID)
+			assert.Equal(t, "jane@example.com", delivery.Payload["email"])
+		}
+
+		var failed models.WebhookDelivery
+		require.NoError(t, tx.First(&failed, "id = ?", corrupt).Error)
+		assert.Equal(t, models.WebhookDeliveryFailed, failed.Status)
+		assert.Equal(t, 1, failed.Attempts)
+		require.Len(t, failed.AttemptLog, 1)
+		assert.Contains(t, failed.AttemptLog[0].Error, "decrypting payload")
+	})
+}
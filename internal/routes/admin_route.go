@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func AdminRoute(router fiber.Router) {
+	admin := router.Group("/admin")
+
+	// GET /admin/reconcile reports drift, POST repairs it
+	admin.Get("/reconcile", adminHandler.GetReconcile)
+	admin.Post("/reconcile", adminHandler.FixReconcile)
+	// POST /admin/reconcile/orphans drops the orphans listed in the body
+	admin.Post("/reconcile/orphans", adminHandler.DropOrphans)
+	// GET /admin/default-partition lists tenants stuck in messages_default
+	admin.Get("/default-partition", adminHandler.GetStrayTenants)
+	admin.Post("/default-partition/:tenantId/adopt", adminHandler.AdoptStrayTenant)
+	// GET /admin/broker/channels reports the publishing channel pool
+	admin.Get("/broker/channels", adminHandler.GetChannelPool)
+}
@@ -9,4 +9,6 @@ func MessageRoute(router fiber.Router) {
 
 	messages.Post("/", messageHandler.PublishMessage)
 	messages.Get("/", messageHandler.GetMessages)
+	// POST /messages/broadcast sends one message to all, listed or labelled tenants
+	messages.Post("/broadcast", broadcastHandler.Broadcast)
 }
@@ -5,6 +5,8 @@ import (
 	"aswadwk/messaging-task-go/internal/handlers"
 	"aswadwk/messaging-task-go/internal/repositories"
 	"aswadwk/messaging-task-go/internal/services"
+	"context"
+	"log"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -17,15 +19,53 @@ var (
 
 	// Repositories
 	messageRepository repositories.MessageRepository
+	erasureRepository repositories.ErasureRepository
+	outboxRepository  repositories.OutboxRepository
+	tenantRepository  repositories.TenantRepository
+	schemaRepository  repositories.SchemaRepository
+	usageRepository   repositories.UsageRepository
+	bindingRepository repositories.BindingRepository
+	webhookRepository repositories.WebhookRepository
+
+	tenantKeyRepository repositories.TenantKeyRepository
+	payloadCipher       repositories.PayloadCipher
 
 	// Services
-	rabbitService    *services.RabbitMQ
+	broker           services.Broker
+	queueLister      services.QueueLister
 	tenantService    *services.TenantManager
 	publisherService *services.PublisherService
+	exportService    *services.ExportService
+	replayService    *services.ReplayService
+	erasureService   *services.ErasureService
+	outboxRelay      *services.OutboxRelay
+	reconcileService *services.ReconcileService
+	schemaService    *services.SchemaService
+	keyRotation      *services.KeyRotationService
+	usageRecorder    *services.UsageRecorder
+	usageService     *services.UsageService
+	broadcastService *services.BroadcastService
+	webhookService   *services.WebhookService
+	messageStream    *services.MessageStream
+
+	replyQueue *services.ReplyQueue
 
 	// Handlers
 	tenantHandler  *handlers.TenantHandler
 	messageHandler *handlers.MessageHandler
+	exportHandler  *handlers.ExportHandler
+	replayHandler  *handlers.ReplayHandler
+	erasureHandler *handlers.ErasureHandler
+	adminHandler   *handlers.AdminHandler
+	schemaHandler  *handlers.SchemaHandler
+	keyHandler     *handlers.KeyHandler
+	usageHandler   *handlers.UsageHandler
+	bindingHandler *handlers.BindingHandler
+
+	broadcastHandler *handlers.BroadcastHandler
+	pipelineHandler  *handlers.PipelineHandler
+	webhookHandler   *handlers.WebhookHandler
+	streamHandler    *handlers.StreamHandler
 )
 
 func Init() {
@@ -34,20 +74,112 @@ func Init() {
 	validate = validator.New(validator.WithRequiredStructEnabled())
 
 	// Repository
-	messageRepository = repositories.NewMessageRepository(db)
+	tenantKeyRepository = repositories.NewTenantKeyRepository(db)
+	payloadCipher = repositories.NewPayloadCipher(tenantKeyRepository, config.LoadMasterKey(), config.Cfg.EncryptionClearFields)
+	messageRepository = repositories.NewMessageRepository(db, payloadCipher)
+	erasureRepository = repositories.NewErasureRepository(db)
+	tenantRepository = repositories.NewTenantRepository(db)
+	schemaRepository = repositories.NewSchemaRepository(db)
+	usageRepository = repositories.NewUsageRepository(db)
+	bindingRepository = repositories.NewBindingRepository(db)
+	webhookRepository = repositories.NewWebhookRepository(db, payloadCipher)
 
 	// Services
-	rabbitService = services.NewRabbitMQ(config.Cfg.RabbitMQURL)
-	tenantService = services.NewTenantManager(rabbitService, messageRepository)
-	publisherService = services.NewPublisherService(rabbitService)
+	var err error
+	broker, queueLister, err = services.NewConfiguredBroker(repositories.NewQueueRepository(db))
+	if err != nil {
+		log.Fatal(err)
+	}
+	usageRecorder = services.NewUsageRecorder(usageRepository, config.Cfg.UsageFlushInterval)
+	usageRecorder.Start()
+	schemaService = services.NewSchemaService(schemaRepository)
+	webhookService = services.NewWebhookService(webhookRepository, config.Cfg.WebhookPollInterval)
+	webhookService.LimitRetries(config.Cfg.WebhookMaxAttempts, config.Cfg.WebhookRetryBackoff)
+	webhookService.SetTimeout(config.Cfg.WebhookTimeout)
+	if config.Cfg.WebhookAllowInsecure {
+		webhookService.AllowInsecureURLs()
+	}
+	webhookService.Start()
+	messageStream = services.NewMessageStream(messageRepository, config.Cfg.StreamHeartbeat)
+	messageStream.Listen(config.DSN())
+	tenantService = services.NewTenantManager(broker, messageRepository, tenantRepository, bindingRepository)
+	tenantService.EnableUsage(usageRecorder)
+	tenantService.EnableValidation(schemaService)
+	tenantService.EnableWebhooks(webhookService)
+	tenantService.EnableStreaming(messageStream)
+	tenantService.LimitRetries(config.Cfg.PipelineMaxAttempts, config.Cfg.PipelineRetryBackoff)
+	if lister, ok := queueLister.(services.BindingLister); ok {
+		tenantService.SyncBindings(lister)
+	}
+	if err := tenantService.RestoreTenants(context.Background()); err != nil {
+		log.Printf("[Init] Failed to restore tenants: %v", err)
+	}
+	compressor, err := services.NewPayloadCompressor(config.Cfg.PayloadCompression, config.Cfg.CompressionThreshold)
+	if err != nil {
+		log.Fatal(err)
+	}
+	publisherService = services.NewPublisherService(broker)
+	publisherService.EnableCompression(compressor)
+	publisherService.LimitPayloadSize(config.Cfg.MaxPayloadBytes)
+	publisherService.EnableUsage(usageRecorder)
+	if config.Cfg.PublishMode == "outbox" {
+		outboxRepository = repositories.NewOutboxRepository(db)
+		publisherService.EnableOutbox(outboxRepository)
+		outboxRelay = services.NewOutboxRelay(outboxRepository, broker, config.Cfg.OutboxPollInterval, config.Cfg.OutboxMaxAttempts)
+		outboxRelay.EnableCompression(compressor)
+		outboxRelay.Start()
+	}
+	exportService = services.NewExportService(messageRepository)
+	replayService = services.NewReplayService(messageRepository, publisherService)
+	if config.Cfg.ErasureEnabled {
+		erasureService = services.NewErasureService(messageRepository, erasureRepository, config.LoadErasureSigningKey())
+		if err := erasureService.FailInterrupted(context.Background()); err != nil {
+			log.Printf("Failed to close interrupted erasures: %v", err)
+		}
+	}
+	keyRotation = services.NewKeyRotationService(messageRepository, payloadCipher)
+	usageService = services.NewUsageService(usageRepository)
+	broadcastService = services.NewBroadcastService(tenantRepository, publisherService)
+	replyQueue = services.NewReplyQueue(broker)
+	if err := replyQueue.Start(); err != nil {
+		log.Fatal(err)
+	}
+	reconcileService = services.NewReconcileService(tenantRepository, messageRepository, broker, queueLister, tenantService)
 
 	// Handlers
 	tenantHandler = handlers.NewTenantHandler(tenantService)
-	messageHandler = handlers.NewMessageHandler(publisherService, tenantService)
+	messageHandler = handlers.NewMessageHandler(publisherService, tenantService, schemaService, replyQueue)
+	exportHandler = handlers.NewExportHandler(exportService)
+	replayHandler = handlers.NewReplayHandler(replayService)
+	if erasureService != nil {
+		erasureHandler = handlers.NewErasureHandler(erasureService)
+	}
+	adminHandler = handlers.NewAdminHandler(reconcileService, broker)
+	schemaHandler = handlers.NewSchemaHandler(schemaService)
+	keyHandler = handlers.NewKeyHandler(keyRotation)
+	usageHandler = handlers.NewUsageHandler(usageService)
+	bindingHandler = handlers.NewBindingHandler(tenantService)
+	broadcastHandler = handlers.NewBroadcastHandler(broadcastService)
+	pipelineHandler = handlers.NewPipelineHandler(tenantService)
+	webhookHandler = handlers.NewWebhookHandler(webhookService)
+	streamHandler = handlers.NewStreamHandler(messageStream)
+}
+
+// Shutdown stops the background workers started by Init once the server no
+// longer accepts requests
+func Shutdown() {
+	if outboxRelay != nil {
+		outboxRelay.Stop()
+	}
+	// Requests waiting for a reply have ended with the server
+	if replyQueue != nil {
+		replyQueue.Stop()
+	}
 }
 
 func SetupRoutes(app *fiber.App) {
 	// api := app.Group("/v1")
 	TenantRoute(app)
 	MessageRoute(app)
+	AdminRoute(app)
 }
@@ -11,4 +11,41 @@ func TenantRoute(router fiber.Router) {
 	tenants.Delete("/:id", tenantHandler.DeleteTenant)
 	// PUT /tenants/{id}/config/concurrency
 	tenants.Put("/:id/config/concurrency", tenantHandler.UpdateConcurrency)
+	// PUT /tenants/{id}/labels replaces the labels used by broadcasts
+	tenants.Put("/:id/labels", tenantHandler.UpdateLabels)
+	// GET /tenants/{id}/messages/export?format=ndjson|csv&from=&to=
+	tenants.Get("/:id/messages/export", exportHandler.ExportMessages)
+	// POST /tenants/{id}/replay
+	tenants.Post("/:id/replay", replayHandler.StartReplay)
+	tenants.Get("/:id/replay/:jobId", replayHandler.GetReplay)
+	// DELETE /tenants/{id}/messages/{messageId}, only with ERASURE_ENABLED=true
+	if erasureHandler != nil {
+		tenants.Delete("/:id/messages/:messageId", erasureHandler.DeleteMessage)
+		tenants.Post("/:id/erasures", erasureHandler.StartErasure)
+		tenants.Get("/:id/erasures/:reportId", erasureHandler.GetErasure)
+	}
+	// POST /tenants/{id}/schemas registers a new JSON Schema version
+	tenants.Post("/:id/schemas", schemaHandler.RegisterSchema)
+	tenants.Get("/:id/schemas", schemaHandler.ListSchemas)
+	tenants.Get("/:id/schemas/:version", schemaHandler.GetSchema)
+	tenants.Put("/:id/schemas/:version/activate", schemaHandler.ActivateSchema)
+	// POST /tenants/{id}/keys/rotate re-encrypts the partition with a new data key
+	tenants.Post("/:id/keys/rotate", keyHandler.RotateKey)
+	tenants.Get("/:id/keys/rotations/:jobId", keyHandler.GetRotation)
+
+	tenants.Get("/:id/stats", usageHandler.GetStats)
+	// POST /tenants/{id}/bindings subscribes the tenant to event types, e.g. orders.*
+	tenants.Post("/:id/bindings", bindingHandler.CreateBinding)
+	tenants.Get("/:id/bindings", bindingHandler.ListBindings)
+	tenants.Delete("/:id/bindings/:pattern", bindingHandler.DeleteBinding)
+	// PUT /tenants/{id}/pipeline sets the steps run on every message
+	tenants.Get("/:id/pipeline", pipelineHandler.GetPipeline)
+	tenants.Put("/:id/pipeline", pipelineHandler.UpdatePipeline)
+	// POST /tenants/{id}/webhooks pushes the tenant messages to an HTTPS endpoint
+	tenants.Post("/:id/webhooks", webhookHandler.CreateWebhook)
+	tenants.Get("/:id/webhooks", webhookHandler.ListWebhooks)
+	tenants.Delete("/:id/webhooks/:hookId", webhookHandler.DeleteWebhook)
+	tenants.Get("/:id/webhooks/:hookId/deliveries", webhookHandler.ListDeliveries)
+	// GET /tenants/{id}/stream pushes stored messages over SSE, or WebSocket on upgrade
+	tenants.Get("/:id/stream", streamHandler.StreamMessages)
 }
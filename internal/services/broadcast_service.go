@@ -0,0 +1,167 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// BroadcastEventType is used for broadcasts sent without an event_type
+const BroadcastEventType = "broadcast"
+
+// Outcome of a broadcast for one tenant
+const (
+	BroadcastPublished = "published"
+	BroadcastFailed    = "failed"
+	BroadcastNotFound  = "not_found"
+)
+
+// BroadcastService sends the same message to every tenant, a list of tenants
+// or the tenants carrying a set of labels
+type BroadcastService struct {
+	tenants   repositories.TenantRepository
+	publisher *PublisherService
+}
+
+// NewBroadcastService constructor
+func NewBroadcastService(tenants repositories.TenantRepository, publisher *PublisherService) *BroadcastService {
+	return &BroadcastService{
+		tenants:   tenants,
+		publisher: publisher,
+	}
+}
+
+// Broadcast publishes req.Payload to the selected tenants. A broadcast to all
+// tenants is published once to the fanout exchange and every tenant gets the
+// result of that publish; a list or label selection is published to each
+// tenant queue so every tenant gets its own result. The rows stored by the
+// consumers share the returned broadcast ID.
+func (s *BroadcastService) Broadcast(ctx context.Context, req dto.BroadcastDto) (dto.BroadcastResultDto, error) {
+	if req.Payload == nil {
+		return dto.BroadcastResultDto{}, fiber.NewError(fiber.StatusBadRequest, "payload cannot be empty")
+	}
+	if req.EventType == "" {
+		req.EventType = BroadcastEventType
+	}
+	if !ValidRoutingKey(req.EventType) {
+		return dto.BroadcastResultDto{}, fiber.NewError(fiber.StatusBadRequest, "event_type must be dot separated words of letters, digits, _ or -")
+	}
+
+	broadcastID, err := uuid.NewV7()
+	if err != nil {
+		return dto.BroadcastResultDto{}, err
+	}
+	result := dto.BroadcastResultDto{
+		BroadcastID: broadcastID.String(),
+		EventType:   req.EventType,
+		Results:     []dto.BroadcastDeliveryDto{},
+	}
+	msg := Message{
+		EventType:   req.EventType,
+		Payload:     req.Payload,
+		BroadcastID: result.BroadcastID,
+	}
+
+	target := req.Target
+	switch {
+	case target.All && len(target.TenantIDs) == 0 && len(target.Labels) == 0:
+		tenants, err := s.tenants.FindAll(ctx)
+		if err != nil {
+			return result, err
+		}
+
+		ids := make([]string, len(tenants))
+		for i, tenant := range tenants {
+			ids[i] = tenant.ID
+		}
+		status, errText := BroadcastPublished, ""
+		if err := s.publisher.PublishBroadcast(msg, ids); err != nil {
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				return result, err
+			}
+			status, errText = BroadcastFailed, err.Error()
+		}
+		for _, tenant := range tenants {
+			result.Results = append(result.Results, dto.BroadcastDeliveryDto{TenantID: tenant.ID, Status: status, Error: errText})
+		}
+
+	case !target.All && len(target.TenantIDs) > 0 && len(target.Labels) == 0:
+		ids, err := broadcastTenantIDs(target.TenantIDs)
+		if err != nil {
+			return result, err
+		}
+		for _, id := range ids {
+			if _, err := s.tenants.Find(ctx, id); err != nil {
+				var fiberErr *fiber.Error
+				if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusNotFound {
+					result.Results = append(result.Results, dto.BroadcastDeliveryDto{TenantID: id, Status: BroadcastNotFound})
+					continue
+				}
+				return result, err
+			}
+			delivery, err := s.publishTo(id, msg)
+			if err != nil {
+				return result, err
+			}
+			result.Results = append(result.Results, delivery)
+		}
+
+	case !target.All && len(target.TenantIDs) == 0 && len(target.Labels) > 0:
+		tenants, err := s.tenants.FindByLabels(ctx, target.Labels)
+		if err != nil {
+			return result, err
+		}
+		for _, tenant := range tenants {
+			delivery, err := s.publishTo(tenant.ID, msg)
+			if err != nil {
+				return result, err
+			}
+			result.Results = append(result.Results, delivery)
+		}
+
+	default:
+		return result, fiber.NewError(fiber.StatusBadRequest, "target must set exactly one of all, tenant_ids or labels")
+	}
+
+	log.Printf("[Broadcast] %s (%s) sent to %d tenant(s)", result.BroadcastID, result.EventType, len(result.Results))
+	return result, nil
+}
+
+// publishTo sends msg straight to the tenant queue. Request errors, e.g. a
+// payload above the size limit, fail the whole broadcast.
+func (s *BroadcastService) publishTo(tenantID string, msg Message) (dto.BroadcastDeliveryDto, error) {
+	msg.TenantID = tenantID
+	if err := s.publisher.Publish(TenantQueueName(tenantID), msg); err != nil {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			return dto.BroadcastDeliveryDto{}, err
+		}
+		return dto.BroadcastDeliveryDto{TenantID: tenantID, Status: BroadcastFailed, Error: err.Error()}, nil
+	}
+	return dto.BroadcastDeliveryDto{TenantID: tenantID, Status: BroadcastPublished}, nil
+}
+
+// broadcastTenantIDs validates and de-duplicates the requested tenant IDs,
+// keeping their order
+func broadcastTenantIDs(raw []string) ([]string, error) {
+	seen := make(map[string]bool, len(raw))
+	ids := make([]string, 0, len(raw))
+	for _, value := range raw {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid tenant id %q", value))
+		}
+		if !seen[id.String()] {
+			seen[id.String()] = true
+			ids = append(ids, id.String())
+		}
+	}
+	return ids, nil
+}
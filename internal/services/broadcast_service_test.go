@@ -0,0 +1,134 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTenantRepository serves a fixed list of tenants
+type fakeTenantRepository struct {
+	repositories.TenantRepository
+	tenants []models.Tenant
+}
+
+func (r *fakeTenantRepository) Find(ctx context.Context, tenantID string) (models.Tenant, error) {
+	for _, tenant := range r.tenants {
+		if tenant.ID == tenantID {
+			return tenant, nil
+		}
+	}
+	return models.Tenant{}, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("tenant %s not found", tenantID))
+}
+
+func (r *fakeTenantRepository) FindAll(ctx context.Context) ([]models.Tenant, error) {
+	return r.tenants, nil
+}
+
+func (r *fakeTenantRepository) FindByLabels(ctx context.Context, labels map[string]string) ([]models.Tenant, error) {
+	var matched []models.Tenant
+	for _, tenant := range r.tenants {
+		matches := true
+		for key, value := range labels {
+			if tenant.Labels[key] != value {
+				matches = false
+			}
+		}
+		if matches {
+			matched = append(matched, tenant)
+		}
+	}
+	return matched, nil
+}
+
+func TestBroadcastFansOutToSelectedTenants(t *testing.T) {
+	gold := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000c1")
+	silver := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000c2")
+	unknown := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000c3")
+	ctx := context.Background()
+
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{
+		{ID: gold.String(), Labels: models.JSONB{"tier": "gold"}},
+		{ID: silver.String(), Labels: models.JSONB{"tier": "silver"}},
+	}}
+	broker := NewMemoryBroker()
+	repo := &recordingMessageRepository{}
+	manager := NewTenantManager(broker, repo, tenants, newFakeBindingRepository())
+	publisher := NewPublisherService(broker)
+	service := NewBroadcastService(tenants, publisher)
+
+	for _, id := range []uuid.UUID{gold, silver} {
+		require.NoError(t, manager.StartTenantConsumer(ctx, id, 1))
+		defer manager.StopTenantConsumer(id)
+	}
+
+	all, err := service.Broadcast(ctx, dto.BroadcastDto{
+		Target:  dto.BroadcastTargetDto{All: true},
+		Payload: map[string]any{"notice": "maintenance"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, BroadcastEventType, all.EventType)
+	assert.Equal(t, []dto.BroadcastDeliveryDto{
+		{TenantID: gold.String(), Status: BroadcastPublished},
+		{TenantID: silver.String(), Status: BroadcastPublished},
+	}, all.Results)
+	assert.Eventually(t, func() bool { return repo.count() == 2 }, time.Second, 5*time.Millisecond)
+
+	listed, err := service.Broadcast(ctx, dto.BroadcastDto{
+		Target:  dto.BroadcastTargetDto{TenantIDs: []string{unknown.String(), gold.String(), gold.String()}},
+		Payload: map[string]any{"notice": "invoice"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []dto.BroadcastDeliveryDto{
+		{TenantID: unknown.String(), Status: BroadcastNotFound},
+		{TenantID: gold.String(), Status: BroadcastPublished},
+	}, listed.Results)
+
+	labelled, err := service.Broadcast(ctx, dto.BroadcastDto{
+		Target:    dto.BroadcastTargetDto{Labels: map[string]string{"tier": "silver"}},
+		EventType: "notices.pricing",
+		Payload:   map[string]any{"notice": "pricing"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []dto.BroadcastDeliveryDto{{TenantID: silver.String(), Status: BroadcastPublished}}, labelled.Results)
+	assert.Eventually(t, func() bool { return repo.count() == 4 }, time.Second, 5*time.Millisecond)
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored := map[string][]string{}
+	for _, message := range repo.stored {
+		stored[message.BroadcastID] = append(stored[message.BroadcastID], message.TenantID)
+		assert.Empty(t, message.Binding)
+	}
+	assert.ElementsMatch(t, []string{gold.String(), silver.String()}, stored[all.BroadcastID])
+	assert.Equal(t, []string{gold.String()}, stored[listed.BroadcastID])
+	assert.Equal(t, []string{silver.String()}, stored[labelled.BroadcastID])
+}
+
+func TestBroadcastRejectsInvalidTargets(t *testing.T) {
+	service := NewBroadcastService(&fakeTenantRepository{}, NewPublisherService(NewMemoryBroker()))
+	payload := map[string]any{"notice": "x"}
+
+	for _, target := range []dto.BroadcastTargetDto{
+		{},
+		{All: true, TenantIDs: []string{"0190d8a4-0000-7000-8000-0000000000c1"}},
+		{TenantIDs: []string{"not-a-uuid"}},
+	} {
+		_, err := service.Broadcast(context.Background(), dto.BroadcastDto{Target: target, Payload: payload})
+		var fiberErr *fiber.Error
+		require.ErrorAs(t, err, &fiberErr)
+		assert.Equal(t, fiber.StatusBadRequest, fiberErr.Code)
+	}
+
+	_, err := service.Broadcast(context.Background(), dto.BroadcastDto{Target: dto.BroadcastTargetDto{All: true}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/config"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Exchanges a message can be published to. The default exchange routes by
+// queue name; the topic exchange routes by the bindings of each queue and the
+// fanout exchange copies every message to each bound queue.
+const (
+	DefaultExchange = ""
+	TopicExchange   = "messages"
+	FanoutExchange  = "broadcast"
+)
+
+// Broker is the message transport behind the publisher and the tenant
+// consumers. RabbitMQ is the production implementation; MemoryBroker runs
+// in-process for tests and local development.
+type Broker interface {
+	// DeclareQueue creates a durable queue if it does not exist yet. Options
+	// are RabbitMQ queue arguments; the other brokers ignore them.
+	DeclareQueue(queueName string, options models.QueueOptions) error
+	// DeleteQueue removes a queue, its bindings and its messages and ends its
+	// consumers
+	DeleteQueue(queueName string) error
+	// BindQueue routes messages published to exchange with a routing key that
+	// matches pattern to the queue. Patterns use the AMQP topic syntax: words
+	// separated by dots, * matches one word and # zero or more words. Fanout
+	// bindings ignore the pattern, use "".
+	BindQueue(queueName, exchange, pattern string) error
+	UnbindQueue(queueName, exchange, pattern string) error
+	// Publish sends a message without waiting for the broker. On the default
+	// exchange the routing key is the queue name. A message that matches no
+	// queue is dropped.
+	Publish(exchange, routingKey string, msg Publishing) error
+	// PublishConfirmed returns once the broker has taken responsibility for msg
+	PublishConfirmed(exchange, routingKey string, msg Publishing) error
+	// PublishDelayed is PublishConfirmed to a queue, but the message is only
+	// delivered after delay. The caller does not wait for the delay.
+	PublishDelayed(queueName string, msg Publishing, delay time.Duration) error
+	// Consume delivers messages from a queue until the consumer is cancelled
+	// or the queue is deleted, then closes the channel. Every delivery must be
+	// acked or nacked.
+	Consume(queueName, consumerTag string) (<-chan Delivery, error)
+	// Cancel stops a consumer started with Consume
+	Cancel(consumerTag string) error
+	Close() error
+}
+
+// ChannelPoolReporter is implemented by brokers that publish through a
+// ChannelPool
+type ChannelPoolReporter interface {
+	ChannelPoolStats() dto.ChannelPoolStatsDto
+}
+
+// NewConfiguredBroker connects the broker selected by QUEUE_DRIVER and returns
+// it with the lister for its queues. queues is only used by the postgres driver.
+func NewConfiguredBroker(queues repositories.QueueRepository) (Broker, QueueLister, error) {
+	switch config.Cfg.QueueDriver {
+	case "rabbitmq":
+		admin, err := NewRabbitMQAdmin(config.Cfg.RabbitMQManagementURL, config.Cfg.RabbitMQURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		broker := NewRabbitMQ(config.Cfg.RabbitMQURL)
+		broker.LimitChannels(config.Cfg.RabbitMQChannelPoolSize, config.Cfg.RabbitMQChannelWaitTimeout)
+		return broker, admin, nil
+	case "postgres":
+		broker := NewPostgresBroker(queues, config.Cfg.QueuePollInterval, config.Cfg.QueueVisibilityTimeout)
+		broker.LimitDeliveries(config.Cfg.QueueMaxDeliveries)
+		broker.Listen(config.DSN())
+		return broker, broker, nil
+	case "redis":
+		broker, err := NewRedisBroker(config.Cfg.RedisURL, config.Cfg.RedisStreamMaxLen, config.Cfg.QueueVisibilityTimeout, config.Cfg.QueuePollInterval)
+		if err != nil {
+			return nil, nil, err
+		}
+		return broker, broker, nil
+	case "memory":
+		// Single process only: queues are lost on restart
+		broker := NewMemoryBroker()
+		return broker, broker, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown QUEUE_DRIVER %q", config.Cfg.QueueDriver)
+	}
+}
+
+// QueueLister lists the queues that exist on a broker
+type QueueLister interface {
+	ListQueues(ctx context.Context) ([]string, error)
+}
+
+// BindingLister lists the patterns a queue is bound with on an exchange, so
+// bindings removed while the queue had no consumer can be cleaned up
+type BindingLister interface {
+	ListBindings(ctx context.Context, queueName, exchange string) ([]string, error)
+}
+
+// Publishing is a message handed to a Broker
+type Publishing struct {
+	ContentType     string
+	ContentEncoding string
+	MessageID       string
+	Headers         map[string]any
+	Body            []byte
+	// ReplyTo names the queue a request expects its reply on, CorrelationID
+	// pairs the reply with the request
+	ReplyTo       string
+	CorrelationID string
+}
+
+// Delivery is a message received from a Broker
+type Delivery struct {
+	Publishing
+	// Redelivered is set when the message was nacked with requeue before
+	Redelivered bool
+
+	ack  func() error
+	nack func(requeue bool) error
+}
+
+// Ack tells the broker the message was handled and can be removed
+func (d Delivery) Ack() error {
+	if d.ack == nil {
+		return nil
+	}
+	return d.ack()
+}
+
+// Nack rejects the message; with requeue it is delivered again later,
+// otherwise it is dropped
+func (d Delivery) Nack(requeue bool) error {
+	if d.nack == nil {
+		return nil
+	}
+	return d.nack(requeue)
+}
+
+// errUnknownExchange is returned by brokers that only know the default and the
+// topic exchange
+func errUnknownExchange(exchange string) error {
+	return fmt.Errorf("unknown exchange %q", exchange)
+}
+
+// bindingMatch reports whether a message published to exchange with routingKey
+// is routed through a binding with pattern
+func bindingMatch(exchange, pattern, routingKey string) bool {
+	if exchange == FanoutExchange {
+		return true
+	}
+	return topicMatch(pattern, routingKey)
+}
+
+// bindingExchange reports whether exchange routes by queue bindings
+func bindingExchange(exchange string) bool {
+	return exchange == TopicExchange || exchange == FanoutExchange
+}
+
+// Queue types and overflow behaviours accepted in QueueOptions. Streams are
+// left out: consumers would need an x-stream-offset and streams cannot requeue
+// a nacked message, which the pipeline retries rely on.
+var (
+	queueTypes     = map[string]bool{"classic": true, "quorum": true}
+	queueOverflows = map[string]bool{"drop-head": true, "reject-publish": true, "reject-publish-dlx": true}
+	queueModes     = map[string]bool{"default": true, "lazy": true}
+)
+
+// ValidateQueueOptions rejects queue arguments RabbitMQ would refuse, so a bad
+// request fails before the tenant is registered
+func ValidateQueueOptions(options models.QueueOptions) error {
+	invalid := func(msg string) error {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid queue options: "+msg)
+	}
+
+	if options.QueueType != "" && !queueTypes[options.QueueType] {
+		return invalid("x-queue-type must be classic or quorum")
+	}
+	if options.MaxLength < 0 || options.MaxLengthBytes < 0 {
+		return invalid("x-max-length and x-max-length-bytes cannot be negative")
+	}
+	if options.Overflow != "" && !queueOverflows[options.Overflow] {
+		return invalid("x-overflow must be drop-head, reject-publish or reject-publish-dlx")
+	}
+	if options.QueueMode != "" && !queueModes[options.QueueMode] {
+		return invalid("x-queue-mode must be default or lazy")
+	}
+
+	switch options.QueueType {
+	case "quorum":
+		if options.Overflow == "reject-publish-dlx" {
+			return invalid("quorum queues do not support x-overflow reject-publish-dlx")
+		}
+		if options.QueueMode != "" {
+			return invalid("x-queue-mode only applies to classic queues")
+		}
+	}
+	return nil
+}
+
+// queueExpirySweep is how often the Postgres and Redis brokers delete the
+// queues whose QueueOptions.Expires has passed
+const queueExpirySweep = time.Minute
+
+// queueOptionsChecker is implemented by brokers that cannot apply queue
+// options, so options they would ignore are refused instead
+type queueOptionsChecker interface {
+	CheckQueueOptions(options models.QueueOptions) error
+}
+
+// unsupportedQueueOptions rejects any queue option for a broker without
+// queue arguments
+func unsupportedQueueOptions(driver string, options models.QueueOptions) error {
+	// Expiry is not a queue argument, every broker applies it
+	options.Expires = 0
+	if options == (models.QueueOptions{}) {
+		return nil
+	}
+	return fiber.NewError(fiber.StatusBadRequest,
+		fmt.Sprintf("invalid queue options: the %s driver does not support queue options", driver))
+}
+
+// topicWord is a literal word of a routing key or binding pattern
+var topicWord = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidRoutingKey reports whether key is a dot separated list of words
+func ValidRoutingKey(key string) bool {
+	for _, word := range strings.Split(key, ".") {
+		if !topicWord.MatchString(word) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidBindingPattern reports whether pattern is a routing key that may also
+// use the * and # wildcards as words
+func ValidBindingPattern(pattern string) bool {
+	for _, word := range strings.Split(pattern, ".") {
+		if word != "*" && word != "#" && !topicWord.MatchString(word) {
+			return false
+		}
+	}
+	return true
+}
+
+// TopicRegexp translates a binding pattern into a regular expression that
+// matches "." + routing key. The expression is also valid in Postgres.
+func TopicRegexp(pattern string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, word := range strings.Split(pattern, ".") {
+		switch word {
+		case "#":
+			expr.WriteString(`(\.[^.]+)*`)
+		case "*":
+			expr.WriteString(`\.[^.]+`)
+		default:
+			expr.WriteString(`\.` + regexp.QuoteMeta(word))
+		}
+	}
+	expr.WriteString("$")
+	return expr.String()
+}
+
+// topicMatch reports whether routingKey matches the binding pattern
+func topicMatch(pattern, routingKey string) bool {
+	matched, _ := regexp.MatchString(TopicRegexp(pattern), "."+routingKey)
+	return matched
+}
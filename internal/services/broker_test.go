@@ -0,0 +1,97 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateQueueOptions(t *testing.T) {
+	valid := []models.QueueOptions{
+		{},
+		{QueueType: "classic", MaxLength: 1000, Overflow: "reject-publish-dlx", QueueMode: "lazy"},
+		{QueueType: "quorum", MaxLengthBytes: 1 << 20, Overflow: "drop-head", SingleActiveConsumer: true},
+	}
+	for _, options := range valid {
+		assert.NoError(t, ValidateQueueOptions(options), "%+v", options)
+	}
+
+	invalid := []models.QueueOptions{
+		{QueueType: "mirrored"},
+		{MaxLength: -1},
+		{Overflow: "drop-tail"},
+		{QueueMode: "eager"},
+		{QueueType: "quorum", Overflow: "reject-publish-dlx"},
+		{QueueType: "quorum", QueueMode: "lazy"},
+		{QueueType: "stream", MaxLengthBytes: 1 << 30},
+	}
+	for _, options := range invalid {
+		assert.Error(t, ValidateQueueOptions(options), "%+v", options)
+	}
+}
+
+func TestQueueArguments(t *testing.T) {
+	assert.Nil(t, queueArguments(models.QueueOptions{}))
+	assert.Equal(t, amqp.Table{
+		"x-queue-type":             "quorum",
+		"x-max-length":             int64(500),
+		"x-overflow":               "reject-publish",
+		"x-single-active-consumer": true,
+	}, queueArguments(models.QueueOptions{
+		QueueType:            "quorum",
+		MaxLength:            500,
+		Overflow:             "reject-publish",
+		SingleActiveConsumer: true,
+	}))
+	assert.Equal(t, amqp.Table{"x-expires": int64(60000)}, queueArguments(models.QueueOptions{Expires: time.Minute}))
+}
+
+// declareRecorder remembers the options each queue was declared with. Unlike
+// the memory broker it stands in for, it accepts them, as RabbitMQ does.
+type declareRecorder struct {
+	*MemoryBroker
+	declared map[string]models.QueueOptions
+}
+
+func (b *declareRecorder) DeclareQueue(queueName string, options models.QueueOptions) error {
+	b.declared[queueName] = options
+	return b.MemoryBroker.DeclareQueue(queueName, models.QueueOptions{})
+}
+
+func TestTenantQueueDeclaredWithStoredOptions(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000d1")
+	options := models.QueueOptions{QueueType: "quorum", MaxLength: 10000, SingleActiveConsumer: true}
+
+	broker := &declareRecorder{MemoryBroker: NewMemoryBroker(), declared: map[string]models.QueueOptions{}}
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: tenantID.String(), QueueOptions: options}}}
+	manager := NewTenantManager(broker, &recordingMessageRepository{}, tenants, newFakeBindingRepository())
+
+	require.NoError(t, manager.StartTenantConsumer(context.Background(), tenantID, 1))
+	defer manager.StopTenantConsumer(tenantID)
+	assert.Equal(t, options, broker.declared[TenantQueueName(tenantID.String())])
+
+	// Unregistered tenants have no stored options to declare their queue with
+	assert.Error(t, manager.StartTenantConsumer(context.Background(), uuid.New(), 1))
+}
+
+func TestBrokersWithoutQueueArgumentsRejectOptions(t *testing.T) {
+	options := models.QueueOptions{QueueType: "quorum"}
+	broker := NewMemoryBroker()
+	assert.Error(t, broker.DeclareQueue("q", options))
+	assert.NoError(t, broker.DeclareQueue("q", models.QueueOptions{}))
+	assert.NoError(t, broker.DeclareQueue("q", models.QueueOptions{Expires: time.Minute}))
+
+	manager := NewTenantManager(broker, &recordingMessageRepository{}, &fakeTenantRepository{}, newFakeBindingRepository())
+	err := manager.ValidateQueueOptions(options)
+	var fiberErr *fiber.Error
+	require.ErrorAs(t, err, &fiberErr)
+	assert.Equal(t, fiber.StatusBadRequest, fiberErr.Code)
+	assert.NoError(t, manager.ValidateQueueOptions(models.QueueOptions{}))
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/streadway/amqp"
+)
+
+// Defaults of the RabbitMQ publishing channel pool
+const (
+	DefaultChannelPoolSize    = 16
+	DefaultChannelWaitTimeout = 5 * time.Second
+)
+
+// channelWaitBuckets are the upper bounds of the pool wait time histogram
+var channelWaitBuckets = []time.Duration{
+	time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second,
+}
+
+// publishChannel is a channel in confirm mode. Only one goroutine uses it at a
+// time, the pool hands it out exclusively.
+type publishChannel interface {
+	// Publish sends msg. With wait set it returns once the broker confirmed it.
+	Publish(exchange, routingKey string, msg amqp.Publishing, wait bool) error
+	// Healthy reports whether the channel is still open
+	Healthy() bool
+	Close() error
+}
+
+// ChannelPool bounds the number of publishing channels and hands each one to a
+// single goroutine at a time; AMQP channels must not be shared by concurrent
+// publishers
+type ChannelPool struct {
+	open    func() (publishChannel, error)
+	timeout time.Duration
+	slots   chan struct{}
+
+	mu   sync.Mutex
+	idle []publishChannel
+
+	statsMu sync.Mutex
+	stats   dto.ChannelPoolStatsDto
+}
+
+// NewChannelPool constructor. open creates a new channel in confirm mode;
+// Get waits up to timeout for a free channel when size are in use.
+func NewChannelPool(size int, timeout time.Duration, open func() (publishChannel, error)) *ChannelPool {
+	if size <= 0 {
+		size = DefaultChannelPoolSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultChannelWaitTimeout
+	}
+	buckets := make([]dto.WaitBucketDto, 0, len(channelWaitBuckets)+1)
+	for _, bound := range channelWaitBuckets {
+		buckets = append(buckets, dto.WaitBucketDto{Le: bound.String()})
+	}
+	buckets = append(buckets, dto.WaitBucketDto{Le: "+Inf"})
+
+	return &ChannelPool{
+		open:    open,
+		timeout: timeout,
+		slots:   make(chan struct{}, size),
+		stats:   dto.ChannelPoolStatsDto{Size: size, WaitBuckets: buckets},
+	}
+}
+
+// Get takes a channel out of the pool, opening one when no healthy channel is
+// idle. It fails with 503 when every channel stays busy for the wait timeout.
+func (p *ChannelPool) Get(ctx context.Context) (publishChannel, error) {
+	start := time.Now()
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-timer.C:
+		p.recordTimeout(time.Since(start))
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, "no publishing channel available")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	p.recordWait(time.Since(start))
+
+	for {
+		ch := p.popIdle()
+		if ch == nil {
+			break
+		}
+		if ch.Healthy() {
+			return ch, nil
+		}
+		// Health check failed, e.g. the broker closed the channel after an error
+		p.discard(ch)
+	}
+
+	ch, err := p.open()
+	if err != nil {
+		<-p.slots
+		return nil, fmt.Errorf("failed to open publishing channel: %w", err)
+	}
+	p.statsMu.Lock()
+	p.stats.Opened++
+	p.statsMu.Unlock()
+	return ch, nil
+}
+
+// Put returns a channel taken with Get. Channels that failed are closed instead
+// of being reused.
+func (p *ChannelPool) Put(ch publishChannel, failed bool) {
+	if failed || !ch.Healthy() {
+		p.discard(ch)
+	} else {
+		p.mu.Lock()
+		p.idle = append(p.idle, ch)
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// Publish runs a publish on a pooled channel
+func (p *ChannelPool) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing, wait bool) error {
+	ch, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	err = ch.Publish(exchange, routingKey, msg, wait)
+	p.Put(ch, err != nil)
+	return err
+}
+
+// Stats returns the pool usage and wait time counters
+func (p *ChannelPool) Stats() dto.ChannelPoolStatsDto {
+	p.mu.Lock()
+	idle := len(p.idle)
+	p.mu.Unlock()
+
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	stats := p.stats
+	stats.WaitBuckets = append([]dto.WaitBucketDto(nil), p.stats.WaitBuckets...)
+	stats.Idle = idle
+	stats.InUse = len(p.slots)
+	if stats.Acquired > 0 {
+		stats.WaitAvgMs = stats.WaitTotalMs / float64(stats.Acquired)
+	}
+	return stats
+}
+
+// Close closes the idle channels; channels in use are closed when returned
+func (p *ChannelPool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, ch := range idle {
+		ch.Close()
+	}
+}
+
+func (p *ChannelPool) popIdle() publishChannel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	ch := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return ch
+}
+
+func (p *ChannelPool) discard(ch publishChannel) {
+	if err := ch.Close(); err != nil && ch.Healthy() {
+		log.Printf("[ChannelPool] Failed to close channel: %v", err)
+	}
+	p.statsMu.Lock()
+	p.stats.Discarded++
+	p.statsMu.Unlock()
+}
+
+func (p *ChannelPool) recordWait(wait time.Duration) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	ms := float64(wait) / float64(time.Millisecond)
+	p.stats.Acquired++
+	p.stats.WaitTotalMs += ms
+	if ms > p.stats.WaitMaxMs {
+		p.stats.WaitMaxMs = ms
+	}
+
+	bucket := len(channelWaitBuckets)
+	for i, bound := range channelWaitBuckets {
+		if wait <= bound {
+			bucket = i
+			break
+		}
+	}
+	p.stats.WaitBuckets[bucket].Count++
+}
+
+func (p *ChannelPool) recordTimeout(wait time.Duration) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.stats.Timeouts++
+	if ms := float64(wait) / float64(time.Millisecond); ms > p.stats.WaitMaxMs {
+		p.stats.WaitMaxMs = ms
+	}
+}
+
+// amqpPublishChannel is a publishChannel over an *amqp.Channel in confirm mode
+type amqpPublishChannel struct {
+	ch       *amqp.Channel
+	confirms *confirmTracker
+	closed   chan *amqp.Error
+}
+
+// openAMQPPublishChannel opens a channel on conn and puts it in confirm mode
+func openAMQPPublishChannel(conn *amqp.Connection) (publishChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable confirm mode: %w", err)
+	}
+
+	tracker := newConfirmTracker()
+	go tracker.run(ch.NotifyPublish(make(chan amqp.Confirmation, DefaultChannelPoolSize)))
+	return &amqpPublishChannel{
+		ch:       ch,
+		confirms: tracker,
+		closed:   ch.NotifyClose(make(chan *amqp.Error, 1)),
+	}, nil
+}
+
+// Publish implements publishChannel
+func (c *amqpPublishChannel) Publish(exchange, routingKey string, msg amqp.Publishing, wait bool) error {
+	confirmed := c.confirms.expect(wait)
+	if err := c.ch.Publish(exchange, routingKey, false, false, msg); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	if !wait {
+		return nil
+	}
+
+	select {
+	case ack, ok := <-confirmed:
+		if !ok {
+			return fmt.Errorf("channel closed before the broker confirmed the message")
+		}
+		if !ack {
+			return fmt.Errorf("broker nacked the message")
+		}
+		return nil
+	case <-time.After(confirmTimeout):
+		// The channel is discarded, so a late confirm is never mixed up
+		return fmt.Errorf("timed out waiting for broker confirm")
+	}
+}
+
+// Healthy implements publishChannel
+func (c *amqpPublishChannel) Healthy() bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Close implements publishChannel
+func (c *amqpPublishChannel) Close() error {
+	return c.ch.Close()
+}
+
+// confirmTracker matches broker confirms to publishes. Delivery tags count the
+// publishes on a channel from 1, so the tag of a publish is known up front.
+type confirmTracker struct {
+	mu      sync.Mutex
+	next    uint64
+	waiters map[uint64]chan bool
+	done    bool
+}
+
+func newConfirmTracker() *confirmTracker {
+	return &confirmTracker{waiters: map[uint64]chan bool{}}
+}
+
+// expect registers the next publish. With wait set the returned channel
+// receives the broker ack (true) or nack (false), or is closed when the
+// channel closes first.
+func (t *confirmTracker) expect(wait bool) <-chan bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.next++
+	confirmed := make(chan bool, 1)
+	switch {
+	case t.done:
+		close(confirmed)
+	case wait:
+		t.waiters[t.next] = confirmed
+	}
+	return confirmed
+}
+
+// run resolves waiters until the confirm channel is closed
+func (t *confirmTracker) run(confirms <-chan amqp.Confirmation) {
+	for confirm := range confirms {
+		t.mu.Lock()
+		if waiter, ok := t.waiters[confirm.DeliveryTag]; ok {
+			waiter <- confirm.Ack
+			delete(t.waiters, confirm.DeliveryTag)
+		} else if !confirm.Ack {
+			log.Printf("[ChannelPool] Broker nacked message %d", confirm.DeliveryTag)
+		}
+		t.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = true
+	for tag, waiter := range t.waiters {
+		close(waiter)
+		delete(t.waiters, tag)
+	}
+}
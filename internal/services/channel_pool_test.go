@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublishChannel records publishes and fails when told to
+type fakePublishChannel struct {
+	inUse     *atomic.Int32
+	published atomic.Int32
+	closed    atomic.Bool
+	fail      error
+}
+
+func (c *fakePublishChannel) Publish(exchange, routingKey string, msg amqp.Publishing, wait bool) error {
+	// Two goroutines on one channel is exactly what the pool must prevent
+	if c.inUse.Add(1) > 1 {
+		panic("channel used concurrently")
+	}
+	defer c.inUse.Add(-1)
+	time.Sleep(time.Millisecond)
+	c.published.Add(1)
+	return c.fail
+}
+
+func (c *fakePublishChannel) Healthy() bool { return !c.closed.Load() }
+
+func (c *fakePublishChannel) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+type fakeChannelOpener struct {
+	mu       sync.Mutex
+	channels []*fakePublishChannel
+}
+
+func (o *fakeChannelOpener) open() (publishChannel, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ch := &fakePublishChannel{inUse: &atomic.Int32{}}
+	o.channels = append(o.channels, ch)
+	return ch, nil
+}
+
+func TestChannelPoolBoundsConcurrentPublishers(t *testing.T) {
+	opener := &fakeChannelOpener{}
+	pool := NewChannelPool(4, time.Second, opener.open)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, pool.Publish(context.Background(), "", "q", amqp.Publishing{}, false))
+		}()
+	}
+	wg.Wait()
+
+	stats := pool.Stats()
+	assert.LessOrEqual(t, len(opener.channels), 4)
+	assert.Equal(t, int64(len(opener.channels)), stats.Opened)
+	assert.Equal(t, int64(50), stats.Acquired)
+	assert.Equal(t, 0, stats.InUse)
+	assert.Equal(t, len(opener.channels), stats.Idle)
+
+	var buckets int64
+	for _, bucket := range stats.WaitBuckets {
+		buckets += bucket.Count
+	}
+	assert.Equal(t, int64(50), buckets)
+	assert.Equal(t, "+Inf", stats.WaitBuckets[len(stats.WaitBuckets)-1].Le)
+	assert.GreaterOrEqual(t, stats.WaitMaxMs, stats.WaitAvgMs)
+}
+
+func TestChannelPoolTimesOutWhenExhausted(t *testing.T) {
+	opener := &fakeChannelOpener{}
+	pool := NewChannelPool(1, 20*time.Millisecond, opener.open)
+
+	held, err := pool.Get(context.Background())
+	require.NoError(t, err)
+
+	_, err = pool.Get(context.Background())
+	var fiberErr *fiber.Error
+	require.ErrorAs(t, err, &fiberErr)
+	assert.Equal(t, fiber.StatusServiceUnavailable, fiberErr.Code)
+	assert.Equal(t, int64(1), pool.Stats().Timeouts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.Get(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	pool.Put(held, false)
+	again, err := pool.Get(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, held, again)
+	pool.Put(again, false)
+}
+
+func TestChannelPoolReplacesUnhealthyChannels(t *testing.T) {
+	opener := &fakeChannelOpener{}
+	pool := NewChannelPool(2, time.Second, opener.open)
+
+	// A failed publish discards the channel
+	ch, err := pool.Get(context.Background())
+	require.NoError(t, err)
+	ch.(*fakePublishChannel).fail = errors.New("channel exception")
+	assert.Error(t, ch.Publish("", "q", amqp.Publishing{}, true))
+	pool.Put(ch, true)
+	assert.True(t, opener.channels[0].closed.Load())
+
+	// An idle channel closed by the broker fails the health check on checkout
+	require.NoError(t, pool.Publish(context.Background(), "", "q", amqp.Publishing{}, false))
+	opener.channels[1].closed.Store(true)
+	require.NoError(t, pool.Publish(context.Background(), "", "q", amqp.Publishing{}, false))
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(3), stats.Opened)
+	assert.Equal(t, int64(2), stats.Discarded)
+	assert.Equal(t, int32(1), opener.channels[2].published.Load())
+}
+
+func TestConfirmTrackerMatchesDeliveryTags(t *testing.T) {
+	tracker := newConfirmTracker()
+	confirms := make(chan amqp.Confirmation, 4)
+	done := make(chan struct{})
+	go func() {
+		tracker.run(confirms)
+		close(done)
+	}()
+
+	first := tracker.expect(true)
+	tracker.expect(false) // fire and forget, tag 2
+	third := tracker.expect(true)
+	fourth := tracker.expect(true)
+
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: false}
+	assert.True(t, <-first)
+	assert.False(t, <-third)
+
+	// Closing the channel releases the publishes still waiting
+	close(confirms)
+	<-done
+	_, ok := <-fourth
+	assert.False(t, ok)
+	_, ok = <-tracker.expect(true)
+	assert.False(t, ok)
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings set on amqp.Publishing.ContentEncoding
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// PayloadCompressor compresses message bodies above a size threshold.
+// A nil compressor leaves every body as is.
+type PayloadCompressor struct {
+	algorithm string
+	threshold int
+	zstd      *zstd.Encoder
+}
+
+// NewPayloadCompressor returns nil for algorithm "none" or ""
+func NewPayloadCompressor(algorithm string, threshold int) (*PayloadCompressor, error) {
+	compressor := &PayloadCompressor{
+		algorithm: algorithm,
+		threshold: threshold,
+	}
+
+	switch algorithm {
+	case "", "none":
+		return nil, nil
+	case EncodingGzip:
+	case EncodingZstd:
+		// EncodeAll is safe for concurrent use, so one encoder is shared
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			return nil, err
+		}
+		compressor.zstd = encoder
+	default:
+		return nil, fmt.Errorf("unknown payload compression %q, expected none, gzip or zstd", algorithm)
+	}
+
+	return compressor, nil
+}
+
+// Compress returns the body to publish and its content encoding, which is
+// empty when the body was left uncompressed
+func (c *PayloadCompressor) Compress(body []byte) ([]byte, string, error) {
+	if c == nil || len(body) <= c.threshold {
+		return body, "", nil
+	}
+
+	var compressed []byte
+	switch c.algorithm {
+	case EncodingZstd:
+		compressed = c.zstd.EncodeAll(body, make([]byte, 0, len(body)/4))
+	default:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(body); err != nil {
+			return nil, "", err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", err
+		}
+		compressed = buf.Bytes()
+	}
+
+	// Already compressed or random data can grow; send those as is
+	if len(compressed) >= len(body) {
+		return body, "", nil
+	}
+	return compressed, c.algorithm, nil
+}
+
+// DecompressPayload reverses Compress based on the delivery content encoding.
+// limit caps the decompressed size to guard against compression bombs, 0 means no cap.
+func DecompressPayload(body []byte, encoding string, limit int) ([]byte, error) {
+	var reader io.Reader
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case EncodingGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip payload: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	case EncodingZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd payload: %w", err)
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	if limit > 0 {
+		reader = io.LimitReader(reader, int64(limit)+1)
+	}
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s payload: %w", encoding, err)
+	}
+	if limit > 0 && len(decompressed) > limit {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", limit)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayloadCompressionRoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"field":"a fairly repetitive value"},`), 1000)
+
+	for _, algorithm := range []string{EncodingGzip, EncodingZstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			compressor, err := NewPayloadCompressor(algorithm, 1024)
+			require.NoError(t, err)
+
+			compressed, encoding, err := compressor.Compress(body)
+			require.NoError(t, err)
+			assert.Equal(t, algorithm, encoding)
+			assert.Less(t, len(compressed), len(body))
+
+			decompressed, err := DecompressPayload(compressed, encoding, len(body))
+			require.NoError(t, err)
+			assert.Equal(t, body, decompressed)
+
+			// A body that inflates beyond the limit is rejected
+			_, err = DecompressPayload(compressed, encoding, len(body)-1)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPayloadCompressionBelowThreshold(t *testing.T) {
+	compressor, err := NewPayloadCompressor(EncodingGzip, 1024)
+	require.NoError(t, err)
+
+	body := []byte(`{"tenant_id":"t","payload":{"small":true}}`)
+	out, encoding, err := compressor.Compress(body)
+	require.NoError(t, err)
+	assert.Empty(t, encoding)
+	assert.Equal(t, body, out)
+
+	none, err := NewPayloadCompressor("none", 0)
+	require.NoError(t, err)
+	out, encoding, err = none.Compress(body)
+	require.NoError(t, err)
+	assert.Empty(t, encoding)
+	assert.Equal(t, body, out)
+
+	_, err = NewPayloadCompressor("brotli", 0)
+	assert.Error(t, err)
+}
+
+// nackingBroker nacks every confirmed publish
+type nackingBroker struct {
+	*MemoryBroker
+}
+
+func (b *nackingBroker) PublishConfirmed(exchange, routingKey string, msg Publishing) error {
+	return errors.New("broker nacked the message")
+}
+
+func TestPublishReturnsBrokerNack(t *testing.T) {
+	broker := &nackingBroker{MemoryBroker: NewMemoryBroker()}
+	publisher := NewPublisherService(broker)
+
+	err := publisher.Publish("tenant_x_queue", Message{TenantID: "x", Payload: map[string]any{"id": 1}})
+	assert.EqualError(t, err, "broker nacked the message")
+}
+
+func TestPublishRejectsOversizedPayload(t *testing.T) {
+	publisher := NewPublisherService(nil)
+	publisher.LimitPayloadSize(64)
+
+	err := publisher.Publish("tenant_x_queue", Message{
+		TenantID: "x",
+		Payload:  map[string]any{"blob": string(bytes.Repeat([]byte("x"), 100))},
+	})
+
+	var fiberErr *fiber.Error
+	require.True(t, errors.As(err, &fiberErr))
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, fiberErr.Code)
+}
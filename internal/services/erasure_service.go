@@ -0,0 +1,259 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/config"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	ErasureStatusRunning   = "running"
+	ErasureStatusCompleted = "completed"
+	ErasureStatusFailed    = "failed"
+
+	erasureBatchSize = 500
+	// A running report without progress for this long was left behind by a
+	// crashed instance
+	erasureStaleAfter = 15 * time.Minute
+)
+
+var erasurePathSegment = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ErasureService deletes data-subject messages and produces a tamper-evident report.
+// Every batch is chained to the previous one with SHA-256 and the final report is
+// signed with HMAC-SHA256, so editing or removing any batch breaks verification.
+type ErasureService struct {
+	messageRepository repositories.MessageRepository
+	erasureRepository repositories.ErasureRepository
+	signingKey        []byte
+}
+
+func NewErasureService(messageRepo repositories.MessageRepository, erasureRepo repositories.ErasureRepository, signingKey []byte) *ErasureService {
+	return &ErasureService{
+		messageRepository: messageRepo,
+		erasureRepository: erasureRepo,
+		signingKey:        signingKey,
+	}
+}
+
+// DeleteMessage removes a single message from the tenant partition
+func (s *ErasureService) DeleteMessage(ctx context.Context, tenantID, messageID uuid.UUID) error {
+	return s.messageRepository.DeleteMessage(ctx, tenantID.String(), messageID.String())
+}
+
+// StartErasure records a new report and deletes matching messages in the background
+func (s *ErasureService) StartErasure(tenantID uuid.UUID, req dto.ErasureRequestDto) (models.ErasureReport, error) {
+	path, err := parseErasurePath(req.Path)
+	if err != nil {
+		return models.ErasureReport{}, err
+	}
+	if req.Value == "" {
+		return models.ErasureReport{}, fiber.NewError(fiber.StatusBadRequest, "value is required")
+	}
+	// Encrypted fields cannot be matched in SQL, so erasure would silently find nothing
+	if config.Cfg.MasterKeyFile != "" && !repositories.IsClearPath(config.Cfg.EncryptionClearFields, path) {
+		return models.ErasureReport{}, fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("%s is encrypted at rest, add it to ENCRYPTION_CLEAR_FIELDS to erase by it", req.Path))
+	}
+
+	reportID, _ := uuid.NewV7()
+	report := models.ErasureReport{
+		ID:       reportID.String(),
+		TenantID: tenantID.String(),
+		JSONPath: strings.Join(path, "."),
+		// Only a keyed hash of the subject identifier is kept in the report
+		ValueHash:   s.hmac(req.Value),
+		Status:      ErasureStatusRunning,
+		Batches:     models.ErasureBatches{},
+		RequestedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	if err := s.erasureRepository.Create(context.Background(), &report); err != nil {
+		return models.ErasureReport{}, err
+	}
+
+	go s.run(report, path, req.Value)
+
+	return report, nil
+}
+
+// GetErasure loads a report and checks its hash chain and signature
+func (s *ErasureService) GetErasure(ctx context.Context, tenantID uuid.UUID, reportID string) (dto.ErasureReportDto, error) {
+	report, err := s.erasureRepository.Find(ctx, tenantID.String(), reportID)
+	if err != nil {
+		return dto.ErasureReportDto{}, err
+	}
+
+	return dto.ErasureReportDto{
+		ErasureReport: report,
+		Verified:      report.Status == ErasureStatusCompleted && s.Verify(report) == nil,
+	}, nil
+}
+
+// Verify recomputes the hash chain and signature of a completed report
+func (s *ErasureService) Verify(report models.ErasureReport) error {
+	prev := erasureGenesisHash(report)
+	var deleted int64
+	for i, batch := range report.Batches {
+		if batch.Seq != i+1 || batch.PrevHash != prev {
+			return fmt.Errorf("batch %d is out of chain", i+1)
+		}
+		if batch.Hash != erasureBatchHash(batch) {
+			return fmt.Errorf("batch %d hash mismatch", i+1)
+		}
+		prev = batch.Hash
+		deleted += int64(len(batch.MessageIDs))
+	}
+
+	if deleted != report.DeletedCount {
+		return fmt.Errorf("deleted count mismatch")
+	}
+	if prev != report.ChainHash {
+		return fmt.Errorf("chain hash mismatch")
+	}
+	if !hmac.Equal([]byte(report.Signature), []byte(s.sign(report))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// FailInterrupted marks reports left running by a crashed instance as failed.
+// They cannot be resumed since only a hash of the subject value is stored, so
+// the erasure has to be requested again; the batches deleted so far stay in
+// the signed report.
+func (s *ErasureService) FailInterrupted(ctx context.Context) error {
+	reports, err := s.erasureRepository.ListStale(ctx, ErasureStatusRunning, time.Now().Add(-erasureStaleAfter))
+	if err != nil {
+		return err
+	}
+
+	for _, report := range reports {
+		s.finish(&report, fmt.Errorf("interrupted before completion, request the erasure again"))
+		if err := s.erasureRepository.Complete(ctx, &report); err != nil {
+			return err
+		}
+		log.Printf("[Erasure %s] Marked failed after an interruption: %d messages deleted", report.ID, report.DeletedCount)
+	}
+	return nil
+}
+
+func (s *ErasureService) run(report models.ErasureReport, path []string, value string) {
+	log.Printf("[Erasure %s] Started for tenant %s on %s", report.ID, report.TenantID, report.JSONPath)
+
+	var err error
+	for {
+		var n int
+		n, err = s.erasureRepository.EraseBatch(context.Background(), &report, path, value, erasureBatchSize, func(ids []string) models.ErasureBatch {
+			prev := erasureGenesisHash(report)
+			if len(report.Batches) > 0 {
+				prev = report.Batches[len(report.Batches)-1].Hash
+			}
+
+			batch := models.ErasureBatch{
+				Seq:        len(report.Batches) + 1,
+				MessageIDs: ids,
+				DeletedAt:  time.Now().UTC(),
+				PrevHash:   prev,
+			}
+			batch.Hash = erasureBatchHash(batch)
+			return batch
+		})
+		if err != nil || n == 0 {
+			break
+		}
+	}
+
+	s.finish(&report, err)
+	if err := s.erasureRepository.Complete(context.Background(), &report); err != nil {
+		log.Printf("[Erasure %s] Failed to complete report: %v", report.ID, err)
+		return
+	}
+
+	log.Printf("[Erasure %s] %s: %d messages deleted", report.ID, report.Status, report.DeletedCount)
+}
+
+// finish closes the hash chain and signs the report, as failed when err is set
+func (s *ErasureService) finish(report *models.ErasureReport, err error) {
+	completedAt := time.Now().UTC().Truncate(time.Microsecond)
+	report.CompletedAt = &completedAt
+	report.Status = ErasureStatusCompleted
+	if err != nil {
+		report.Status = ErasureStatusFailed
+		report.Error = err.Error()
+	}
+
+	report.ChainHash = erasureGenesisHash(*report)
+	if len(report.Batches) > 0 {
+		report.ChainHash = report.Batches[len(report.Batches)-1].Hash
+	}
+	report.Signature = s.sign(*report)
+}
+
+func (s *ErasureService) hmac(data string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *ErasureService) sign(report models.ErasureReport) string {
+	completedAt := ""
+	if report.CompletedAt != nil {
+		completedAt = report.CompletedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return s.hmac(strings.Join([]string{
+		report.ID,
+		report.TenantID,
+		report.JSONPath,
+		report.ValueHash,
+		report.Status,
+		strconv.FormatInt(report.DeletedCount, 10),
+		report.ChainHash,
+		completedAt,
+	}, "|"))
+}
+
+// erasureGenesisHash anchors the chain to the report's identity
+func erasureGenesisHash(report models.ErasureReport) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{report.ID, report.TenantID, report.JSONPath, report.ValueHash}, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+func erasureBatchHash(batch models.ErasureBatch) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		batch.PrevHash,
+		strconv.Itoa(batch.Seq),
+		strings.Join(batch.MessageIDs, ","),
+		batch.DeletedAt.UTC().Format(time.RFC3339Nano),
+	}, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseErasurePath splits a dotted JSON path such as "customer.id" into segments
+func parseErasurePath(path string) ([]string, error) {
+	if path == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "path is required")
+	}
+
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if !erasurePathSegment.MatchString(segment) {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid path segment %q", segment))
+		}
+	}
+	return segments, nil
+}
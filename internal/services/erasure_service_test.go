@@ -0,0 +1,118 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sealedTestReport(s *ErasureService) models.ErasureReport {
+	report := models.ErasureReport{
+		ID:        "0190d8a4-0000-7000-8000-000000000001",
+		TenantID:  "0190d8a4-0000-7000-8000-0000000000aa",
+		JSONPath:  "customer.id",
+		ValueHash: s.hmac("C-42"),
+		Status:    ErasureStatusCompleted,
+	}
+
+	prev := erasureGenesisHash(report)
+	for i, ids := range [][]string{{"m1", "m2"}, {"m3"}} {
+		batch := models.ErasureBatch{Seq: i + 1, MessageIDs: ids, DeletedAt: time.Now().UTC(), PrevHash: prev}
+		batch.Hash = erasureBatchHash(batch)
+		report.Batches = append(report.Batches, batch)
+		report.DeletedCount += int64(len(ids))
+		prev = batch.Hash
+	}
+
+	completedAt := time.Now().UTC().Truncate(time.Microsecond)
+	report.CompletedAt = &completedAt
+	report.ChainHash = prev
+	report.Signature = s.sign(report)
+	return report
+}
+
+func TestErasureReportVerifies(t *testing.T) {
+	s := &ErasureService{signingKey: []byte("secret")}
+	require.NoError(t, s.Verify(sealedTestReport(s)))
+}
+
+func TestErasureReportDetectsTampering(t *testing.T) {
+	s := &ErasureService{signingKey: []byte("secret")}
+
+	dropped := sealedTestReport(s)
+	dropped.Batches = dropped.Batches[1:]
+	assert.Error(t, s.Verify(dropped))
+
+	edited := sealedTestReport(s)
+	edited.Batches[0].MessageIDs = []string{"m1"}
+	assert.Error(t, s.Verify(edited))
+
+	resigned := sealedTestReport(s)
+	other := &ErasureService{signingKey: []byte("other")}
+	assert.Error(t, other.Verify(resigned))
+}
+
+// staleErasureRepository returns fixed stale reports and records completions
+type staleErasureRepository struct {
+	repositories.ErasureRepository
+	stale     []models.ErasureReport
+	completed []models.ErasureReport
+}
+
+func (r *staleErasureRepository) ListStale(ctx context.Context, status string, before time.Time) ([]models.ErasureReport, error) {
+	var reports []models.ErasureReport
+	for _, report := range r.stale {
+		if report.Status == status && report.UpdatedAt.Before(before) {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+func (r *staleErasureRepository) Complete(ctx context.Context, report *models.ErasureReport) error {
+	r.completed = append(r.completed, *report)
+	return nil
+}
+
+func TestErasureFailsInterruptedReports(t *testing.T) {
+	s := &ErasureService{signingKey: []byte("secret")}
+
+	interrupted := sealedTestReport(s)
+	interrupted.Status = ErasureStatusRunning
+	interrupted.ChainHash, interrupted.Signature, interrupted.CompletedAt = "", "", nil
+	interrupted.UpdatedAt = time.Now().Add(-time.Hour)
+	active := interrupted
+	active.ID = "0190d8a4-0000-7000-8000-000000000002"
+	active.UpdatedAt = time.Now()
+
+	repo := &staleErasureRepository{stale: []models.ErasureReport{interrupted, active}}
+	s.erasureRepository = repo
+	require.NoError(t, s.FailInterrupted(context.Background()))
+
+	// Only the report without recent progress is closed, with the batches
+	// deleted before the crash still verifiable
+	require.Len(t, repo.completed, 1)
+	failed := repo.completed[0]
+	assert.Equal(t, interrupted.ID, failed.ID)
+	assert.Equal(t, ErasureStatusFailed, failed.Status)
+	assert.NotEmpty(t, failed.Error)
+	assert.NotNil(t, failed.CompletedAt)
+	assert.Equal(t, int64(3), failed.DeletedCount)
+	assert.NoError(t, s.Verify(failed))
+}
+
+func TestParseErasurePath(t *testing.T) {
+	segments, err := parseErasurePath("customer.id")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"customer", "id"}, segments)
+
+	_, err = parseErasurePath("customer.{id}")
+	assert.Error(t, err)
+	_, err = parseErasurePath("")
+	assert.Error(t, err)
+}
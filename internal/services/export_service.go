@@ -0,0 +1,206 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ExportFormatNDJSON = "ndjson"
+	ExportFormatCSV    = "csv"
+
+	// exportFlushEvery controls how often buffered rows are pushed to the client
+	exportFlushEvery = 500
+)
+
+// ExportColumns lists the columns that can be selected for an export, in default order
+var ExportColumns = []string{"id", "tenant_id", "payload", "created_at"}
+
+type ExportService struct {
+	messageRepository repositories.MessageRepository
+}
+
+func NewExportService(messageRepo repositories.MessageRepository) *ExportService {
+	return &ExportService{
+		messageRepository: messageRepo,
+	}
+}
+
+// Validate normalizes the export request and rejects unknown formats or columns
+func (s *ExportService) Validate(req *dto.ExportMessagesDto) error {
+	if req.Format == "" {
+		req.Format = ExportFormatNDJSON
+	}
+	if req.Format != ExportFormatNDJSON && req.Format != ExportFormatCSV {
+		return fiber.NewError(fiber.StatusBadRequest, "format must be one of: ndjson, csv")
+	}
+
+	if len(req.Columns) == 0 {
+		req.Columns = ExportColumns
+	}
+	for _, column := range req.Columns {
+		if !slices.Contains(ExportColumns, column) {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown column %q", column))
+		}
+	}
+
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		return fiber.NewError(fiber.StatusBadRequest, "from must be before to")
+	}
+
+	return nil
+}
+
+// ExportAbortedError is the error of the last NDJSON line of an export that
+// failed partway
+const ExportAbortedError = "export aborted, the output is incomplete"
+
+// Export writes every matching message to w, row by row, as NDJSON or CSV.
+// When req.Gzip is set the output is gzip-compressed. An NDJSON export that
+// fails partway ends with an {"error": ...} line, so the client can tell it is
+// incomplete; a CSV has no room for one and the caller must abort the response.
+func (s *ExportService) Export(ctx context.Context, w io.Writer, req dto.ExportMessagesDto) error {
+	out := w
+	var gz *gzip.Writer
+	if req.Gzip {
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+
+	var encode func(models.Message) error
+	var flush func() error
+	// abort marks a failed export in the output, where the format allows it
+	abort := func() {}
+
+	switch req.Format {
+	case ExportFormatCSV:
+		cw := csv.NewWriter(out)
+		if err := cw.Write(req.Columns); err != nil {
+			return err
+		}
+		encode = func(message models.Message) error {
+			record, err := exportRecord(message, req.Columns)
+			if err != nil {
+				return err
+			}
+			return cw.Write(record)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		enc := json.NewEncoder(out)
+		encode = func(message models.Message) error {
+			return enc.Encode(exportRow(message, req.Columns))
+		}
+		flush = func() error { return nil }
+		abort = func() {
+			enc.Encode(map[string]any{"error": ExportAbortedError})
+		}
+	}
+
+	rows := 0
+	err := s.messageRepository.StreamMessages(ctx, req.MessageFilter, func(message models.Message) error {
+		if err := encode(message); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushEvery == 0 {
+			return flushExport(w, gz, flush)
+		}
+		return nil
+	})
+	if err != nil {
+		// Best effort, the client may be gone already
+		abort()
+		flush()
+		if gz != nil {
+			gz.Close()
+		}
+		if f, ok := w.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+		return err
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// flushExport pushes everything buffered so far through the encoder, gzip and
+// the underlying writer so the client receives data while the cursor advances
+func flushExport(w io.Writer, gz *gzip.Writer, flush func() error) error {
+	if err := flush(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func exportRow(message models.Message, columns []string) map[string]any {
+	row := make(map[string]any, len(columns))
+	for _, column := range columns {
+		switch column {
+		case "id":
+			row[column] = message.ID
+		case "tenant_id":
+			row[column] = message.TenantID
+		case "payload":
+			row[column] = message.Payload
+		case "created_at":
+			row[column] = message.CreatedAt.Format(time.RFC3339Nano)
+		}
+	}
+	return row
+}
+
+func exportRecord(message models.Message, columns []string) ([]string, error) {
+	record := make([]string, 0, len(columns))
+	for _, column := range columns {
+		switch column {
+		case "id":
+			record = append(record, message.ID)
+		case "tenant_id":
+			record = append(record, message.TenantID)
+		case "payload":
+			payload, err := json.Marshal(message.Payload)
+			if err != nil {
+				return nil, err
+			}
+			record = append(record, string(payload))
+		case "created_at":
+			record = append(record, message.CreatedAt.Format(time.RFC3339Nano))
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type streamingRepository struct {
+	repositories.MessageRepository
+	messages []models.Message
+	// failAfter fails the stream after that many messages, when set
+	failAfter int
+}
+
+func (r *streamingRepository) StreamMessages(ctx context.Context, filter dto.MessageFilter, fn func(models.Message) error) error {
+	for i, message := range r.messages {
+		if r.failAfter > 0 && i == r.failAfter {
+			return errors.New("connection reset")
+		}
+		if err := fn(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newExportTestService() *ExportService {
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	return NewExportService(&streamingRepository{
+		messages: []models.Message{
+			{ID: "a", TenantID: "t", Payload: models.JSONB{"n": 1.0}, CreatedAt: createdAt},
+			{ID: "b", TenantID: "t", Payload: models.JSONB{"n": 2.0}, CreatedAt: createdAt},
+		},
+	})
+}
+
+func TestExportNDJSON(t *testing.T) {
+	svc := newExportTestService()
+	req := dto.ExportMessagesDto{Columns: []string{"id", "payload"}}
+	require.NoError(t, svc.Validate(&req))
+
+	var buf bytes.Buffer
+	require.NoError(t, svc.Export(context.Background(), &buf, req))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var row map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &row))
+	assert.Equal(t, "b", row["id"])
+	assert.Equal(t, map[string]any{"n": 2.0}, row["payload"])
+	assert.NotContains(t, row, "tenant_id")
+}
+
+func TestExportNDJSONMarksAbortedExports(t *testing.T) {
+	svc := newExportTestService()
+	svc.messageRepository.(*streamingRepository).failAfter = 1
+	req := dto.ExportMessagesDto{Columns: []string{"id"}}
+	require.NoError(t, svc.Validate(&req))
+
+	var buf bytes.Buffer
+	require.Error(t, svc.Export(context.Background(), &buf, req))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"id": "a"}`, lines[0])
+	assert.JSONEq(t, `{"error": "`+ExportAbortedError+`"}`, lines[1])
+}
+
+func TestExportCSVGzip(t *testing.T) {
+	svc := newExportTestService()
+	req := dto.ExportMessagesDto{Format: ExportFormatCSV, Gzip: true}
+	require.NoError(t, svc.Validate(&req))
+
+	var buf bytes.Buffer
+	require.NoError(t, svc.Export(context.Background(), &buf, req))
+
+	gz, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	plain, err := io.ReadAll(gz)
+	require.NoError(t, err)
+
+	records, err := csv.NewReader(bytes.NewReader(plain)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, ExportColumns, records[0])
+	assert.Equal(t, []string{"a", "t", `{"n":1}`, "2025-01-02T03:04:05Z"}, records[1])
+}
+
+func TestExportValidate(t *testing.T) {
+	svc := newExportTestService()
+
+	assert.Error(t, svc.Validate(&dto.ExportMessagesDto{Format: "xml"}))
+	assert.Error(t, svc.Validate(&dto.ExportMessagesDto{Columns: []string{"secret"}}))
+
+	from := time.Now()
+	to := from.Add(-time.Hour)
+	assert.Error(t, svc.Validate(&dto.ExportMessagesDto{MessageFilter: dto.MessageFilter{From: &from, To: &to}}))
+}
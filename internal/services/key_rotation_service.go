@@ -0,0 +1,210 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	KeyRotationStatusRunning   = "running"
+	KeyRotationStatusCompleted = "completed"
+	KeyRotationStatusFailed    = "failed"
+
+	keyRotationBatchSize = 200
+)
+
+// KeyRotationJob tracks the re-encryption of a tenant partition
+type KeyRotationJob struct {
+	mu    sync.Mutex
+	state dto.KeyRotationJobDto
+}
+
+// Snapshot returns a copy of the job state that is safe to serialize
+func (j *KeyRotationJob) Snapshot() dto.KeyRotationJobDto {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+func (j *KeyRotationJob) update(fn func(state *dto.KeyRotationJobDto)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.state)
+}
+
+// KeyRotationService gives a tenant a new data key and re-encrypts its stored
+// messages with it. Only one rotation per tenant runs at a time in this
+// process; the rotate-key command must not run while the server rotates the
+// same tenant.
+type KeyRotationService struct {
+	messageRepository repositories.MessageRepository
+	cipher            repositories.PayloadCipher
+	jobs              map[string]*KeyRotationJob
+	running           map[string]string // tenant ID -> job ID
+	mu                sync.RWMutex
+}
+
+func NewKeyRotationService(messageRepo repositories.MessageRepository, cipher repositories.PayloadCipher) *KeyRotationService {
+	return &KeyRotationService{
+		messageRepository: messageRepo,
+		cipher:            cipher,
+		jobs:              make(map[string]*KeyRotationJob),
+		running:           make(map[string]string),
+	}
+}
+
+// Start rotates the tenant key and re-encrypts the partition in the background
+func (s *KeyRotationService) Start(tenantID uuid.UUID) (dto.KeyRotationJobDto, error) {
+	job, err := s.prepare(context.Background(), tenantID)
+	if err != nil {
+		return dto.KeyRotationJobDto{}, err
+	}
+
+	go s.run(context.Background(), job)
+
+	return job.Snapshot(), nil
+}
+
+// Rotate rotates the tenant key and re-encrypts the partition before returning
+func (s *KeyRotationService) Rotate(ctx context.Context, tenantID uuid.UUID) (dto.KeyRotationJobDto, error) {
+	job, err := s.prepare(ctx, tenantID)
+	if err != nil {
+		return dto.KeyRotationJobDto{}, err
+	}
+
+	s.run(ctx, job)
+
+	return job.Snapshot(), nil
+}
+
+// Get returns the state of a rotation job belonging to the tenant
+func (s *KeyRotationService) Get(tenantID uuid.UUID, jobID string) (dto.KeyRotationJobDto, error) {
+	s.mu.RLock()
+	job, ok := s.jobs[jobID]
+	s.mu.RUnlock()
+
+	if !ok {
+		return dto.KeyRotationJobDto{}, fiber.NewError(fiber.StatusNotFound, "key rotation job not found")
+	}
+
+	state := job.Snapshot()
+	if state.TenantID != tenantID.String() {
+		return dto.KeyRotationJobDto{}, fiber.NewError(fiber.StatusNotFound, "key rotation job not found")
+	}
+	return state, nil
+}
+
+func (s *KeyRotationService) prepare(ctx context.Context, tenantID uuid.UUID) (*KeyRotationJob, error) {
+	if s.cipher == nil {
+		return nil, fiber.NewError(fiber.StatusConflict, "payload encryption is not enabled, set MASTER_KEY_FILE")
+	}
+
+	// Reserve the tenant before the key is rotated, so a second request
+	// cannot rotate it again while the partition is being re-encrypted
+	s.mu.Lock()
+	if jobID, ok := s.running[tenantID.String()]; ok {
+		s.mu.Unlock()
+		return nil, fiber.NewError(fiber.StatusConflict,
+			fmt.Sprintf("key rotation %s is already running for tenant %s", jobID, tenantID))
+	}
+	s.running[tenantID.String()] = ""
+	s.mu.Unlock()
+
+	job, err := s.newJob(ctx, tenantID)
+	if err != nil {
+		s.finish(tenantID.String())
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.jobs[job.state.ID] = job
+	s.running[tenantID.String()] = job.state.ID
+	s.mu.Unlock()
+
+	return job, nil
+}
+
+func (s *KeyRotationService) newJob(ctx context.Context, tenantID uuid.UUID) (*KeyRotationJob, error) {
+	key, err := s.cipher.RotateKey(ctx, tenantID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.messageRepository.CountMessages(ctx, dto.MessageFilter{TenantID: tenantID.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	jobID, _ := uuid.NewV7()
+	job := &KeyRotationJob{
+		state: dto.KeyRotationJobDto{
+			ID:         jobID.String(),
+			TenantID:   tenantID.String(),
+			KeyVersion: key.Version,
+			Status:     KeyRotationStatusRunning,
+			Total:      total,
+			StartedAt:  time.Now(),
+		},
+	}
+	return job, nil
+}
+
+// finish releases the tenant for the next rotation
+func (s *KeyRotationService) finish(tenantID string) {
+	s.mu.Lock()
+	delete(s.running, tenantID)
+	s.mu.Unlock()
+}
+
+// run re-encrypts every row of the tenant, including rows stored in clear
+// before encryption was enabled. Rows are read decrypted with whichever key
+// version sealed them and written back under the new active key.
+func (s *KeyRotationService) run(ctx context.Context, job *KeyRotationJob) {
+	state := job.Snapshot()
+	filter := dto.MessageFilter{TenantID: state.TenantID}
+	log.Printf("[KeyRotation %s] Re-encrypting tenant %s with key version %d", state.ID, state.TenantID, state.KeyVersion)
+
+	var err error
+	var batch []models.Message
+	afterID := ""
+	for {
+		batch, err = s.messageRepository.ListMessagesAfter(ctx, filter, afterID, keyRotationBatchSize)
+		if err != nil || len(batch) == 0 {
+			break
+		}
+
+		for _, message := range batch {
+			if err := s.messageRepository.ReencryptMessage(ctx, message); err != nil {
+				log.Printf("[KeyRotation %s] Failed to re-encrypt %s: %v", state.ID, message.ID, err)
+				job.update(func(state *dto.KeyRotationJobDto) { state.Failed++ })
+				continue
+			}
+			job.update(func(state *dto.KeyRotationJobDto) { state.Done++ })
+		}
+
+		afterID = batch[len(batch)-1].ID
+	}
+
+	s.finish(state.TenantID)
+	finishedAt := time.Now()
+	job.update(func(state *dto.KeyRotationJobDto) {
+		state.FinishedAt = &finishedAt
+		state.Status = KeyRotationStatusCompleted
+		if err != nil {
+			state.Status = KeyRotationStatusFailed
+			state.Error = err.Error()
+		}
+	})
+
+	state = job.Snapshot()
+	log.Printf("[KeyRotation %s] %s: %d re-encrypted, %d failed of %d", state.ID, state.Status, state.Done, state.Failed, state.Total)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// versionCipher only counts key versions per tenant
+type versionCipher struct {
+	repositories.PayloadCipher
+	mu       sync.Mutex
+	versions map[string]int
+}
+
+func (c *versionCipher) RotateKey(ctx context.Context, tenantID string) (models.TenantKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions[tenantID]++
+	return models.TenantKey{TenantID: tenantID, Version: c.versions[tenantID]}, nil
+}
+
+// reencryptingRepository records re-encrypted rows; a non-nil gate holds every
+// re-encryption until it is closed
+type reencryptingRepository struct {
+	replayMessageRepository
+	gate        chan struct{}
+	reencrypted []string
+}
+
+func (r *reencryptingRepository) ReencryptMessage(ctx context.Context, message models.Message) error {
+	if r.gate != nil {
+		<-r.gate
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reencrypted = append(r.reencrypted, message.ID)
+	return nil
+}
+
+func TestKeyRotationReencryptsTenantRows(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-000000000131")
+	other := uuid.MustParse("0190d8a4-0000-7000-8000-000000000132")
+
+	repo := &reencryptingRepository{}
+	var ids []string
+	for i := range keyRotationBatchSize + 5 {
+		id, _ := uuid.NewV7()
+		ids = append(ids, id.String())
+		require.NoError(t, repo.Store(dto.NewMessageDto{ID: id.String(), TenantID: tenantID.String(), Payload: map[string]any{"n": i}}))
+	}
+	otherID, _ := uuid.NewV7()
+	require.NoError(t, repo.Store(dto.NewMessageDto{ID: otherID.String(), TenantID: other.String()}))
+
+	rotation := NewKeyRotationService(repo, &versionCipher{versions: map[string]int{tenantID.String(): 1}})
+	job, err := rotation.Rotate(context.Background(), tenantID)
+	require.NoError(t, err)
+
+	assert.Equal(t, KeyRotationStatusCompleted, job.Status)
+	assert.Equal(t, 2, job.KeyVersion)
+	assert.Equal(t, int64(len(ids)), job.Total)
+	assert.Equal(t, int64(len(ids)), job.Done)
+	assert.Zero(t, job.Failed)
+	// Every row of the tenant across batches, and nothing else
+	assert.Equal(t, ids, repo.reencrypted)
+
+	state, err := rotation.Get(tenantID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, job, state)
+	_, err = rotation.Get(other, job.ID)
+	assert.Error(t, err)
+}
+
+func TestKeyRotationRunsOncePerTenant(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-000000000133")
+	other := uuid.MustParse("0190d8a4-0000-7000-8000-000000000134")
+
+	repo := &reencryptingRepository{gate: make(chan struct{})}
+	for _, tenant := range []uuid.UUID{tenantID, other} {
+		id, _ := uuid.NewV7()
+		require.NoError(t, repo.Store(dto.NewMessageDto{ID: id.String(), TenantID: tenant.String()}))
+	}
+	cipher := &versionCipher{versions: map[string]int{}}
+	rotation := NewKeyRotationService(repo, cipher)
+
+	first, err := rotation.Start(tenantID)
+	require.NoError(t, err)
+
+	// A second rotation of the same tenant is refused without a new key
+	_, err = rotation.Start(tenantID)
+	var fiberErr *fiber.Error
+	require.ErrorAs(t, err, &fiberErr)
+	assert.Equal(t, fiber.StatusConflict, fiberErr.Code)
+	assert.Contains(t, fiberErr.Message, first.ID)
+	assert.Equal(t, 1, cipher.versions[tenantID.String()])
+
+	// Other tenants rotate meanwhile
+	second, err := rotation.Start(other)
+	require.NoError(t, err)
+
+	close(repo.gate)
+	for _, job := range []struct {
+		tenant uuid.UUID
+		id     string
+	}{{tenantID, first.ID}, {other, second.ID}} {
+		assert.Eventually(t, func() bool {
+			state, err := rotation.Get(job.tenant, job.id)
+			return err == nil && state.Status == KeyRotationStatusCompleted
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	// Finished rotations release the tenant
+	again, err := rotation.Start(tenantID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, again.KeyVersion)
+}
+
+func TestKeyRotationNeedsEncryption(t *testing.T) {
+	rotation := NewKeyRotationService(&reencryptingRepository{}, nil)
+	_, err := rotation.Start(uuid.New())
+	var fiberErr *fiber.Error
+	require.ErrorAs(t, err, &fiberErr)
+	assert.Equal(t, fiber.StatusConflict, fiberErr.Code)
+}
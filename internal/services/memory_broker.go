@@ -0,0 +1,338 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ Broker = (*MemoryBroker)(nil)
+
+// MemoryBroker is an in-process Broker for tests and local development. Queues
+// live only as long as the process; like the AMQP default exchange, messages
+// published to a queue that does not exist are dropped.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	queues    map[string]*memoryQueue
+	consumers map[string]*memoryConsumer
+	closed    bool
+}
+
+type memoryQueue struct {
+	// bindings are the exchange patterns routed to the queue
+	bindings map[memoryBinding]bool
+	ready    []Delivery
+	unacked  int
+	cond     *sync.Cond
+	deleted  bool
+}
+
+type memoryBinding struct {
+	exchange string
+	pattern  string
+}
+
+type memoryConsumer struct {
+	queue     *memoryQueue
+	cancelled bool
+	stop      chan struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues:    make(map[string]*memoryQueue),
+		consumers: make(map[string]*memoryConsumer),
+	}
+}
+
+// CheckQueueOptions refuses every queue option, there are no queue arguments
+func (b *MemoryBroker) CheckQueueOptions(options models.QueueOptions) error {
+	return unsupportedQueueOptions("memory", options)
+}
+
+// DeclareQueue implements Broker; queue options are refused. Expires is
+// accepted and has nothing to do: the queues go away with the process.
+func (b *MemoryBroker) DeclareQueue(queueName string, options models.QueueOptions) error {
+	if err := b.CheckQueueOptions(options); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("broker closed")
+	}
+	if _, ok := b.queues[queueName]; !ok {
+		b.queues[queueName] = &memoryQueue{bindings: map[memoryBinding]bool{}, cond: sync.NewCond(&b.mu)}
+	}
+	return nil
+}
+
+// DeleteQueue implements Broker
+func (b *MemoryBroker) DeleteQueue(queueName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil
+	}
+	q.deleted = true
+	for tag, consumer := range b.consumers {
+		if consumer.queue == q {
+			b.cancel(tag, consumer)
+		}
+	}
+	delete(b.queues, queueName)
+	return nil
+}
+
+// BindQueue implements Broker
+func (b *MemoryBroker) BindQueue(queueName, exchange, pattern string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !bindingExchange(exchange) {
+		return errUnknownExchange(exchange)
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return fmt.Errorf("failed to bind queue %s: not found", queueName)
+	}
+	q.bindings[memoryBinding{exchange, pattern}] = true
+	return nil
+}
+
+// UnbindQueue implements Broker
+func (b *MemoryBroker) UnbindQueue(queueName, exchange, pattern string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !bindingExchange(exchange) {
+		return errUnknownExchange(exchange)
+	}
+	if q, ok := b.queues[queueName]; ok {
+		delete(q.bindings, memoryBinding{exchange, pattern})
+	}
+	return nil
+}
+
+// ListBindings implements BindingLister
+func (b *MemoryBroker) ListBindings(ctx context.Context, queueName, exchange string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var patterns []string
+	if q, ok := b.queues[queueName]; ok {
+		for binding := range q.bindings {
+			if binding.exchange == exchange {
+				patterns = append(patterns, binding.pattern)
+			}
+		}
+	}
+	sort.Strings(patterns)
+	return patterns, nil
+}
+
+// Publish implements Broker
+func (b *MemoryBroker) Publish(exchange, routingKey string, msg Publishing) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("broker closed")
+	}
+
+	switch exchange {
+	case DefaultExchange:
+		if q, ok := b.queues[routingKey]; ok {
+			b.push(q, Delivery{Publishing: msg}, false)
+		}
+	case TopicExchange, FanoutExchange:
+		for _, q := range b.queues {
+			for binding := range q.bindings {
+				if binding.exchange == exchange && bindingMatch(exchange, binding.pattern, routingKey) {
+					b.push(q, Delivery{Publishing: msg}, false)
+					break
+				}
+			}
+		}
+	default:
+		return errUnknownExchange(exchange)
+	}
+	return nil
+}
+
+// PublishConfirmed implements Broker; the message is queued once Publish returns
+func (b *MemoryBroker) PublishConfirmed(exchange, routingKey string, msg Publishing) error {
+	return b.Publish(exchange, routingKey, msg)
+}
+
+// PublishDelayed implements Broker; the message waits in a timer and is lost
+// with the process, like every message of this broker
+func (b *MemoryBroker) PublishDelayed(queueName string, msg Publishing, delay time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("broker closed")
+	}
+	time.AfterFunc(delay, func() {
+		b.Publish(DefaultExchange, queueName, msg)
+	})
+	return nil
+}
+
+// Consume implements Broker. Consumers of the same queue receive messages in
+// turn.
+func (b *MemoryBroker) Consume(queueName, consumerTag string) (<-chan Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil, fmt.Errorf("failed to consume messages: queue %s not found", queueName)
+	}
+	if _, exists := b.consumers[consumerTag]; exists {
+		return nil, fmt.Errorf("failed to consume messages: consumer %s already exists", consumerTag)
+	}
+
+	consumer := &memoryConsumer{queue: q, stop: make(chan struct{})}
+	b.consumers[consumerTag] = consumer
+
+	deliveries := make(chan Delivery)
+	go b.deliver(consumer, deliveries)
+	return deliveries, nil
+}
+
+// deliver hands queued messages to one consumer until it is cancelled or its
+// queue is deleted
+func (b *MemoryBroker) deliver(consumer *memoryConsumer, deliveries chan<- Delivery) {
+	defer close(deliveries)
+	q := consumer.queue
+
+	for {
+		b.mu.Lock()
+		for len(q.ready) == 0 && !q.deleted && !consumer.cancelled {
+			q.cond.Wait()
+		}
+		if q.deleted || consumer.cancelled {
+			b.mu.Unlock()
+			return
+		}
+		msg := q.ready[0]
+		q.ready = q.ready[1:]
+		q.unacked++
+		b.mu.Unlock()
+
+		msg.ack, msg.nack = b.settle(q, msg)
+
+		select {
+		case deliveries <- msg:
+		case <-consumer.stop:
+			// Not handed out, so it goes back to the front of the queue
+			b.mu.Lock()
+			q.unacked--
+			q.ready = append([]Delivery{msg}, q.ready...)
+			q.cond.Signal()
+			b.mu.Unlock()
+		}
+	}
+}
+
+// settle returns the ack and nack functions of a delivery; only the first call
+// of either has an effect
+func (b *MemoryBroker) settle(q *memoryQueue, msg Delivery) (func() error, func(bool) error) {
+	var once sync.Once
+	ack := func() error {
+		once.Do(func() {
+			b.mu.Lock()
+			q.unacked--
+			b.mu.Unlock()
+		})
+		return nil
+	}
+	nack := func(requeue bool) error {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			q.unacked--
+			if requeue && !q.deleted {
+				b.push(q, Delivery{Publishing: msg.Publishing}, true)
+			}
+		})
+		return nil
+	}
+	return ack, nack
+}
+
+// push queues a message; b.mu must be held
+func (b *MemoryBroker) push(q *memoryQueue, msg Delivery, redelivered bool) {
+	msg.Redelivered = redelivered
+	q.ready = append(q.ready, msg)
+	q.cond.Signal()
+}
+
+// Cancel implements Broker
+func (b *MemoryBroker) Cancel(consumerTag string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	consumer, ok := b.consumers[consumerTag]
+	if !ok {
+		return fmt.Errorf("failed to cancel consumer %s: not found", consumerTag)
+	}
+	b.cancel(consumerTag, consumer)
+	return nil
+}
+
+// cancel ends a consumer; b.mu must be held
+func (b *MemoryBroker) cancel(consumerTag string, consumer *memoryConsumer) {
+	if consumer.cancelled {
+		return
+	}
+	consumer.cancelled = true
+	close(consumer.stop)
+	consumer.queue.cond.Broadcast()
+	delete(b.consumers, consumerTag)
+}
+
+// Close implements Broker; all consumers are cancelled
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for tag, consumer := range b.consumers {
+		b.cancel(tag, consumer)
+	}
+	return nil
+}
+
+// ListQueues implements QueueLister
+func (b *MemoryBroker) ListQueues(ctx context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := make([]string, 0, len(b.queues))
+	for name := range b.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// QueueLength returns the number of messages waiting in a queue and the number
+// delivered but not yet acked
+func (b *MemoryBroker) QueueLength(queueName string) (ready, unacked int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if q, ok := b.queues[queueName]; ok {
+		return len(q.ready), q.unacked
+	}
+	return 0, 0
+}
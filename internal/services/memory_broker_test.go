@@ -0,0 +1,334 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, deliveries <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case msg, ok := <-deliveries:
+		require.True(t, ok, "deliveries closed")
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+		return Delivery{}
+	}
+}
+
+func TestMemoryBrokerAckNackAndCancel(t *testing.T) {
+	b := NewMemoryBroker()
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+
+	// Undeclared queues drop messages, like the AMQP default exchange
+	require.NoError(t, b.Publish(DefaultExchange, "missing", Publishing{Body: []byte("lost")}))
+
+	require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{Body: []byte("1"), Headers: map[string]any{"k": "v"}}))
+	require.NoError(t, b.PublishConfirmed(DefaultExchange, "q", Publishing{Body: []byte("2")}))
+
+	deliveries, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+	_, err = b.Consume("q", "c1")
+	assert.Error(t, err)
+
+	first := receive(t, deliveries)
+	assert.Equal(t, "1", string(first.Body))
+	assert.Equal(t, "v", first.Headers["k"])
+	assert.False(t, first.Redelivered)
+	require.NoError(t, first.Nack(true))
+
+	second := receive(t, deliveries)
+	assert.Equal(t, "2", string(second.Body))
+	require.NoError(t, second.Ack())
+
+	again := receive(t, deliveries)
+	assert.Equal(t, "1", string(again.Body))
+	assert.True(t, again.Redelivered)
+
+	ready, unacked := b.QueueLength("q")
+	assert.Equal(t, 0, ready)
+	assert.Equal(t, 1, unacked)
+	require.NoError(t, again.Nack(false))
+	require.NoError(t, again.Ack()) // settled already, no effect
+	_, unacked = b.QueueLength("q")
+	assert.Equal(t, 0, unacked)
+
+	require.NoError(t, b.Cancel("c1"))
+	_, ok := <-deliveries
+	assert.False(t, ok)
+
+	queues, err := b.ListQueues(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"q"}, queues)
+
+	require.NoError(t, b.DeleteQueue("q"))
+	_, err = b.Consume("q", "c2")
+	assert.Error(t, err)
+}
+
+func TestTopicMatch(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"t1.#", "t1.orders.created", true},
+		{"t1.#", "t1", true},
+		{"t1.#", "t10.orders", false},
+		{"t1.orders.*", "t1.orders.created", true},
+		{"t1.orders.*", "t1.orders", false},
+		{"t1.orders.*", "t1.orders.created.eu", false},
+		{"t1.*.created", "t1.orders.created", true},
+		{"t1.#.created", "t1.created", true},
+		{"t1.#.created", "t1.a.b.created", true},
+		{"t1.order-v1", "t1.order-v1", true},
+		{"t1.a_b", "t1.aXb", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, topicMatch(c.pattern, c.key), "%s ~ %s", c.pattern, c.key)
+	}
+
+	assert.True(t, ValidRoutingKey("orders.created"))
+	assert.False(t, ValidRoutingKey("orders.*"))
+	assert.False(t, ValidRoutingKey("orders..created"))
+	assert.True(t, ValidBindingPattern("orders.*"))
+	assert.True(t, ValidBindingPattern("#"))
+	assert.False(t, ValidBindingPattern("orders.cre*"))
+	assert.False(t, ValidBindingPattern(""))
+}
+
+func TestMemoryBrokerTopicRouting(t *testing.T) {
+	b := NewMemoryBroker()
+	require.NoError(t, b.DeclareQueue("orders", models.QueueOptions{}))
+	require.NoError(t, b.DeclareQueue("all", models.QueueOptions{}))
+	require.NoError(t, b.BindQueue("orders", TopicExchange, "t1.orders.*"))
+	require.NoError(t, b.BindQueue("all", TopicExchange, "t1.#"))
+	require.NoError(t, b.BindQueue("all", TopicExchange, "t1.orders.#"))
+	assert.Error(t, b.BindQueue("missing", TopicExchange, "t1.#"))
+	assert.Error(t, b.BindQueue("all", "unknown", "t1.#"))
+
+	require.NoError(t, b.Publish(TopicExchange, "t1.orders.created", Publishing{Body: []byte("1")}))
+	require.NoError(t, b.Publish(TopicExchange, "t1.users.created", Publishing{Body: []byte("2")}))
+	require.NoError(t, b.Publish(TopicExchange, "t2.orders.created", Publishing{Body: []byte("3")}))
+
+	ready, _ := b.QueueLength("orders")
+	assert.Equal(t, 1, ready)
+	// Matching two bindings still delivers a single copy
+	ready, _ = b.QueueLength("all")
+	assert.Equal(t, 2, ready)
+
+	require.NoError(t, b.UnbindQueue("orders", TopicExchange, "t1.orders.*"))
+	require.NoError(t, b.Publish(TopicExchange, "t1.orders.created", Publishing{Body: []byte("4")}))
+	ready, _ = b.QueueLength("orders")
+	assert.Equal(t, 1, ready)
+}
+
+// fakeBindingRepository keeps tenant bindings in memory
+type fakeBindingRepository struct {
+	mu       sync.Mutex
+	bindings map[string]map[string]bool
+}
+
+func newFakeBindingRepository() *fakeBindingRepository {
+	return &fakeBindingRepository{bindings: map[string]map[string]bool{}}
+}
+
+func (r *fakeBindingRepository) List(ctx context.Context, tenantID string) ([]models.TenantBinding, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	patterns := make([]string, 0, len(r.bindings[tenantID]))
+	for pattern := range r.bindings[tenantID] {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	bindings := make([]models.TenantBinding, len(patterns))
+	for i, pattern := range patterns {
+		bindings[i] = models.TenantBinding{TenantID: tenantID, Pattern: pattern}
+	}
+	return bindings, nil
+}
+
+func (r *fakeBindingRepository) Create(ctx context.Context, binding *models.TenantBinding) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.bindings[binding.TenantID] == nil {
+		r.bindings[binding.TenantID] = map[string]bool{}
+	}
+	if r.bindings[binding.TenantID][binding.Pattern] {
+		return false, nil
+	}
+	r.bindings[binding.TenantID][binding.Pattern] = true
+	return true, nil
+}
+
+func (r *fakeBindingRepository) Delete(ctx context.Context, tenantID, pattern string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.bindings[tenantID][pattern] {
+		return false, nil
+	}
+	delete(r.bindings[tenantID], pattern)
+	return true, nil
+}
+
+func (r *fakeBindingRepository) DeleteAll(ctx context.Context, tenantID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.bindings, tenantID)
+	return nil
+}
+
+type recordingMessageRepository struct {
+	repositories.MessageRepository
+	mu     sync.Mutex
+	stored []dto.NewMessageDto
+}
+
+func (r *recordingMessageRepository) Store(message dto.NewMessageDto) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stored = append(r.stored, message)
+	return nil
+}
+
+func (r *recordingMessageRepository) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.stored)
+}
+
+func TestTenantConsumerOverMemoryBroker(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000aa")
+	queueName := TenantQueueName(tenantID.String())
+
+	broker := NewMemoryBroker()
+	repo := &recordingMessageRepository{}
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: tenantID.String()}}}
+	manager := NewTenantManager(broker, repo, tenants, newFakeBindingRepository())
+	publisher := NewPublisherService(broker)
+
+	require.NoError(t, manager.StartTenantConsumer(context.Background(), tenantID, 2))
+
+	for range 3 {
+		require.NoError(t, publisher.Publish(queueName, Message{
+			TenantID: tenantID.String(),
+			Payload:  map[string]any{"order_id": "A-1"},
+		}))
+	}
+	assert.Eventually(t, func() bool { return repo.count() == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "A-1", repo.stored[0].Payload["order_id"])
+
+	assert.Eventually(t, func() bool {
+		ready, unacked := broker.QueueLength(queueName)
+		return ready == 0 && unacked == 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, manager.StopTenantConsumer(tenantID))
+	assert.Empty(t, manager.RunningTenants())
+	// Dead letters outlive the consumer, they go with the tenant
+	queues, _ := broker.ListQueues(context.Background())
+	assert.Equal(t, []string{TenantDeadLetterQueueName(tenantID.String())}, queues)
+}
+
+func TestTenantConsumerRecordsMatchingBinding(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000bb")
+	ctx := context.Background()
+
+	broker := NewMemoryBroker()
+	repo := &recordingMessageRepository{}
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: tenantID.String()}}}
+	manager := NewTenantManager(broker, repo, tenants, newFakeBindingRepository())
+	publisher := NewPublisherService(broker)
+
+	// Bound before the consumer starts
+	_, err := manager.AddBinding(ctx, tenantID, "orders.*")
+	require.NoError(t, err)
+	require.NoError(t, manager.StartTenantConsumer(ctx, tenantID, 1))
+	defer manager.StopTenantConsumer(tenantID)
+
+	_, err = manager.AddBinding(ctx, tenantID, "orders.*")
+	assert.Error(t, err)
+	_, err = manager.AddBinding(ctx, tenantID, "orders.cre*")
+	assert.Error(t, err)
+
+	publish := func(eventType string) {
+		t.Helper()
+		require.NoError(t, publisher.PublishEvent(Message{
+			TenantID:  tenantID.String(),
+			EventType: eventType,
+			Payload:   map[string]any{"event": eventType},
+		}))
+	}
+	publish("orders.created")
+	publish("users.created") // not bound, dropped by the exchange
+	assert.Eventually(t, func() bool { return repo.count() == 1 }, time.Second, 5*time.Millisecond)
+
+	// Bound while the consumer runs
+	_, err = manager.AddBinding(ctx, tenantID, DefaultBindingPattern)
+	require.NoError(t, err)
+	publish("users.created")
+	publish("")
+	assert.Eventually(t, func() bool { return repo.count() == 3 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, manager.RemoveBinding(ctx, tenantID, "orders.*"))
+	assert.Error(t, manager.RemoveBinding(ctx, tenantID, "orders.*"))
+	publish("orders.updated")
+	assert.Eventually(t, func() bool { return repo.count() == 4 }, time.Second, 5*time.Millisecond)
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	recorded := map[string]string{}
+	for _, message := range repo.stored {
+		recorded[message.EventType] = message.Binding
+	}
+	assert.Equal(t, map[string]string{
+		"orders.created": "orders.*",
+		"users.created":  "#",
+		"message":        "#",
+		"orders.updated": "#",
+	}, recorded)
+}
+
+func TestBindingsAppliedWithoutConsumerAndSyncedOnStart(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000bc")
+	id := tenantID.String()
+	queueName := TenantQueueName(id)
+	ctx := context.Background()
+
+	broker := NewMemoryBroker()
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: id}}}
+	bindings := newFakeBindingRepository()
+	manager := NewTenantManager(broker, &recordingMessageRepository{}, tenants, bindings)
+	manager.SyncBindings(broker)
+
+	// The consumer may run on another instance, the broker is bound anyway
+	_, err := manager.AddBinding(ctx, tenantID, "orders.*")
+	require.NoError(t, err)
+	_, err = manager.AddBinding(ctx, tenantID, "users.*")
+	require.NoError(t, err)
+	require.NoError(t, manager.RemoveBinding(ctx, tenantID, "users.*"))
+	bound, err := broker.ListBindings(ctx, queueName, TopicExchange)
+	require.NoError(t, err)
+	assert.Equal(t, []string{TenantRoutingKey(id, "orders.*")}, bound)
+
+	// A binding deleted while the broker was unreachable stays bound until
+	// the consumer starts
+	require.NoError(t, broker.BindQueue(queueName, TopicExchange, TenantRoutingKey(id, "users.*")))
+	require.NoError(t, manager.StartTenantConsumer(ctx, tenantID, 1))
+	defer manager.StopTenantConsumer(tenantID)
+
+	bound, err = broker.ListBindings(ctx, queueName, TopicExchange)
+	require.NoError(t, err)
+	assert.Equal(t, []string{TenantRoutingKey(id, "orders.*")}, bound)
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultStreamHeartbeat is how often an idle stream is pinged
+const DefaultStreamHeartbeat = 15 * time.Second
+
+const (
+	// streamBufferSize is how many messages a subscriber may fall behind
+	streamBufferSize = 256
+	// streamReplayPage is the page size of the Last-Event-ID catch-up
+	streamReplayPage = 500
+)
+
+// ErrStreamLagged ends a stream whose client could not keep up; it should
+// reconnect with the last event ID it received
+var ErrStreamLagged = errors.New("stream subscriber fell behind")
+
+// MessageStream pushes stored messages to live subscribers, e.g. dashboards
+// connected over SSE or WebSocket. Messages stored on this instance are handed
+// over directly; with Listen, messages stored by other instances arrive
+// through NOTIFY.
+type MessageStream struct {
+	messages  repositories.MessageRepository
+	heartbeat time.Duration
+	// origin tells our own notifications apart from other instances'
+	origin string
+	notify bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	subscribers map[string]map[*StreamSubscription]struct{}
+}
+
+// StreamSubscription receives the messages stored for one tenant
+type StreamSubscription struct {
+	tenantID string
+	messages chan models.Message
+}
+
+// NewMessageStream constructor
+func NewMessageStream(messages repositories.MessageRepository, heartbeat time.Duration) *MessageStream {
+	if heartbeat <= 0 {
+		heartbeat = DefaultStreamHeartbeat
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MessageStream{
+		messages:    messages,
+		heartbeat:   heartbeat,
+		origin:      uuid.NewString(),
+		ctx:         ctx,
+		cancel:      cancel,
+		subscribers: make(map[string]map[*StreamSubscription]struct{}),
+	}
+}
+
+// Listen announces stored messages to other instances and receives theirs,
+// using a dedicated connection to dsn. Without it subscribers only see the
+// messages stored by this instance live.
+func (s *MessageStream) Listen(dsn string) {
+	s.notify = true
+	go func() {
+		for {
+			err := s.listen(dsn)
+			if s.ctx.Err() != nil {
+				return
+			}
+			log.Printf("[Stream] Listener failed, retrying: %v", err)
+
+			select {
+			case <-time.After(5 * time.Second):
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the listener
+func (s *MessageStream) Close() {
+	s.cancel()
+}
+
+func (s *MessageStream) listen(dsn string) error {
+	conn, err := pgx.Connect(s.ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(s.ctx, "LISTEN "+repositories.MessageNotifyChannel); err != nil {
+		return err
+	}
+	log.Println("[Stream] Listening for stored messages")
+
+	for {
+		notification, err := conn.WaitForNotification(s.ctx)
+		if err != nil {
+			return err
+		}
+		s.received(notification.Payload)
+	}
+}
+
+// received passes a message another instance stored on to our subscribers
+// of the tenant
+func (s *MessageStream) received(payload string) {
+	fields := strings.Fields(payload)
+	if len(fields) != 3 || fields[0] == s.origin {
+		return
+	}
+	s.load(fields[1], fields[2])
+}
+
+// Stored passes a message the consumer has just stored to the subscribers of
+// its tenant. A nil stream does nothing.
+func (s *MessageStream) Stored(message models.Message) {
+	if s == nil {
+		return
+	}
+	s.load(message.TenantID, message.ID)
+
+	if s.notify {
+		payload := fmt.Sprintf("%s %s %s", s.origin, message.TenantID, message.ID)
+		if err := s.messages.NotifyStored(s.ctx, payload); err != nil {
+			log.Printf("[Stream] %v", err)
+		}
+	}
+}
+
+// load reads a stored message back, with the seq the database gave it, and
+// publishes it when the tenant has subscribers
+func (s *MessageStream) load(tenantID, messageID string) {
+	if !s.hasSubscribers(tenantID) {
+		return
+	}
+	message, err := s.messages.FindMessage(s.ctx, tenantID, messageID)
+	if err != nil {
+		log.Printf("[Stream] Failed to load message %s: %v", messageID, err)
+		return
+	}
+	s.publish(message)
+}
+
+// Subscribe starts receiving the tenant's messages
+func (s *MessageStream) Subscribe(tenantID string) *StreamSubscription {
+	sub := &StreamSubscription{
+		tenantID: tenantID,
+		messages: make(chan models.Message, streamBufferSize),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[tenantID] == nil {
+		s.subscribers[tenantID] = make(map[*StreamSubscription]struct{})
+	}
+	s.subscribers[tenantID][sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops a subscription; it is safe to call more than once
+func (s *MessageStream) Unsubscribe(sub *StreamSubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop(sub)
+}
+
+// LastSeq resolves a Last-Event-ID to the seq to resume after: a seq as sent
+// in the event IDs, or a message ID as sent before seqs were. An empty
+// lastEventID returns -1, which only follows new messages.
+func (s *MessageStream) LastSeq(ctx context.Context, tenantID, lastEventID string) (int64, error) {
+	if lastEventID == "" {
+		return -1, nil
+	}
+	if seq, err := strconv.ParseInt(lastEventID, 10, 64); err == nil && seq >= 0 {
+		return seq, nil
+	}
+	if _, err := uuid.Parse(lastEventID); err != nil {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "Invalid last event ID")
+	}
+	message, err := s.messages.FindMessage(ctx, tenantID, lastEventID)
+	if err != nil {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusNotFound {
+			return 0, fiber.NewError(fiber.StatusBadRequest, "Unknown last event ID")
+		}
+		return 0, err
+	}
+	return message.Seq, nil
+}
+
+// Follow sends the tenant's messages stored after lastSeq in commit order,
+// then every new message as it is stored, until ctx ends or send fails. A
+// negative lastSeq only follows new messages. ping is called when the stream
+// has been idle for the heartbeat interval, so dead connections are noticed.
+func (s *MessageStream) Follow(ctx context.Context, tenantID string, lastSeq int64, send func(models.Message) error, ping func() error) error {
+	// Subscribe before catching up so nothing stored meanwhile is missed
+	sub := s.Subscribe(tenantID)
+	defer s.Unsubscribe(sub)
+
+	// Live copies of the messages sent while catching up are skipped by ID:
+	// live messages arrive in the order they are published, not by seq
+	var sent map[string]struct{}
+	if lastSeq >= 0 {
+		var err error
+		if sent, err = s.replay(ctx, tenantID, lastSeq, send); err != nil {
+			return err
+		}
+	}
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case message, ok := <-sub.messages:
+			if !ok {
+				return ErrStreamLagged
+			}
+			if _, ok := sent[message.ID]; ok {
+				delete(sent, message.ID)
+				continue
+			}
+			if err := send(message); err != nil {
+				return err
+			}
+			heartbeat.Reset(s.heartbeat)
+		case <-heartbeat.C:
+			if err := ping(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// replay sends the stored messages after lastSeq in seq order and returns the
+// IDs it sent
+func (s *MessageStream) replay(ctx context.Context, tenantID string, lastSeq int64, send func(models.Message) error) (map[string]struct{}, error) {
+	sent := make(map[string]struct{})
+	cursor := lastSeq
+	for {
+		page, err := s.messages.ListMessagesAfterSeq(ctx, tenantID, cursor, streamReplayPage)
+		if err != nil {
+			return sent, err
+		}
+		for _, message := range page {
+			if err := send(message); err != nil {
+				return sent, err
+			}
+			sent[message.ID] = struct{}{}
+			cursor = message.Seq
+		}
+		if len(page) < streamReplayPage {
+			return sent, nil
+		}
+	}
+}
+
+// publish hands a message to the subscribers of its tenant. A subscriber
+// whose buffer is full is dropped rather than holding up the consumer.
+func (s *MessageStream) publish(message models.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subscribers[message.TenantID] {
+		select {
+		case sub.messages <- message:
+		default:
+			log.Printf("[Stream] Dropping slow subscriber of tenant %s", message.TenantID)
+			s.drop(sub)
+		}
+	}
+}
+
+func (s *MessageStream) hasSubscribers(tenantID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers[tenantID]) > 0
+}
+
+// drop removes a subscriber and closes its channel; callers hold s.mu
+func (s *MessageStream) drop(sub *StreamSubscription) {
+	subs, ok := s.subscribers[sub.tenantID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.messages)
+	if len(subs) == 0 {
+		delete(s.subscribers, sub.tenantID)
+	}
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamMessageRepository keeps stored messages in memory in commit order,
+// numbering them like the seq column
+type streamMessageRepository struct {
+	repositories.MessageRepository
+	mu       sync.Mutex
+	messages []models.Message
+}
+
+func (r *streamMessageRepository) Store(message dto.NewMessageDto) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, models.Message{
+		ID: message.ID, TenantID: message.TenantID, Payload: message.Payload, EventType: message.EventType,
+		Seq: int64(len(r.messages) + 1),
+	})
+	return nil
+}
+
+func (r *streamMessageRepository) ListMessagesAfter(ctx context.Context, filter dto.MessageFilter, afterID string, limit int) ([]models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var page []models.Message
+	for _, message := range r.messages {
+		if message.TenantID == filter.TenantID && message.ID > afterID {
+			page = append(page, message)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool { return page[i].ID < page[j].ID })
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return page, nil
+}
+
+func (r *streamMessageRepository) ListMessagesAfterSeq(ctx context.Context, tenantID string, afterSeq int64, limit int) ([]models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var page []models.Message
+	for _, message := range r.messages {
+		if message.TenantID == tenantID && message.Seq > afterSeq && len(page) < limit {
+			page = append(page, message)
+		}
+	}
+	return page, nil
+}
+
+func (r *streamMessageRepository) FindMessage(ctx context.Context, tenantID, messageID string) (models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, message := range r.messages {
+		if message.TenantID == tenantID && message.ID == messageID {
+			return message, nil
+		}
+	}
+	return models.Message{}, fiber.NewError(fiber.StatusNotFound, "message not found")
+}
+
+// follow runs Follow in the background and returns the messages it sends
+func follow(t *testing.T, stream *MessageStream, tenantID string, lastSeq int64) (<-chan models.Message, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan models.Message, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Follow(ctx, tenantID, lastSeq, func(message models.Message) error {
+			received <- message
+			return nil
+		}, func() error { return nil })
+	}()
+	return received, cancel, done
+}
+
+func nextMessage(t *testing.T, received <-chan models.Message) models.Message {
+	t.Helper()
+	select {
+	case message := <-received:
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("no message streamed")
+		return models.Message{}
+	}
+}
+
+func TestStreamPushesStoredMessages(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000f1")
+	ctx := context.Background()
+
+	repo := &streamMessageRepository{}
+	stream := NewMessageStream(repo, time.Hour)
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: tenantID.String()}}}
+	manager := NewTenantManager(NewMemoryBroker(), repo, tenants, newFakeBindingRepository())
+	manager.EnableStreaming(stream)
+	require.NoError(t, manager.StartTenantConsumer(ctx, tenantID, 1))
+	defer manager.StopTenantConsumer(tenantID)
+
+	received, cancel, done := follow(t, stream, tenantID.String(), -1)
+	assert.Eventually(t, func() bool { return stream.hasSubscribers(tenantID.String()) }, time.Second, time.Millisecond)
+
+	publisher := NewPublisherService(manager.broker)
+	for i := range 3 {
+		require.NoError(t, publisher.Publish(TenantQueueName(tenantID.String()), Message{
+			TenantID: tenantID.String(), EventType: "orders.created", Payload: map[string]any{"n": i},
+		}))
+	}
+
+	var ids []string
+	for i := range 3 {
+		message := nextMessage(t, received)
+		assert.Equal(t, float64(i), message.Payload["n"])
+		assert.Equal(t, "orders.created", message.EventType)
+		ids = append(ids, message.ID)
+	}
+
+	// Live messages carry the seq of the stored row, the resume cursor
+	repo.mu.Lock()
+	require.Len(t, repo.messages, 3)
+	for i, message := range repo.messages {
+		assert.Equal(t, message.ID, ids[i])
+		assert.Equal(t, int64(i+1), message.Seq)
+	}
+	repo.mu.Unlock()
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.False(t, stream.hasSubscribers(tenantID.String()))
+}
+
+func TestStreamResumesFromLastEventID(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000f2").String()
+	ctx := context.Background()
+	repo := &streamMessageRepository{}
+	stream := NewMessageStream(repo, time.Hour)
+
+	var ids []string
+	for range 4 {
+		id, _ := uuid.NewV7()
+		ids = append(ids, id.String())
+	}
+	for _, i := range []int{0, 1, 2} {
+		require.NoError(t, repo.Store(dto.NewMessageDto{ID: ids[i], TenantID: tenantID, Payload: map[string]any{"n": i}}))
+	}
+
+	// Event IDs are seqs; message IDs from earlier versions still resolve
+	lastSeq, err := stream.LastSeq(ctx, tenantID, "1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), lastSeq)
+	lastSeq, err = stream.LastSeq(ctx, tenantID, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), lastSeq)
+	for _, invalid := range []string{"-1", "abc", uuid.NewString()} {
+		_, err := stream.LastSeq(ctx, tenantID, invalid)
+		var fiberErr *fiber.Error
+		require.ErrorAs(t, err, &fiberErr, invalid)
+		assert.Equal(t, fiber.StatusBadRequest, fiberErr.Code)
+	}
+
+	// Stored before the reconnect: messages after the cursor are replayed
+	received, cancel, done := follow(t, stream, tenantID, lastSeq)
+	assert.Equal(t, ids[1], nextMessage(t, received).ID)
+	assert.Equal(t, ids[2], nextMessage(t, received).ID)
+
+	// A live copy of a replayed message is not sent twice
+	stream.Stored(models.Message{ID: ids[2], TenantID: tenantID})
+	require.NoError(t, repo.Store(dto.NewMessageDto{ID: ids[3], TenantID: tenantID}))
+	stream.Stored(models.Message{ID: ids[3], TenantID: tenantID})
+	assert.Equal(t, ids[3], nextMessage(t, received).ID)
+
+	// A message whose ID was generated first but committed last is still
+	// streamed live, and resuming after the message before it replays it
+	late, _ := uuid.NewV7()
+	lateID := "00000000" + late.String()[8:]
+	require.NoError(t, repo.Store(dto.NewMessageDto{ID: lateID, TenantID: tenantID}))
+	stream.Stored(models.Message{ID: lateID, TenantID: tenantID})
+	assert.Equal(t, lateID, nextMessage(t, received).ID)
+
+	// Messages stored by another instance arrive through NOTIFY
+	id, _ := uuid.NewV7()
+	require.NoError(t, repo.Store(dto.NewMessageDto{ID: id.String(), TenantID: tenantID, Payload: map[string]any{"n": 4}}))
+	stream.received(fmt.Sprintf("%s %s %s", stream.origin, tenantID, id))
+	stream.received(fmt.Sprintf("%s %s %s", uuid.NewString(), tenantID, id))
+	assert.Equal(t, id.String(), nextMessage(t, received).ID)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Empty(t, received)
+
+	resumed, cancel, done := follow(t, stream, tenantID, 4)
+	assert.Equal(t, lateID, nextMessage(t, resumed).ID)
+	assert.Equal(t, id.String(), nextMessage(t, resumed).ID)
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestStreamDropsSlowSubscribers(t *testing.T) {
+	tenantID := "0190d8a4-0000-7000-8000-0000000000f3"
+	stream := NewMessageStream(&streamMessageRepository{}, time.Hour)
+
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Follow(context.Background(), tenantID, -1, func(models.Message) error {
+			<-release
+			return nil
+		}, func() error { return nil })
+	}()
+	assert.Eventually(t, func() bool { return stream.hasSubscribers(tenantID) }, time.Second, time.Millisecond)
+
+	// The consumer never waits for a subscriber that stopped reading
+	for range streamBufferSize + 2 {
+		id, _ := uuid.NewV7()
+		stream.publish(models.Message{ID: id.String(), TenantID: tenantID})
+	}
+	assert.False(t, stream.hasSubscribers(tenantID))
+
+	close(release)
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrStreamLagged)
+	case <-time.After(time.Second):
+		t.Fatal("stream did not end")
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	outboxBatchSize = 100
+	// outboxLockFor covers publishing a whole batch, each publish waiting up to
+	// the broker confirm timeout; a relay slower than that may see rows re-sent
+	outboxLockFor = 10 * time.Minute
+)
+
+// OutboxRelay publishes pending outbox rows and marks them sent once the
+// broker has confirmed them. A row that fails maxAttempts times is parked as
+// failed so the rows behind it are relayed.
+type OutboxRelay struct {
+	outbox      repositories.OutboxRepository
+	broker      Broker
+	compressor  *PayloadCompressor
+	interval    time.Duration
+	maxAttempts int
+	stopChan    chan struct{}
+	doneChan    chan struct{}
+}
+
+func NewOutboxRelay(outbox repositories.OutboxRepository, broker Broker, interval time.Duration, maxAttempts int) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:      outbox,
+		broker:      broker,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		stopChan:    make(chan struct{}),
+		doneChan:    make(chan struct{}),
+	}
+}
+
+// EnableCompression compresses relayed bodies above the compressor threshold
+func (r *OutboxRelay) EnableCompression(compressor *PayloadCompressor) {
+	r.compressor = compressor
+}
+
+// Start runs the relay loop in the background
+func (r *OutboxRelay) Start() {
+	go func() {
+		log.Printf("[Outbox] Relay started, polling every %s", r.interval)
+		defer close(r.doneChan)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.drain()
+			case <-r.stopChan:
+				log.Println("[Outbox] Relay stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the current batch to finish and stops the relay
+func (r *OutboxRelay) Stop() {
+	close(r.stopChan)
+	<-r.doneChan
+}
+
+// drain relays full batches until the outbox is empty or publishing fails.
+// Every row is marked on its own once the broker confirmed it, so a later
+// failure never sends the rows already published again. A row failing for the
+// last time is parked and the batch goes on past it.
+func (r *OutboxRelay) drain() {
+	ctx := context.Background()
+	for {
+		claimed, err := r.outbox.Claim(ctx, outboxBatchSize, outboxLockFor)
+		if err != nil {
+			log.Printf("[Outbox] Relay batch failed: %v", err)
+			return
+		}
+
+		for i, message := range claimed {
+			if err := r.publish(message); err != nil {
+				log.Printf("[Outbox] Failed to relay %s: %v", message.ID, err)
+				if err := r.outbox.MarkFailed(ctx, message.ID, err, r.maxAttempts); err != nil {
+					log.Printf("[Outbox] %v", err)
+				}
+				if message.Attempts+1 >= r.maxAttempts {
+					log.Printf("[Outbox] Parked %s after %d attempts", message.ID, message.Attempts+1)
+					continue
+				}
+
+				// Stop here; the broker is most likely down
+				if err := r.outbox.Release(ctx, outboxIDs(claimed[i+1:])); err != nil {
+					log.Printf("[Outbox] %v", err)
+				}
+				return
+			}
+
+			if err := r.outbox.MarkSent(ctx, message.ID); err != nil {
+				// The row is relayed again once its lock expires
+				log.Printf("[Outbox] %v", err)
+			}
+		}
+
+		if len(claimed) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func (r *OutboxRelay) publish(message models.OutboxMessage) error {
+	body, err := json.Marshal(message.Body)
+	if err != nil {
+		return err
+	}
+
+	body, encoding, err := r.compressor.Compress(body)
+	if err != nil {
+		return err
+	}
+
+	return r.broker.PublishConfirmed(message.Exchange, message.QueueName, Publishing{
+		ContentType:     "application/json",
+		ContentEncoding: encoding,
+		MessageID:       message.ID,
+		Headers:         message.Headers,
+		Body:            body,
+	})
+}
+
+func outboxIDs(messages []models.OutboxMessage) []string {
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	return ids
+}
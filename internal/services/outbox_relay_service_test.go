@@ -0,0 +1,207 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryOutbox keeps outbox rows in memory with the claim semantics of the
+// Postgres repository
+type memoryOutbox struct {
+	repositories.OutboxRepository
+	mu   sync.Mutex
+	rows []*models.OutboxMessage
+}
+
+func (o *memoryOutbox) add(id, exchange, queue string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.rows = append(o.rows, &models.OutboxMessage{
+		ID: id, Exchange: exchange, QueueName: queue, Body: models.JSONB{"id": id}, Status: models.OutboxStatusPending,
+	})
+}
+
+func (o *memoryOutbox) row(id string) models.OutboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, row := range o.rows {
+		if row.ID == id {
+			return *row
+		}
+	}
+	return models.OutboxMessage{}
+}
+
+func (o *memoryOutbox) Claim(ctx context.Context, limit int, lockFor time.Duration) ([]models.OutboxMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var claimed []models.OutboxMessage
+	now := time.Now()
+	for _, row := range o.rows {
+		if len(claimed) == limit {
+			break
+		}
+		if row.Status != models.OutboxStatusPending || (row.LockedUntil != nil && row.LockedUntil.After(now)) {
+			continue
+		}
+		lockedUntil := now.Add(lockFor)
+		row.LockedUntil = &lockedUntil
+		claimed = append(claimed, *row)
+	}
+	return claimed, nil
+}
+
+func (o *memoryOutbox) update(id string, fn func(row *models.OutboxMessage)) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, row := range o.rows {
+		if row.ID == id {
+			fn(row)
+		}
+	}
+	return nil
+}
+
+func (o *memoryOutbox) MarkSent(ctx context.Context, id string) error {
+	return o.update(id, func(row *models.OutboxMessage) {
+		row.Status = models.OutboxStatusSent
+		row.Attempts++
+		row.LockedUntil = nil
+	})
+}
+
+func (o *memoryOutbox) MarkFailed(ctx context.Context, id string, cause error, maxAttempts int) error {
+	return o.update(id, func(row *models.OutboxMessage) {
+		row.Attempts++
+		if row.Attempts >= maxAttempts {
+			row.Status = models.OutboxStatusFailed
+		}
+		row.LastError = cause.Error()
+		row.LockedUntil = nil
+	})
+}
+
+func (o *memoryOutbox) Release(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		o.update(id, func(row *models.OutboxMessage) { row.LockedUntil = nil })
+	}
+	return nil
+}
+
+// drainQueue returns the IDs of the messages waiting in queue
+func drainQueue(t *testing.T, broker *MemoryBroker, queue string) []string {
+	t.Helper()
+	deliveries, err := broker.Consume(queue, "outbox-test")
+	require.NoError(t, err)
+	defer broker.Cancel("outbox-test")
+
+	var ids []string
+	for {
+		select {
+		case delivery := <-deliveries:
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(delivery.Body, &body))
+			ids = append(ids, body["id"].(string))
+			require.NoError(t, delivery.Ack())
+		case <-time.After(50 * time.Millisecond):
+			return ids
+		}
+	}
+}
+
+func TestOutboxRelayMarksEachPublishedRow(t *testing.T) {
+	broker := NewMemoryBroker()
+	require.NoError(t, broker.DeclareQueue("orders", models.QueueOptions{}))
+
+	outbox := &memoryOutbox{}
+	outbox.add("m1", DefaultExchange, "orders")
+	outbox.add("m2", DefaultExchange, "orders")
+	// The broker rejects this row, the relay stops at it
+	outbox.add("m3", "missing-exchange", "orders")
+	outbox.add("m4", DefaultExchange, "orders")
+
+	relay := NewOutboxRelay(outbox, broker, time.Hour, 5)
+	relay.drain()
+
+	assert.Equal(t, []string{"m1", "m2"}, drainQueue(t, broker, "orders"))
+	assert.Equal(t, models.OutboxStatusSent, outbox.row("m1").Status)
+	assert.Equal(t, models.OutboxStatusSent, outbox.row("m2").Status)
+	failed := outbox.row("m3")
+	assert.Equal(t, models.OutboxStatusPending, failed.Status)
+	assert.Equal(t, 1, failed.Attempts)
+	assert.NotEmpty(t, failed.LastError)
+	assert.Nil(t, failed.LockedUntil)
+	// Rows after the failure were released unpublished
+	assert.Equal(t, models.OutboxStatusPending, outbox.row("m4").Status)
+	assert.Nil(t, outbox.row("m4").LockedUntil)
+
+	// Once the broker accepts the row again only the rows not yet published go out
+	outbox.update("m3", func(row *models.OutboxMessage) { row.Exchange = DefaultExchange })
+	relay.drain()
+	assert.Equal(t, []string{"m3", "m4"}, drainQueue(t, broker, "orders"))
+	assert.Equal(t, 2, outbox.row("m3").Attempts)
+	assert.Equal(t, 1, outbox.row("m1").Attempts)
+}
+
+func TestOutboxRelayParksRowsThatKeepFailing(t *testing.T) {
+	broker := NewMemoryBroker()
+	require.NoError(t, broker.DeclareQueue("orders", models.QueueOptions{}))
+
+	outbox := &memoryOutbox{}
+	// The broker always rejects this row
+	outbox.add("m1", "missing-exchange", "orders")
+	outbox.add("m2", DefaultExchange, "orders")
+
+	relay := NewOutboxRelay(outbox, broker, time.Hour, 2)
+	relay.drain()
+	assert.Empty(t, drainQueue(t, broker, "orders"))
+	assert.Equal(t, models.OutboxStatusPending, outbox.row("m1").Status)
+
+	// The last attempt parks the row and the relay moves past it
+	relay.drain()
+	assert.Equal(t, []string{"m2"}, drainQueue(t, broker, "orders"))
+	parked := outbox.row("m1")
+	assert.Equal(t, models.OutboxStatusFailed, parked.Status)
+	assert.Equal(t, 2, parked.Attempts)
+	assert.NotEmpty(t, parked.LastError)
+
+	// Parked rows are not claimed again
+	relay.drain()
+	assert.Empty(t, drainQueue(t, broker, "orders"))
+	assert.Equal(t, 2, outbox.row("m1").Attempts)
+}
+
+func TestOutboxRelayStops(t *testing.T) {
+	broker := NewMemoryBroker()
+	require.NoError(t, broker.DeclareQueue("orders", models.QueueOptions{}))
+	outbox := &memoryOutbox{}
+	outbox.add("m1", DefaultExchange, "orders")
+
+	relay := NewOutboxRelay(outbox, broker, 5*time.Millisecond, 5)
+	relay.Start()
+	assert.Eventually(t, func() bool { return outbox.row("m1").Status == models.OutboxStatusSent }, time.Second, 5*time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		relay.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("relay did not stop")
+	}
+
+	// Nothing is relayed after Stop
+	outbox.add("m2", DefaultExchange, "orders")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, models.OutboxStatusPending, outbox.row("m2").Status)
+}
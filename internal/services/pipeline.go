@@ -0,0 +1,422 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"aswadwk/messaging-task-go/internal/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Pipeline step types
+const (
+	StepValidate  = "validate"
+	StepTransform = "transform"
+	StepStore     = "store"
+	StepForward   = "forward"
+	StepDrop      = "drop"
+	StepWebhook   = "webhook"
+	StepReply     = "reply"
+)
+
+// pipelineStepTypes lists the step types in the order used by error messages
+var pipelineStepTypes = []string{StepValidate, StepTransform, StepStore, StepForward, StepDrop, StepWebhook, StepReply}
+
+// maxForwardHops bounds how often a message is forwarded between tenants, so
+// forward steps pointing at each other cannot loop forever
+const maxForwardHops = 8
+
+// DefaultPipeline runs for tenants without a pipeline: store every message and
+// send it to the tenant webhooks
+var DefaultPipeline = models.Pipeline{{Type: StepStore}, {Type: StepWebhook}}
+
+// ErrDropMessage stops a pipeline; the message is acked without running the
+// remaining steps
+var ErrDropMessage = errors.New("message dropped")
+
+// RetryableError marks a step failure that may succeed later, e.g. the
+// database being unreachable. Any other error dead-letters the message.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+// Retryable wraps err in a RetryableError
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{Err: err}
+}
+
+// PipelineMessage is the message a pipeline works on; processors change it in
+// place
+type PipelineMessage struct {
+	dto.NewMessageDto
+	// Size is the decoded body size, counted as stored bytes
+	Size int
+	// ReplyTo and CorrelationID are set on requests that wait for a reply
+	ReplyTo       string
+	CorrelationID string
+	// Hops counts the forward steps the message already went through
+	Hops int
+}
+
+// MessageProcessor is one step of a tenant pipeline
+type MessageProcessor interface {
+	Process(ctx context.Context, msg *PipelineMessage) error
+}
+
+// MessagePipeline runs its processors in order
+type MessagePipeline struct {
+	names []string
+	steps []MessageProcessor
+}
+
+// Run processes msg starting at step from. On failure it returns the index of
+// the step that failed, so a retry can resume there.
+func (p *MessagePipeline) Run(ctx context.Context, msg *PipelineMessage, from int) (int, error) {
+	for i := from; i < len(p.steps); i++ {
+		if err := p.steps[i].Process(ctx, msg); err != nil {
+			return i, err
+		}
+	}
+	return len(p.steps), nil
+}
+
+// StepName returns the type of step i, used in logs and dead-letter headers
+func (p *MessagePipeline) StepName(i int) string {
+	if i < 0 || i >= len(p.names) {
+		return ""
+	}
+	return p.names[i]
+}
+
+// ValidatePipeline checks the pipeline configuration of tenantID, returning 400
+// for unknown step types and invalid step settings
+func ValidatePipeline(tenantID string, steps models.Pipeline) error {
+	for i, step := range steps {
+		invalid := func(format string, args ...any) error {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("step %d (%s): ", i+1, step.Type)+fmt.Sprintf(format, args...))
+		}
+
+		switch step.Type {
+		case StepValidate, StepStore, StepWebhook, StepReply:
+		case StepTransform:
+			if len(step.Rename) == 0 && len(step.Remove) == 0 && len(step.Set) == 0 {
+				return invalid("set at least one of rename, remove or set")
+			}
+			paths := append([]string{}, step.Remove...)
+			for from, to := range step.Rename {
+				// A chained rename would depend on the order the renames run in
+				if _, ok := step.Rename[to]; ok {
+					return invalid("rename target %q is also renamed", to)
+				}
+				paths = append(paths, from, to)
+			}
+			for path := range step.Set {
+				paths = append(paths, path)
+			}
+			for _, path := range paths {
+				if _, err := parseErasurePath(path); err != nil {
+					return invalid("invalid path %q", path)
+				}
+			}
+		case StepForward:
+			forwardTo, err := uuid.Parse(step.TenantID)
+			if err != nil {
+				return invalid("tenant_id must be a tenant UUID")
+			}
+			if forwardTo.String() == tenantID {
+				return invalid("cannot forward to the tenant itself")
+			}
+			if step.EventType != "" && !ValidRoutingKey(step.EventType) {
+				return invalid("event_type must be dot separated words of letters, digits, _ or -")
+			}
+		case StepDrop:
+			if step.EventTypes != "" && !ValidBindingPattern(step.EventTypes) {
+				return invalid("event_types must be a binding pattern")
+			}
+			for path := range step.Match {
+				if _, err := parseErasurePath(path); err != nil {
+					return invalid("invalid path %q", path)
+				}
+			}
+		default:
+			last := len(pipelineStepTypes) - 1
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("step %d: type must be %s or %s",
+				i+1, strings.Join(pipelineStepTypes[:last], ", "), pipelineStepTypes[last]))
+		}
+	}
+	return nil
+}
+
+// buildPipeline turns a validated configuration into processors
+func (tm *TenantManager) buildPipeline(tenantID string, steps models.Pipeline) (*MessagePipeline, error) {
+	if len(steps) == 0 {
+		steps = DefaultPipeline
+	}
+	if err := ValidatePipeline(tenantID, steps); err != nil {
+		return nil, err
+	}
+
+	pipeline := &MessagePipeline{}
+	for _, step := range steps {
+		var processor MessageProcessor
+		switch step.Type {
+		case StepValidate:
+			if tm.schemas == nil {
+				return nil, fiber.NewError(fiber.StatusBadRequest, "schema validation is not enabled")
+			}
+			processor = &validateProcessor{schemas: tm.schemas}
+		case StepTransform:
+			processor = &transformProcessor{rename: step.Rename, remove: step.Remove, set: step.Set}
+		case StepStore:
+			processor = &storeProcessor{repo: tm.messageRepository, usage: tm.usage, stream: tm.stream}
+		case StepForward:
+			processor = &forwardProcessor{broker: tm.broker, tenantID: step.TenantID, eventType: step.EventType}
+		case StepDrop:
+			processor = &dropProcessor{eventTypes: step.EventTypes, match: step.Match}
+		case StepWebhook:
+			processor = &webhookProcessor{webhooks: tm.webhooks}
+		case StepReply:
+			processor = &replyProcessor{broker: tm.broker}
+		}
+		pipeline.names = append(pipeline.names, step.Type)
+		pipeline.steps = append(pipeline.steps, processor)
+	}
+	return pipeline, nil
+}
+
+// validateProcessor checks the payload against the tenant's active schema.
+// Invalid payloads are dead-lettered; failing to load the schema is retried.
+type validateProcessor struct {
+	schemas *SchemaService
+}
+
+func (p *validateProcessor) Process(ctx context.Context, msg *PipelineMessage) error {
+	version, err := p.schemas.Validate(ctx, msg.TenantID, msg.Payload)
+	if err != nil {
+		var fieldErrors utils.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			return fmt.Errorf("payload does not match schema: %w", err)
+		}
+		return Retryable(err)
+	}
+	if version != nil {
+		msg.SchemaVersion = version
+	}
+	return nil
+}
+
+// transformProcessor renames, removes and sets payload fields. Renames run in
+// the order of their source paths, so every message is changed the same way.
+type transformProcessor struct {
+	rename map[string]string
+	remove []string
+	set    map[string]any
+}
+
+func (p *transformProcessor) Process(ctx context.Context, msg *PipelineMessage) error {
+	for _, from := range slices.Sorted(maps.Keys(p.rename)) {
+		if value, ok := payloadValue(msg.Payload, from); ok {
+			deletePayloadValue(msg.Payload, from)
+			setPayloadValue(msg.Payload, p.rename[from], value)
+		}
+	}
+	for _, path := range p.remove {
+		deletePayloadValue(msg.Payload, path)
+	}
+	for path, value := range p.set {
+		setPayloadValue(msg.Payload, path, value)
+	}
+	return nil
+}
+
+// storeProcessor saves the message in the tenant partition and hands it to
+// the live stream subscribers
+type storeProcessor struct {
+	repo   repositories.MessageRepository
+	usage  *UsageRecorder
+	stream *MessageStream
+}
+
+func (p *storeProcessor) Process(ctx context.Context, msg *PipelineMessage) error {
+	if msg.ID == "" {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return Retryable(err)
+		}
+		msg.ID = id.String()
+	}
+	if err := p.repo.Store(msg.NewMessageDto); err != nil {
+		return Retryable(err)
+	}
+	p.usage.Stored(msg.TenantID, msg.Size)
+
+	stored := models.Message{
+		ID:            msg.ID,
+		TenantID:      msg.TenantID,
+		Payload:       msg.Payload,
+		SchemaVersion: msg.SchemaVersion,
+		EventType:     msg.EventType,
+		Binding:       msg.Binding,
+		CreatedAt:     time.Now(),
+	}
+	if msg.BroadcastID != "" {
+		stored.BroadcastID = &msg.BroadcastID
+	}
+	p.stream.Stored(stored)
+	return nil
+}
+
+// forwardProcessor publishes a copy of the message to another tenant through
+// the topic exchange, so the bindings of that tenant apply. The copy carries
+// the hop count; a message forwarded more than maxForwardHops times, e.g.
+// between two tenants forwarding to each other, is dead-lettered.
+type forwardProcessor struct {
+	broker    Broker
+	tenantID  string
+	eventType string
+}
+
+func (p *forwardProcessor) Process(ctx context.Context, msg *PipelineMessage) error {
+	if msg.Hops >= maxForwardHops {
+		return fmt.Errorf("message was forwarded %d times, the limit is %d", msg.Hops, maxForwardHops)
+	}
+
+	eventType := p.eventType
+	if eventType == "" {
+		eventType = msg.EventType
+	}
+	if eventType == "" {
+		eventType = DefaultEventType
+	}
+
+	body, err := json.Marshal(Message{
+		TenantID:      p.tenantID,
+		EventType:     eventType,
+		Payload:       msg.Payload,
+		SchemaVersion: msg.SchemaVersion,
+	})
+	if err != nil {
+		return err
+	}
+	err = p.broker.PublishConfirmed(TopicExchange, TenantRoutingKey(p.tenantID, eventType), Publishing{
+		ContentType: "application/json",
+		Headers:     map[string]any{headerForwardHops: msg.Hops + 1},
+		Body:        body,
+	})
+	if err != nil {
+		return Retryable(fmt.Errorf("failed to forward to tenant %s: %w", p.tenantID, err))
+	}
+	return nil
+}
+
+// dropProcessor stops the pipeline for messages whose event type matches
+// eventTypes and whose payload holds every match value
+type dropProcessor struct {
+	eventTypes string
+	match      map[string]any
+}
+
+func (p *dropProcessor) Process(ctx context.Context, msg *PipelineMessage) error {
+	if p.eventTypes != "" && !topicMatch(p.eventTypes, msg.EventType) {
+		return nil
+	}
+	for path, want := range p.match {
+		value, ok := payloadValue(msg.Payload, path)
+		if !ok || !reflect.DeepEqual(value, want) {
+			return nil
+		}
+	}
+	return ErrDropMessage
+}
+
+// webhookProcessor queues the message for the tenant webhooks whose event
+// types match. It does nothing when webhooks are not enabled.
+type webhookProcessor struct {
+	webhooks *WebhookService
+}
+
+func (p *webhookProcessor) Process(ctx context.Context, msg *PipelineMessage) error {
+	if p.webhooks == nil {
+		return nil
+	}
+	return Retryable(p.webhooks.Enqueue(ctx, msg))
+}
+
+// payloadValue reads the value at a dotted payload path
+func payloadValue(payload map[string]any, path string) (any, bool) {
+	segments := strings.Split(path, ".")
+	current := payload
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := current[segment].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	value, ok := current[segments[len(segments)-1]]
+	return value, ok
+}
+
+// setPayloadValue writes value at a dotted payload path, creating the objects
+// on the way
+func setPayloadValue(payload map[string]any, path string, value any) {
+	segments := strings.Split(path, ".")
+	current := payload
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := current[segment].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			current[segment] = next
+		}
+		current = next
+	}
+	current[segments[len(segments)-1]] = value
+}
+
+// deletePayloadValue removes the value at a dotted payload path
+func deletePayloadValue(payload map[string]any, path string) {
+	segments := strings.Split(path, ".")
+	current := payload
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := current[segment].(map[string]any)
+		if !ok {
+			return
+		}
+		current = next
+	}
+	delete(current, segments[len(segments)-1])
+}
+
+// replyProcessor answers a request published with ?wait with the message as
+// processed so far. Messages nobody waits for pass through.
+type replyProcessor struct {
+	broker Broker
+}
+
+func (p *replyProcessor) Process(ctx context.Context, msg *PipelineMessage) error {
+	if msg.ReplyTo == "" {
+		return nil
+	}
+	if err := sendReply(p.broker, *msg, ""); err != nil {
+		return Retryable(fmt.Errorf("failed to reply to %s: %w", msg.CorrelationID, err))
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyMessageRepository fails the first failures stores
+type flakyMessageRepository struct {
+	recordingMessageRepository
+	failures int
+	calls    int
+}
+
+func (r *flakyMessageRepository) Store(message dto.NewMessageDto) error {
+	r.mu.Lock()
+	r.calls++
+	fail := r.calls <= r.failures
+	r.mu.Unlock()
+	if fail {
+		return errors.New("database unavailable")
+	}
+	return r.recordingMessageRepository.Store(message)
+}
+
+func TestPipelineRunsStepsInOrder(t *testing.T) {
+	source := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000d1")
+	audit := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000d2")
+	ctx := context.Background()
+
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{
+		{ID: source.String(), Pipeline: models.Pipeline{
+			{Type: StepDrop, EventTypes: "debug.#"},
+			{Type: StepDrop, Match: map[string]any{"customer.tier": "test"}},
+			{Type: StepTransform, Rename: map[string]string{"customer.email": "contact"}, Remove: []string{"secret"}, Set: map[string]any{"meta.source": "pipeline"}},
+			{Type: StepStore},
+			{Type: StepForward, TenantID: audit.String(), EventType: "audit.copy"},
+		}},
+		{ID: audit.String()},
+	}}
+	broker := NewMemoryBroker()
+	repo := &recordingMessageRepository{}
+	manager := NewTenantManager(broker, repo, tenants, newFakeBindingRepository())
+	publisher := NewPublisherService(broker)
+
+	for _, id := range []uuid.UUID{source, audit} {
+		require.NoError(t, manager.AddDefaultBinding(ctx, id))
+		require.NoError(t, manager.StartTenantConsumer(ctx, id, 1))
+		defer manager.StopTenantConsumer(id)
+	}
+
+	publish := func(eventType string, payload map[string]any) {
+		require.NoError(t, publisher.PublishEvent(Message{TenantID: source.String(), EventType: eventType, Payload: payload}))
+	}
+	publish("debug.trace", map[string]any{"n": 1})
+	publish("orders.created", map[string]any{"customer": map[string]any{"tier": "test"}})
+	publish("orders.created", map[string]any{
+		"customer": map[string]any{"tier": "gold", "email": "a@example.com"},
+		"secret":   "x",
+	})
+
+	assert.Eventually(t, func() bool { return repo.count() == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	require.Len(t, repo.stored, 2)
+	byTenant := map[string]dto.NewMessageDto{}
+	for _, message := range repo.stored {
+		byTenant[message.TenantID] = message
+	}
+
+	stored := byTenant[source.String()]
+	assert.Equal(t, "orders.created", stored.EventType)
+	assert.Equal(t, "a@example.com", stored.Payload["contact"])
+	assert.Equal(t, map[string]any{"tier": "gold"}, stored.Payload["customer"])
+	assert.NotContains(t, stored.Payload, "secret")
+	assert.Equal(t, map[string]any{"source": "pipeline"}, stored.Payload["meta"])
+
+	// The forwarded copy reaches the other tenant through its bindings
+	forwarded := byTenant[audit.String()]
+	assert.Equal(t, "audit.copy", forwarded.EventType)
+	assert.Equal(t, DefaultBindingPattern, forwarded.Binding)
+	assert.Equal(t, "a@example.com", forwarded.Payload["contact"])
+}
+
+func TestPipelineRetriesThenDeadLetters(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000d3")
+	ctx := context.Background()
+	queueName := TenantQueueName(tenantID.String())
+
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: tenantID.String(), Pipeline: models.Pipeline{
+		{Type: StepTransform, Rename: map[string]string{"a": "b"}},
+		{Type: StepStore},
+	}}}}
+	broker := NewMemoryBroker()
+	repo := &flakyMessageRepository{failures: 2}
+	manager := NewTenantManager(broker, repo, tenants, newFakeBindingRepository())
+	manager.LimitRetries(3, time.Millisecond)
+	publisher := NewPublisherService(broker)
+
+	require.NoError(t, manager.StartTenantConsumer(ctx, tenantID, 1))
+	defer manager.StopTenantConsumer(tenantID)
+
+	// Two failed stores are retried; the retry resumes at the store step with
+	// the renamed field, renaming again would lose it
+	require.NoError(t, publisher.Publish(queueName, Message{TenantID: tenantID.String(), Payload: map[string]any{"a": 1}}))
+	assert.Eventually(t, func() bool { return repo.count() == 1 }, time.Second, 5*time.Millisecond)
+	repo.mu.Lock()
+	assert.Equal(t, map[string]any{"b": float64(1)}, repo.stored[0].Payload)
+	repo.mu.Unlock()
+
+	// Out of attempts the message goes to the dead-letter queue
+	repo.mu.Lock()
+	repo.failures = repo.calls + 3
+	repo.mu.Unlock()
+	require.NoError(t, publisher.Publish(queueName, Message{TenantID: tenantID.String(), Payload: map[string]any{"a": 2}}))
+
+	// Undecodable bodies are dead-lettered right away
+	require.NoError(t, broker.Publish(DefaultExchange, queueName, Publishing{ContentEncoding: "gzip", Body: []byte("not gzip")}))
+
+	dead, err := broker.Consume(TenantDeadLetterQueueName(tenantID.String()), "dlq")
+	require.NoError(t, err)
+	first, second := receive(t, dead), receive(t, dead)
+	letters := map[string]Delivery{}
+	for _, letter := range []Delivery{first, second} {
+		letters[letter.ContentEncoding] = letter
+		letter.Ack()
+	}
+
+	failed := letters[""]
+	assert.Contains(t, failed.Headers[headerDeathReason], "store: database unavailable")
+	assert.Equal(t, 3, headerInt(failed.Headers, headerAttempts))
+	var message Message
+	require.NoError(t, json.Unmarshal(failed.Body, &message))
+	assert.Equal(t, map[string]any{"b": float64(2)}, message.Payload)
+
+	undecodable := letters["gzip"]
+	assert.Contains(t, undecodable.Headers[headerDeathReason], "decode:")
+	assert.Equal(t, []byte("not gzip"), undecodable.Body)
+	assert.Equal(t, 1, repo.count())
+}
+
+func TestPipelineRetryDoesNotHoldTheWorker(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000d4")
+	ctx := context.Background()
+	queueName := TenantQueueName(tenantID.String())
+
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: tenantID.String()}}}
+	broker := NewMemoryBroker()
+	repo := &flakyMessageRepository{failures: 1}
+	manager := NewTenantManager(broker, repo, tenants, newFakeBindingRepository())
+	manager.LimitRetries(3, 300*time.Millisecond)
+	publisher := NewPublisherService(broker)
+
+	require.NoError(t, manager.StartTenantConsumer(ctx, tenantID, 1))
+	defer manager.StopTenantConsumer(tenantID)
+
+	// The single worker stores the second message while the first one waits
+	// for its retry
+	require.NoError(t, publisher.Publish(queueName, Message{TenantID: tenantID.String(), Payload: map[string]any{"n": 1}}))
+	require.NoError(t, publisher.Publish(queueName, Message{TenantID: tenantID.String(), Payload: map[string]any{"n": 2}}))
+	assert.Eventually(t, func() bool { return repo.count() == 1 }, 150*time.Millisecond, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return repo.count() == 2 }, time.Second, 5*time.Millisecond)
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	assert.Equal(t, map[string]any{"n": float64(2)}, repo.stored[0].Payload)
+	assert.Equal(t, map[string]any{"n": float64(1)}, repo.stored[1].Payload)
+}
+
+func TestPublisherDropsPipelineRetryHeaders(t *testing.T) {
+	broker := NewMemoryBroker()
+	require.NoError(t, broker.DeclareQueue("q", models.QueueOptions{}))
+	deliveries, err := broker.Consume("q", "c1")
+	require.NoError(t, err)
+
+	publisher := NewPublisherService(broker)
+	require.NoError(t, publisher.PublishWithHeaders("q", Message{Payload: map[string]any{"n": 1}}, map[string]any{
+		headerPipelineStep: 3,
+		headerAttempts:     9,
+		headerForwardHops:  2,
+		HeaderReplay:       "job-1",
+	}))
+	msg := receive(t, deliveries)
+	assert.Equal(t, map[string]any{HeaderReplay: "job-1"}, msg.Headers)
+	require.NoError(t, msg.Ack())
+}
+
+func TestTransformRenamesInSourceOrder(t *testing.T) {
+	transform := &transformProcessor{rename: map[string]string{"b": "target", "a": "target"}}
+	for range 20 {
+		msg := &PipelineMessage{}
+		msg.Payload = map[string]any{"a": 1, "b": 2}
+		require.NoError(t, transform.Process(context.Background(), msg))
+		// "b" is renamed last and wins every time
+		assert.Equal(t, map[string]any{"target": 2}, msg.Payload)
+	}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	tenantID := "0190d8a4-0000-7000-8000-0000000000d0"
+	assert.NoError(t, ValidatePipeline(tenantID, nil))
+	assert.NoError(t, ValidatePipeline(tenantID, models.Pipeline{
+		{Type: StepValidate},
+		{Type: StepDrop},
+		{Type: StepTransform, Set: map[string]any{"a.b": 1}},
+		{Type: StepStore},
+		{Type: StepForward, TenantID: "0190d8a4-0000-7000-8000-0000000000d1"},
+	}))
+
+	for _, steps := range []models.Pipeline{
+		{{Type: "enrich"}},
+		{{Type: StepTransform}},
+		{{Type: StepTransform, Remove: []string{"a..b"}}},
+		{{Type: StepTransform, Rename: map[string]string{"a": "b", "b": "a"}}},
+		{{Type: StepForward, TenantID: "not-a-uuid"}},
+		{{Type: StepForward, TenantID: tenantID}},
+		{{Type: StepForward, TenantID: "0190d8a4-0000-7000-8000-0000000000d1", EventType: "a.*"}},
+		{{Type: StepDrop, EventTypes: "a..b"}},
+	} {
+		err := ValidatePipeline(tenantID, steps)
+		var fiberErr *fiber.Error
+		require.ErrorAs(t, err, &fiberErr, "%+v", steps)
+		assert.Equal(t, fiber.StatusBadRequest, fiberErr.Code)
+	}
+
+	err := ValidatePipeline(tenantID, models.Pipeline{{Type: "enrich"}})
+	assert.EqualError(t, err, "step 1: type must be validate, transform, store, forward, drop, webhook or reply")
+
+	// The validate step needs the schema service
+	manager := NewTenantManager(NewMemoryBroker(), &recordingMessageRepository{}, &fakeTenantRepository{}, newFakeBindingRepository())
+	_, err = manager.buildPipeline(tenantID, models.Pipeline{{Type: StepValidate}})
+	assert.Error(t, err)
+
+	// Tenants forwarding to each other pass validation, the hop count stops the loop
+	broker := NewMemoryBroker()
+	require.NoError(t, broker.DeclareQueue("forwarded", models.QueueOptions{}))
+	require.NoError(t, broker.BindQueue("forwarded", TopicExchange, "#"))
+	deliveries, err := broker.Consume("forwarded", "c1")
+	require.NoError(t, err)
+
+	forward := &forwardProcessor{broker: broker, tenantID: "0190d8a4-0000-7000-8000-0000000000d1"}
+	msg := &PipelineMessage{Hops: 2}
+	msg.Payload = map[string]any{"n": 1}
+	require.NoError(t, forward.Process(context.Background(), msg))
+	delivery := receive(t, deliveries)
+	assert.Equal(t, 3, headerInt(delivery.Headers, headerForwardHops))
+	require.NoError(t, delivery.Ack())
+
+	msg.Hops = maxForwardHops
+	err = forward.Process(context.Background(), msg)
+	require.Error(t, err)
+	var retryable *RetryableError
+	assert.False(t, errors.As(err, &retryable), "a message over the hop limit is dead-lettered")
+}
@@ -0,0 +1,514 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pgClaimBatch is how many messages a consumer claims per query
+const pgClaimBatch = 20
+
+// DefaultQueueMaxDeliveries is how often the Postgres broker delivers a
+// message before it moves it to the dead-letter queue
+const DefaultQueueMaxDeliveries = 10
+
+var _ Broker = (*PostgresBroker)(nil)
+
+// PostgresBroker keeps queues in Postgres tables, for deployments without
+// RabbitMQ. Consumers claim messages with FOR UPDATE SKIP LOCKED and are woken
+// by LISTEN/NOTIFY; they also poll, so a lost notification or an expired lock
+// only delays delivery. Locks of claimed messages are extended until they are
+// acked, so a slow handler does not get its message delivered twice; a message
+// delivered more than maxDeliveries times (e.g. it keeps crashing the process)
+// is moved to the dead-letter queue.
+type PostgresBroker struct {
+	queues        repositories.QueueRepository
+	pollInterval  time.Duration
+	lockFor       time.Duration
+	maxDeliveries int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	consumers map[string]*pgConsumer
+	// expiring holds the expiry of the queues declared with one here
+	expiring map[string]time.Duration
+}
+
+type pgConsumer struct {
+	queueName string
+	wake      chan struct{}
+	stop      chan struct{}
+
+	// inFlight holds the claimed messages not yet acked or released
+	mu       sync.Mutex
+	inFlight map[int64]bool
+}
+
+func (c *pgConsumer) track(messages []models.QueueMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, message := range messages {
+		c.inFlight[message.ID] = true
+	}
+}
+
+func (c *pgConsumer) done(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inFlight, id)
+}
+
+func (c *pgConsumer) claimed() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]int64, 0, len(c.inFlight))
+	for id := range c.inFlight {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// NewPostgresBroker constructor. A claimed message that is not acked within
+// lockFor is delivered again.
+func NewPostgresBroker(queues repositories.QueueRepository, pollInterval, lockFor time.Duration) *PostgresBroker {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &PostgresBroker{
+		queues:        queues,
+		pollInterval:  pollInterval,
+		lockFor:       lockFor,
+		maxDeliveries: DefaultQueueMaxDeliveries,
+		ctx:           ctx,
+		cancel:        cancel,
+		consumers:     make(map[string]*pgConsumer),
+		expiring:      make(map[string]time.Duration),
+	}
+	go b.sweepExpired()
+	return b
+}
+
+// LimitDeliveries sets how often a message is delivered before it is moved to
+// the dead-letter queue
+func (b *PostgresBroker) LimitDeliveries(maxDeliveries int) {
+	if maxDeliveries > 0 {
+		b.maxDeliveries = maxDeliveries
+	}
+}
+
+// Listen wakes consumers on NOTIFY, using a dedicated connection to dsn.
+// Without it consumers only poll.
+func (b *PostgresBroker) Listen(dsn string) {
+	go func() {
+		for {
+			err := b.listen(dsn)
+			if b.ctx.Err() != nil {
+				return
+			}
+			log.Printf("[PostgresBroker] Listener failed, retrying: %v", err)
+
+			select {
+			case <-time.After(5 * time.Second):
+			case <-b.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (b *PostgresBroker) listen(dsn string) error {
+	conn, err := pgx.Connect(b.ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(b.ctx, "LISTEN "+repositories.QueueNotifyChannel); err != nil {
+		return err
+	}
+	log.Println("[PostgresBroker] Listening for queue notifications")
+
+	// Messages published while we were not listening
+	b.wake("")
+
+	for {
+		notification, err := conn.WaitForNotification(b.ctx)
+		if err != nil {
+			return err
+		}
+		b.wake(notification.Payload)
+	}
+}
+
+// wake signals the consumers of a queue, or of every queue when queueName is empty
+func (b *PostgresBroker) wake(queueName string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, consumer := range b.consumers {
+		if queueName != "" && consumer.queueName != queueName {
+			continue
+		}
+		select {
+		case consumer.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// CheckQueueOptions refuses every queue option, there are no queue arguments
+func (b *PostgresBroker) CheckQueueOptions(options models.QueueOptions) error {
+	return unsupportedQueueOptions("postgres", options)
+}
+
+// DeclareQueue implements Broker; queue options other than Expires are refused
+func (b *PostgresBroker) DeclareQueue(queueName string, options models.QueueOptions) error {
+	if err := b.CheckQueueOptions(options); err != nil {
+		return err
+	}
+	if err := b.queues.Declare(b.ctx, queueName, options.Expires); err != nil {
+		return err
+	}
+
+	if options.Expires > 0 {
+		b.mu.Lock()
+		b.expiring[queueName] = options.Expires
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// DeleteQueue implements Broker
+func (b *PostgresBroker) DeleteQueue(queueName string) error {
+	if err := b.queues.Delete(b.ctx, queueName); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for tag, consumer := range b.consumers {
+		if consumer.queueName == queueName {
+			b.cancelConsumer(tag, consumer)
+		}
+	}
+	delete(b.expiring, queueName)
+	log.Printf("[PostgresBroker] Queue %s deleted successfully.", queueName)
+	return nil
+}
+
+// BindQueue implements Broker
+func (b *PostgresBroker) BindQueue(queueName, exchange, pattern string) error {
+	if !bindingExchange(exchange) {
+		return errUnknownExchange(exchange)
+	}
+	// The empty expression matches every routing key
+	regex := ""
+	if exchange == TopicExchange {
+		regex = TopicRegexp(pattern)
+	}
+	return b.queues.Bind(b.ctx, models.QueueBinding{
+		QueueName: queueName,
+		Exchange:  exchange,
+		Pattern:   pattern,
+		Regex:     regex,
+	})
+}
+
+// UnbindQueue implements Broker
+func (b *PostgresBroker) UnbindQueue(queueName, exchange, pattern string) error {
+	if !bindingExchange(exchange) {
+		return errUnknownExchange(exchange)
+	}
+	return b.queues.Unbind(b.ctx, models.QueueBinding{QueueName: queueName, Exchange: exchange, Pattern: pattern})
+}
+
+// ListBindings implements BindingLister
+func (b *PostgresBroker) ListBindings(ctx context.Context, queueName, exchange string) ([]string, error) {
+	return b.queues.Bindings(ctx, queueName, exchange)
+}
+
+// Publish implements Broker
+func (b *PostgresBroker) Publish(exchange, routingKey string, msg Publishing) error {
+	message := &models.QueueMessage{
+		QueueName:       routingKey,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		MessageID:       msg.MessageID,
+		Headers:         models.JSONB(msg.Headers),
+		Body:            msg.Body,
+		ReplyTo:         msg.ReplyTo,
+		CorrelationID:   msg.CorrelationID,
+	}
+
+	switch exchange {
+	case DefaultExchange:
+		_, err := b.queues.Enqueue(b.ctx, message)
+		return err
+	case TopicExchange, FanoutExchange:
+		_, err := b.queues.Route(b.ctx, exchange, routingKey, message)
+		return err
+	default:
+		return errUnknownExchange(exchange)
+	}
+}
+
+// PublishConfirmed implements Broker; the message is committed once Publish returns
+func (b *PostgresBroker) PublishConfirmed(exchange, routingKey string, msg Publishing) error {
+	return b.Publish(exchange, routingKey, msg)
+}
+
+// PublishDelayed implements Broker. The row is hidden from Claim until its
+// available_at; local consumers are woken then, others find it on their
+// next poll.
+func (b *PostgresBroker) PublishDelayed(queueName string, msg Publishing, delay time.Duration) error {
+	availableAt := time.Now().Add(delay)
+	message := &models.QueueMessage{
+		QueueName:       queueName,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		MessageID:       msg.MessageID,
+		Headers:         models.JSONB(msg.Headers),
+		Body:            msg.Body,
+		ReplyTo:         msg.ReplyTo,
+		CorrelationID:   msg.CorrelationID,
+		AvailableAt:     &availableAt,
+	}
+	if _, err := b.queues.Enqueue(b.ctx, message); err != nil {
+		return err
+	}
+	time.AfterFunc(delay, func() { b.wake(queueName) })
+	return nil
+}
+
+// Consume implements Broker
+func (b *PostgresBroker) Consume(queueName, consumerTag string) (<-chan Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ctx.Err() != nil {
+		return nil, fmt.Errorf("failed to consume messages: broker closed")
+	}
+	if _, exists := b.consumers[consumerTag]; exists {
+		return nil, fmt.Errorf("failed to consume messages: consumer %s already exists", consumerTag)
+	}
+
+	consumer := &pgConsumer{
+		queueName: queueName,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		inFlight:  make(map[int64]bool),
+	}
+	b.consumers[consumerTag] = consumer
+
+	deliveries := make(chan Delivery)
+	go b.consume(consumer, deliveries)
+	go b.keepLocked(consumer)
+	if expires := b.expiring[queueName]; expires > 0 {
+		go b.keepAlive(consumer, expires)
+	}
+	return deliveries, nil
+}
+
+// keepAlive pushes the expiry of the consumer's queue back well before it is
+// reached, until the consumer is cancelled
+func (b *PostgresBroker) keepAlive(consumer *pgConsumer, expires time.Duration) {
+	ticker := time.NewTicker(expires / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.queues.Touch(b.ctx, consumer.queueName, expires); err != nil && b.ctx.Err() == nil {
+				log.Printf("[PostgresBroker] %s: %v", consumer.queueName, err)
+			}
+		case <-consumer.stop:
+			return
+		}
+	}
+}
+
+// sweepExpired deletes the expired queues every queueExpirySweep until the
+// broker is closed. Every instance sweeps, so the queues of an instance that
+// died are deleted too.
+func (b *PostgresBroker) sweepExpired() {
+	ticker := time.NewTicker(queueExpirySweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.deleteExpired()
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *PostgresBroker) deleteExpired() {
+	names, err := b.queues.DeleteExpired(b.ctx)
+	if err != nil {
+		if b.ctx.Err() == nil {
+			log.Printf("[PostgresBroker] %v", err)
+		}
+		return
+	}
+	for _, name := range names {
+		log.Printf("[PostgresBroker] Queue %s expired and was deleted.", name)
+	}
+}
+
+// keepLocked extends the locks of the consumer's claimed messages well before
+// they expire, until the consumer is cancelled
+func (b *PostgresBroker) keepLocked(consumer *pgConsumer) {
+	ticker := time.NewTicker(b.lockFor / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.queues.Extend(b.ctx, consumer.claimed(), b.lockFor); err != nil && b.ctx.Err() == nil {
+				log.Printf("[PostgresBroker] %s: %v", consumer.queueName, err)
+			}
+		case <-consumer.stop:
+			return
+		}
+	}
+}
+
+// consume claims batches of messages and hands them out until the consumer is
+// cancelled. Claimed messages that were not handed out are released.
+func (b *PostgresBroker) consume(consumer *pgConsumer, deliveries chan<- Delivery) {
+	defer close(deliveries)
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		claimed, err := b.queues.Claim(b.ctx, consumer.queueName, pgClaimBatch, b.lockFor)
+		if err != nil && b.ctx.Err() == nil {
+			log.Printf("[PostgresBroker] %s: %v", consumer.queueName, err)
+		}
+		messages := b.deadLetterExhausted(consumer.queueName, claimed)
+		consumer.track(messages)
+
+		for i, message := range messages {
+			select {
+			case deliveries <- b.delivery(consumer, message):
+			case <-consumer.stop:
+				b.release(consumer, messages[i:])
+				return
+			}
+		}
+
+		// A full batch means more messages are probably waiting
+		if len(claimed) == pgClaimBatch {
+			continue
+		}
+
+		select {
+		case <-consumer.wake:
+		case <-ticker.C:
+		case <-consumer.stop:
+			return
+		}
+	}
+}
+
+// deadLetterExhausted moves the messages delivered more than maxDeliveries
+// times to the dead-letter queue and returns the others
+func (b *PostgresBroker) deadLetterExhausted(queueName string, messages []models.QueueMessage) []models.QueueMessage {
+	deliverable := messages[:0]
+	for _, message := range messages {
+		if message.Deliveries <= b.maxDeliveries {
+			deliverable = append(deliverable, message)
+			continue
+		}
+
+		deadLetterQueue := DeadLetterQueueName(queueName)
+		reason := fmt.Sprintf("delivered %d times without an ack", message.Deliveries-1)
+		if err := b.queues.DeadLetter(b.ctx, message.ID, deadLetterQueue, reason); err != nil {
+			log.Printf("[PostgresBroker] %s: %v", queueName, err)
+			continue
+		}
+		log.Printf("[PostgresBroker] Message %d of %s moved to %s: %s", message.ID, queueName, deadLetterQueue, reason)
+	}
+	return deliverable
+}
+
+func (b *PostgresBroker) delivery(consumer *pgConsumer, message models.QueueMessage) Delivery {
+	return Delivery{
+		Publishing: Publishing{
+			ContentType:     message.ContentType,
+			ContentEncoding: message.ContentEncoding,
+			MessageID:       message.MessageID,
+			Headers:         map[string]any(message.Headers),
+			Body:            message.Body,
+			ReplyTo:         message.ReplyTo,
+			CorrelationID:   message.CorrelationID,
+		},
+		Redelivered: message.Deliveries > 1,
+		ack: func() error {
+			consumer.done(message.ID)
+			return b.queues.Ack(context.Background(), message.ID)
+		},
+		nack: func(requeue bool) error {
+			consumer.done(message.ID)
+			if requeue {
+				return b.queues.Release(context.Background(), message.ID)
+			}
+			return b.queues.Ack(context.Background(), message.ID)
+		},
+	}
+}
+
+func (b *PostgresBroker) release(consumer *pgConsumer, messages []models.QueueMessage) {
+	for _, message := range messages {
+		consumer.done(message.ID)
+		if err := b.queues.Release(context.Background(), message.ID); err != nil {
+			log.Printf("[PostgresBroker] %v", err)
+		}
+	}
+}
+
+// Cancel implements Broker
+func (b *PostgresBroker) Cancel(consumerTag string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	consumer, ok := b.consumers[consumerTag]
+	if !ok {
+		return fmt.Errorf("failed to cancel consumer %s: not found", consumerTag)
+	}
+	b.cancelConsumer(consumerTag, consumer)
+	return nil
+}
+
+// cancelConsumer stops a consumer; b.mu must be held
+func (b *PostgresBroker) cancelConsumer(consumerTag string, consumer *pgConsumer) {
+	close(consumer.stop)
+	delete(b.consumers, consumerTag)
+}
+
+// Close implements Broker; consumers are cancelled and the listener stops
+func (b *PostgresBroker) Close() error {
+	b.mu.Lock()
+	for tag, consumer := range b.consumers {
+		b.cancelConsumer(tag, consumer)
+	}
+	b.mu.Unlock()
+
+	b.cancel()
+	return nil
+}
+
+// ListQueues implements QueueLister
+func (b *PostgresBroker) ListQueues(ctx context.Context) ([]string, error) {
+	return b.queues.List(ctx)
+}
@@ -0,0 +1,402 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeQueueRepository keeps the queue tables in memory
+type fakeQueueRepository struct {
+	mu       sync.Mutex
+	queues   map[string]bool
+	bindings map[models.QueueBinding]bool
+	messages map[int64]*models.QueueMessage
+	nextID   int64
+	expires  map[string]time.Time
+}
+
+func newFakeQueueRepository() *fakeQueueRepository {
+	return &fakeQueueRepository{
+		queues:   map[string]bool{},
+		bindings: map[models.QueueBinding]bool{},
+		messages: map[int64]*models.QueueMessage{},
+		expires:  map[string]time.Time{},
+	}
+}
+
+func (r *fakeQueueRepository) Declare(ctx context.Context, queueName string, expires time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queues[queueName] = true
+	if expires > 0 {
+		r.expires[queueName] = time.Now().Add(expires)
+	}
+	return nil
+}
+
+func (r *fakeQueueRepository) Touch(ctx context.Context, queueName string, expires time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.expires[queueName]; ok {
+		r.expires[queueName] = time.Now().Add(expires)
+	}
+	return nil
+}
+
+func (r *fakeQueueRepository) DeleteExpired(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	var names []string
+	for name, expiresAt := range r.expires {
+		if expiresAt.Before(time.Now()) {
+			names = append(names, name)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, name := range names {
+		r.Delete(ctx, name)
+	}
+	return names, nil
+}
+
+func (r *fakeQueueRepository) Delete(ctx context.Context, queueName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.queues, queueName)
+	delete(r.expires, queueName)
+	for binding := range r.bindings {
+		if binding.QueueName == queueName {
+			delete(r.bindings, binding)
+		}
+	}
+	for id, message := range r.messages {
+		if message.QueueName == queueName {
+			delete(r.messages, id)
+		}
+	}
+	return nil
+}
+
+func (r *fakeQueueRepository) List(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return sortedKeys(r.queues), nil
+}
+
+func (r *fakeQueueRepository) Bind(ctx context.Context, binding models.QueueBinding) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bindings[binding] = true
+	return nil
+}
+
+func (r *fakeQueueRepository) Unbind(ctx context.Context, binding models.QueueBinding) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for bound := range r.bindings {
+		if bound.QueueName == binding.QueueName && bound.Exchange == binding.Exchange && bound.Pattern == binding.Pattern {
+			delete(r.bindings, bound)
+		}
+	}
+	return nil
+}
+
+func (r *fakeQueueRepository) Bindings(ctx context.Context, queueName, exchange string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var patterns []string
+	for binding := range r.bindings {
+		if binding.QueueName == queueName && binding.Exchange == exchange {
+			patterns = append(patterns, binding.Pattern)
+		}
+	}
+	sort.Strings(patterns)
+	return patterns, nil
+}
+
+func (r *fakeQueueRepository) Enqueue(ctx context.Context, message *models.QueueMessage) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enqueue(message), nil
+}
+
+func (r *fakeQueueRepository) Route(ctx context.Context, exchange, routingKey string, message *models.QueueMessage) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := map[string]bool{}
+	for binding := range r.bindings {
+		if binding.Exchange == exchange && regexp.MustCompile(binding.Regex).MatchString("."+routingKey) {
+			matched[binding.QueueName] = true
+		}
+	}
+	for _, queueName := range sortedKeys(matched) {
+		routed := *message
+		routed.QueueName = queueName
+		r.enqueue(&routed)
+	}
+	return int64(len(matched)), nil
+}
+
+func (r *fakeQueueRepository) enqueue(message *models.QueueMessage) bool {
+	if !r.queues[message.QueueName] {
+		return false
+	}
+	r.nextID++
+	stored := *message
+	stored.ID = r.nextID
+	r.messages[stored.ID] = &stored
+	return true
+}
+
+func (r *fakeQueueRepository) Claim(ctx context.Context, queueName string, limit int, lockFor time.Duration) ([]models.QueueMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var claimed []models.QueueMessage
+	for _, message := range r.messages {
+		if message.QueueName != queueName || (message.LockedUntil != nil && message.LockedUntil.After(now)) ||
+			(message.AvailableAt != nil && message.AvailableAt.After(now)) {
+			continue
+		}
+		until := now.Add(lockFor)
+		message.LockedUntil = &until
+		message.Deliveries++
+		claimed = append(claimed, *message)
+	}
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].ID < claimed[j].ID })
+	if len(claimed) > limit {
+		for _, message := range claimed[limit:] {
+			r.messages[message.ID].LockedUntil = nil
+			r.messages[message.ID].Deliveries--
+		}
+		claimed = claimed[:limit]
+	}
+	return claimed, nil
+}
+
+func (r *fakeQueueRepository) Ack(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.messages, id)
+	return nil
+}
+
+func (r *fakeQueueRepository) Release(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if message, ok := r.messages[id]; ok {
+		message.LockedUntil = nil
+	}
+	return nil
+}
+
+func (r *fakeQueueRepository) Extend(ctx context.Context, ids []int64, lockFor time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	until := time.Now().Add(lockFor)
+	for _, id := range ids {
+		if message, ok := r.messages[id]; ok && message.LockedUntil != nil {
+			message.LockedUntil = &until
+		}
+	}
+	return nil
+}
+
+func (r *fakeQueueRepository) DeadLetter(ctx context.Context, id int64, deadLetterQueue, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queues[deadLetterQueue] = true
+	if message, ok := r.messages[id]; ok {
+		message.QueueName = deadLetterQueue
+		message.LockedUntil = nil
+		message.Deliveries = 0
+		message.Headers = models.JSONB{"x-death-reason": reason}
+	}
+	return nil
+}
+
+func (r *fakeQueueRepository) locked() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	locked := 0
+	for _, message := range r.messages {
+		if message.LockedUntil != nil {
+			locked++
+		}
+	}
+	return locked
+}
+
+func (r *fakeQueueRepository) pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages)
+}
+
+func TestPostgresBrokerDeliversAndRedelivers(t *testing.T) {
+	repo := newFakeQueueRepository()
+	// Long poll interval: deliveries after the first claim rely on wake-ups
+	b := NewPostgresBroker(repo, time.Hour, time.Minute)
+	defer b.Close()
+
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+	require.NoError(t, b.Publish(DefaultExchange, "missing", Publishing{Body: []byte("lost")}))
+	assert.Zero(t, repo.pending())
+
+	deliveries, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{Body: []byte("1"), Headers: map[string]any{"k": "v"}}))
+	b.wake("q")
+	first := receive(t, deliveries)
+	assert.Equal(t, "1", string(first.Body))
+	assert.Equal(t, "v", first.Headers["k"])
+	assert.False(t, first.Redelivered)
+
+	require.NoError(t, first.Nack(true))
+	b.wake("q")
+	again := receive(t, deliveries)
+	assert.Equal(t, "1", string(again.Body))
+	assert.True(t, again.Redelivered)
+	require.NoError(t, again.Ack())
+	assert.Zero(t, repo.pending())
+
+	queues, err := b.ListQueues(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"q"}, queues)
+
+	require.NoError(t, b.DeleteQueue("q"))
+	_, ok := <-deliveries
+	assert.False(t, ok)
+}
+
+func TestPostgresBrokerDelaysMessages(t *testing.T) {
+	repo := newFakeQueueRepository()
+	b := NewPostgresBroker(repo, time.Hour, time.Minute)
+	defer b.Close()
+
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+	deliveries, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, b.PublishDelayed("q", Publishing{Body: []byte("later")}, 100*time.Millisecond))
+	require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{Body: []byte("now")}))
+
+	assert.Equal(t, "now", string(receive(t, deliveries).Body))
+	later := receive(t, deliveries)
+	assert.Equal(t, "later", string(later.Body))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	require.NoError(t, later.Ack())
+}
+
+func TestPostgresBrokerDeletesExpiredQueues(t *testing.T) {
+	repo := newFakeQueueRepository()
+	b := NewPostgresBroker(repo, time.Hour, time.Minute)
+	defer b.Close()
+
+	expires := models.QueueOptions{Expires: 60 * time.Millisecond}
+	require.NoError(t, b.DeclareQueue("consumed", expires))
+	require.NoError(t, b.DeclareQueue("abandoned", expires))
+	require.NoError(t, b.DeclareQueue("durable", models.QueueOptions{}))
+	_, err := b.Consume("consumed", "c1")
+	require.NoError(t, err)
+
+	// The consumer keeps its queue alive past the expiry
+	time.Sleep(150 * time.Millisecond)
+	b.deleteExpired()
+	queues, err := b.ListQueues(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"consumed", "durable"}, queues)
+
+	require.NoError(t, b.Cancel("c1"))
+	time.Sleep(150 * time.Millisecond)
+	b.deleteExpired()
+	queues, err = b.ListQueues(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"durable"}, queues)
+}
+
+func TestPostgresBrokerCancelReleasesClaimedMessages(t *testing.T) {
+	repo := newFakeQueueRepository()
+	b := NewPostgresBroker(repo, time.Hour, time.Minute)
+	defer b.Close()
+
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+	for range 3 {
+		require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{Body: []byte("m")}))
+	}
+
+	deliveries, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+	receive(t, deliveries).Ack()
+
+	// The other two were claimed but never handed out
+	require.NoError(t, b.Cancel("c1"))
+	assert.Eventually(t, func() bool { return repo.locked() == 0 }, time.Second, 5*time.Millisecond)
+
+	claimed, err := repo.Claim(context.Background(), "q", 10, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 2)
+}
+
+func TestPostgresBrokerKeepsSlowMessagesLocked(t *testing.T) {
+	repo := newFakeQueueRepository()
+	// Polls far more often than the lock lasts
+	b := NewPostgresBroker(repo, 5*time.Millisecond, 30*time.Millisecond)
+	defer b.Close()
+
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+	require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{Body: []byte("slow")}))
+	deliveries, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+	slow := receive(t, deliveries)
+
+	// Held for several lock periods without being delivered again
+	select {
+	case again := <-deliveries:
+		t.Fatalf("delivered twice: %s", again.Body)
+	case <-time.After(150 * time.Millisecond):
+	}
+	require.NoError(t, slow.Ack())
+	assert.Zero(t, repo.pending())
+}
+
+func TestPostgresBrokerDeadLettersAfterMaxDeliveries(t *testing.T) {
+	repo := newFakeQueueRepository()
+	b := NewPostgresBroker(repo, 5*time.Millisecond, time.Minute)
+	b.LimitDeliveries(3)
+	defer b.Close()
+
+	queue := TenantQueueName("0190d8a4-0000-7000-8000-0000000000e1")
+	require.NoError(t, b.DeclareQueue(queue, models.QueueOptions{}))
+	require.NoError(t, b.Publish(DefaultExchange, queue, Publishing{Body: []byte("poison")}))
+	deliveries, err := b.Consume(queue, "c1")
+	require.NoError(t, err)
+
+	for range 3 {
+		require.NoError(t, receive(t, deliveries).Nack(true))
+	}
+	select {
+	case again := <-deliveries:
+		t.Fatalf("delivered after the limit: %s", again.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	dlq := TenantDeadLetterQueueName("0190d8a4-0000-7000-8000-0000000000e1")
+	dead, err := repo.Claim(context.Background(), dlq, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "poison", string(dead[0].Body))
+	assert.Equal(t, "delivered 3 times without an ack", dead[0].Headers["x-death-reason"])
+}
@@ -1,40 +1,190 @@
 package services
 
 import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
 	"encoding/json"
+	"fmt"
 
-	"github.com/streadway/amqp"
+	"github.com/gofiber/fiber/v2"
 )
 
+// HeaderOrderingKey carries the ordering key of a message; the tenant consumer
+// handles messages with the same key one at a time, in queue order
+const HeaderOrderingKey = "x-ordering-key"
+
+// MaxOrderingKeyLength is the longest ordering key accepted on publish
+const MaxOrderingKeyLength = 256
+
 type Message struct {
-	TenantID string `json:"tenant_id"`
-	Payload  any    `json:"payload"`
+	TenantID      string `json:"tenant_id"`
+	EventType     string `json:"event_type,omitempty"`
+	Payload       any    `json:"payload"`
+	SchemaVersion *int   `json:"schema_version,omitempty"`
+	BroadcastID   string `json:"broadcast_id,omitempty"`
+	// OrderingKey is sent in the x-ordering-key header
+	OrderingKey string `json:"-"`
+
+	// recipients are the tenants receiving a broadcast without a tenant ID,
+	// usage is counted for each of them
+	recipients []string
 }
 
 type PublisherService struct {
-	rabbit *RabbitMQ
+	broker          Broker
+	outbox          repositories.OutboxRepository
+	compressor      *PayloadCompressor
+	maxPayloadBytes int
+	usage           *UsageRecorder
 }
 
-func NewPublisherService(rabbit *RabbitMQ) *PublisherService {
+func NewPublisherService(broker Broker) *PublisherService {
 	return &PublisherService{
-		rabbit: rabbit,
+		broker: broker,
 	}
 }
 
+// EnableOutbox makes Publish write to the outbox table instead of the broker.
+// An OutboxRelay must be running to deliver the stored messages.
+func (s *PublisherService) EnableOutbox(outbox repositories.OutboxRepository) {
+	s.outbox = outbox
+}
+
+// EnableCompression compresses bodies above the compressor threshold and sets
+// ContentEncoding so consumers can decompress them
+func (s *PublisherService) EnableCompression(compressor *PayloadCompressor) {
+	s.compressor = compressor
+}
+
+// LimitPayloadSize rejects messages whose encoded body exceeds maxBytes with 413
+func (s *PublisherService) LimitPayloadSize(maxBytes int) {
+	s.maxPayloadBytes = maxBytes
+}
+
+// EnableUsage counts published messages and their uncompressed size per tenant
+func (s *PublisherService) EnableUsage(usage *UsageRecorder) {
+	s.usage = usage
+}
+
 func (s *PublisherService) Publish(queueName string, msg Message) error {
+	return s.PublishWithHeaders(queueName, msg, nil)
+}
+
+// PublishWithHeaders publishes msg with additional headers, e.g. x-replay
+func (s *PublisherService) PublishWithHeaders(queueName string, msg Message, headers map[string]any) error {
+	return s.publish(DefaultExchange, queueName, msg, Publishing{Headers: headers})
+}
+
+// PublishEvent publishes msg to the topic exchange with the routing key
+// "<tenant_id>.<event_type>"; it reaches the tenant queue only when one of the
+// tenant's bindings matches
+func (s *PublisherService) PublishEvent(msg Message) error {
+	if msg.EventType == "" {
+		msg.EventType = DefaultEventType
+	}
+	if !ValidRoutingKey(msg.EventType) {
+		return fiber.NewError(fiber.StatusBadRequest, "event_type must be dot separated words of letters, digits, _ or -")
+	}
+	return s.publish(TopicExchange, TenantRoutingKey(msg.TenantID, msg.EventType), msg, Publishing{})
+}
+
+// PublishRequest publishes msg like PublishEvent, asking the tenant pipeline to
+// answer on the replyTo queue with correlationID. Requests skip the outbox: the
+// caller is waiting for the reply.
+func (s *PublisherService) PublishRequest(msg Message, replyTo, correlationID string) error {
+	if msg.EventType == "" {
+		msg.EventType = DefaultEventType
+	}
+	if !ValidRoutingKey(msg.EventType) {
+		return fiber.NewError(fiber.StatusBadRequest, "event_type must be dot separated words of letters, digits, _ or -")
+	}
+	return s.publish(TopicExchange, TenantRoutingKey(msg.TenantID, msg.EventType), msg, Publishing{
+		ReplyTo:       replyTo,
+		CorrelationID: correlationID,
+	})
+}
+
+// PublishBroadcast publishes msg once to the fanout exchange, every tenant
+// queue receives a copy. tenantIDs are the receiving tenants, the publish is
+// counted in the usage of each.
+func (s *PublisherService) PublishBroadcast(msg Message, tenantIDs []string) error {
+	msg.recipients = tenantIDs
+	return s.publish(FanoutExchange, "", msg, Publishing{})
+}
+
+// publish encodes msg into the body of publishing, which carries the headers
+// and reply address. The pipeline retry headers are dropped: only a retry
+// published by the tenant consumer may skip steps or spend attempts.
+func (s *PublisherService) publish(exchange, routingKey string, msg Message, publishing Publishing) error {
 	body, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
-	return s.rabbit.Channel().Publish(
-		"",        // exchange
-		queueName, // routing key
-		false,     // mandatory
-		false,     // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
-	)
+	if len(msg.OrderingKey) > MaxOrderingKeyLength {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("ordering_key cannot be longer than %d bytes", MaxOrderingKeyLength))
+	}
+	if msg.OrderingKey != "" || len(publishing.Headers) > 0 {
+		headers := make(map[string]any, len(publishing.Headers)+1)
+		for key, value := range publishing.Headers {
+			if key != headerPipelineStep && key != headerAttempts && key != headerForwardHops {
+				headers[key] = value
+			}
+		}
+		if msg.OrderingKey != "" {
+			headers[HeaderOrderingKey] = msg.OrderingKey
+		}
+		publishing.Headers = headers
+	}
+
+	if s.maxPayloadBytes > 0 && len(body) > s.maxPayloadBytes {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge,
+			fmt.Sprintf("message is %d bytes, the limit is %d bytes", len(body), s.maxPayloadBytes))
+	}
+
+	// The outbox relay compresses when it publishes
+	if s.outbox != nil && publishing.ReplyTo == "" {
+		if err := s.enqueue(exchange, routingKey, body, publishing.Headers); err != nil {
+			return err
+		}
+		s.countPublished(msg, len(body))
+		return nil
+	}
+
+	size := len(body)
+	body, encoding, err := s.compressor.Compress(body)
+	if err != nil {
+		return err
+	}
+
+	publishing.ContentType = "application/json"
+	publishing.ContentEncoding = encoding
+	publishing.Body = body
+	// Wait for the broker confirm, a nacked message fails the request
+	if err := s.broker.PublishConfirmed(exchange, routingKey, publishing); err != nil {
+		return err
+	}
+
+	s.countPublished(msg, size)
+	return nil
+}
+
+func (s *PublisherService) countPublished(msg Message, size int) {
+	if msg.TenantID != "" {
+		s.usage.Published(msg.TenantID, size)
+		return
+	}
+	for _, tenantID := range msg.recipients {
+		s.usage.Published(tenantID, size)
+	}
+}
+
+func (s *PublisherService) enqueue(exchange, routingKey string, raw []byte, headers map[string]any) error {
+	var body models.JSONB
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return err
+	}
+
+	return s.outbox.Enqueue(context.Background(), exchange, routingKey, body, models.JSONB(headers))
 }
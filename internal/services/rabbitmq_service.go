@@ -1,6 +1,9 @@
 package services
 
 import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -12,13 +15,29 @@ type RabbitMQ struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
 	url     string
+
+	// publishers are confirm mode channels, each used by one publish at a
+	// time; channel is kept for consuming and topology changes
+	publishers *ChannelPool
 }
 
+// confirmTimeout bounds how long PublishConfirmed waits for the broker ack
+const confirmTimeout = 5 * time.Second
+
+// consumerPrefetch caps the unacked deliveries the broker pushes per consumer
+const consumerPrefetch = 100
+
+var (
+	_ Broker              = (*RabbitMQ)(nil)
+	_ ChannelPoolReporter = (*RabbitMQ)(nil)
+)
+
 // NewRabbitMQ creates and connects to RabbitMQ
 func NewRabbitMQ(amqpURL string) *RabbitMQ {
 	rmq := &RabbitMQ{
 		url: amqpURL,
 	}
+	rmq.LimitChannels(DefaultChannelPoolSize, DefaultChannelWaitTimeout)
 
 	if err := rmq.connect(); err != nil {
 		log.Fatalf("[RabbitMQ] Failed to connect: %v", err)
@@ -28,6 +47,22 @@ func NewRabbitMQ(amqpURL string) *RabbitMQ {
 	return rmq
 }
 
+// LimitChannels sets how many publishing channels may be open at once and how
+// long a publish waits for one to become free
+func (r *RabbitMQ) LimitChannels(size int, wait time.Duration) {
+	if r.publishers != nil {
+		r.publishers.Close()
+	}
+	r.publishers = NewChannelPool(size, wait, func() (publishChannel, error) {
+		return openAMQPPublishChannel(r.conn)
+	})
+}
+
+// ChannelPoolStats reports the publishing channel pool
+func (r *RabbitMQ) ChannelPoolStats() dto.ChannelPoolStatsDto {
+	return r.publishers.Stats()
+}
+
 // connect handles the actual connection and channel creation
 func (r *RabbitMQ) connect() error {
 	var err error
@@ -42,49 +77,145 @@ func (r *RabbitMQ) connect() error {
 		return fmt.Errorf("failed to open a channel: %w", err)
 	}
 
+	if err := r.channel.Qos(consumerPrefetch, 0, false); err != nil {
+		return fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	if err := r.channel.ExchangeDeclare(TopicExchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", TopicExchange, err)
+	}
+	if err := r.channel.ExchangeDeclare(FanoutExchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", FanoutExchange, err)
+	}
+
 	log.Println("[RabbitMQ] Connected successfully.")
 	return nil
 }
 
-// DeclareQueue declares a queue with given name
-func (r *RabbitMQ) DeclareQueue(queueName string) (amqp.Queue, error) {
-	q, err := r.channel.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // auto-delete
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // args
+// DeclareQueue declares a queue with given name and arguments. It runs on a
+// channel of its own: RabbitMQ closes the channel when the queue exists with
+// different arguments, which must not take the shared channel down.
+func (r *RabbitMQ) DeclareQueue(queueName string, options models.QueueOptions) error {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a channel: %w", err)
+	}
+	defer ch.Close()
+
+	_, err = ch.QueueDeclare(
+		queueName,               // name
+		true,                    // durable
+		false,                   // auto-delete
+		false,                   // exclusive
+		false,                   // no-wait
+		queueArguments(options), // args
 	)
 	if err != nil {
-		return q, fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+		return fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+	}
+	return nil
+}
+
+// queueArguments converts tenant queue options into x-arguments, leaving out
+// the ones that are not set
+func queueArguments(options models.QueueOptions) amqp.Table {
+	args := amqp.Table{}
+	if options.QueueType != "" {
+		args["x-queue-type"] = options.QueueType
+	}
+	if options.MaxLength > 0 {
+		args["x-max-length"] = options.MaxLength
+	}
+	if options.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = options.MaxLengthBytes
+	}
+	if options.Overflow != "" {
+		args["x-overflow"] = options.Overflow
+	}
+	if options.SingleActiveConsumer {
+		args["x-single-active-consumer"] = true
+	}
+	if options.QueueMode != "" {
+		args["x-queue-mode"] = options.QueueMode
+	}
+	if options.Expires > 0 {
+		args["x-expires"] = options.Expires.Milliseconds()
+	}
+	if len(args) == 0 {
+		return nil
 	}
-	return q, nil
+	return args
 }
 
 // PublishMessage publishes message to the given queue
 func (r *RabbitMQ) PublishMessage(queueName string, body []byte) error {
-	err := r.channel.Publish(
-		"",        // exchange
-		queueName, // routing key
-		false,     // mandatory
-		false,     // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
-	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
+	return r.Publish(DefaultExchange, queueName, Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// BindQueue implements Broker
+func (r *RabbitMQ) BindQueue(queueName, exchange, pattern string) error {
+	if err := r.channel.QueueBind(queueName, pattern, exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %s to %s: %w", queueName, pattern, err)
+	}
+	return nil
+}
+
+// UnbindQueue implements Broker
+func (r *RabbitMQ) UnbindQueue(queueName, exchange, pattern string) error {
+	if err := r.channel.QueueUnbind(queueName, pattern, exchange, nil); err != nil {
+		return fmt.Errorf("failed to unbind queue %s from %s: %w", queueName, pattern, err)
 	}
 	return nil
 }
 
-// ConsumeMessages consumes messages from the given queue with a specific consumer tag
-func (r *RabbitMQ) ConsumeMessages(queueName, consumerTag string) (<-chan amqp.Delivery, error) {
+// Publish implements Broker. The message goes out on a pooled channel; its
+// confirm is collected in the background.
+func (r *RabbitMQ) Publish(exchange, routingKey string, msg Publishing) error {
+	return r.publishers.Publish(context.Background(), exchange, routingKey, amqpPublishing(msg), false)
+}
+
+// PublishConfirmed publishes on a pooled channel and waits for the broker ack
+func (r *RabbitMQ) PublishConfirmed(exchange, routingKey string, msg Publishing) error {
+	return r.publishers.Publish(context.Background(), exchange, routingKey, amqpPublishing(msg), true)
+}
+
+// PublishDelayed implements Broker. The message waits in a retry queue per
+// target queue and delay, whose x-message-ttl dead-letters it to the target
+// queue. Every message in it expires after the same delay, so none is stuck
+// behind a later one. The retry queue expires once unused for longer than its
+// messages live; publishing redeclares it.
+func (r *RabbitMQ) PublishDelayed(queueName string, msg Publishing, delay time.Duration) error {
+	retryQueue := fmt.Sprintf("%s_retry_%d", queueName, delay.Milliseconds())
+
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a channel: %w", err)
+	}
+	defer ch.Close()
+
+	_, err = ch.QueueDeclare(retryQueue, true, false, false, false, amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    DefaultExchange,
+		"x-dead-letter-routing-key": queueName,
+		"x-expires":                 (delay + time.Minute).Milliseconds(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", retryQueue, err)
+	}
+	return r.PublishConfirmed(DefaultExchange, retryQueue, msg)
+}
+
+// Consume consumes messages from the given queue with a specific consumer tag.
+// Deliveries are not auto-acked; unacked messages return to the queue when the
+// channel closes.
+func (r *RabbitMQ) Consume(queueName, consumerTag string) (<-chan Delivery, error) {
 	msgs, err := r.channel.Consume(
 		queueName,
 		consumerTag,
-		true,  // auto-ack
+		false, // auto-ack
 		false, // exclusive
 		false, // no-local
 		false, // no-wait
@@ -93,11 +224,55 @@ func (r *RabbitMQ) ConsumeMessages(queueName, consumerTag string) (<-chan amqp.D
 	if err != nil {
 		return nil, fmt.Errorf("failed to consume messages: %w", err)
 	}
-	return msgs, nil
+
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for msg := range msgs {
+			deliveries <- Delivery{
+				Publishing: Publishing{
+					ContentType:     msg.ContentType,
+					ContentEncoding: msg.ContentEncoding,
+					MessageID:       msg.MessageId,
+					Headers:         map[string]any(msg.Headers),
+					Body:            msg.Body,
+					ReplyTo:         msg.ReplyTo,
+					CorrelationID:   msg.CorrelationId,
+				},
+				Redelivered: msg.Redelivered,
+				ack:         func() error { return msg.Ack(false) },
+				nack:        func(requeue bool) error { return msg.Nack(false, requeue) },
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+// Cancel stops deliveries to the consumer; its channel closes once the
+// deliveries already received have been handed out
+func (r *RabbitMQ) Cancel(consumerTag string) error {
+	if err := r.channel.Cancel(consumerTag, false); err != nil {
+		return fmt.Errorf("failed to cancel consumer %s: %w", consumerTag, err)
+	}
+	return nil
+}
+
+func amqpPublishing(msg Publishing) amqp.Publishing {
+	return amqp.Publishing{
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		MessageId:       msg.MessageID,
+		Headers:         amqp.Table(msg.Headers),
+		Body:            msg.Body,
+		ReplyTo:         msg.ReplyTo,
+		CorrelationId:   msg.CorrelationID,
+	}
 }
 
 // Close shuts down channel and connection gracefully
 func (r *RabbitMQ) Close() error {
+	r.publishers.Close()
+
 	if err := r.channel.Close(); err != nil {
 		log.Printf("[RabbitMQ] Channel close error: %v", err)
 	}
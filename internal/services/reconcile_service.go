@@ -0,0 +1,303 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of drift between registered tenants, partitions and queues
+const (
+	IssueMissingPartition = "missing_partition"
+	IssueMissingQueue     = "missing_queue"
+	IssueMissingConsumer  = "missing_consumer"
+	IssueOrphanPartition  = "orphan_partition"
+	IssueOrphanQueue      = "orphan_queue"
+)
+
+// ReconcileService compares the tenants table with the partitions attached to
+// messages and the tenant queues on the broker, and repairs the differences
+type ReconcileService struct {
+	tenants  repositories.TenantRepository
+	messages repositories.MessageRepository
+	broker   Broker
+	queues   QueueLister
+	manager  *TenantManager
+}
+
+// NewReconcileService constructor. manager is optional; without it running
+// consumers are not checked (e.g. from the CLI, where no consumers run).
+func NewReconcileService(
+	tenants repositories.TenantRepository,
+	messages repositories.MessageRepository,
+	broker Broker,
+	queues QueueLister,
+	manager *TenantManager,
+) *ReconcileService {
+	return &ReconcileService{
+		tenants:  tenants,
+		messages: messages,
+		broker:   broker,
+		queues:   queues,
+		manager:  manager,
+	}
+}
+
+// Check reports every mismatch without changing anything
+func (s *ReconcileService) Check(ctx context.Context) (dto.ReconcileReportDto, error) {
+	tenants, err := s.tenants.FindAll(ctx)
+	if err != nil {
+		return dto.ReconcileReportDto{}, err
+	}
+
+	partitions, err := s.messages.ListPartitions(ctx)
+	if err != nil {
+		return dto.ReconcileReportDto{}, err
+	}
+
+	queues, err := s.queues.ListQueues(ctx)
+	if err != nil {
+		return dto.ReconcileReportDto{}, err
+	}
+
+	var running []string
+	if s.manager != nil {
+		running = s.manager.RunningTenants()
+	}
+
+	return dto.ReconcileReportDto{
+		Tenants:    len(tenants),
+		Partitions: len(tenantIDsFrom(partitions, partitionTenantID)),
+		Queues:     len(tenantIDsFrom(queues, queueTenantID)),
+		Issues:     findDrift(tenants, partitions, queues, running, s.manager != nil),
+		CheckedAt:  time.Now(),
+	}, nil
+}
+
+// Fix creates missing partitions, queues and consumers. Orphaned partitions
+// and queues are left alone, DropOrphans removes them.
+func (s *ReconcileService) Fix(ctx context.Context, report *dto.ReconcileReportDto) {
+	registered := map[string]models.Tenant{}
+	if tenants, err := s.tenants.FindAll(ctx); err == nil {
+		for _, tenant := range tenants {
+			registered[tenant.ID] = tenant
+		}
+	}
+
+	for i := range report.Issues {
+		issue := &report.Issues[i]
+		tenantID, err := uuid.Parse(issue.TenantID)
+		if err != nil {
+			issue.Error = "invalid tenant id"
+			continue
+		}
+
+		switch issue.Kind {
+		case IssueMissingPartition:
+			err = s.messages.CreatePartition(tenantID)
+		case IssueMissingQueue:
+			err = s.broker.DeclareQueue(issue.Resource, registered[issue.TenantID].QueueOptions)
+		case IssueMissingConsumer:
+			err = s.manager.StartTenantConsumer(ctx, tenantID, registered[issue.TenantID].Workers)
+		default:
+			continue
+		}
+
+		if err != nil {
+			issue.Error = err.Error()
+			log.Printf("[Reconcile] Failed to fix %s %s: %v", issue.Kind, issue.Resource, err)
+			continue
+		}
+		issue.Fixed = true
+		log.Printf("[Reconcile] Fixed %s %s", issue.Kind, issue.Resource)
+	}
+}
+
+// DropOrphans drops the orphaned partitions and queues named in resources,
+// e.g. as confirmed by an operator after reading the report. They are checked
+// again first: a resource whose tenant was registered meanwhile, or that was
+// not listed, is kept. The returned issues are the confirmed orphans.
+func (s *ReconcileService) DropOrphans(ctx context.Context, resources []string) ([]dto.ReconcileIssueDto, error) {
+	confirmed := map[string]bool{}
+	for _, resource := range resources {
+		confirmed[resource] = true
+	}
+
+	report, err := s.Check(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dropped := []dto.ReconcileIssueDto{}
+	for _, issue := range report.Issues {
+		if !confirmed[issue.Resource] {
+			continue
+		}
+		switch issue.Kind {
+		case IssueOrphanPartition:
+			err = s.messages.DropPartition(uuid.MustParse(issue.TenantID))
+		case IssueOrphanQueue:
+			err = s.broker.DeleteQueue(issue.Resource)
+		default:
+			continue
+		}
+
+		if err != nil {
+			issue.Error = err.Error()
+			log.Printf("[Reconcile] Failed to drop %s: %v", issue.Resource, err)
+		} else {
+			issue.Fixed = true
+			log.Printf("[Reconcile] Dropped %s %s", issue.Kind, issue.Resource)
+		}
+		dropped = append(dropped, issue)
+	}
+	return dropped, nil
+}
+
+// StrayTenants lists tenants whose messages landed in the default partition
+// because no partition existed for them when the messages were stored
+func (s *ReconcileService) StrayTenants(ctx context.Context) ([]dto.StrayTenantDto, error) {
+	strays, err := s.messages.ListDefaultTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := s.tenants.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	registered := map[string]bool{}
+	for _, tenant := range tenants {
+		registered[tenant.ID] = true
+	}
+
+	for i := range strays {
+		strays[i].Registered = registered[strays[i].TenantID]
+	}
+	return strays, nil
+}
+
+// AdoptStrayTenant moves a registered tenant's rows out of the default
+// partition into its own partition, creating the partition if needed
+func (s *ReconcileService) AdoptStrayTenant(ctx context.Context, tenantID uuid.UUID) (dto.AdoptStrayTenantDto, error) {
+	if _, err := s.tenants.Find(ctx, tenantID.String()); err != nil {
+		return dto.AdoptStrayTenantDto{}, err
+	}
+
+	moved, err := s.messages.AdoptDefaultRows(ctx, tenantID)
+	if err != nil {
+		return dto.AdoptStrayTenantDto{}, fmt.Errorf("failed to adopt rows for tenant %s: %w", tenantID, err)
+	}
+
+	log.Printf("[Reconcile] Moved %d message(s) of tenant %s out of the default partition", moved, tenantID)
+	return dto.AdoptStrayTenantDto{TenantID: tenantID.String(), Moved: moved}, nil
+}
+
+// findDrift lists mismatches between registered tenants and the partitions,
+// queues and running consumers found. Partitions and queues that do not follow
+// the tenant naming scheme are ignored.
+func findDrift(tenants []models.Tenant, partitions, queues, running []string, checkConsumers bool) []dto.ReconcileIssueDto {
+	registered := map[string]bool{}
+	for _, tenant := range tenants {
+		registered[tenant.ID] = true
+	}
+	partitionSet := tenantIDsFrom(partitions, partitionTenantID)
+	queueSet := tenantIDsFrom(queues, queueTenantID)
+	runningSet := map[string]bool{}
+	for _, id := range running {
+		runningSet[id] = true
+	}
+
+	issues := []dto.ReconcileIssueDto{}
+	for _, tenant := range tenants {
+		if !partitionSet[tenant.ID] {
+			issues = append(issues, dto.ReconcileIssueDto{
+				Kind: IssueMissingPartition, TenantID: tenant.ID, Resource: repositories.TenantPartitionPrefix + tenant.ID,
+			})
+		}
+		if !queueSet[tenant.ID] {
+			issues = append(issues, dto.ReconcileIssueDto{
+				Kind: IssueMissingQueue, TenantID: tenant.ID, Resource: TenantQueueName(tenant.ID),
+			})
+		}
+		if checkConsumers && !runningSet[tenant.ID] {
+			issues = append(issues, dto.ReconcileIssueDto{
+				Kind: IssueMissingConsumer, TenantID: tenant.ID, Resource: TenantQueueName(tenant.ID),
+			})
+		}
+	}
+
+	for _, id := range sortedKeys(partitionSet) {
+		if !registered[id] {
+			issues = append(issues, dto.ReconcileIssueDto{
+				Kind: IssueOrphanPartition, TenantID: id, Resource: repositories.TenantPartitionPrefix + id,
+			})
+		}
+	}
+	for _, id := range sortedKeys(queueSet) {
+		if !registered[id] {
+			issues = append(issues, dto.ReconcileIssueDto{
+				Kind: IssueOrphanQueue, TenantID: id, Resource: TenantQueueName(id),
+			})
+		}
+	}
+
+	return issues
+}
+
+func tenantIDsFrom(names []string, parse func(string) (string, bool)) map[string]bool {
+	ids := map[string]bool{}
+	for _, name := range names {
+		if id, ok := parse(name); ok {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// partitionTenantID extracts the tenant from a messages_tenant_<uuid> partition name
+func partitionTenantID(name string) (string, bool) {
+	id, ok := strings.CutPrefix(name, repositories.TenantPartitionPrefix)
+	if !ok {
+		return "", false
+	}
+	return normalizeTenantID(id)
+}
+
+// queueTenantID extracts the tenant from a tenant_<uuid>_queue queue name
+func queueTenantID(name string) (string, bool) {
+	id, ok := strings.CutPrefix(name, "tenant_")
+	if !ok {
+		return "", false
+	}
+	id, ok = strings.CutSuffix(id, "_queue")
+	if !ok {
+		return "", false
+	}
+	return normalizeTenantID(id)
+}
+
+func normalizeTenantID(id string) (string, bool) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDrift(t *testing.T) {
+	const (
+		healthy  = "0190d8a4-0000-7000-8000-000000000001"
+		noQueue  = "0190d8a4-0000-7000-8000-000000000002"
+		orphaned = "0190d8a4-0000-7000-8000-000000000003"
+	)
+
+	tenants := []models.Tenant{{ID: healthy, Workers: 3}, {ID: noQueue, Workers: 3}}
+	partitions := []string{"messages_tenant_" + healthy, "messages_tenant_" + noQueue, "messages_tenant_" + orphaned, "messages_default"}
+	queues := []string{TenantQueueName(healthy), TenantQueueName(orphaned), "unrelated_queue"}
+	running := []string{healthy}
+
+	issues := findDrift(tenants, partitions, queues, running, true)
+
+	var kinds []string
+	for _, issue := range issues {
+		kinds = append(kinds, issue.Kind+":"+issue.TenantID)
+	}
+	assert.Equal(t, []string{
+		IssueMissingQueue + ":" + noQueue,
+		IssueMissingConsumer + ":" + noQueue,
+		IssueOrphanPartition + ":" + orphaned,
+		IssueOrphanQueue + ":" + orphaned,
+	}, kinds)
+
+	// Without a tenant manager running consumers are not checked
+	assert.Len(t, findDrift(tenants, partitions, queues, nil, false), 3)
+}
+
+func TestQueueTenantID(t *testing.T) {
+	id, ok := queueTenantID("tenant_0190d8a4-0000-7000-8000-000000000001_queue")
+	assert.True(t, ok)
+	assert.Equal(t, "0190d8a4-0000-7000-8000-000000000001", id)
+
+	_, ok = queueTenantID("tenant_abc_queue")
+	assert.False(t, ok)
+}
+
+// partitionRepository lists and drops partitions in memory
+type partitionRepository struct {
+	repositories.MessageRepository
+	partitions []string
+}
+
+func (r *partitionRepository) ListPartitions(ctx context.Context) ([]string, error) {
+	return r.partitions, nil
+}
+
+func (r *partitionRepository) DropPartition(tenantID uuid.UUID) error {
+	r.partitions = slices.DeleteFunc(r.partitions, func(name string) bool {
+		return name == repositories.TenantPartitionPrefix+tenantID.String()
+	})
+	return nil
+}
+
+func TestReconcileDropsOnlyConfirmedOrphans(t *testing.T) {
+	const (
+		registered = "0190d8a4-0000-7000-8000-000000000011"
+		confirmed  = "0190d8a4-0000-7000-8000-000000000012"
+		unlisted   = "0190d8a4-0000-7000-8000-000000000013"
+	)
+	ctx := context.Background()
+
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: registered}}}
+	messages := &partitionRepository{partitions: []string{
+		repositories.TenantPartitionPrefix + registered,
+		repositories.TenantPartitionPrefix + confirmed,
+		repositories.TenantPartitionPrefix + unlisted,
+	}}
+	broker := NewMemoryBroker()
+	for _, id := range []string{registered, confirmed, unlisted} {
+		require.NoError(t, broker.DeclareQueue(TenantQueueName(id), models.QueueOptions{}))
+	}
+	reconcile := NewReconcileService(tenants, messages, broker, broker, nil)
+
+	// Fixing drift never drops orphans
+	report, err := reconcile.Check(ctx)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 4)
+	reconcile.Fix(ctx, &report)
+	assert.Len(t, messages.partitions, 3)
+
+	// Registered resources are kept even when listed
+	dropped, err := reconcile.DropOrphans(ctx, []string{
+		repositories.TenantPartitionPrefix + confirmed,
+		TenantQueueName(confirmed),
+		repositories.TenantPartitionPrefix + registered,
+	})
+	require.NoError(t, err)
+	require.Len(t, dropped, 2)
+	for _, issue := range dropped {
+		assert.True(t, issue.Fixed, issue.Resource)
+		assert.Equal(t, confirmed, issue.TenantID)
+	}
+
+	assert.Equal(t, []string{
+		repositories.TenantPartitionPrefix + registered,
+		repositories.TenantPartitionPrefix + unlisted,
+	}, messages.partitions)
+	queues, err := broker.ListQueues(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{TenantQueueName(registered), TenantQueueName(unlisted)}, queues)
+}
@@ -0,0 +1,675 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisQueuesKey is a set with the name of every declared queue
+	redisQueuesKey = "queues"
+	// redisExpiringKey is a sorted set of the queues declared with an expiry,
+	// scored by the unix milliseconds they expire at
+	redisExpiringKey = "queues:expiring"
+	// redisGroup is the consumer group every tenant consumer reads through
+	redisGroup = "consumers"
+	// redisReadBatch is how many entries a consumer reads per XREADGROUP
+	redisReadBatch = 20
+)
+
+var _ Broker = (*RedisBroker)(nil)
+
+// RedisBroker maps each queue to a Redis stream read through one consumer
+// group. Entries stay pending until acked; entries left pending by a crashed
+// worker for longer than claimIdle are reclaimed by the remaining consumers.
+// Streams are trimmed to about maxLen entries, dropping the oldest.
+type RedisBroker struct {
+	client    *redis.Client
+	maxLen    int64
+	claimIdle time.Duration
+	block     time.Duration
+	// instance keeps consumer names unique when several servers consume the
+	// same tenant
+	instance string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	consumers map[string]*redisConsumer
+	// expiring holds the expiry of the queues declared with one here
+	expiring map[string]time.Duration
+}
+
+type redisConsumer struct {
+	queueName string
+	name      string
+	cancel    context.CancelFunc
+}
+
+// NewRedisBroker connects to redisURL, e.g. redis://localhost:6379/0
+func NewRedisBroker(redisURL string, maxLen int, claimIdle, block time.Duration) (*RedisBroker, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	log.Println("[Redis] Connected successfully.")
+	b := &RedisBroker{
+		client:    client,
+		maxLen:    int64(maxLen),
+		claimIdle: claimIdle,
+		block:     block,
+		instance:  uuid.NewString()[:8],
+		ctx:       ctx,
+		cancel:    cancel,
+		consumers: make(map[string]*redisConsumer),
+		expiring:  make(map[string]time.Duration),
+	}
+	go b.sweepExpired()
+	return b, nil
+}
+
+func redisStreamKey(queueName string) string {
+	return "queue:" + queueName
+}
+
+// redisDelayedKey is a sorted set of the delayed messages of a queue, scored
+// by the unix milliseconds they are due at. Each member is the key of a hash
+// holding the stream entry values.
+func redisDelayedKey(queueName string) string {
+	return "delayed:" + queueName
+}
+
+// redisPromoteDelayed moves due delayed messages to the stream of a declared
+// queue, atomically so a message is neither lost nor added twice.
+// KEYS: delayed set, stream, queue set; ARGV: now, limit, maxlen, queue name.
+var redisPromoteDelayed = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+local declared = redis.call('SISMEMBER', KEYS[3], ARGV[4]) == 1
+for _, key in ipairs(due) do
+	local values = redis.call('HGETALL', key)
+	if declared and #values > 0 then
+		redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[3], '*', unpack(values))
+	end
+	redis.call('DEL', key)
+	redis.call('ZREM', KEYS[1], key)
+end
+return #due
+`)
+
+// redisBindingsKey is a set of "<queue> <pattern>" bindings of an exchange
+func redisBindingsKey(exchange string) string {
+	return "bindings:" + exchange
+}
+
+// CheckQueueOptions refuses every queue option, there are no queue arguments
+func (b *RedisBroker) CheckQueueOptions(options models.QueueOptions) error {
+	return unsupportedQueueOptions("redis", options)
+}
+
+// DeclareQueue implements Broker; queue options other than Expires are refused
+func (b *RedisBroker) DeclareQueue(queueName string, options models.QueueOptions) error {
+	if err := b.CheckQueueOptions(options); err != nil {
+		return err
+	}
+	err := b.client.XGroupCreateMkStream(b.ctx, redisStreamKey(queueName), redisGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+	}
+	if err := b.client.SAdd(b.ctx, redisQueuesKey, queueName).Err(); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+	}
+
+	if options.Expires > 0 {
+		expiresAt := time.Now().Add(options.Expires).UnixMilli()
+		if err := b.client.ZAdd(b.ctx, redisExpiringKey, redis.Z{Score: float64(expiresAt), Member: queueName}).Err(); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", queueName, err)
+		}
+		b.mu.Lock()
+		b.expiring[queueName] = options.Expires
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// DeleteQueue implements Broker
+func (b *RedisBroker) DeleteQueue(queueName string) error {
+	b.mu.Lock()
+	for tag, consumer := range b.consumers {
+		if consumer.queueName == queueName {
+			consumer.cancel()
+			delete(b.consumers, tag)
+		}
+	}
+	delete(b.expiring, queueName)
+	b.mu.Unlock()
+
+	bindings := map[string][]string{}
+	for _, exchange := range []string{TopicExchange, FanoutExchange} {
+		patterns, err := b.bindings(exchange, queueName)
+		if err != nil {
+			return fmt.Errorf("failed to delete queue %s: %w", queueName, err)
+		}
+		bindings[exchange] = patterns
+	}
+	delayed, err := b.client.ZRange(b.ctx, redisDelayedKey(queueName), 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete queue %s: %w", queueName, err)
+	}
+
+	_, err = b.client.TxPipelined(b.ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(b.ctx, redisQueuesKey, queueName)
+		pipe.ZRem(b.ctx, redisExpiringKey, queueName)
+		for exchange, patterns := range bindings {
+			for _, pattern := range patterns {
+				pipe.SRem(b.ctx, redisBindingsKey(exchange), queueName+" "+pattern)
+			}
+		}
+		pipe.Del(b.ctx, append(delayed, redisStreamKey(queueName), redisDelayedKey(queueName))...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete queue %s: %w", queueName, err)
+	}
+	log.Printf("[Redis] Queue %s deleted successfully.", queueName)
+	return nil
+}
+
+// BindQueue implements Broker
+func (b *RedisBroker) BindQueue(queueName, exchange, pattern string) error {
+	if !bindingExchange(exchange) {
+		return errUnknownExchange(exchange)
+	}
+	if err := b.client.SAdd(b.ctx, redisBindingsKey(exchange), queueName+" "+pattern).Err(); err != nil {
+		return fmt.Errorf("failed to bind queue %s to %s: %w", queueName, pattern, err)
+	}
+	return nil
+}
+
+// UnbindQueue implements Broker
+func (b *RedisBroker) UnbindQueue(queueName, exchange, pattern string) error {
+	if !bindingExchange(exchange) {
+		return errUnknownExchange(exchange)
+	}
+	if err := b.client.SRem(b.ctx, redisBindingsKey(exchange), queueName+" "+pattern).Err(); err != nil {
+		return fmt.Errorf("failed to unbind queue %s from %s: %w", queueName, pattern, err)
+	}
+	return nil
+}
+
+// ListBindings implements BindingLister
+func (b *RedisBroker) ListBindings(ctx context.Context, queueName, exchange string) ([]string, error) {
+	patterns, err := b.bindings(exchange, queueName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bindings of %s: %w", queueName, err)
+	}
+	sort.Strings(patterns)
+	return patterns, nil
+}
+
+// bindings returns the patterns of exchange bound to a queue, or every
+// "<queue> <pattern>" binding of exchange when queueName is empty
+func (b *RedisBroker) bindings(exchange, queueName string) ([]string, error) {
+	members, err := b.client.SMembers(b.ctx, redisBindingsKey(exchange)).Result()
+	if err != nil || queueName == "" {
+		return members, err
+	}
+
+	var patterns []string
+	for _, member := range members {
+		if pattern, ok := strings.CutPrefix(member, queueName+" "); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// Publish implements Broker; messages for a queue that was never declared are
+// dropped
+func (b *RedisBroker) Publish(exchange, routingKey string, msg Publishing) error {
+	var queues []string
+	switch exchange {
+	case DefaultExchange:
+		declared, err := b.client.SIsMember(b.ctx, redisQueuesKey, routingKey).Result()
+		if err != nil {
+			return fmt.Errorf("failed to publish message: %w", err)
+		}
+		if declared {
+			queues = append(queues, routingKey)
+		}
+	case TopicExchange, FanoutExchange:
+		bindings, err := b.bindings(exchange, "")
+		if err != nil {
+			return fmt.Errorf("failed to publish message: %w", err)
+		}
+		routed := map[string]bool{}
+		for _, binding := range bindings {
+			queueName, pattern, _ := strings.Cut(binding, " ")
+			if !routed[queueName] && bindingMatch(exchange, pattern, routingKey) {
+				routed[queueName] = true
+				queues = append(queues, queueName)
+			}
+		}
+	default:
+		return errUnknownExchange(exchange)
+	}
+
+	if len(queues) == 0 {
+		return nil
+	}
+	_, err := b.client.Pipelined(b.ctx, func(pipe redis.Pipeliner) error {
+		for _, queueName := range queues {
+			b.add(b.ctx, pipe, queueName, msg, false)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	return nil
+}
+
+// PublishConfirmed implements Broker; XADD returns once Redis stored the entry
+func (b *RedisBroker) PublishConfirmed(exchange, routingKey string, msg Publishing) error {
+	return b.Publish(exchange, routingKey, msg)
+}
+
+// PublishDelayed implements Broker. The message is kept in a hash listed in
+// the delayed set of the queue; consumers of the queue promote it to the
+// stream once due.
+func (b *RedisBroker) PublishDelayed(queueName string, msg Publishing, delay time.Duration) error {
+	key := redisDelayedKey(queueName) + ":" + uuid.NewString()
+	due := time.Now().Add(delay).UnixMilli()
+	_, err := b.client.TxPipelined(b.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(b.ctx, key, redisValues(msg, false))
+		pipe.ZAdd(b.ctx, redisDelayedKey(queueName), redis.Z{Score: float64(due), Member: key})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish delayed message: %w", err)
+	}
+	return nil
+}
+
+// promoteDelayed moves the due delayed messages of a queue to its stream and
+// returns how long until the next one is due, at most wait
+func (b *RedisBroker) promoteDelayed(ctx context.Context, queueName string, wait time.Duration) time.Duration {
+	delayedKey := redisDelayedKey(queueName)
+	keys := []string{delayedKey, redisStreamKey(queueName), redisQueuesKey}
+	now := time.Now().UnixMilli()
+	if err := redisPromoteDelayed.Run(ctx, b.client, keys, now, redisReadBatch, b.maxLen, queueName).Err(); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[Redis] %s: failed to promote delayed messages: %v", queueName, err)
+		}
+		return wait
+	}
+
+	next, err := b.client.ZRangeWithScores(ctx, delayedKey, 0, 0).Result()
+	if err != nil || len(next) == 0 {
+		return wait
+	}
+	until := time.Duration(int64(next[0].Score)-now) * time.Millisecond
+	return max(min(until, wait), time.Millisecond)
+}
+
+func (b *RedisBroker) add(ctx context.Context, client redis.Cmdable, queueName string, msg Publishing, redelivered bool) *redis.StringCmd {
+	return client.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisStreamKey(queueName),
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: redisValues(msg, redelivered),
+	})
+}
+
+// redisValues are the stream entry values of a message
+func redisValues(msg Publishing, redelivered bool) map[string]any {
+	headers, _ := json.Marshal(msg.Headers)
+	values := map[string]any{
+		"content_type":     msg.ContentType,
+		"content_encoding": msg.ContentEncoding,
+		"message_id":       msg.MessageID,
+		"headers":          headers,
+		"body":             msg.Body,
+		"reply_to":         msg.ReplyTo,
+		"correlation_id":   msg.CorrelationID,
+	}
+	if redelivered {
+		values["redelivered"] = "1"
+	}
+	return values
+}
+
+// Consume implements Broker
+func (b *RedisBroker) Consume(queueName, consumerTag string) (<-chan Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ctx.Err() != nil {
+		return nil, fmt.Errorf("failed to consume messages: broker closed")
+	}
+	if _, exists := b.consumers[consumerTag]; exists {
+		return nil, fmt.Errorf("failed to consume messages: consumer %s already exists", consumerTag)
+	}
+
+	ctx, cancel := context.WithCancel(b.ctx)
+	consumer := &redisConsumer{
+		queueName: queueName,
+		name:      consumerTag + "@" + b.instance,
+		cancel:    cancel,
+	}
+	b.consumers[consumerTag] = consumer
+
+	deliveries := make(chan Delivery)
+	go b.consume(ctx, consumer, deliveries)
+	if expires := b.expiring[queueName]; expires > 0 {
+		go b.keepAlive(ctx, queueName, expires)
+	}
+	return deliveries, nil
+}
+
+// keepAlive pushes the expiry of a queue back well before it is reached,
+// until the consumer is cancelled. XX leaves a queue that was deleted meanwhile
+// deleted.
+func (b *RedisBroker) keepAlive(ctx context.Context, queueName string, expires time.Duration) {
+	ticker := time.NewTicker(expires / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			expiresAt := time.Now().Add(expires).UnixMilli()
+			err := b.client.ZAddXX(ctx, redisExpiringKey, redis.Z{Score: float64(expiresAt), Member: queueName}).Err()
+			if err != nil && ctx.Err() == nil {
+				log.Printf("[Redis] %s: %v", queueName, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweepExpired deletes the expired queues every queueExpirySweep until the
+// broker is closed. Every instance sweeps, so the queues of an instance that
+// died are deleted too.
+func (b *RedisBroker) sweepExpired() {
+	ticker := time.NewTicker(queueExpirySweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.deleteExpired()
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// redisRemoveExpired removes a queue from the expiring set if it is still
+// expired, so a queue kept alive meanwhile is not deleted.
+// KEYS: expiring set; ARGV: queue name, now.
+var redisRemoveExpired = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) <= tonumber(ARGV[2]) then
+	return redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// deleteExpired deletes the queues past their expiry. Only the instance that
+// removed a queue from the expiring set deletes it.
+func (b *RedisBroker) deleteExpired() {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	names, err := b.client.ZRangeByScore(b.ctx, redisExpiringKey, &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		if b.ctx.Err() == nil {
+			log.Printf("[Redis] Failed to list expired queues: %v", err)
+		}
+		return
+	}
+
+	for _, name := range names {
+		removed, err := redisRemoveExpired.Run(b.ctx, b.client, []string{redisExpiringKey}, name, now).Int()
+		if err != nil || removed == 0 {
+			continue
+		}
+		if err := b.DeleteQueue(name); err != nil {
+			log.Printf("[Redis] Failed to delete expired queue %s: %v", name, err)
+			continue
+		}
+		log.Printf("[Redis] Queue %s expired and was deleted.", name)
+	}
+}
+
+// consume reclaims entries other consumers left pending, then reads new
+// entries, until the consumer is cancelled. Entries read but not handed out
+// stay pending and are reclaimed after claimIdle.
+func (b *RedisBroker) consume(ctx context.Context, consumer *redisConsumer, deliveries chan<- Delivery) {
+	defer close(deliveries)
+	key := redisStreamKey(consumer.queueName)
+	defer b.removeConsumer(key, consumer.name)
+
+	// A claim pass walks the pending list one batch at a time from cursor and
+	// only waits claimIdle again once XAUTOCLAIM wraps around to 0-0
+	cursor := "0-0"
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		var entries []redis.XMessage
+		var err error
+
+		if cursor != "0-0" || time.Since(lastClaim) >= b.claimIdle {
+			if cursor == "0-0" {
+				lastClaim = time.Now()
+			}
+			var next string
+			entries, next, err = b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   key,
+				Group:    redisGroup,
+				Consumer: consumer.name,
+				MinIdle:  b.claimIdle,
+				Start:    cursor,
+				Count:    redisReadBatch,
+			}).Result()
+			if err != nil {
+				cursor = "0-0"
+			} else {
+				cursor = next
+				if cursor == "0-0" {
+					b.pruneConsumers(ctx, key, consumer.name)
+				}
+			}
+			entries = b.reclaimed(ctx, key, entries)
+		}
+
+		if err == nil && len(entries) == 0 {
+			// Wake up in time for the next delayed message
+			block := b.promoteDelayed(ctx, consumer.queueName, b.block)
+			var streams []redis.XStream
+			streams, err = b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    redisGroup,
+				Consumer: consumer.name,
+				Streams:  []string{key, ">"},
+				Count:    redisReadBatch,
+				Block:    block,
+			}).Result()
+			for _, stream := range streams {
+				entries = append(entries, stream.Messages...)
+			}
+		}
+
+		if err != nil && !errors.Is(err, redis.Nil) {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[Redis] %s: %v", consumer.queueName, err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			select {
+			case deliveries <- b.delivery(consumer.queueName, entry):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// pruneConsumers deletes consumers of crashed or stopped servers. Only
+// consumers without pending entries that have not read for claimIdle are
+// removed, so no entry is lost; a live consumer that is removed anyway is
+// recreated by its next XREADGROUP.
+func (b *RedisBroker) pruneConsumers(ctx context.Context, key, self string) {
+	consumers, err := b.client.XInfoConsumers(ctx, key, redisGroup).Result()
+	if err != nil {
+		return
+	}
+	for _, consumer := range consumers {
+		if consumer.Name == self || consumer.Pending > 0 || consumer.Idle < b.claimIdle {
+			continue
+		}
+		b.client.XGroupDelConsumer(ctx, key, redisGroup, consumer.Name)
+	}
+}
+
+// removeConsumer deletes a stopped consumer from the group unless it still
+// owns pending entries; those are reclaimed by the remaining consumers and
+// the consumer is pruned afterwards
+func (b *RedisBroker) removeConsumer(key, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	pending, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   key,
+		Group:    redisGroup,
+		Consumer: name,
+		Start:    "-",
+		End:      "+",
+		Count:    1,
+	}).Result()
+	if err != nil || len(pending) > 0 {
+		return
+	}
+	b.client.XGroupDelConsumer(ctx, key, redisGroup, name)
+}
+
+// reclaimed marks reclaimed entries as redelivered. Entries trimmed from the
+// stream while pending come back without values and are acked away.
+func (b *RedisBroker) reclaimed(ctx context.Context, key string, entries []redis.XMessage) []redis.XMessage {
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Values == nil {
+			b.client.XAck(ctx, key, redisGroup, entry.ID)
+			continue
+		}
+		entry.Values["redelivered"] = "1"
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+func (b *RedisBroker) delivery(queueName string, entry redis.XMessage) Delivery {
+	field := func(name string) string {
+		value, _ := entry.Values[name].(string)
+		return value
+	}
+
+	var headers map[string]any
+	if raw := field("headers"); raw != "" {
+		_ = json.Unmarshal([]byte(raw), &headers)
+	}
+
+	msg := Publishing{
+		ContentType:     field("content_type"),
+		ContentEncoding: field("content_encoding"),
+		MessageID:       field("message_id"),
+		Headers:         headers,
+		Body:            []byte(field("body")),
+		ReplyTo:         field("reply_to"),
+		CorrelationID:   field("correlation_id"),
+	}
+	key := redisStreamKey(queueName)
+
+	return Delivery{
+		Publishing:  msg,
+		Redelivered: field("redelivered") == "1",
+		ack: func() error {
+			return b.client.XAck(context.Background(), key, redisGroup, entry.ID).Err()
+		},
+		nack: func(requeue bool) error {
+			ctx := context.Background()
+			if !requeue {
+				return b.client.XAck(ctx, key, redisGroup, entry.ID).Err()
+			}
+			// Streams cannot put an entry back, so append a copy and ack the original
+			_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				b.add(ctx, pipe, queueName, msg, true)
+				pipe.XAck(ctx, key, redisGroup, entry.ID)
+				return nil
+			})
+			return err
+		},
+	}
+}
+
+// Cancel implements Broker
+func (b *RedisBroker) Cancel(consumerTag string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	consumer, ok := b.consumers[consumerTag]
+	if !ok {
+		return fmt.Errorf("failed to cancel consumer %s: not found", consumerTag)
+	}
+	consumer.cancel()
+	delete(b.consumers, consumerTag)
+	return nil
+}
+
+// Close implements Broker
+func (b *RedisBroker) Close() error {
+	b.mu.Lock()
+	for tag, consumer := range b.consumers {
+		consumer.cancel()
+		delete(b.consumers, tag)
+	}
+	b.mu.Unlock()
+
+	b.cancel()
+	return b.client.Close()
+}
+
+// ListQueues implements QueueLister
+func (b *RedisBroker) ListQueues(ctx context.Context) ([]string, error) {
+	names, err := b.client.SMembers(ctx, redisQueuesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queues: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
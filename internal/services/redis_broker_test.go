@@ -0,0 +1,223 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRedisBroker connects to REDIS_TEST_URL when set (use a scratch
+// database, it is flushed), otherwise to an in-process miniredis
+func newTestRedisBroker(t *testing.T, claimIdle time.Duration) *RedisBroker {
+	t.Helper()
+
+	url := os.Getenv("REDIS_TEST_URL")
+	if url == "" {
+		url = "redis://" + miniredis.RunT(t).Addr()
+	}
+
+	b, err := NewRedisBroker(url, 1000, claimIdle, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, b.client.FlushDB(context.Background()).Err())
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestRedisBrokerDeliversAndRequeues(t *testing.T) {
+	b := newTestRedisBroker(t, time.Minute)
+
+	require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{Body: []byte("lost")}))
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+
+	deliveries, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{
+		ContentType: "application/json",
+		Headers:     map[string]any{"k": "v"},
+		Body:        []byte(`{"n":1}`),
+	}))
+
+	first := receive(t, deliveries)
+	assert.Equal(t, `{"n":1}`, string(first.Body))
+	assert.Equal(t, "application/json", first.ContentType)
+	assert.Equal(t, "v", first.Headers["k"])
+	assert.False(t, first.Redelivered)
+
+	require.NoError(t, first.Nack(true))
+	again := receive(t, deliveries)
+	assert.Equal(t, `{"n":1}`, string(again.Body))
+	assert.True(t, again.Redelivered)
+	require.NoError(t, again.Ack())
+
+	pending, err := b.client.XPending(context.Background(), redisStreamKey("q"), redisGroup).Result()
+	require.NoError(t, err)
+	assert.Zero(t, pending.Count)
+
+	queues, err := b.ListQueues(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"q"}, queues)
+
+	require.NoError(t, b.DeleteQueue("q"))
+	for range deliveries {
+	}
+	queues, _ = b.ListQueues(context.Background())
+	assert.Empty(t, queues)
+}
+
+func TestRedisBrokerReclaimsEntriesOfCrashedConsumer(t *testing.T) {
+	b := newTestRedisBroker(t, 100*time.Millisecond)
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+	require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{Body: []byte("work")}))
+
+	crashed, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+	receive(t, crashed) // never acked
+	require.NoError(t, b.Cancel("c1"))
+
+	time.Sleep(150 * time.Millisecond)
+
+	deliveries, err := b.Consume("q", "c2")
+	require.NoError(t, err)
+	msg := receive(t, deliveries)
+	assert.Equal(t, "work", string(msg.Body))
+	assert.True(t, msg.Redelivered)
+	require.NoError(t, msg.Ack())
+}
+
+func TestRedisBrokerDelaysMessages(t *testing.T) {
+	b := newTestRedisBroker(t, time.Minute)
+	// The consumer blocks no longer than until the delayed message is due
+	b.block = time.Hour
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+
+	start := time.Now()
+	require.NoError(t, b.PublishDelayed("q", Publishing{Headers: map[string]any{"k": "v"}, Body: []byte("later")}, 100*time.Millisecond))
+	require.NoError(t, b.PublishDelayed("missing", Publishing{Body: []byte("lost")}, time.Millisecond))
+	deliveries, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+
+	msg := receive(t, deliveries)
+	assert.Equal(t, "later", string(msg.Body))
+	assert.Equal(t, "v", msg.Headers["k"])
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	require.NoError(t, msg.Ack())
+
+	keys, err := b.client.Keys(context.Background(), "delayed:q*").Result()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestRedisBrokerDeletesExpiredQueues(t *testing.T) {
+	b := newTestRedisBroker(t, time.Minute)
+	expires := models.QueueOptions{Expires: 60 * time.Millisecond}
+	require.NoError(t, b.DeclareQueue("consumed", expires))
+	require.NoError(t, b.DeclareQueue("abandoned", expires))
+	require.NoError(t, b.DeclareQueue("durable", models.QueueOptions{}))
+	_, err := b.Consume("consumed", "c1")
+	require.NoError(t, err)
+
+	// The consumer keeps its queue alive past the expiry
+	time.Sleep(150 * time.Millisecond)
+	b.deleteExpired()
+	queues, err := b.ListQueues(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"consumed", "durable"}, queues)
+
+	require.NoError(t, b.Cancel("c1"))
+	time.Sleep(150 * time.Millisecond)
+	b.deleteExpired()
+	queues, err = b.ListQueues(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"durable"}, queues)
+	exists, err := b.client.Exists(context.Background(), redisStreamKey("abandoned"), redisStreamKey("consumed")).Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists)
+}
+
+func TestRedisBrokerTopicRouting(t *testing.T) {
+	b := newTestRedisBroker(t, time.Minute)
+	require.NoError(t, b.DeclareQueue("orders", models.QueueOptions{}))
+	require.NoError(t, b.BindQueue("orders", TopicExchange, "t1.orders.*"))
+	require.NoError(t, b.BindQueue("orders", TopicExchange, "t1.#"))
+
+	deliveries, err := b.Consume("orders", "c1")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(TopicExchange, "t2.orders.created", Publishing{Body: []byte("other tenant")}))
+	require.NoError(t, b.Publish(TopicExchange, "t1.orders.created", Publishing{Body: []byte("created")}))
+	msg := receive(t, deliveries)
+	assert.Equal(t, "created", string(msg.Body))
+	require.NoError(t, msg.Ack())
+
+	length, err := b.client.XLen(context.Background(), redisStreamKey("orders")).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), length, "one copy although two bindings match")
+
+	require.NoError(t, b.DeleteQueue("orders"))
+	bindings, err := b.bindings(TopicExchange, "")
+	require.NoError(t, err)
+	assert.Empty(t, bindings)
+}
+
+func TestRedisBrokerReclaimsInBatchesAndRemovesConsumers(t *testing.T) {
+	b := newTestRedisBroker(t, 100*time.Millisecond)
+	require.NoError(t, b.DeclareQueue("q", models.QueueOptions{}))
+	const total = 2*redisReadBatch + 5
+	for range total {
+		require.NoError(t, b.Publish(DefaultExchange, "q", Publishing{Body: []byte("work")}))
+	}
+
+	crashed, err := b.Consume("q", "c1")
+	require.NoError(t, err)
+	for range total {
+		receive(t, crashed) // never acked
+	}
+	require.NoError(t, b.Cancel("c1"))
+	for range crashed {
+	}
+
+	// c1 still owns pending entries, so it stays in the group
+	consumers := func() []string {
+		infos, err := b.client.XInfoConsumers(context.Background(), redisStreamKey("q"), redisGroup).Result()
+		require.NoError(t, err)
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"c1@" + b.instance}, consumers())
+
+	time.Sleep(150 * time.Millisecond)
+
+	deliveries, err := b.Consume("q", "c2")
+	require.NoError(t, err)
+	for range total {
+		msg := receive(t, deliveries)
+		assert.True(t, msg.Redelivered)
+		require.NoError(t, msg.Ack())
+	}
+
+	// Once its entries are reclaimed, the next claim pass prunes c1. miniredis
+	// does not report consumer idle times, so this needs a real server.
+	if os.Getenv("REDIS_TEST_URL") != "" {
+		assert.Eventually(t, func() bool {
+			names := consumers()
+			return len(names) == 1 && names[0] == "c2@"+b.instance
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	// A consumer stopped with nothing pending removes itself
+	require.NoError(t, b.Cancel("c2"))
+	for range deliveries {
+	}
+	assert.NotContains(t, consumers(), "c2@"+b.instance)
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/config"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	ReplayStatusRunning   = "running"
+	ReplayStatusCompleted = "completed"
+	ReplayStatusFailed    = "failed"
+
+	// HeaderReplay marks republished messages with the ID of the replay job
+	HeaderReplay = "x-replay"
+	// HeaderOriginalMessageID carries the ID of the stored row being replayed
+	HeaderOriginalMessageID = "x-original-message-id"
+
+	defaultReplayRate = 100
+	maxReplayRate     = 1000
+	replayBatchSize   = 200
+
+	// Finished jobs can be looked up for this long before they are pruned;
+	// job state lives in memory only and is lost on restart
+	replayJobRetention = time.Hour
+)
+
+// ReplayJob tracks the progress of a single replay
+type ReplayJob struct {
+	mu    sync.Mutex
+	state dto.ReplayJobDto
+}
+
+// Snapshot returns a copy of the job state that is safe to serialize
+func (j *ReplayJob) Snapshot() dto.ReplayJobDto {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+func (j *ReplayJob) update(fn func(state *dto.ReplayJobDto)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.state)
+}
+
+// ReplayService republishes stored messages back into their tenant queue
+type ReplayService struct {
+	messageRepository repositories.MessageRepository
+	publisher         *PublisherService
+	jobs              map[string]*ReplayJob
+	mu                sync.RWMutex
+}
+
+func NewReplayService(messageRepo repositories.MessageRepository, publisher *PublisherService) *ReplayService {
+	return &ReplayService{
+		messageRepository: messageRepo,
+		publisher:         publisher,
+		jobs:              make(map[string]*ReplayJob),
+	}
+}
+
+// Start counts the matching rows and republishes them in the background
+func (s *ReplayService) Start(tenantID uuid.UUID, req dto.ReplayRequestDto) (dto.ReplayJobDto, error) {
+	if req.Rate <= 0 {
+		req.Rate = defaultReplayRate
+	}
+	if req.Rate > maxReplayRate {
+		return dto.ReplayJobDto{}, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("rate must be <= %d", maxReplayRate))
+	}
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		return dto.ReplayJobDto{}, fiber.NewError(fiber.StatusBadRequest, "from must be before to")
+	}
+	if err := checkClearFilter(req.Filter); err != nil {
+		return dto.ReplayJobDto{}, err
+	}
+
+	// Bound open-ended ranges to the start time so replayed copies, which the
+	// consumer stores as new rows, are never picked up by the same job
+	if req.To == nil {
+		now := time.Now()
+		req.To = &now
+	}
+
+	filter := dto.MessageFilter{
+		TenantID: tenantID.String(),
+		From:     req.From,
+		To:       req.To,
+		Payload:  req.Filter,
+	}
+
+	total, err := s.messageRepository.CountMessages(context.Background(), filter)
+	if err != nil {
+		return dto.ReplayJobDto{}, err
+	}
+
+	jobID, _ := uuid.NewV7()
+	job := &ReplayJob{
+		state: dto.ReplayJobDto{
+			ID:        jobID.String(),
+			TenantID:  filter.TenantID,
+			Status:    ReplayStatusRunning,
+			Total:     total,
+			StartedAt: time.Now(),
+		},
+	}
+
+	s.mu.Lock()
+	s.prune(time.Now())
+	s.jobs[job.state.ID] = job
+	s.mu.Unlock()
+
+	go s.run(job, filter, req.Rate)
+
+	return job.Snapshot(), nil
+}
+
+// Get returns the state of a replay job belonging to the tenant
+func (s *ReplayService) Get(tenantID uuid.UUID, jobID string) (dto.ReplayJobDto, error) {
+	s.mu.RLock()
+	job, ok := s.jobs[jobID]
+	s.mu.RUnlock()
+
+	if !ok {
+		return dto.ReplayJobDto{}, fiber.NewError(fiber.StatusNotFound, "replay job not found")
+	}
+
+	state := job.Snapshot()
+	if state.TenantID != tenantID.String() {
+		return dto.ReplayJobDto{}, fiber.NewError(fiber.StatusNotFound, "replay job not found")
+	}
+	return state, nil
+}
+
+// prune drops jobs that finished more than replayJobRetention ago. The caller
+// holds s.mu.
+func (s *ReplayService) prune(now time.Time) {
+	for id, job := range s.jobs {
+		state := job.Snapshot()
+		if state.FinishedAt != nil && now.Sub(*state.FinishedAt) > replayJobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *ReplayService) run(job *ReplayJob, filter dto.MessageFilter, rate int) {
+	jobID := job.Snapshot().ID
+	queueName := TenantQueueName(filter.TenantID)
+	log.Printf("[Replay %s] Started for tenant %s at %d msg/s", jobID, filter.TenantID, rate)
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+
+	var err error
+	var batch []models.Message
+	afterID := ""
+	for {
+		batch, err = s.messageRepository.ListMessagesAfter(context.Background(), filter, afterID, replayBatchSize)
+		if err != nil || len(batch) == 0 {
+			break
+		}
+
+		for _, stored := range batch {
+			<-ticker.C
+
+			headers := map[string]any{
+				HeaderReplay:            jobID,
+				HeaderOriginalMessageID: stored.ID,
+			}
+			if err := s.publisher.PublishWithHeaders(queueName, replayMessage(stored), headers); err != nil {
+				log.Printf("[Replay %s] Failed to republish %s: %v", jobID, stored.ID, err)
+				job.update(func(state *dto.ReplayJobDto) { state.Failed++ })
+				continue
+			}
+
+			job.update(func(state *dto.ReplayJobDto) { state.Published++ })
+		}
+
+		afterID = batch[len(batch)-1].ID
+	}
+
+	finishedAt := time.Now()
+	job.update(func(state *dto.ReplayJobDto) {
+		state.FinishedAt = &finishedAt
+		state.Status = ReplayStatusCompleted
+		if err != nil {
+			state.Status = ReplayStatusFailed
+			state.Error = err.Error()
+		}
+	})
+
+	state := job.Snapshot()
+	log.Printf("[Replay %s] %s: %d published, %d failed of %d", jobID, state.Status, state.Published, state.Failed, state.Total)
+}
+
+// checkClearFilter rejects payload filters on fields that are encrypted at
+// rest: containment is matched in SQL, so they would silently match nothing
+func checkClearFilter(filter map[string]any) error {
+	if config.Cfg.MasterKeyFile == "" {
+		return nil
+	}
+	for _, path := range filterPaths(nil, filter) {
+		if !repositories.IsClearPath(config.Cfg.EncryptionClearFields, path) {
+			return fiber.NewError(fiber.StatusBadRequest,
+				fmt.Sprintf("%s is encrypted at rest, add it to ENCRYPTION_CLEAR_FIELDS to filter by it", strings.Join(path, ".")))
+		}
+	}
+	return nil
+}
+
+// filterPaths returns the path of every leaf value in a containment filter
+func filterPaths(prefix []string, filter map[string]any) [][]string {
+	var paths [][]string
+	for key, value := range filter {
+		path := append(append([]string{}, prefix...), key)
+		if nested, ok := value.(map[string]any); ok && len(nested) > 0 {
+			paths = append(paths, filterPaths(path, nested)...)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// replayMessage rebuilds the original queue message from a stored row.
+// Older rows hold the raw delivery body under "content", so that body is
+// decoded back into a Message when possible.
+func replayMessage(stored models.Message) Message {
+	if content, ok := stored.Payload["content"].(string); ok {
+		var original Message
+		if err := json.Unmarshal([]byte(content), &original); err == nil && original.Payload != nil {
+			original.TenantID = stored.TenantID
+			return original
+		}
+	}
+
+	return Message{
+		TenantID:      stored.TenantID,
+		EventType:     stored.EventType,
+		Payload:       map[string]any(stored.Payload),
+		SchemaVersion: stored.SchemaVersion,
+	}
+}
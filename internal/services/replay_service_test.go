@@ -0,0 +1,129 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/config"
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayMessageRepository adds CountMessages to the in-memory stream repository
+type replayMessageRepository struct {
+	streamMessageRepository
+}
+
+func (r *replayMessageRepository) CountMessages(ctx context.Context, filter dto.MessageFilter) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, message := range r.messages {
+		if message.TenantID == filter.TenantID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func TestReplayRepublishesStoredMessages(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-000000000121")
+	other := uuid.MustParse("0190d8a4-0000-7000-8000-000000000122")
+
+	repo := &replayMessageRepository{}
+	var ids []string
+	for i := range 5 {
+		id, _ := uuid.NewV7()
+		ids = append(ids, id.String())
+		require.NoError(t, repo.Store(dto.NewMessageDto{
+			ID: id.String(), TenantID: tenantID.String(), EventType: "orders.created", Payload: map[string]any{"n": i},
+		}))
+	}
+	// Legacy rows keep the raw delivery body under "content"
+	legacy, _ := json.Marshal(Message{TenantID: tenantID.String(), EventType: "orders.paid", Payload: map[string]any{"n": 5}})
+	id, _ := uuid.NewV7()
+	ids = append(ids, id.String())
+	require.NoError(t, repo.Store(dto.NewMessageDto{ID: id.String(), TenantID: tenantID.String(), Payload: map[string]any{"content": string(legacy)}}))
+	otherID, _ := uuid.NewV7()
+	require.NoError(t, repo.Store(dto.NewMessageDto{ID: otherID.String(), TenantID: other.String(), Payload: map[string]any{"n": 0}}))
+
+	broker := NewMemoryBroker()
+	queue := TenantQueueName(tenantID.String())
+	require.NoError(t, broker.DeclareQueue(queue, models.QueueOptions{}))
+	deliveries, err := broker.Consume(queue, "replay-test")
+	require.NoError(t, err)
+
+	replay := NewReplayService(repo, NewPublisherService(broker))
+	job, err := replay.Start(tenantID, dto.ReplayRequestDto{Rate: maxReplayRate})
+	require.NoError(t, err)
+	assert.Equal(t, ReplayStatusRunning, job.Status)
+	assert.Equal(t, int64(6), job.Total)
+
+	for i, storedID := range ids {
+		select {
+		case delivery := <-deliveries:
+			assert.Equal(t, job.ID, delivery.Headers[HeaderReplay])
+			assert.Equal(t, storedID, delivery.Headers[HeaderOriginalMessageID])
+			var msg Message
+			require.NoError(t, json.Unmarshal(delivery.Body, &msg))
+			assert.Equal(t, map[string]any{"n": float64(i)}, msg.Payload)
+			assert.Equal(t, tenantID.String(), msg.TenantID)
+			require.NoError(t, delivery.Ack())
+		case <-time.After(time.Second):
+			t.Fatalf("message %d was not replayed", i)
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		state, err := replay.Get(tenantID, job.ID)
+		return err == nil && state.Status == ReplayStatusCompleted
+	}, time.Second, 5*time.Millisecond)
+	state, _ := replay.Get(tenantID, job.ID)
+	assert.Equal(t, int64(6), state.Published)
+	assert.Zero(t, state.Failed)
+
+	// Jobs are only visible to their own tenant
+	_, err = replay.Get(other, job.ID)
+	assert.Error(t, err)
+}
+
+func TestReplayPrunesFinishedJobs(t *testing.T) {
+	replay := NewReplayService(&replayMessageRepository{}, NewPublisherService(NewMemoryBroker()))
+
+	now := time.Now()
+	old := now.Add(-2 * replayJobRetention)
+	recent := now.Add(-time.Minute)
+	replay.jobs["old"] = &ReplayJob{state: dto.ReplayJobDto{ID: "old", Status: ReplayStatusCompleted, FinishedAt: &old}}
+	replay.jobs["recent"] = &ReplayJob{state: dto.ReplayJobDto{ID: "recent", Status: ReplayStatusFailed, FinishedAt: &recent}}
+	replay.jobs["running"] = &ReplayJob{state: dto.ReplayJobDto{ID: "running", Status: ReplayStatusRunning, StartedAt: old}}
+
+	replay.prune(now)
+	assert.NotContains(t, replay.jobs, "old")
+	assert.Contains(t, replay.jobs, "recent")
+	assert.Contains(t, replay.jobs, "running")
+}
+
+func TestReplayRejectsEncryptedFilterPaths(t *testing.T) {
+	saved := config.Cfg
+	defer func() { config.Cfg = saved }()
+	config.Cfg.MasterKeyFile = "master.key"
+	config.Cfg.EncryptionClearFields = []string{"order_id", "customer.id"}
+
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-000000000123")
+	replay := NewReplayService(&replayMessageRepository{}, NewPublisherService(NewMemoryBroker()))
+
+	_, err := replay.Start(tenantID, dto.ReplayRequestDto{Filter: map[string]any{"customer": map[string]any{"email": "a@b.c"}}})
+	var fiberErr *fiber.Error
+	require.ErrorAs(t, err, &fiberErr)
+	assert.Equal(t, fiber.StatusBadRequest, fiberErr.Code)
+	assert.Contains(t, fiberErr.Message, "customer.email")
+
+	_, err = replay.Start(tenantID, dto.ReplayRequestDto{Filter: map[string]any{"order_id": "A-1", "customer": map[string]any{"id": 7}}})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/config"
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// MaxReplyWait caps how long a request waits for its reply
+const MaxReplyWait = 30 * time.Second
+
+// replyQueueExpires is how long a reply queue outlives its consumer, e.g.
+// when the instance died without calling Stop
+const replyQueueExpires = time.Minute
+
+// Reply answers a request published with PublishRequest
+type Reply struct {
+	CorrelationID string         `json:"correlation_id"`
+	TenantID      string         `json:"tenant_id"`
+	EventType     string         `json:"event_type,omitempty"`
+	Payload       map[string]any `json:"payload,omitempty"`
+	// Error is set when the tenant pipeline dead-lettered the request
+	Error string `json:"error,omitempty"`
+}
+
+// ReplyQueue receives the replies to the requests published by this instance.
+// Every instance consumes a queue of its own, so a reply reaches the instance
+// whose caller is waiting for it.
+type ReplyQueue struct {
+	broker      Broker
+	name        string
+	consumerTag string
+
+	mu      sync.Mutex
+	pending map[string]chan Reply
+}
+
+// NewReplyQueue constructor
+func NewReplyQueue(broker Broker) *ReplyQueue {
+	instanceID := uuid.NewString()
+	return &ReplyQueue{
+		broker:      broker,
+		name:        ReplyQueueName(instanceID),
+		consumerTag: fmt.Sprintf("reply-consumer-%s", instanceID),
+		pending:     make(map[string]chan Reply),
+	}
+}
+
+// ReplyQueueName returns the reply queue of an instance
+func ReplyQueueName(instanceID string) string {
+	return fmt.Sprintf("reply_%s", instanceID)
+}
+
+// Name returns the queue replies are sent to
+func (q *ReplyQueue) Name() string {
+	return q.name
+}
+
+// Start declares the reply queue and hands each reply to the request waiting
+// for it. The queue is deleted by Stop, or by the broker once it has been
+// without a consumer for replyQueueExpires.
+func (q *ReplyQueue) Start() error {
+	if err := q.broker.DeclareQueue(q.name, models.QueueOptions{Expires: replyQueueExpires}); err != nil {
+		return err
+	}
+	deliveries, err := q.broker.Consume(q.name, q.consumerTag)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for delivery := range deliveries {
+			q.received(delivery)
+			delivery.Ack()
+		}
+	}()
+	log.Printf("[Reply] Waiting for replies on %s", q.name)
+	return nil
+}
+
+// Stop ends the consumer and deletes the reply queue; requests still waiting
+// time out
+func (q *ReplyQueue) Stop() {
+	if err := q.broker.Cancel(q.consumerTag); err != nil {
+		log.Printf("[Reply] %v", err)
+	}
+	if err := q.broker.DeleteQueue(q.name); err != nil {
+		log.Printf("[Reply] Failed to delete %s: %v", q.name, err)
+	}
+}
+
+// Call publishes a request through publish, which must send it with the given
+// reply queue and correlation ID, and waits for the reply until ctx ends. A
+// request the tenant pipeline dead-lettered fails with 502, one without a
+// reply in time with 504.
+func (q *ReplyQueue) Call(ctx context.Context, publish func(replyTo, correlationID string) error) (Reply, error) {
+	correlationID := uuid.NewString()
+	replies := make(chan Reply, 1)
+
+	// Registered before publishing, the reply may come back right away
+	q.mu.Lock()
+	q.pending[correlationID] = replies
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, correlationID)
+		q.mu.Unlock()
+	}()
+
+	if err := publish(q.name, correlationID); err != nil {
+		return Reply{}, err
+	}
+
+	select {
+	case reply := <-replies:
+		if reply.Error != "" {
+			return reply, fiber.NewError(fiber.StatusBadGateway, "request failed: "+reply.Error)
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return Reply{CorrelationID: correlationID}, fiber.NewError(fiber.StatusGatewayTimeout,
+			fmt.Sprintf("no reply to request %s in time", correlationID))
+	}
+}
+
+func (q *ReplyQueue) received(delivery Delivery) {
+	body, err := DecompressPayload(delivery.Body, delivery.ContentEncoding, config.Cfg.MaxPayloadBytes)
+	if err != nil {
+		log.Printf("[Reply] Undecodable reply %s: %v", delivery.CorrelationID, err)
+		return
+	}
+	var reply Reply
+	if err := json.Unmarshal(body, &reply); err != nil {
+		log.Printf("[Reply] Undecodable reply %s: %v", delivery.CorrelationID, err)
+		return
+	}
+	reply.CorrelationID = delivery.CorrelationID
+
+	q.mu.Lock()
+	replies, ok := q.pending[delivery.CorrelationID]
+	delete(q.pending, delivery.CorrelationID)
+	q.mu.Unlock()
+	if !ok {
+		// The caller gave up, or this is a second reply
+		log.Printf("[Reply] No request waiting for reply %s", delivery.CorrelationID)
+		return
+	}
+	replies <- reply
+}
+
+// sendReply answers the request msg with its current payload, or with
+// errMessage when it failed
+func sendReply(broker Broker, msg PipelineMessage, errMessage string) error {
+	reply := Reply{
+		CorrelationID: msg.CorrelationID,
+		TenantID:      msg.TenantID,
+		EventType:     msg.EventType,
+		Error:         errMessage,
+	}
+	if errMessage == "" {
+		reply.Payload = msg.Payload
+	}
+	body, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	// Replies go straight to the queue of the waiting instance
+	return broker.PublishConfirmed(DefaultExchange, msg.ReplyTo, Publishing{
+		ContentType:   "application/json",
+		CorrelationID: msg.CorrelationID,
+		Body:          body,
+	})
+}
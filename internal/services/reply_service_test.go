@@ -0,0 +1,106 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestsWaitForTheirReply(t *testing.T) {
+	replying := uuid.MustParse("0190d8a4-0000-7000-8000-000000000101")
+	silent := uuid.MustParse("0190d8a4-0000-7000-8000-000000000102")
+	failing := uuid.MustParse("0190d8a4-0000-7000-8000-000000000103")
+	ctx := context.Background()
+
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{
+		{ID: replying.String(), Pipeline: models.Pipeline{
+			{Type: StepStore},
+			{Type: StepTransform, Set: map[string]any{"status": "approved"}},
+			{Type: StepReply},
+		}},
+		{ID: silent.String()},
+		// validate cannot be built without schemas, so every attempt fails
+		{ID: failing.String(), Pipeline: models.Pipeline{{Type: StepValidate}, {Type: StepReply}}},
+	}}
+	broker := NewMemoryBroker()
+	repo := &recordingMessageRepository{}
+	manager := NewTenantManager(broker, repo, tenants, newFakeBindingRepository())
+	manager.LimitRetries(2, time.Millisecond)
+	for _, id := range []uuid.UUID{replying, silent, failing} {
+		require.NoError(t, manager.AddDefaultBinding(ctx, id))
+		require.NoError(t, manager.StartTenantConsumer(ctx, id, 2))
+		defer manager.StopTenantConsumer(id)
+	}
+
+	// The handler turns requests to tenants without a reply step away
+	hasReply, err := manager.HasReplyStep(replying)
+	require.NoError(t, err)
+	assert.True(t, hasReply)
+	hasReply, err = manager.HasReplyStep(silent)
+	require.NoError(t, err)
+	assert.False(t, hasReply, "the default pipeline has no reply step")
+
+	recorder := &declareRecorder{MemoryBroker: broker, declared: map[string]models.QueueOptions{}}
+	replies := NewReplyQueue(recorder)
+	require.NoError(t, replies.Start())
+	defer replies.Stop()
+	// The queue of an instance that dies without Stop is not left behind
+	assert.Equal(t, replyQueueExpires, recorder.declared[replies.Name()].Expires)
+	publisher := NewPublisherService(broker)
+
+	call := func(tenantID uuid.UUID, wait time.Duration) (Reply, error) {
+		callCtx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		return replies.Call(callCtx, func(replyTo, correlationID string) error {
+			return publisher.PublishRequest(Message{
+				TenantID: tenantID.String(), EventType: "loans.requested", Payload: map[string]any{"amount": 100},
+			}, replyTo, correlationID)
+		})
+	}
+
+	// Concurrent requests each get their own reply
+	results := make(chan Reply, 5)
+	for range 5 {
+		go func() {
+			reply, err := call(replying, time.Second)
+			assert.NoError(t, err)
+			results <- reply
+		}()
+	}
+	seen := map[string]bool{}
+	for range 5 {
+		reply := <-results
+		assert.Equal(t, replying.String(), reply.TenantID)
+		assert.Equal(t, "loans.requested", reply.EventType)
+		assert.Equal(t, map[string]any{"amount": float64(100), "status": "approved"}, reply.Payload)
+		require.NoError(t, uuid.Validate(reply.CorrelationID))
+		seen[reply.CorrelationID] = true
+	}
+	assert.Len(t, seen, 5)
+	assert.Equal(t, 5, repo.count())
+
+	// Without a reply step the caller gives up after wait; the default
+	// pipeline still stores the message
+	_, err = call(silent, 50*time.Millisecond)
+	var fiberErr *fiber.Error
+	require.ErrorAs(t, err, &fiberErr)
+	assert.Equal(t, fiber.StatusGatewayTimeout, fiberErr.Code)
+
+	// A dead-lettered request fails right away with the reason
+	start := time.Now()
+	_, err = call(failing, 5*time.Second)
+	require.ErrorAs(t, err, &fiberErr)
+	assert.Equal(t, fiber.StatusBadGateway, fiberErr.Code)
+	assert.Contains(t, fiberErr.Message, "schema validation is not enabled")
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Messages published without waiting pass the reply step
+	require.NoError(t, publisher.PublishEvent(Message{TenantID: replying.String(), Payload: map[string]any{"amount": 1}}))
+	assert.Eventually(t, func() bool { return repo.count() == 7 }, time.Second, 5*time.Millisecond)
+}
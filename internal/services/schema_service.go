@@ -0,0 +1,214 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"aswadwk/messaging-task-go/internal/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// schemaResourceURL is where a tenant schema is registered in the compiler;
+// it only has to be an absolute URL, nothing is fetched from it
+const schemaResourceURL = "mem:///tenant-schema.json"
+
+var schemaPrinter = message.NewPrinter(language.English)
+
+// SchemaService keeps versioned JSON Schemas per tenant and validates
+// payloads against the tenant's active schema
+type SchemaService struct {
+	repo repositories.SchemaRepository
+
+	// Versions are immutable, so compiled schemas are cached by tenant and version
+	mu       sync.RWMutex
+	compiled map[string]*jsonschema.Schema
+}
+
+// NewSchemaService constructor
+func NewSchemaService(repo repositories.SchemaRepository) *SchemaService {
+	return &SchemaService{
+		repo:     repo,
+		compiled: make(map[string]*jsonschema.Schema),
+	}
+}
+
+// Register stores a new schema version after checking that it compiles
+func (s *SchemaService) Register(ctx context.Context, tenantID uuid.UUID, req dto.RegisterSchemaDto) (models.TenantSchema, error) {
+	if req.Schema == nil {
+		return models.TenantSchema{}, fiber.NewError(fiber.StatusBadRequest, "schema is required")
+	}
+	if _, err := compileSchema(req.Schema); err != nil {
+		return models.TenantSchema{}, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid schema: %v", err))
+	}
+
+	schema := models.TenantSchema{
+		TenantID: tenantID.String(),
+		Schema:   models.JSONB(req.Schema),
+		Active:   req.Activate == nil || *req.Activate,
+	}
+	if err := s.repo.Create(ctx, &schema); err != nil {
+		return models.TenantSchema{}, err
+	}
+	return schema, nil
+}
+
+// List returns every schema version of a tenant
+func (s *SchemaService) List(ctx context.Context, tenantID uuid.UUID) ([]models.TenantSchema, error) {
+	return s.repo.List(ctx, tenantID.String())
+}
+
+// Get returns a single schema version
+func (s *SchemaService) Get(ctx context.Context, tenantID uuid.UUID, version int) (models.TenantSchema, error) {
+	return s.repo.Find(ctx, tenantID.String(), version)
+}
+
+// Activate makes an existing version the one payloads are validated against
+func (s *SchemaService) Activate(ctx context.Context, tenantID uuid.UUID, version int) (models.TenantSchema, error) {
+	if err := s.repo.Activate(ctx, tenantID.String(), version); err != nil {
+		return models.TenantSchema{}, err
+	}
+	return s.repo.Find(ctx, tenantID.String(), version)
+}
+
+// Validate checks a payload against the tenant's active schema and returns the
+// version it was validated against, or nil when the tenant has no schema.
+// Validation failures are returned as utils.FieldErrors keyed by payload path.
+func (s *SchemaService) Validate(ctx context.Context, tenantID string, payload map[string]any) (*int, error) {
+	// Schemas are registered by tenant UUID, anything else cannot have one
+	if _, err := uuid.Parse(tenantID); err != nil {
+		return nil, nil
+	}
+
+	active, err := s.repo.FindActive(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if active == nil {
+		return nil, nil
+	}
+
+	schema, err := s.compiledSchema(*active)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePayload(schema, payload); err != nil {
+		return nil, err
+	}
+
+	version := active.Version
+	return &version, nil
+}
+
+func (s *SchemaService) compiledSchema(stored models.TenantSchema) (*jsonschema.Schema, error) {
+	key := fmt.Sprintf("%s:%d", stored.TenantID, stored.Version)
+
+	s.mu.RLock()
+	schema, ok := s.compiled[key]
+	s.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := compileSchema(stored.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile schema %s: %w", key, err)
+	}
+
+	s.mu.Lock()
+	s.compiled[key] = schema
+	s.mu.Unlock()
+	return schema, nil
+}
+
+// compileSchema compiles a schema document. External $refs are not resolved,
+// so a tenant schema cannot make the server read files or fetch URLs.
+func compileSchema(doc map[string]any) (*jsonschema.Schema, error) {
+	normalized, err := normalizeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.UseLoader(jsonschema.SchemeURLLoader{})
+	compiler.AssertFormat()
+	if err := compiler.AddResource(schemaResourceURL, normalized); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(schemaResourceURL)
+}
+
+func validatePayload(schema *jsonschema.Schema, payload map[string]any) error {
+	instance, err := normalizeJSON(payload)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid payload")
+	}
+
+	err = schema.Validate(instance)
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		return schemaFieldErrors(validationErr)
+	}
+	return err
+}
+
+// normalizeJSON round-trips a decoded document so numbers become json.Number,
+// which is what the schema library expects for exact numeric checks
+func normalizeJSON(v any) (any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+}
+
+// schemaFieldErrors flattens a validation error tree into messages keyed by
+// the dotted path of the offending field, e.g. "payload.customer.id"
+func schemaFieldErrors(err *jsonschema.ValidationError) utils.FieldErrors {
+	fields := utils.FieldErrors{}
+
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+
+		if required, ok := e.ErrorKind.(*kind.Required); ok {
+			for _, missing := range required.Missing {
+				field := payloadField(append(e.InstanceLocation, missing))
+				fields[field] = append(fields[field], "field is required")
+			}
+			return
+		}
+
+		field := payloadField(e.InstanceLocation)
+		fields[field] = append(fields[field], e.ErrorKind.LocalizedString(schemaPrinter))
+	}
+	walk(err)
+
+	for field := range fields {
+		sort.Strings(fields[field])
+	}
+	return fields
+}
+
+func payloadField(location []string) string {
+	return strings.Join(append([]string{"payload"}, location...), ".")
+}
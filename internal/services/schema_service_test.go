@@ -0,0 +1,76 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"aswadwk/messaging-task-go/internal/utils"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type activeSchemaRepository struct {
+	repositories.SchemaRepository
+	active *models.TenantSchema
+}
+
+func (r *activeSchemaRepository) FindActive(ctx context.Context, tenantID string) (*models.TenantSchema, error) {
+	return r.active, nil
+}
+
+var orderSchema = map[string]any{
+	"type":     "object",
+	"required": []any{"order_id", "customer"},
+	"properties": map[string]any{
+		"order_id": map[string]any{"type": "string"},
+		"amount":   map[string]any{"type": "number", "minimum": 0},
+		"customer": map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"email": map[string]any{"type": "string", "format": "email"}},
+		},
+	},
+}
+
+func TestSchemaValidateReturnsFieldErrors(t *testing.T) {
+	const tenantID = "0190d8a4-0000-7000-8000-0000000000aa"
+	s := NewSchemaService(&activeSchemaRepository{
+		active: &models.TenantSchema{TenantID: tenantID, Version: 2, Schema: orderSchema, Active: true},
+	})
+
+	version, err := s.Validate(context.Background(), tenantID, map[string]any{
+		"order_id": "A-1",
+		"amount":   12.5,
+		"customer": map[string]any{"email": "jane@example.com"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, version)
+	assert.Equal(t, 2, *version)
+
+	_, err = s.Validate(context.Background(), tenantID, map[string]any{
+		"amount":   -1,
+		"customer": map[string]any{"email": "not-an-email"},
+	})
+	var fields utils.FieldErrors
+	require.ErrorAs(t, err, &fields)
+	assert.Contains(t, fields, "payload.order_id")
+	assert.Contains(t, fields, "payload.amount")
+	assert.Contains(t, fields, "payload.customer.email")
+}
+
+func TestSchemaValidateWithoutActiveSchema(t *testing.T) {
+	s := NewSchemaService(&activeSchemaRepository{})
+
+	version, err := s.Validate(context.Background(), "0190d8a4-0000-7000-8000-0000000000aa", map[string]any{"anything": true})
+	require.NoError(t, err)
+	assert.Nil(t, version)
+}
+
+func TestCompileSchemaRejectsExternalRefs(t *testing.T) {
+	_, err := compileSchema(map[string]any{"$ref": "file:///etc/passwd"})
+	assert.Error(t, err)
+
+	_, err = compileSchema(map[string]any{"type": "no-such-type"})
+	assert.Error(t, err)
+}
@@ -2,44 +2,431 @@ package services
 
 import (
 	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/config"
 	"aswadwk/messaging-task-go/internal/models"
 	"aswadwk/messaging-task-go/internal/repositories"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	"github.com/streadway/amqp"
 )
 
 type TenantManager struct {
-	rabbit            *RabbitMQ
+	broker            Broker
 	consumers         map[string]*TenantConsumer
 	mu                sync.Mutex
 	messageRepository repositories.MessageRepository
+	tenantRepository  repositories.TenantRepository
+	bindingRepository repositories.BindingRepository
+	usage             *UsageRecorder
+	schemas           *SchemaService
+	webhooks          *WebhookService
+	stream            *MessageStream
+
+	// bindingLister lets StartTenantConsumer remove broker bindings that are
+	// no longer stored
+	bindingLister BindingLister
+
+	bindingsMu sync.Mutex
+	bindings   map[string]cachedBindings
+
+	pipelinesMu sync.Mutex
+	pipelines   map[string]cachedPipeline
+
+	maxAttempts  int
+	retryBackoff time.Duration
+}
+
+// cachedBindings keeps a tenant's binding patterns for handleMessage. They are
+// reloaded after bindingCacheTTL so changes made on other instances show up.
+type cachedBindings struct {
+	patterns []string
+	loadedAt time.Time
+}
+
+const bindingCacheTTL = time.Minute
+
+// cachedPipeline keeps a tenant's built pipeline, reloaded like the bindings
+type cachedPipeline struct {
+	pipeline *MessagePipeline
+	loadedAt time.Time
 }
 
+// Defaults of the pipeline retries
+const (
+	DefaultPipelineMaxAttempts  = 5
+	DefaultPipelineRetryBackoff = 500 * time.Millisecond
+	maxPipelineRetryBackoff     = 30 * time.Second
+)
+
+// Headers carried by retried and dead-lettered messages
+const (
+	headerPipelineStep = "x-pipeline-step"
+	headerAttempts     = "x-attempts"
+	headerDeathReason  = "x-death-reason"
+	headerForwardHops  = "x-forward-hops"
+)
+
 // TenantConsumer menyimpan control untuk setiap tenant
 type TenantConsumer struct {
+	tag        string
 	stopChan   chan struct{}
 	doneChan   chan struct{}
 	workerPool *WorkerPool // Optional: kalau kamu pakai worker pool
 }
 
+// TenantQueueName returns the queue that holds messages for a tenant
+func TenantQueueName(tenantID string) string {
+	return fmt.Sprintf("tenant_%s_queue", tenantID)
+}
+
+// TenantDeadLetterQueueName returns the queue holding the messages a tenant
+// pipeline gave up on
+func TenantDeadLetterQueueName(tenantID string) string {
+	return fmt.Sprintf("tenant_%s_dlq", tenantID)
+}
+
+// DeadLetterQueueName returns the dead-letter queue of any queue: the tenant
+// dead-letter queue for tenant queues, "<queue>_dlq" otherwise
+func DeadLetterQueueName(queueName string) string {
+	if tenantID, ok := strings.CutPrefix(queueName, "tenant_"); ok {
+		if tenantID, ok := strings.CutSuffix(tenantID, "_queue"); ok {
+			return TenantDeadLetterQueueName(tenantID)
+		}
+	}
+	return queueName + "_dlq"
+}
+
+// DefaultEventType is used for messages published without an event_type
+const DefaultEventType = "message"
+
+// DefaultBindingPattern subscribes a tenant to every event type
+const DefaultBindingPattern = "#"
+
+// TenantRoutingKey returns the topic exchange routing key of an event
+func TenantRoutingKey(tenantID, eventType string) string {
+	return tenantID + "." + eventType
+}
+
 // NewTenantManager inisialisasi manager
 func NewTenantManager(
-	rabbit *RabbitMQ,
+	broker Broker,
 	messageRepo repositories.MessageRepository,
+	tenantRepo repositories.TenantRepository,
+	bindingRepo repositories.BindingRepository,
 ) *TenantManager {
 	return &TenantManager{
-		rabbit:            rabbit,
+		broker:            broker,
 		consumers:         make(map[string]*TenantConsumer),
 		messageRepository: messageRepo,
+		tenantRepository:  tenantRepo,
+		bindingRepository: bindingRepo,
+		bindings:          make(map[string]cachedBindings),
+		pipelines:         make(map[string]cachedPipeline),
+		maxAttempts:       DefaultPipelineMaxAttempts,
+		retryBackoff:      DefaultPipelineRetryBackoff,
 	}
 }
 
+// EnableUsage menghitung pesan yang disimpan dan gagal per tenant
+func (tm *TenantManager) EnableUsage(usage *UsageRecorder) {
+	tm.usage = usage
+}
+
+// EnableValidation lets tenant pipelines use the validate step
+func (tm *TenantManager) EnableValidation(schemas *SchemaService) {
+	tm.schemas = schemas
+}
+
+// EnableWebhooks lets tenant pipelines send messages to the tenant webhooks
+func (tm *TenantManager) EnableWebhooks(webhooks *WebhookService) {
+	tm.webhooks = webhooks
+}
+
+// EnableStreaming pushes stored messages to the live stream subscribers
+func (tm *TenantManager) EnableStreaming(stream *MessageStream) {
+	tm.stream = stream
+}
+
+// SyncBindings makes StartTenantConsumer remove broker bindings of the tenant
+// queue that are no longer stored, e.g. when removing them failed
+func (tm *TenantManager) SyncBindings(lister BindingLister) {
+	tm.bindingLister = lister
+}
+
+// LimitRetries sets how often a pipeline retries a message before it is
+// dead-lettered, and the backoff before the first retry; it doubles on every
+// further attempt
+func (tm *TenantManager) LimitRetries(maxAttempts int, backoff time.Duration) {
+	if maxAttempts > 0 {
+		tm.maxAttempts = maxAttempts
+	}
+	if backoff > 0 {
+		tm.retryBackoff = backoff
+	}
+}
+
+// SaveTenant mencatat tenant beserta jumlah worker-nya
+func (tm *TenantManager) SaveTenant(ctx context.Context, tenantID uuid.UUID, workers int) error {
+	return tm.tenantRepository.Save(ctx, &models.Tenant{
+		ID:      tenantID.String(),
+		Workers: workers,
+	})
+}
+
+// SetLabels mengganti label tenant yang dipakai untuk broadcast
+func (tm *TenantManager) SetLabels(ctx context.Context, tenantID uuid.UUID, labels map[string]string) error {
+	for key := range labels {
+		if key == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "label keys cannot be empty")
+		}
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return tm.tenantRepository.SetLabels(ctx, tenantID.String(), labels)
+}
+
+// SetQueueOptions menyimpan argumen queue tenant. Options only take effect
+// when the queue is declared, RabbitMQ refuses to redeclare an existing queue
+// with other arguments.
+func (tm *TenantManager) SetQueueOptions(ctx context.Context, tenantID uuid.UUID, options models.QueueOptions) error {
+	if err := tm.ValidateQueueOptions(options); err != nil {
+		return err
+	}
+	return tm.tenantRepository.SetQueueOptions(ctx, tenantID.String(), options)
+}
+
+// ValidateQueueOptions rejects options RabbitMQ would refuse and options the
+// configured broker cannot apply
+func (tm *TenantManager) ValidateQueueOptions(options models.QueueOptions) error {
+	if err := ValidateQueueOptions(options); err != nil {
+		return err
+	}
+	if checker, ok := tm.broker.(queueOptionsChecker); ok {
+		return checker.CheckQueueOptions(options)
+	}
+	return nil
+}
+
+// GetPipeline returns the pipeline steps of a tenant, the default pipeline
+// when none is configured
+func (tm *TenantManager) GetPipeline(ctx context.Context, tenantID uuid.UUID) (models.Pipeline, error) {
+	tenant, err := tm.tenantRepository.Find(ctx, tenantID.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(tenant.Pipeline) == 0 {
+		return DefaultPipeline, nil
+	}
+	return tenant.Pipeline, nil
+}
+
+// HasReplyStep reports whether the tenant pipeline answers requests, i.e. has
+// a reply step; without one a request waiting for a reply always times out
+func (tm *TenantManager) HasReplyStep(tenantID uuid.UUID) (bool, error) {
+	pipeline, err := tm.tenantPipeline(tenantID.String())
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(pipeline.names, StepReply), nil
+}
+
+// SetPipeline menyimpan pipeline tenant. Messages already being processed
+// finish with the old steps.
+func (tm *TenantManager) SetPipeline(ctx context.Context, tenantID uuid.UUID, steps models.Pipeline) error {
+	if _, err := tm.buildPipeline(tenantID.String(), steps); err != nil {
+		return err
+	}
+	id := tenantID.String()
+	if err := tm.tenantRepository.SetPipeline(ctx, id, steps); err != nil {
+		return err
+	}
+	tm.forgetPipeline(id)
+	return nil
+}
+
+// RemoveTenant menghapus catatan tenant beserta partition, binding, webhook
+// dan dead-letter queue-nya
+func (tm *TenantManager) RemoveTenant(ctx context.Context, tenantID uuid.UUID) error {
+	id := tenantID.String()
+	// Dropped before the row, so a failure never leaves a partition nobody owns
+	if err := tm.messageRepository.DropPartition(tenantID); err != nil {
+		return err
+	}
+	if err := tm.bindingRepository.DeleteAll(ctx, id); err != nil {
+		return err
+	}
+	if tm.webhooks != nil {
+		if err := tm.webhooks.DeleteAll(ctx, id); err != nil {
+			return err
+		}
+	}
+	tm.forgetBindings(id)
+	tm.forgetPipeline(id)
+	if err := tm.broker.DeleteQueue(TenantDeadLetterQueueName(id)); err != nil {
+		log.Printf("[TenantManager] Failed to delete dead-letter queue: %v", err)
+	}
+	return tm.tenantRepository.Delete(ctx, id)
+}
+
+// ListBindings returns the binding patterns of a tenant
+func (tm *TenantManager) ListBindings(ctx context.Context, tenantID uuid.UUID) ([]models.TenantBinding, error) {
+	return tm.bindingRepository.List(ctx, tenantID.String())
+}
+
+// AddDefaultBinding subscribes a new tenant to every event type. It is a no-op
+// when the tenant already has the binding.
+func (tm *TenantManager) AddDefaultBinding(ctx context.Context, tenantID uuid.UUID) error {
+	_, err := tm.bindingRepository.Create(ctx, &models.TenantBinding{
+		TenantID: tenantID.String(),
+		Pattern:  DefaultBindingPattern,
+	})
+	tm.forgetBindings(tenantID.String())
+	return err
+}
+
+// AddBinding subscribes a tenant to the event types matching pattern. The
+// queue is bound right away, whichever instance runs the tenant consumer.
+func (tm *TenantManager) AddBinding(ctx context.Context, tenantID uuid.UUID, pattern string) (*models.TenantBinding, error) {
+	if !ValidBindingPattern(pattern) {
+		return nil, fiber.NewError(fiber.StatusBadRequest,
+			"pattern must be dot separated words of letters, digits, _ or -, or the wildcards * and #")
+	}
+
+	id := tenantID.String()
+	binding := &models.TenantBinding{TenantID: id, Pattern: pattern}
+	created, err := tm.bindingRepository.Create(ctx, binding)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("binding %s already exists", pattern))
+	}
+	tm.forgetBindings(id)
+
+	if err := tm.declareQueues(ctx, id); err != nil {
+		return nil, err
+	}
+	if err := tm.broker.BindQueue(TenantQueueName(id), TopicExchange, TenantRoutingKey(id, pattern)); err != nil {
+		return nil, err
+	}
+	return binding, nil
+}
+
+// RemoveBinding unsubscribes a tenant from pattern
+func (tm *TenantManager) RemoveBinding(ctx context.Context, tenantID uuid.UUID, pattern string) error {
+	id := tenantID.String()
+	found, err := tm.bindingRepository.Delete(ctx, id, pattern)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("binding %s not found", pattern))
+	}
+	tm.forgetBindings(id)
+
+	if err := tm.declareQueues(ctx, id); err != nil {
+		return err
+	}
+	return tm.broker.UnbindQueue(TenantQueueName(id), TopicExchange, TenantRoutingKey(id, pattern))
+}
+
+// declareQueues declares the tenant queue with its stored arguments and the
+// tenant dead letter queue
+func (tm *TenantManager) declareQueues(ctx context.Context, tenantID string) error {
+	tenant, err := tm.tenantRepository.Find(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if err := tm.broker.DeclareQueue(TenantQueueName(tenantID), tenant.QueueOptions); err != nil {
+		return err
+	}
+	return tm.broker.DeclareQueue(TenantDeadLetterQueueName(tenantID), models.QueueOptions{})
+}
+
+// bindQueue binds the tenant queue to the stored patterns and, with a
+// bindingLister, unbinds the routing keys that are no longer stored
+func (tm *TenantManager) bindQueue(ctx context.Context, tenantID string) error {
+	queueName := TenantQueueName(tenantID)
+	bindings, err := tm.bindingRepository.List(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+
+	stored := make(map[string]bool, len(bindings))
+	for _, binding := range bindings {
+		routingKey := TenantRoutingKey(tenantID, binding.Pattern)
+		stored[routingKey] = true
+		if err := tm.broker.BindQueue(queueName, TopicExchange, routingKey); err != nil {
+			return err
+		}
+	}
+	if tm.bindingLister == nil {
+		return nil
+	}
+
+	bound, err := tm.bindingLister.ListBindings(ctx, queueName, TopicExchange)
+	if err != nil {
+		return err
+	}
+	for _, routingKey := range bound {
+		if stored[routingKey] {
+			continue
+		}
+		if err := tm.broker.UnbindQueue(queueName, TopicExchange, routingKey); err != nil {
+			return err
+		}
+		log.Printf("[TenantManager] Removed stale binding %s of %s", routingKey, queueName)
+	}
+	return nil
+}
+
+// RestoreTenants starts a consumer for every registered tenant, so tenants
+// survive a restart instead of living only in memory
+func (tm *TenantManager) RestoreTenants(ctx context.Context) error {
+	tenants, err := tm.tenantRepository.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, tenant := range tenants {
+		tenantID, err := uuid.Parse(tenant.ID)
+		if err != nil {
+			continue
+		}
+		if err := tm.StartTenantConsumer(ctx, tenantID, tenant.Workers); err != nil {
+			log.Printf("[TenantManager] Failed to restore tenant %s: %v", tenant.ID, err)
+			continue
+		}
+	}
+
+	log.Printf("[TenantManager] Restored %d tenant(s)", len(tenants))
+	return nil
+}
+
+// RunningTenants returns the IDs of tenants with an active consumer
+func (tm *TenantManager) RunningTenants() []string {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	ids := make([]string, 0, len(tm.consumers))
+	for id := range tm.consumers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // StartTenantConsumer membuat queue & mulai consumer baru
 func (tm *TenantManager) StartTenantConsumer(ctx context.Context, tenantID uuid.UUID, concurrency int) error {
 	tm.mu.Lock()
@@ -50,16 +437,23 @@ func (tm *TenantManager) StartTenantConsumer(ctx context.Context, tenantID uuid.
 		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("tenant %s already exists", id))
 	}
 
-	queueName := fmt.Sprintf("tenant_%s_queue", id)
+	queueName := TenantQueueName(id)
 
-	// Declare queue
-	if _, err := tm.rabbit.DeclareQueue(queueName); err != nil {
+	// Declare queue with the arguments stored for the tenant
+	if err := tm.declareQueues(ctx, id); err != nil {
+		return err
+	}
+	if err := tm.bindQueue(ctx, id); err != nil {
+		return err
+	}
+	// Broadcasts to every tenant reach the queue through the fanout exchange
+	if err := tm.broker.BindQueue(queueName, FanoutExchange, ""); err != nil {
 		return err
 	}
 
 	// Start consuming
 	consumerTag := fmt.Sprintf("consumer_%s", id)
-	msgs, err := tm.rabbit.ConsumeMessages(queueName, consumerTag)
+	msgs, err := tm.broker.Consume(queueName, consumerTag)
 	if err != nil {
 		return err
 	}
@@ -77,13 +471,20 @@ func (tm *TenantManager) StartTenantConsumer(ctx context.Context, tenantID uuid.
 
 		for {
 			select {
-			case msg := <-msgs:
+			case msg, ok := <-msgs:
+				if !ok {
+					log.Printf("[TenantManager] Deliveries closed for tenant %s", id)
+					return
+				}
 				if pool != nil {
-					pool.Submit(func() {
-						tm.handleMessage(id, msg)
+					// Messages sharing an ordering key run one after another,
+					// among the messages this consumer receives
+					orderingKey, _ := msg.Headers[HeaderOrderingKey].(string)
+					pool.SubmitOrdered(orderingKey, func() {
+						tm.handleMessage(id, msg, done)
 					})
 				} else {
-					tm.handleMessage(id, msg)
+					tm.handleMessage(id, msg, done)
 				}
 			case <-stop:
 				log.Printf("[TenantManager] Stopping consumer for tenant %s", id)
@@ -93,6 +494,7 @@ func (tm *TenantManager) StartTenantConsumer(ctx context.Context, tenantID uuid.
 	}()
 
 	tm.consumers[id] = &TenantConsumer{
+		tag:        consumerTag,
 		stopChan:   stop,
 		doneChan:   done,
 		workerPool: pool,
@@ -112,18 +514,11 @@ func (tm *TenantManager) StopTenantConsumer(tenantID uuid.UUID) error {
 		return fmt.Errorf("tenant %s not found", id)
 	}
 
-	// Stop worker pool
-	if consumer.workerPool != nil {
-		consumer.workerPool.Stop()
-	}
-
-	// Stop consuming goroutine
-	close(consumer.stopChan)
-	<-consumer.doneChan
+	tm.stopConsumer(id, consumer)
 
 	// Optional: Hapus queue (kalau memang mau hapus)
-	queueName := fmt.Sprintf("tenant_%s_queue", id)
-	if err := tm.rabbit.DeleteQueue(queueName); err != nil {
+	queueName := TenantQueueName(id)
+	if err := tm.broker.DeleteQueue(queueName); err != nil {
 		log.Printf("[TenantManager] Failed to delete queue: %v", err)
 	}
 
@@ -140,11 +535,22 @@ func (tm *TenantManager) GracefulShutdown() {
 
 	for tenantID, consumer := range tm.consumers {
 		log.Printf("[Shutdown] Stopping tenant: %s", tenantID)
-		if consumer.workerPool != nil {
-			consumer.workerPool.Stop()
-		}
+		tm.stopConsumer(tenantID, consumer)
+	}
+}
+
+// stopConsumer membatalkan consumer di broker, menunggu deliveries yang sudah
+// diterima selesai diproses (dan di-ack), lalu menghentikan worker pool
+func (tm *TenantManager) stopConsumer(tenantID string, consumer *TenantConsumer) {
+	if err := tm.broker.Cancel(consumer.tag); err != nil {
+		// Deliveries may never close, stop reading them right away
+		log.Printf("[TenantManager] Failed to cancel consumer for tenant %s: %v", tenantID, err)
 		close(consumer.stopChan)
-		<-consumer.doneChan
+	}
+	<-consumer.doneChan
+
+	if consumer.workerPool != nil {
+		consumer.workerPool.Stop()
 	}
 }
 
@@ -161,11 +567,271 @@ func (tm *TenantManager) GetMessages(cursor int) (dto.QueryResponse, error) {
 	return tm.messageRepository.GetMessages(cursor)
 }
 
-func (tm *TenantManager) handleMessage(tenantID string, msg amqp.Delivery) {
-	log.Printf("[Tenant %s] Received: %s", tenantID, msg.Body)
+// handleMessage runs the tenant pipeline on a delivery. Retryable failures are
+// published to the queue again with a delay of the backoff and resume at the
+// failing step; the worker moves on right away. A message with an ordering key
+// is retried in place instead, after the backoff, so the later messages of its
+// key wait behind it on the worker; once stopped is closed it is published
+// for a delayed retry like the others. Other failures, and messages out of
+// attempts, go to the dead-letter queue.
+func (tm *TenantManager) handleMessage(tenantID string, msg Delivery, stopped <-chan struct{}) {
+	body, err := DecompressPayload(msg.Body, msg.ContentEncoding, config.Cfg.MaxPayloadBytes)
+	if err != nil {
+		log.Printf("[Tenant %s] Undecodable message: %v", tenantID, err)
+		tm.usage.Failed(tenantID)
+		tm.deadLetter(tenantID, msg, msg.Publishing, "decode", err)
+		return
+	}
+	log.Printf("[Tenant %s] Received %d bytes (%d on the wire)", tenantID, len(body), len(msg.Body))
+
+	message := PipelineMessage{
+		NewMessageDto: storedMessage(tenantID, body),
+		Size:          len(body),
+		ReplyTo:       msg.ReplyTo,
+		CorrelationID: msg.CorrelationID,
+		Hops:          headerInt(msg.Headers, headerForwardHops),
+	}
+	message.OrderingKey, _ = msg.Headers[HeaderOrderingKey].(string)
+	// Broadcasts bypass the tenant bindings
+	if message.BroadcastID == "" {
+		message.Binding = tm.matchingBinding(tenantID, message.EventType)
+	}
+
+	attempts := headerInt(msg.Headers, headerAttempts)
+	step := headerInt(msg.Headers, headerPipelineStep)
+	for {
+		pipeline, err := tm.tenantPipeline(tenantID)
+		if err == nil {
+			step, err = pipeline.Run(context.Background(), &message, step)
+		} else {
+			err = Retryable(err)
+		}
+		if err == nil || errors.Is(err, ErrDropMessage) {
+			msg.Ack()
+			return
+		}
+
+		stepName := "load"
+		if pipeline != nil {
+			stepName = pipeline.StepName(step)
+		}
+		log.Printf("[Tenant %s] Pipeline step %s failed: %v", tenantID, stepName, err)
+		tm.usage.Failed(tenantID)
+
+		var retryable *RetryableError
+		if !errors.As(err, &retryable) || attempts+1 >= tm.maxAttempts {
+			tm.deadLetter(tenantID, msg, pipelineRetry(message, step, attempts+1), stepName, err)
+			return
+		}
+		// A delayed retry would be overtaken by the later messages of the key
+		if message.OrderingKey == "" || !waitUnlessStopped(tm.backoff(attempts), stopped) {
+			break
+		}
+		attempts++
+	}
+
+	retry := pipelineRetry(message, step, attempts+1)
+	if err := tm.broker.PublishDelayed(TenantQueueName(tenantID), retry, tm.backoff(attempts)); err != nil {
+		log.Printf("[Tenant %s] Failed to schedule retry: %v", tenantID, err)
+		msg.Nack(true)
+		return
+	}
+	msg.Ack()
+}
+
+// deadLetter moves a message to the tenant dead-letter queue with the reason
+// it failed. When that fails the delivery is requeued instead of being lost.
+func (tm *TenantManager) deadLetter(tenantID string, msg Delivery, failed Publishing, step string, cause error) {
+	headers := make(map[string]any, len(failed.Headers)+1)
+	for key, value := range failed.Headers {
+		headers[key] = value
+	}
+	reason := fmt.Sprintf("%s: %v", step, cause)
+	headers[headerDeathReason] = reason
+	failed.Headers = headers
+
+	if err := tm.broker.PublishConfirmed(DefaultExchange, TenantDeadLetterQueueName(tenantID), failed); err != nil {
+		log.Printf("[Tenant %s] Failed to dead-letter message: %v", tenantID, err)
+		msg.Nack(true)
+		return
+	}
+	tm.usage.DeadLettered(tenantID)
+	msg.Ack()
+
+	// Fail a waiting request now instead of letting it time out
+	if failed.ReplyTo != "" {
+		request := PipelineMessage{ReplyTo: failed.ReplyTo, CorrelationID: failed.CorrelationID}
+		request.TenantID = tenantID
+		if err := sendReply(tm.broker, request, reason); err != nil {
+			log.Printf("[Tenant %s] Failed to reply to %s: %v", tenantID, failed.CorrelationID, err)
+		}
+	}
+}
+
+// waitUnlessStopped waits for delay and reports false when stopped closes first
+func waitUnlessStopped(delay time.Duration, stopped <-chan struct{}) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stopped:
+		return false
+	}
+}
+
+// backoff is the delay before retry attempts+1
+func (tm *TenantManager) backoff(attempts int) time.Duration {
+	delay := tm.retryBackoff
+	for range attempts {
+		delay *= 2
+		if delay >= maxPipelineRetryBackoff {
+			return maxPipelineRetryBackoff
+		}
+	}
+	return delay
+}
+
+// tenantPipeline returns the cached pipeline of a tenant
+func (tm *TenantManager) tenantPipeline(tenantID string) (*MessagePipeline, error) {
+	tm.pipelinesMu.Lock()
+	cached, ok := tm.pipelines[tenantID]
+	tm.pipelinesMu.Unlock()
+	if ok && time.Since(cached.loadedAt) < bindingCacheTTL {
+		return cached.pipeline, nil
+	}
+
+	tenant, err := tm.tenantRepository.Find(context.Background(), tenantID)
+	if err != nil {
+		if ok {
+			log.Printf("[Tenant %s] Failed to reload pipeline: %v", tenantID, err)
+			return cached.pipeline, nil
+		}
+		return nil, err
+	}
+	pipeline, err := tm.buildPipeline(tenantID, tenant.Pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	tm.pipelinesMu.Lock()
+	tm.pipelines[tenantID] = cachedPipeline{pipeline: pipeline, loadedAt: time.Now()}
+	tm.pipelinesMu.Unlock()
+	return pipeline, nil
+}
+
+func (tm *TenantManager) forgetPipeline(tenantID string) {
+	tm.pipelinesMu.Lock()
+	delete(tm.pipelines, tenantID)
+	tm.pipelinesMu.Unlock()
+}
 
-	tm.messageRepository.Store(dto.NewMessageDto{
-		TenantID: tenantID,
-		Payload:  models.JSONB{"content": string(msg.Body)},
+// pipelineRetry encodes the message as processed so far, so a retry resumes
+// at step without running the earlier steps again
+func pipelineRetry(msg PipelineMessage, step, attempts int) Publishing {
+	headers := map[string]any{headerPipelineStep: step, headerAttempts: attempts}
+	if msg.OrderingKey != "" {
+		headers[HeaderOrderingKey] = msg.OrderingKey
+	}
+	if msg.Hops > 0 {
+		headers[headerForwardHops] = msg.Hops
+	}
+	body, _ := json.Marshal(Message{
+		TenantID:      msg.TenantID,
+		EventType:     msg.EventType,
+		Payload:       msg.Payload,
+		SchemaVersion: msg.SchemaVersion,
+		BroadcastID:   msg.BroadcastID,
 	})
+	return Publishing{
+		ContentType: "application/json",
+		Headers:     headers,
+		Body:        body,
+		// The reply step may still run
+		ReplyTo:       msg.ReplyTo,
+		CorrelationID: msg.CorrelationID,
+	}
+}
+
+// headerInt reads an integer header; brokers hand numbers back as different
+// integer types, or as float64 after a JSON round trip
+func headerInt(headers map[string]any, key string) int {
+	switch value := headers[key].(type) {
+	case int:
+		return value
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	case float64:
+		return int(value)
+	}
+	return 0
+}
+
+// matchingBinding returns the first binding of the tenant that matches
+// eventType, or "" when none does, e.g. for messages sent straight to the queue
+func (tm *TenantManager) matchingBinding(tenantID, eventType string) string {
+	if eventType == "" {
+		return ""
+	}
+	for _, pattern := range tm.tenantBindings(tenantID) {
+		if topicMatch(pattern, eventType) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// tenantBindings returns the cached binding patterns of a tenant
+func (tm *TenantManager) tenantBindings(tenantID string) []string {
+	tm.bindingsMu.Lock()
+	cached, ok := tm.bindings[tenantID]
+	tm.bindingsMu.Unlock()
+	if ok && time.Since(cached.loadedAt) < bindingCacheTTL {
+		return cached.patterns
+	}
+
+	bindings, err := tm.bindingRepository.List(context.Background(), tenantID)
+	if err != nil {
+		log.Printf("[Tenant %s] Failed to load bindings: %v", tenantID, err)
+		return cached.patterns
+	}
+	patterns := make([]string, len(bindings))
+	for i, binding := range bindings {
+		patterns[i] = binding.Pattern
+	}
+
+	tm.bindingsMu.Lock()
+	tm.bindings[tenantID] = cachedBindings{patterns: patterns, loadedAt: time.Now()}
+	tm.bindingsMu.Unlock()
+	return patterns
+}
+
+func (tm *TenantManager) forgetBindings(tenantID string) {
+	tm.bindingsMu.Lock()
+	delete(tm.bindings, tenantID)
+	tm.bindingsMu.Unlock()
+}
+
+// storedMessage keeps the published payload as structured JSONB so it can be
+// filtered and erased by path; bodies that are not a Message are kept verbatim.
+// The event type and the schema version the publisher validated against are
+// kept alongside.
+func storedMessage(tenantID string, body []byte) dto.NewMessageDto {
+	stored := dto.NewMessageDto{TenantID: tenantID}
+
+	var message Message
+	if err := json.Unmarshal(body, &message); err == nil {
+		if payload, ok := message.Payload.(map[string]any); ok {
+			stored.Payload = payload
+			stored.EventType = message.EventType
+			stored.SchemaVersion = message.SchemaVersion
+			stored.BroadcastID = message.BroadcastID
+			return stored
+		}
+	}
+
+	stored.Payload = map[string]any{"content": string(body)}
+	return stored
 }
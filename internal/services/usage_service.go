@@ -0,0 +1,238 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	UsageBucketHour = "hour"
+	UsageBucketDay  = "day"
+
+	// Bounds on the number of points a single stats request returns
+	maxHourBuckets = 31 * 24
+	maxDayBuckets  = 366
+)
+
+type usageKey struct {
+	tenantID string
+	bucket   time.Time
+}
+
+// UsageRecorder counts message activity per tenant and hour in memory and adds
+// the counts to the usage_rollups table every flush interval. A nil recorder
+// records nothing. Counts since the last flush are lost if the process dies.
+type UsageRecorder struct {
+	repo     repositories.UsageRepository
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[usageKey]*models.UsageRollup
+}
+
+func NewUsageRecorder(repo repositories.UsageRepository, interval time.Duration) *UsageRecorder {
+	return &UsageRecorder{
+		repo:     repo,
+		interval: interval,
+		pending:  make(map[usageKey]*models.UsageRollup),
+	}
+}
+
+// Start flushes pending counts in the background
+func (r *UsageRecorder) Start() {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := r.Flush(context.Background()); err != nil {
+				log.Printf("[Usage] Flush failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Published counts a message accepted by the publisher
+func (r *UsageRecorder) Published(tenantID string, bytes int) {
+	r.add(tenantID, func(u *models.UsageRollup) {
+		u.Published++
+		u.PublishedBytes += int64(bytes)
+	})
+}
+
+// Stored counts a message the consumer wrote to its partition
+func (r *UsageRecorder) Stored(tenantID string, bytes int) {
+	r.add(tenantID, func(u *models.UsageRollup) {
+		u.Stored++
+		u.StoredBytes += int64(bytes)
+	})
+}
+
+// Failed counts a message the consumer could not decode or store
+func (r *UsageRecorder) Failed(tenantID string) {
+	r.add(tenantID, func(u *models.UsageRollup) { u.Failed++ })
+}
+
+// DeadLettered counts a message moved to the dead-letter queue
+func (r *UsageRecorder) DeadLettered(tenantID string) {
+	r.add(tenantID, func(u *models.UsageRollup) { u.DeadLettered++ })
+}
+
+func (r *UsageRecorder) add(tenantID string, fn func(u *models.UsageRollup)) {
+	if r == nil {
+		return
+	}
+
+	key := usageKey{tenantID: tenantID, bucket: time.Now().UTC().Truncate(time.Hour)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rollup, ok := r.pending[key]
+	if !ok {
+		rollup = &models.UsageRollup{TenantID: key.tenantID, Bucket: key.bucket}
+		r.pending[key] = rollup
+	}
+	fn(rollup)
+}
+
+// Flush writes the pending counts; on failure they are kept for the next flush
+func (r *UsageRecorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[usageKey]*models.UsageRollup)
+	r.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	rollups := make([]models.UsageRollup, 0, len(pending))
+	for key, rollup := range pending {
+		// Tenant IDs come from the queue, skip anything that cannot be a tenant
+		if _, err := uuid.Parse(key.tenantID); err != nil {
+			continue
+		}
+		rollups = append(rollups, *rollup)
+	}
+
+	if err := r.repo.Increment(ctx, rollups); err != nil {
+		r.mu.Lock()
+		for key, rollup := range pending {
+			if current, ok := r.pending[key]; ok {
+				mergeUsage(rollup, *current)
+			}
+			r.pending[key] = rollup
+		}
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func mergeUsage(into *models.UsageRollup, from models.UsageRollup) {
+	into.Published += from.Published
+	into.PublishedBytes += from.PublishedBytes
+	into.Stored += from.Stored
+	into.StoredBytes += from.StoredBytes
+	into.Failed += from.Failed
+	into.DeadLettered += from.DeadLettered
+}
+
+// UsageService answers usage statistics queries from the rollup table
+type UsageService struct {
+	repo repositories.UsageRepository
+}
+
+func NewUsageService(repo repositories.UsageRepository) *UsageService {
+	return &UsageService{
+		repo: repo,
+	}
+}
+
+// Stats returns a gap-free time series for the range, with zero points for
+// buckets that had no activity. The range defaults to the last 24 hours.
+func (s *UsageService) Stats(ctx context.Context, tenantID uuid.UUID, query dto.UsageQueryDto) (dto.UsageStatsDto, error) {
+	if query.Bucket == "" {
+		query.Bucket = UsageBucketHour
+	}
+
+	step, limit := time.Hour, maxHourBuckets
+	switch query.Bucket {
+	case UsageBucketHour:
+	case UsageBucketDay:
+		step, limit = 24*time.Hour, maxDayBuckets
+	default:
+		return dto.UsageStatsDto{}, fiber.NewError(fiber.StatusBadRequest, "bucket must be hour or day")
+	}
+
+	to := time.Now().UTC()
+	if query.To != nil {
+		to = query.To.UTC()
+	}
+	from := to.Add(-24 * time.Hour)
+	if query.From != nil {
+		from = query.From.UTC()
+	}
+
+	// Align to whole buckets; the last bucket includes "to"
+	from = from.Truncate(step)
+	to = to.Truncate(step).Add(step)
+	if !from.Before(to) {
+		return dto.UsageStatsDto{}, fiber.NewError(fiber.StatusBadRequest, "from must be before to")
+	}
+	if int(to.Sub(from)/step) > limit {
+		return dto.UsageStatsDto{}, fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("range too large, at most %d %s buckets", limit, query.Bucket))
+	}
+
+	points, err := s.repo.Series(ctx, tenantID.String(), from, to, query.Bucket)
+	if err != nil {
+		return dto.UsageStatsDto{}, err
+	}
+
+	stats := dto.UsageStatsDto{
+		TenantID: tenantID.String(),
+		Bucket:   query.Bucket,
+		From:     from,
+		To:       to,
+		Series:   fillUsageSeries(points, from, to, step),
+	}
+	for _, point := range stats.Series {
+		stats.Totals.Published += point.Published
+		stats.Totals.PublishedBytes += point.PublishedBytes
+		stats.Totals.Stored += point.Stored
+		stats.Totals.StoredBytes += point.StoredBytes
+		stats.Totals.Failed += point.Failed
+		stats.Totals.DeadLettered += point.DeadLettered
+	}
+	return stats, nil
+}
+
+// fillUsageSeries returns one point per bucket in [from, to)
+func fillUsageSeries(points []dto.UsagePointDto, from, to time.Time, step time.Duration) []dto.UsagePointDto {
+	byBucket := make(map[int64]dto.UsagePointDto, len(points))
+	for _, point := range points {
+		byBucket[point.Bucket.Unix()] = point
+	}
+
+	series := make([]dto.UsagePointDto, 0, int(to.Sub(from)/step))
+	for bucket := from; bucket.Before(to); bucket = bucket.Add(step) {
+		point, ok := byBucket[bucket.Unix()]
+		if !ok {
+			point = dto.UsagePointDto{}
+		}
+		point.Bucket = bucket
+		series = append(series, point)
+	}
+	return series
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUsageRepository struct {
+	flushed []models.UsageRollup
+	err     error
+	points  []dto.UsagePointDto
+}
+
+func (r *fakeUsageRepository) Increment(ctx context.Context, rollups []models.UsageRollup) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.flushed = append(r.flushed, rollups...)
+	return nil
+}
+
+func (r *fakeUsageRepository) Series(ctx context.Context, tenantID string, from, to time.Time, bucket string) ([]dto.UsagePointDto, error) {
+	return r.points, nil
+}
+
+func TestUsageRecorderAggregatesAndKeepsCountsOnFailure(t *testing.T) {
+	const tenantID = "0190d8a4-0000-7000-8000-0000000000aa"
+	repo := &fakeUsageRepository{err: errors.New("db down")}
+	r := NewUsageRecorder(repo, time.Minute)
+
+	r.Published(tenantID, 100)
+	r.Published(tenantID, 50)
+	r.Stored(tenantID, 100)
+	r.Failed(tenantID)
+	r.Published("not-a-tenant", 10)
+
+	require.Error(t, r.Flush(context.Background()))
+
+	r.DeadLettered(tenantID)
+	repo.err = nil
+	require.NoError(t, r.Flush(context.Background()))
+
+	require.Len(t, repo.flushed, 1)
+	got := repo.flushed[0]
+	assert.Equal(t, tenantID, got.TenantID)
+	assert.Equal(t, got.Bucket, got.Bucket.Truncate(time.Hour))
+	assert.Equal(t, int64(2), got.Published)
+	assert.Equal(t, int64(150), got.PublishedBytes)
+	assert.Equal(t, int64(1), got.Stored)
+	assert.Equal(t, int64(1), got.Failed)
+	assert.Equal(t, int64(1), got.DeadLettered)
+
+	var nilRecorder *UsageRecorder
+	assert.NotPanics(t, func() { nilRecorder.Published(tenantID, 1) })
+}
+
+func TestUsageStatsFillsGapsAndValidates(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000aa")
+	from := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)
+	repo := &fakeUsageRepository{points: []dto.UsagePointDto{
+		{Bucket: time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC), Published: 4, Stored: 3, Failed: 1},
+	}}
+	s := NewUsageService(repo)
+
+	stats, err := s.Stats(context.Background(), tenantID, dto.UsageQueryDto{From: &from, To: &to})
+	require.NoError(t, err)
+	assert.Equal(t, UsageBucketHour, stats.Bucket)
+	require.Len(t, stats.Series, 4) // 10:00, 11:00, 12:00, 13:00
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), stats.Series[0].Bucket)
+	assert.Zero(t, stats.Series[0].Published)
+	assert.Equal(t, int64(4), stats.Series[1].Published)
+	assert.Equal(t, int64(3), stats.Totals.Stored)
+	assert.Equal(t, int64(1), stats.Totals.Failed)
+
+	_, err = s.Stats(context.Background(), tenantID, dto.UsageQueryDto{Bucket: "minute"})
+	assert.Error(t, err)
+
+	longAgo := to.Add(-40 * 24 * time.Hour)
+	_, err = s.Stats(context.Background(), tenantID, dto.UsageQueryDto{From: &longAgo, To: &to})
+	assert.Error(t, err)
+
+	_, err = s.Stats(context.Background(), tenantID, dto.UsageQueryDto{From: &longAgo, To: &to, Bucket: UsageBucketDay})
+	assert.NoError(t, err)
+}
+
+func TestUsageCountsBroadcastsAndDeadLettersPerTenant(t *testing.T) {
+	gold := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000d1")
+	silver := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000d2")
+	ctx := context.Background()
+
+	repo := &fakeUsageRepository{}
+	recorder := NewUsageRecorder(repo, time.Hour)
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{
+		{ID: gold.String()},
+		// validate cannot be built without schemas, so every attempt fails
+		{ID: silver.String(), Pipeline: models.Pipeline{{Type: StepValidate}}},
+	}}
+	broker := NewMemoryBroker()
+	manager := NewTenantManager(broker, &recordingMessageRepository{}, tenants, newFakeBindingRepository())
+	manager.EnableUsage(recorder)
+	manager.LimitRetries(1, time.Millisecond)
+	for _, id := range []uuid.UUID{gold, silver} {
+		require.NoError(t, manager.StartTenantConsumer(ctx, id, 1))
+		defer manager.StopTenantConsumer(id)
+	}
+	publisher := NewPublisherService(broker)
+	publisher.EnableUsage(recorder)
+
+	_, err := NewBroadcastService(tenants, publisher).Broadcast(ctx, dto.BroadcastDto{
+		Target:  dto.BroadcastTargetDto{All: true},
+		Payload: map[string]any{"notice": "maintenance"},
+	})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		ready, unacked := broker.QueueLength(TenantDeadLetterQueueName(silver.String()))
+		return ready+unacked == 1
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, recorder.Flush(ctx))
+	got := map[string]models.UsageRollup{}
+	for _, rollup := range repo.flushed {
+		got[rollup.TenantID] = rollup
+	}
+	require.Len(t, got, 2, "no usage without a tenant")
+	assert.Equal(t, int64(1), got[gold.String()].Published)
+	assert.Equal(t, int64(1), got[gold.String()].Stored)
+	assert.Equal(t, int64(1), got[silver.String()].Published)
+	assert.Equal(t, int64(1), got[silver.String()].DeadLettered)
+	assert.Equal(t, got[gold.String()].PublishedBytes, got[silver.String()].PublishedBytes)
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Defaults of the webhook deliveries
+const (
+	DefaultWebhookMaxAttempts  = 8
+	DefaultWebhookRetryBackoff = 10 * time.Second
+	DefaultWebhookTimeout      = 10 * time.Second
+	maxWebhookRetryBackoff     = time.Hour
+
+	webhookBatchSize        = 50
+	webhookConcurrency      = 10
+	defaultDeliveriesLimit  = 50
+	maxDeliveriesLimit      = 500
+	maxWebhookErrorBodySize = 512
+)
+
+// Headers sent with every webhook delivery
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookService registers tenant webhooks and POSTs the tenant messages to
+// them. Deliveries are stored first and sent by a background loop, so a slow
+// or failing endpoint never holds up the consumer.
+type WebhookService struct {
+	repo     repositories.WebhookRepository
+	client   *http.Client
+	interval time.Duration
+
+	maxAttempts   int
+	retryBackoff  time.Duration
+	allowInsecure bool
+
+	// lookupIP resolves webhook hosts at registration
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+
+	hooksMu sync.Mutex
+	hooks   map[string]cachedWebhooks
+
+	wake     chan struct{}
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+// cachedWebhooks keeps a tenant's webhooks for Enqueue, reloaded like the
+// tenant bindings
+type cachedWebhooks struct {
+	hooks    []models.TenantWebhook
+	loadedAt time.Time
+}
+
+// NewWebhookService constructor. Due deliveries are looked up every interval
+// and right after messages are enqueued.
+func NewWebhookService(repo repositories.WebhookRepository, interval time.Duration) *WebhookService {
+	s := &WebhookService{
+		repo:         repo,
+		interval:     interval,
+		maxAttempts:  DefaultWebhookMaxAttempts,
+		retryBackoff: DefaultWebhookRetryBackoff,
+		hooks:        make(map[string]cachedWebhooks),
+		wake:         make(chan struct{}, 1),
+		stopChan:     make(chan struct{}),
+		doneChan:     make(chan struct{}),
+	}
+	s.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		return net.DefaultResolver.LookupIP(ctx, "ip", host)
+	}
+
+	// Every connection, redirects included, is checked once the host is
+	// resolved, so a webhook host re-pointed at an internal address after
+	// registration is still refused. No proxy is used, the check must see the
+	// endpoint itself.
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: s.checkDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	s.client = &http.Client{Timeout: DefaultWebhookTimeout, Transport: transport}
+	return s
+}
+
+// LimitRetries sets how often a delivery is attempted and the delay before the
+// first retry; it doubles on every further attempt
+func (s *WebhookService) LimitRetries(maxAttempts int, backoff time.Duration) {
+	if maxAttempts > 0 {
+		s.maxAttempts = maxAttempts
+	}
+	if backoff > 0 {
+		s.retryBackoff = backoff
+	}
+}
+
+// SetTimeout bounds a single POST, including reading the response
+func (s *WebhookService) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		s.client.Timeout = timeout
+	}
+}
+
+// AllowInsecureURLs accepts plain http webhook URLs and hosts on loopback or
+// private addresses, for local development
+func (s *WebhookService) AllowInsecureURLs() {
+	s.allowInsecure = true
+}
+
+// Register adds a webhook for the tenant and returns it with its signing secret
+func (s *WebhookService) Register(ctx context.Context, tenantID uuid.UUID, req dto.CreateWebhookDto) (models.TenantWebhook, error) {
+	target, err := url.Parse(req.URL)
+	if err != nil || target.Host == "" || (target.Scheme != "https" && (target.Scheme != "http" || !s.allowInsecure)) {
+		return models.TenantWebhook{}, fiber.NewError(fiber.StatusBadRequest, "url must be an absolute https URL")
+	}
+	if err := s.checkHost(ctx, target.Hostname()); err != nil {
+		return models.TenantWebhook{}, err
+	}
+	if req.EventTypes == "" {
+		req.EventTypes = DefaultBindingPattern
+	}
+	if !ValidBindingPattern(req.EventTypes) {
+		return models.TenantWebhook{}, fiber.NewError(fiber.StatusBadRequest,
+			"event_types must be dot separated words of letters, digits, _ or -, or the wildcards * and #")
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return models.TenantWebhook{}, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return models.TenantWebhook{}, err
+	}
+
+	hook := models.TenantWebhook{
+		ID:         id.String(),
+		TenantID:   tenantID.String(),
+		URL:        target.String(),
+		EventTypes: req.EventTypes,
+		Secret:     "whsec_" + hex.EncodeToString(secret),
+	}
+	if err := s.repo.Create(ctx, &hook); err != nil {
+		return models.TenantWebhook{}, err
+	}
+	s.forget(hook.TenantID)
+	return hook, nil
+}
+
+// List returns the webhooks of a tenant without their secrets
+func (s *WebhookService) List(ctx context.Context, tenantID uuid.UUID) ([]models.TenantWebhook, error) {
+	hooks, err := s.repo.List(ctx, tenantID.String())
+	if err != nil {
+		return nil, err
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return hooks, nil
+}
+
+// Delete removes a webhook together with its delivery log
+func (s *WebhookService) Delete(ctx context.Context, tenantID uuid.UUID, hookID string) error {
+	found, err := s.repo.Delete(ctx, tenantID.String(), hookID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("webhook %s not found", hookID))
+	}
+	s.forget(tenantID.String())
+	return nil
+}
+
+// DeleteAll removes every webhook of a tenant
+func (s *WebhookService) DeleteAll(ctx context.Context, tenantID string) error {
+	if err := s.repo.DeleteAll(ctx, tenantID); err != nil {
+		return err
+	}
+	s.forget(tenantID)
+	return nil
+}
+
+// Deliveries returns the newest deliveries of a webhook with their attempts
+func (s *WebhookService) Deliveries(ctx context.Context, tenantID uuid.UUID, hookID string, query dto.WebhookDeliveryQueryDto) ([]models.WebhookDelivery, error) {
+	if _, err := uuid.Parse(hookID); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid webhook id")
+	}
+	switch query.Status {
+	case "", models.WebhookDeliveryPending, models.WebhookDeliveryDelivered, models.WebhookDeliveryFailed:
+	default:
+		return nil, fiber.NewError(fiber.StatusBadRequest, "status must be pending, delivered or failed")
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultDeliveriesLimit
+	}
+	if query.Limit > maxDeliveriesLimit {
+		query.Limit = maxDeliveriesLimit
+	}
+
+	if _, err := s.repo.Find(ctx, tenantID.String(), hookID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListDeliveries(ctx, hookID, query.Status, query.Limit)
+}
+
+// Enqueue stores a delivery of msg for every webhook of the tenant whose
+// event types match
+func (s *WebhookService) Enqueue(ctx context.Context, msg *PipelineMessage) error {
+	hooks, err := s.tenantWebhooks(ctx, msg.TenantID)
+	if err != nil {
+		return err
+	}
+
+	eventType := msg.EventType
+	if eventType == "" {
+		eventType = DefaultEventType
+	}
+	var deliveries []models.WebhookDelivery
+	for _, hook := range hooks {
+		if !topicMatch(hook.EventTypes, eventType) {
+			continue
+		}
+		id, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID:            id.String(),
+			WebhookID:     hook.ID,
+			TenantID:      msg.TenantID,
+			EventType:     msg.EventType,
+			Payload:       msg.Payload,
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := s.repo.EnqueueDeliveries(ctx, deliveries); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start runs the delivery loop in the background
+func (s *WebhookService) Start() {
+	go func() {
+		log.Printf("[Webhook] Dispatcher started, polling every %s", s.interval)
+		defer close(s.doneChan)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.drain()
+			case <-s.wake:
+				s.drain()
+			case <-s.stopChan:
+				log.Println("[Webhook] Dispatcher stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the current batch to finish and stops the delivery loop
+func (s *WebhookService) Stop() {
+	close(s.stopChan)
+	<-s.doneChan
+}
+
+// drain sends due deliveries in batches until none are left. A batch is
+// claimed, then sent webhookConcurrency at a time, and every attempt is saved
+// on its own, so one failed save does not make the others be sent again.
+func (s *WebhookService) drain() {
+	ctx := context.Background()
+	for {
+		due, err := s.repo.ClaimDue(ctx, webhookBatchSize, s.lease())
+		if err != nil {
+			log.Printf("[Webhook] Delivery batch failed: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, webhookConcurrency)
+		for i := range due {
+			delivery := &due[i]
+			wg.Add(1)
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots; wg.Done() }()
+				s.deliver(delivery)
+				// The delivery is attempted again when its lease ends
+				if err := s.repo.SaveAttempt(ctx, delivery); err != nil {
+					log.Printf("[Webhook] Failed to save delivery %s: %v", delivery.ID, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(due) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// lease is how long a claimed batch is hidden from other instances: long
+// enough for every POST of the batch to time out
+func (s *WebhookService) lease() time.Duration {
+	rounds := (webhookBatchSize + webhookConcurrency - 1) / webhookConcurrency
+	return time.Duration(rounds)*s.client.Timeout + time.Minute
+}
+
+// deliver POSTs a delivery once and records the attempt on it. A 2xx response
+// completes it; otherwise it is retried after a backoff until it runs out of
+// attempts.
+func (s *WebhookService) deliver(delivery *models.WebhookDelivery) {
+	delivery.Attempts++
+	attempt := models.WebhookAttempt{Attempt: delivery.Attempts, At: time.Now()}
+
+	statusCode, err := s.post(delivery)
+	attempt.DurationMs = time.Since(attempt.At).Milliseconds()
+	attempt.StatusCode = statusCode
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	delivery.AttemptLog = append(delivery.AttemptLog, attempt)
+
+	switch {
+	case err == nil:
+		now := time.Now()
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= s.maxAttempts:
+		delivery.Status = models.WebhookDeliveryFailed
+		log.Printf("[Webhook] Delivery %s to %s failed after %d attempts: %v", delivery.ID, delivery.WebhookID, delivery.Attempts, err)
+	default:
+		delivery.NextAttemptAt = time.Now().Add(s.backoff(delivery.Attempts))
+	}
+}
+
+// post sends the signed delivery and returns the response status code
+func (s *WebhookService) post(delivery *models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(map[string]any{
+		"id":         delivery.ID,
+		"webhook_id": delivery.WebhookID,
+		"tenant_id":  delivery.TenantID,
+		"event_type": delivery.EventType,
+		"payload":    delivery.Payload,
+		"created_at": delivery.CreatedAt,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(delivery.Webhook.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookErrorBodySize))
+		return resp.StatusCode, fmt.Errorf("endpoint responded %d: %s", resp.StatusCode, bytes.TrimSpace(snippet))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookErrorBodySize))
+	return resp.StatusCode, nil
+}
+
+// checkHost rejects webhook hosts that resolve to an address the service must
+// not call, such as loopback, private or link-local ones
+func (s *WebhookService) checkHost(ctx context.Context, host string) error {
+	if s.allowInsecure {
+		return nil
+	}
+	ips, err := s.lookupIP(ctx, host)
+	if err != nil || len(ips) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("url host %s cannot be resolved", host))
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("url host %s resolves to a non-public address", host))
+		}
+	}
+	return nil
+}
+
+// checkDial is the dialer Control of the webhook client. address is the
+// resolved ip:port about to be connected.
+func (s *WebhookService) checkDial(network, address string, _ syscall.RawConn) error {
+	if s.allowInsecure {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// cgnatNet is the carrier-grade NAT range (RFC 6598), not covered by net.IP.IsPrivate
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is a globally routed unicast address
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnatNet.Contains(ip)
+}
+
+// backoff is the delay after attempt number attempts
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	delay := s.retryBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxWebhookRetryBackoff {
+			return maxWebhookRetryBackoff
+		}
+	}
+	return delay
+}
+
+// tenantWebhooks returns the cached webhooks of a tenant
+func (s *WebhookService) tenantWebhooks(ctx context.Context, tenantID string) ([]models.TenantWebhook, error) {
+	s.hooksMu.Lock()
+	cached, ok := s.hooks[tenantID]
+	s.hooksMu.Unlock()
+	if ok && time.Since(cached.loadedAt) < bindingCacheTTL {
+		return cached.hooks, nil
+	}
+
+	hooks, err := s.repo.List(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.hooksMu.Lock()
+	s.hooks[tenantID] = cachedWebhooks{hooks: hooks, loadedAt: time.Now()}
+	s.hooksMu.Unlock()
+	return hooks, nil
+}
+
+func (s *WebhookService) forget(tenantID string) {
+	s.hooksMu.Lock()
+	delete(s.hooks, tenantID)
+	s.hooksMu.Unlock()
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with
+// the webhook secret. Receivers recompute it to verify a delivery and reject
+// old timestamps to stop replays.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
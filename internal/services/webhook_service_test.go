@@ -0,0 +1,322 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/dto"
+	"aswadwk/messaging-task-go/internal/models"
+	"aswadwk/messaging-task-go/internal/repositories"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWebhookRepository keeps webhooks and deliveries in memory
+type fakeWebhookRepository struct {
+	repositories.WebhookRepository
+	mu         sync.Mutex
+	hooks      []models.TenantWebhook
+	deliveries []models.WebhookDelivery
+	// failSave, when set, can fail saving an attempt
+	failSave func(delivery *models.WebhookDelivery) error
+}
+
+func (r *fakeWebhookRepository) Create(ctx context.Context, hook *models.TenantWebhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, *hook)
+	return nil
+}
+
+func (r *fakeWebhookRepository) List(ctx context.Context, tenantID string) ([]models.TenantWebhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hooks []models.TenantWebhook
+	for _, hook := range r.hooks {
+		if hook.TenantID == tenantID {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (r *fakeWebhookRepository) Find(ctx context.Context, tenantID, hookID string) (models.TenantWebhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, hook := range r.hooks {
+		if hook.TenantID == tenantID && hook.ID == hookID {
+			return hook, nil
+		}
+	}
+	return models.TenantWebhook{}, fiber.NewError(fiber.StatusNotFound, "webhook not found")
+}
+
+func (r *fakeWebhookRepository) EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, delivery := range deliveries {
+		delivery.CreatedAt = time.Now()
+		r.deliveries = append(r.deliveries, delivery)
+	}
+	return nil
+}
+
+func (r *fakeWebhookRepository) ClaimDue(ctx context.Context, limit int, lockFor time.Duration) ([]models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var due []models.WebhookDelivery
+	for i := range r.deliveries {
+		delivery := &r.deliveries[i]
+		if len(due) == limit || delivery.Status != models.WebhookDeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		delivery.NextAttemptAt = now.Add(lockFor)
+		claimed := *delivery
+		for _, hook := range r.hooks {
+			if hook.ID == delivery.WebhookID {
+				claimed.Webhook = hook
+			}
+		}
+		due = append(due, claimed)
+	}
+	return due, nil
+}
+
+func (r *fakeWebhookRepository) SaveAttempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failSave != nil {
+		if err := r.failSave(delivery); err != nil {
+			return err
+		}
+	}
+	for i := range r.deliveries {
+		if r.deliveries[i].ID == delivery.ID {
+			saved := *delivery
+			saved.Webhook = models.TenantWebhook{}
+			r.deliveries[i] = saved
+		}
+	}
+	return nil
+}
+
+func (r *fakeWebhookRepository) ListDeliveries(ctx context.Context, hookID, status string, limit int) ([]models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.WebhookID == hookID && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func TestWebhookDeliveriesAreSignedAndRetried(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000e1")
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	var secret atomic.Value
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		if r.Header.Get(WebhookSignatureHeader) != "sha256="+SignWebhook(secret.Load().(string), timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// The first attempt fails, the retry succeeds
+		if calls.Add(1) == 1 {
+			http.Error(w, "try again", http.StatusBadGateway)
+			return
+		}
+		var delivery map[string]any
+		json.Unmarshal(body, &delivery)
+		if delivery["id"] != r.Header.Get(WebhookIDHeader) || delivery["event_type"] != "orders.created" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer okServer.Close()
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failingServer.Close()
+
+	repo := &fakeWebhookRepository{}
+	webhooks := NewWebhookService(repo, time.Hour)
+	webhooks.AllowInsecureURLs()
+	webhooks.LimitRetries(3, time.Millisecond)
+	webhooks.Start()
+	defer webhooks.Stop()
+
+	ok, err := webhooks.Register(ctx, tenantID, dto.CreateWebhookDto{URL: okServer.URL, EventTypes: "orders.*"})
+	require.NoError(t, err)
+	secret.Store(ok.Secret)
+	failing, err := webhooks.Register(ctx, tenantID, dto.CreateWebhookDto{URL: failingServer.URL})
+	require.NoError(t, err)
+	skipped, err := webhooks.Register(ctx, tenantID, dto.CreateWebhookDto{URL: okServer.URL, EventTypes: "invoices.#"})
+	require.NoError(t, err)
+
+	broker := NewMemoryBroker()
+	messages := &recordingMessageRepository{}
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: tenantID.String()}}}
+	manager := NewTenantManager(broker, messages, tenants, newFakeBindingRepository())
+	manager.EnableWebhooks(webhooks)
+	require.NoError(t, manager.AddDefaultBinding(ctx, tenantID))
+	require.NoError(t, manager.StartTenantConsumer(ctx, tenantID, 1))
+	defer manager.StopTenantConsumer(tenantID)
+
+	require.NoError(t, NewPublisherService(broker).PublishEvent(Message{
+		TenantID: tenantID.String(), EventType: "orders.created", Payload: map[string]any{"order_id": "A-1"},
+	}))
+
+	// The retry is due a millisecond later, only the poll interval would run it
+	assert.Eventually(t, func() bool {
+		webhooks.drain()
+		delivered, _ := webhooks.Deliveries(ctx, tenantID, ok.ID, dto.WebhookDeliveryQueryDto{Status: models.WebhookDeliveryDelivered})
+		failed, _ := webhooks.Deliveries(ctx, tenantID, failing.ID, dto.WebhookDeliveryQueryDto{Status: models.WebhookDeliveryFailed})
+		return len(delivered) == 1 && len(failed) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, messages.count())
+
+	delivered, err := webhooks.Deliveries(ctx, tenantID, ok.ID, dto.WebhookDeliveryQueryDto{})
+	require.NoError(t, err)
+	require.Len(t, delivered, 1)
+	assert.Equal(t, 2, delivered[0].Attempts)
+	require.Len(t, delivered[0].AttemptLog, 2)
+	assert.Equal(t, http.StatusBadGateway, delivered[0].AttemptLog[0].StatusCode)
+	assert.Contains(t, delivered[0].AttemptLog[0].Error, "try again")
+	assert.Equal(t, http.StatusNoContent, delivered[0].AttemptLog[1].StatusCode)
+	assert.NotNil(t, delivered[0].DeliveredAt)
+
+	failed, err := webhooks.Deliveries(ctx, tenantID, failing.ID, dto.WebhookDeliveryQueryDto{})
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, 3, failed[0].Attempts)
+	for _, attempt := range failed[0].AttemptLog {
+		assert.Equal(t, http.StatusInternalServerError, attempt.StatusCode)
+	}
+
+	none, err := webhooks.Deliveries(ctx, tenantID, skipped.ID, dto.WebhookDeliveryQueryDto{})
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	// Secrets are only shown when the hook is created
+	hooks, err := webhooks.List(ctx, tenantID)
+	require.NoError(t, err)
+	require.Len(t, hooks, 3)
+	for _, hook := range hooks {
+		assert.Empty(t, hook.Secret)
+	}
+}
+
+func TestWebhookRegisterRejectsInvalidHooks(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000e2")
+	webhooks := NewWebhookService(&fakeWebhookRepository{}, time.Hour)
+	webhooks.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IP{ip}, nil
+		}
+		hosts := map[string][]net.IP{
+			"example.com":          {net.ParseIP("93.184.215.14")},
+			"internal.example.com": {net.ParseIP("93.184.215.14"), net.ParseIP("10.0.0.5")},
+		}
+		return hosts[host], nil
+	}
+
+	for _, req := range []dto.CreateWebhookDto{
+		{URL: "http://example.com/hook"},
+		{URL: "example.com/hook"},
+		{URL: "https://example.com/hook", EventTypes: "orders..created"},
+		{URL: "https://127.0.0.1/hook"},
+		{URL: "https://[::1]/hook"},
+		{URL: "https://169.254.169.254/latest/meta-data"},
+		{URL: "https://internal.example.com/hook"},
+	} {
+		_, err := webhooks.Register(context.Background(), tenantID, req)
+		var fiberErr *fiber.Error
+		require.ErrorAs(t, err, &fiberErr, req.URL)
+		assert.Equal(t, fiber.StatusBadRequest, fiberErr.Code)
+	}
+
+	hook, err := webhooks.Register(context.Background(), tenantID, dto.CreateWebhookDto{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultBindingPattern, hook.EventTypes)
+	assert.Regexp(t, "^whsec_[0-9a-f]{64}$", hook.Secret)
+
+	_, err = webhooks.Deliveries(context.Background(), tenantID, uuid.NewString(), dto.WebhookDeliveryQueryDto{})
+	var fiberErr *fiber.Error
+	require.ErrorAs(t, err, &fiberErr)
+	assert.Equal(t, fiber.StatusNotFound, fiberErr.Code)
+
+	// A host that resolves to an internal address later is refused on connect
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("internal endpoint was called")
+	}))
+	defer server.Close()
+	_, err = webhooks.post(&models.WebhookDelivery{ID: uuid.NewString(), Webhook: models.TenantWebhook{URL: server.URL, Secret: hook.Secret}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not public")
+}
+
+func TestWebhookAttemptsAreSavedOneByOne(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-0000000000e3")
+	ctx := context.Background()
+
+	var calls sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, _ := calls.LoadOrStore(r.URL.Path, new(atomic.Int32))
+		count.(*atomic.Int32).Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	repo := &fakeWebhookRepository{}
+	webhooks := NewWebhookService(repo, time.Hour)
+	webhooks.AllowInsecureURLs()
+	saved, err := webhooks.Register(ctx, tenantID, dto.CreateWebhookDto{URL: server.URL + "/saved"})
+	require.NoError(t, err)
+	lost, err := webhooks.Register(ctx, tenantID, dto.CreateWebhookDto{URL: server.URL + "/lost"})
+	require.NoError(t, err)
+	repo.failSave = func(delivery *models.WebhookDelivery) error {
+		if delivery.WebhookID == lost.ID {
+			return errors.New("database unavailable")
+		}
+		return nil
+	}
+
+	message := &PipelineMessage{NewMessageDto: dto.NewMessageDto{TenantID: tenantID.String(), Payload: map[string]any{"n": 1}}}
+	require.NoError(t, webhooks.Enqueue(ctx, message))
+	webhooks.drain()
+	webhooks.drain()
+
+	// The delivery whose save failed stays leased instead of being sent again
+	// right away; the saved one is done
+	for _, path := range []string{"/saved", "/lost"} {
+		count, ok := calls.Load(path)
+		require.True(t, ok, path)
+		assert.Equal(t, int32(1), count.(*atomic.Int32).Load(), path)
+	}
+	delivered, err := webhooks.Deliveries(ctx, tenantID, saved.ID, dto.WebhookDeliveryQueryDto{Status: models.WebhookDeliveryDelivered})
+	require.NoError(t, err)
+	assert.Len(t, delivered, 1)
+	pending, err := webhooks.Deliveries(ctx, tenantID, lost.ID, dto.WebhookDeliveryQueryDto{Status: models.WebhookDeliveryPending})
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.True(t, pending[0].NextAttemptAt.After(time.Now().Add(webhooks.client.Timeout)))
+}
@@ -1,45 +1,173 @@
 package services
 
 import (
+	"hash/fnv"
 	"sync"
 	"sync/atomic"
 )
 
+// keyedBacklogPerWorker sizes the pool-wide limit of ordered tasks waiting
+// for their worker
+const keyedBacklogPerWorker = 100
+
 type WorkerPool struct {
-	tasks       chan func()
+	tasks chan func()
+	// keyed holds one queue per worker; tasks with the same ordering key
+	// always go to the same worker and run in submission order
+	keyed []*keyedQueue
+	// backlog counts the ordered tasks not run yet. SubmitOrdered only waits
+	// once the whole pool is backed up, never for one busy worker.
+	backlog     chan struct{}
 	wg          sync.WaitGroup
 	activeCount int64 // Atomic counter for active workers
 	totalCount  int64 // Atomic counter for total workers
 }
 
+// keyedQueue is the FIFO of the ordered tasks of one worker. It grows as
+// needed, so a slow key holds up its own worker but not the submitter.
+type keyedQueue struct {
+	mu     sync.Mutex
+	tasks  []func()
+	closed bool
+	ready  chan struct{}
+}
+
+func (q *keyedQueue) push(task func()) {
+	q.mu.Lock()
+	q.tasks = append(q.tasks, task)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// pop returns the next task, or done once the queue is closed and empty
+func (q *keyedQueue) pop() (task func(), done bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tasks) == 0 {
+		return nil, q.closed
+	}
+	task = q.tasks[0]
+	q.tasks[0] = nil
+	q.tasks = q.tasks[1:]
+	if len(q.tasks) > 0 {
+		q.signal()
+	}
+	return task, false
+}
+
+func (q *keyedQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *keyedQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
 func NewWorkerPool(workers int) *WorkerPool {
+	workers = max(workers, 1)
 	p := &WorkerPool{
-		tasks: make(chan func(), 100),
+		tasks:   make(chan func(), 100),
+		keyed:   make([]*keyedQueue, workers),
+		backlog: make(chan struct{}, workers*keyedBacklogPerWorker),
 	}
 
 	atomic.StoreInt64(&p.totalCount, int64(workers))
 
-	for range workers {
-		go func() {
-			for task := range p.tasks {
-				atomic.AddInt64(&p.activeCount, 1)
-				task()
-				atomic.AddInt64(&p.activeCount, -1)
-				p.wg.Done()
-			}
-		}()
+	for i := range workers {
+		p.keyed[i] = &keyedQueue{ready: make(chan struct{}, 1)}
+		go p.work(p.keyed[i])
 	}
 
 	return p
 }
 
+// work runs tasks from the shared queue and from the worker's own keyed queue
+// until both are closed
+func (p *WorkerPool) work(keyed *keyedQueue) {
+	tasks := p.tasks
+	ready := keyed.ready
+	for tasks != nil || ready != nil {
+		select {
+		case task, ok := <-tasks:
+			if !ok {
+				tasks = nil
+				continue
+			}
+			p.run(task)
+		case <-ready:
+			task, done := keyed.pop()
+			if done {
+				ready = nil
+				continue
+			}
+			if task != nil {
+				p.run(task)
+				<-p.backlog
+			}
+		}
+	}
+}
+
+func (p *WorkerPool) run(task func()) {
+	atomic.AddInt64(&p.activeCount, 1)
+	task()
+	atomic.AddInt64(&p.activeCount, -1)
+	p.wg.Done()
+}
+
 func (p *WorkerPool) Submit(task func()) {
 	p.wg.Add(1)
 	p.tasks <- task
 }
 
+// SubmitOrdered runs task on the worker owning key, after the tasks submitted
+// before with the same key. Different keys still run in parallel. An empty key
+// is the same as Submit.
+//
+// The order only holds among the tasks of this pool: messages of one key must
+// all reach a single consumer for them to be handled in order. A slow key
+// holds up its own worker only; SubmitOrdered returns right away until the
+// pool has workers*keyedBacklogPerWorker ordered tasks pending in total.
+func (p *WorkerPool) SubmitOrdered(key string, task func()) {
+	if key == "" {
+		p.Submit(task)
+		return
+	}
+	p.backlog <- struct{}{}
+	p.wg.Add(1)
+	p.keyed[p.workerFor(key)].push(task)
+}
+
+// workerFor maps key to a worker with jump consistent hashing, so a different
+// worker count moves as few keys as possible to another worker
+func (p *WorkerPool) workerFor(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return jumpHash(h.Sum64(), len(p.keyed))
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
 func (p *WorkerPool) Stop() {
 	close(p.tasks)
+	for _, keyed := range p.keyed {
+		keyed.close()
+	}
 	p.wg.Wait()
 }
 
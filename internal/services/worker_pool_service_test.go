@@ -0,0 +1,163 @@
+package services
+
+import (
+	"aswadwk/messaging-task-go/internal/models"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPoolKeepsKeyOrderUnderConcurrency(t *testing.T) {
+	const keys, perKey = 16, 200
+	pool := NewWorkerPool(8)
+
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	var running, maxRunning atomic.Int64
+
+	for i := range perKey {
+		for k := range keys {
+			key := fmt.Sprintf("customer-%d", k)
+			pool.SubmitOrdered(key, func() {
+				now := running.Add(1)
+				for {
+					peak := maxRunning.Load()
+					if now <= peak || maxRunning.CompareAndSwap(peak, now) {
+						break
+					}
+				}
+				// Uneven task durations shuffle the interleaving across workers
+				time.Sleep(time.Duration(rand.IntN(50)) * time.Microsecond)
+
+				mu.Lock()
+				seen[key] = append(seen[key], i)
+				mu.Unlock()
+				running.Add(-1)
+			})
+		}
+	}
+	pool.Stop()
+
+	require.Len(t, seen, keys)
+	for key, sequence := range seen {
+		require.Len(t, sequence, perKey, key)
+		for i, n := range sequence {
+			require.Equal(t, i, n, "%s ran out of order", key)
+		}
+	}
+	// Different keys were handled at the same time
+	assert.Greater(t, maxRunning.Load(), int64(1))
+}
+
+func TestWorkerPoolSlowKeyDoesNotBlockSubmit(t *testing.T) {
+	pool := NewWorkerPool(4)
+	release := make(chan struct{})
+	pool.SubmitOrdered("slow", func() { <-release })
+
+	// More tasks of the stuck key than one worker used to buffer, then other
+	// keys: none of the submits waits and the other keys still run
+	var ran atomic.Int64
+	submitted := make(chan struct{})
+	go func() {
+		defer close(submitted)
+		for range 2 * keyedBacklogPerWorker {
+			pool.SubmitOrdered("slow", func() {})
+		}
+		for i := range 50 {
+			pool.SubmitOrdered(fmt.Sprintf("customer-%d", i), func() { ran.Add(1) })
+		}
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("SubmitOrdered waited for the slow key")
+	}
+
+	slowWorker := pool.workerFor("slow")
+	expected := int64(0)
+	for i := range 50 {
+		if pool.workerFor(fmt.Sprintf("customer-%d", i)) != slowWorker {
+			expected++
+		}
+	}
+	assert.Eventually(t, func() bool { return ran.Load() == expected }, time.Second, time.Millisecond)
+
+	close(release)
+	pool.Stop()
+	assert.Equal(t, int64(50), ran.Load())
+}
+
+func TestWorkerPoolRoutesKeysConsistently(t *testing.T) {
+	pool := NewWorkerPool(8)
+	defer pool.Stop()
+
+	used := make(map[int]int)
+	for i := range 1000 {
+		key := fmt.Sprintf("customer-%d", i)
+		worker := pool.workerFor(key)
+		require.Equal(t, worker, pool.workerFor(key))
+		used[worker]++
+	}
+	assert.Len(t, used, 8)
+
+	// Adding a worker only moves keys to the new worker
+	bigger := NewWorkerPool(9)
+	defer bigger.Stop()
+	moved := 0
+	for i := range 1000 {
+		key := fmt.Sprintf("customer-%d", i)
+		if worker := bigger.workerFor(key); worker != pool.workerFor(key) {
+			assert.Equal(t, 8, worker)
+			moved++
+		}
+	}
+	assert.Less(t, moved, 250)
+}
+
+func TestTenantConsumerKeepsOrderingKeyOrder(t *testing.T) {
+	tenantID := uuid.MustParse("0190d8a4-0000-7000-8000-000000000111")
+	ctx := context.Background()
+
+	broker := NewMemoryBroker()
+	// The first stores fail; their retries must not be overtaken by later
+	// messages of the same key
+	repo := &flakyMessageRepository{failures: 3}
+	tenants := &fakeTenantRepository{tenants: []models.Tenant{{ID: tenantID.String()}}}
+	manager := NewTenantManager(broker, repo, tenants, newFakeBindingRepository())
+	manager.LimitRetries(5, time.Millisecond)
+	require.NoError(t, manager.AddDefaultBinding(ctx, tenantID))
+	require.NoError(t, manager.StartTenantConsumer(ctx, tenantID, 4))
+	defer manager.StopTenantConsumer(tenantID)
+
+	publisher := NewPublisherService(broker)
+	const perKey = 50
+	for i := range perKey {
+		for _, customer := range []string{"c-1", "c-2", "c-3"} {
+			require.NoError(t, publisher.PublishEvent(Message{
+				TenantID:    tenantID.String(),
+				EventType:   "customers.updated",
+				Payload:     map[string]any{"customer": customer, "seq": i},
+				OrderingKey: customer,
+			}))
+		}
+	}
+	assert.Eventually(t, func() bool { return repo.count() == 3*perKey }, 2*time.Second, 5*time.Millisecond)
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	next := make(map[string]float64)
+	for _, stored := range repo.stored {
+		customer := stored.Payload["customer"].(string)
+		assert.Equal(t, next[customer], stored.Payload["seq"], customer)
+		assert.Equal(t, customer, stored.OrderingKey)
+		next[customer]++
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"unicode"
 
@@ -52,6 +53,27 @@ type ApiError struct {
 	Msg   string `json:"message"`
 }
 
+// FieldErrors maps a field to its validation messages, for validation done
+// outside the validator package (e.g. JSON Schema payload validation)
+type FieldErrors map[string][]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return "validation failed"
+	}
+	sort.Strings(fields)
+
+	message := fmt.Sprintf("The %s field is invalid: %s.", fields[0], e[fields[0]][0])
+	if len(fields) > 1 {
+		message = fmt.Sprintf("%s and %d other error(s)", message, len(fields)-1)
+	}
+	return message
+}
+
 func SuccessResponse(message string, data any, errors any) Response {
 	res := Response{
 		Success: true,
@@ -99,6 +121,16 @@ func ErrorResponse(message string, err ...error) Response {
 		}
 	}
 
+	var fe FieldErrors
+	if errors.As(err[0], &fe) {
+		return Response{
+			Success: false,
+			Message: fe.Error(),
+			Errors:  fe,
+			Data:    nil,
+		}
+	}
+
 	// fallback error
 	return Response{
 		Success: false,
@@ -147,6 +179,12 @@ func HandleError(c *fiber.Ctx, err error) error {
 			err,
 		))
 
+	case FieldErrors:
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse(
+			err.Error(),
+			err,
+		))
+
 	case *fiber.Error:
 		code = e.Code
 		if code == fiber.StatusInternalServerError {
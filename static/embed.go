@@ -0,0 +1,7 @@
+// Package static embeds the public static files served under /static.
+package static
+
+import "embed"
+
+//go:embed favicon.ico
+var Files embed.FS